@@ -4,44 +4,76 @@ import (
 	"context"
 	"edge-agent/internal/client"
 	"edge-agent/internal/config"
+	"edge-agent/internal/logging"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"log"
+	"log/slog"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 	"time"
 )
 
+// defaultShutdownTimeout is used when commands.shutdown_timeout is not configured.
+const defaultShutdownTimeout = 60 * time.Second
+
+var (
+	errShutdownTimeout = errors.New("shutdown timed out")
+	errForceQuit       = errors.New("forced to quit")
+)
+
+// stopWithin runs stop and waits for it to return, giving up after timeout
+// or as soon as another signal arrives on signals.
+func stopWithin(stop func() error, timeout time.Duration, signals <-chan os.Signal) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- stop()
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		return fmt.Errorf("%w after %s", errShutdownTimeout, timeout)
+	case sig := <-signals:
+		return fmt.Errorf("%w: received %s during shutdown", errForceQuit, sig)
+	}
+}
+
 func main() {
 	log.Println("Starting application...")
 
 	// Parse flags FIRST before getting config
 	flag.Parse()
-	log.Println("Flags parsed")
+	slog.Debug("Flags parsed")
 
 	// Load configuration
-	log.Println("Loading configuration...")
+	slog.Debug("Loading configuration...")
 	cfg := config.GetConfig()
-	log.Println("Configuration loaded")
+	slog.Debug("Configuration loaded")
 
 	// Setup logging
-	log.Println("Setting up logging...")
-	setupLogging(cfg.Logging)
-	log.Println("Logging setup complete")
+	slog.Debug("Setting up logging...")
+	logging.Setup(cfg.Logging)
+	slog.Debug("Logging setup complete")
 
 	// Create socket client
-	log.Println("Creating client...")
+	slog.Debug("Creating client...")
 	client := client.NewClient(cfg)
-	log.Println("Client created")
+	slog.Debug("Client created")
 
 	// Create context for graceful shutdown
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
 	// Start client
-	log.Println("Starting client...")
+	slog.Debug("Starting client...")
 	if err := client.Start(ctx); err != nil {
 		log.Fatalf("Failed to start client: %v", err)
 	}
@@ -49,7 +81,11 @@ func main() {
 
 	// Show initial status
 	if cfg.WebSocket.Enabled {
-		log.Printf("WebSocket client enabled - attempting to connect to %s", cfg.WebSocket.URL)
+		if len(cfg.WebSocket.URLs) > 0 {
+			log.Printf("WebSocket client enabled - attempting to connect to %s", strings.Join(cfg.WebSocket.URLs, ", "))
+		} else {
+			log.Printf("WebSocket client enabled - attempting to connect to %s", cfg.WebSocket.URL)
+		}
 	} else {
 		log.Println("WebSocket client disabled - running in standalone mode")
 	}
@@ -61,7 +97,11 @@ func main() {
 	log.Println("Socket proxy client is running. Press Ctrl+C to stop.")
 
 	// Status ticker to show connection status
-	statusTicker := time.NewTicker(30 * time.Second)
+	statusInterval := cfg.Logging.StatusInterval
+	if statusInterval <= 0 {
+		statusInterval = 30 * time.Second
+	}
+	statusTicker := time.NewTicker(statusInterval)
 	defer statusTicker.Stop()
 
 	for {
@@ -70,60 +110,29 @@ func main() {
 			log.Println("Shutdown signal received...")
 			goto shutdown
 		case <-statusTicker.C:
-			stats := client.GetStats()
-			log.Printf("stats: %s", stats)
-			log.Printf("Status: Running=%v, WebSocket Connected=%v",
-				stats["running"], stats["url"])
+			stats := client.Stats()
+			slog.Debug("Stats", "stats", stats)
+			log.Printf("Status: Running=%v, Protocol=%s, Connected=%v, Reconnect=%s (reconnects=%d), Commands processed=%d (failed=%d)",
+				stats.Running, stats.Protocol, stats.Connected,
+				stats.ReconnectState, stats.Reconnects, stats.CommandsProcessed, stats.CommandsFailed)
 		}
 	}
 
 shutdown:
 
-	// Graceful shutdown
-	if err := client.Stop(); err != nil {
-		log.Printf("Error during shutdown: %v", err)
+	// Graceful shutdown; a second signal or a stuck Stop exits right away
+	shutdownTimeout := cfg.Commands.ShutdownTimeout
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = defaultShutdownTimeout
 	}
-
-	log.Println("Socket proxy client stopped")
-}
-
-func setupLogging(loggingConfig config.Logging) {
-	// Set log flags
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
-
-	// If log file is specified, create both console and file logging
-	if loggingConfig.File != "" {
-		// Try to open the file
-		file, err := os.OpenFile(loggingConfig.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
-		if err != nil {
-			fmt.Fprintf(os.Stderr, "Error opening log file %s: %v\n", loggingConfig.File, err)
-			fmt.Fprintf(os.Stderr, "Continuing with console logging only...\n")
-			return
-		}
-
-		// Test write to ensure file is writable
-		if _, err := file.WriteString(""); err != nil {
-			fmt.Fprintf(os.Stderr, "Error writing to log file %s: %v\n", loggingConfig.File, err)
-			file.Close()
-			fmt.Fprintf(os.Stderr, "Continuing with console logging only...\n")
-			return
+	if err := stopWithin(client.Stop, shutdownTimeout, sigChan); err != nil {
+		if errors.Is(err, errShutdownTimeout) || errors.Is(err, errForceQuit) {
+			slog.Error("Forcing shutdown", "error", err)
+			cancel()
+			os.Exit(1)
 		}
-
-		// Create a multi-writer that writes to both console and file
-		multiWriter := io.MultiWriter(os.Stdout, file)
-		log.SetOutput(multiWriter)
-		fmt.Fprintf(os.Stderr, "Logging enabled: console + file (%s)\n", loggingConfig.File)
+		slog.Error("Error during shutdown", "error", err)
 	}
 
-	// Set log level if needed (simplified version)
-	switch loggingConfig.Level {
-	case "debug":
-		log.Println("Debug logging enabled")
-	case "info":
-		log.Println("Info logging enabled")
-	case "warn":
-		log.Println("Warning logging enabled")
-	case "error":
-		log.Println("Error logging enabled")
-	}
+	log.Println("Socket proxy client stopped")
 }
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestStopWithinTimesOutOnBlockingStop(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stop := func() error {
+		<-release
+		return nil
+	}
+
+	start := time.Now()
+	err := stopWithin(stop, 50*time.Millisecond, make(chan os.Signal))
+	if !errors.Is(err, errShutdownTimeout) {
+		t.Fatalf("Expected a shutdown timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected to give up after the timeout, took %s", elapsed)
+	}
+}
+
+func TestStopWithinForceQuitsOnSecondSignal(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	stop := func() error {
+		<-release
+		return nil
+	}
+
+	signals := make(chan os.Signal, 1)
+	signals <- syscall.SIGINT
+	if err := stopWithin(stop, time.Minute, signals); !errors.Is(err, errForceQuit) {
+		t.Fatalf("Expected a second signal to force quit, got %v", err)
+	}
+}
+
+func TestStopWithinReturnsStopResult(t *testing.T) {
+	failed := errors.New("disconnect failed")
+	if err := stopWithin(func() error { return failed }, time.Minute, make(chan os.Signal)); err != failed {
+		t.Errorf("Expected Stop's own error, got %v", err)
+	}
+}
@@ -0,0 +1,61 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"testing"
+	"time"
+)
+
+func TestAckArrivesBeforeResponse(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.Ack = true
+	c := NewClient(cfg)
+	tr := &recordingTransport{}
+	c.transport = tr
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		time.Sleep(100 * time.Millisecond)
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": "slow-1"}); resp != nil {
+		t.Fatalf("Expected the command to be queued, got %v", resp)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	events := tr.recorded()
+	if len(events) != 2 || events[0] != "send:command_ack" || events[1] != "send:command_response" {
+		t.Fatalf("Expected command_ack then command_response, got %v", events)
+	}
+	tr.mu.Lock()
+	ack := tr.sent[0]
+	tr.mu.Unlock()
+	payload := ack["payload"].(map[string]interface{})
+	if ack["id"] != "slow-1" || payload["status"] != "accepted" {
+		t.Errorf("Unexpected ack %v", ack)
+	}
+}
+
+func TestAckDisabledByDefault(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	c.handleCommand(map[string]interface{}{"type": "status", "id": "s1"})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if events := tr.recorded(); len(events) != 1 || events[0] != "send:command_response" {
+		t.Errorf("Expected only a command_response, got %v", events)
+	}
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAPICommandsReportUpstreamErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			w.Write([]byte(`{"success": true}`))
+		}))
+
+		cfg := &config.Config{}
+		cfg.APIProxy.BaseURL = server.URL
+		cfg.EnabledCommands.APICall = true
+		cfg.EnabledCommands.HTTPRequest = true
+		c := NewClient(cfg)
+
+		for _, command := range []Command{
+			{Type: "api_call", ID: "api", Payload: map[string]interface{}{"url": "/status", "method": "GET"}},
+			{Type: "http_request", ID: "http", Payload: map[string]interface{}{"url": server.URL + "/status"}},
+		} {
+			resp := c.processCommand(context.Background(), command)
+			if resp.Success {
+				t.Errorf("%s with status %d: expected failure, got success", command.Type, status)
+			}
+			if !strings.Contains(resp.Error, fmt.Sprintf("status %d", status)) {
+				t.Errorf("%s with status %d: expected error to mention the status, got %q", command.Type, status, resp.Error)
+			}
+		}
+		server.Close()
+	}
+}
+
+func TestAPICallReturnsStatusAndHeaders(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Location", "/api/cells/7")
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"success": true, "data": {"id": 7}}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.EnabledCommands.APICall = true
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "api_call",
+		ID:      "create",
+		Payload: map[string]interface{}{"url": "/api/cells", "method": "POST", "body": map[string]interface{}{"number": 7}},
+	})
+	if !resp.Success {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	if resp.StatusCode != http.StatusCreated {
+		t.Errorf("Expected status_code 201, got %d", resp.StatusCode)
+	}
+	if resp.Headers["Location"] != "/api/cells/7" || resp.Headers["Etag"] != `"v1"` {
+		t.Errorf("Expected Location and ETag headers, got %v", resp.Headers)
+	}
+}
+
+func TestAPICommandsMergeQuery(t *testing.T) {
+	queries := make(chan string, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries <- r.URL.RawQuery
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.EnabledCommands.APICall = true
+	cfg.EnabledCommands.HTTPRequest = true
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+	c := NewClient(cfg)
+
+	for _, tt := range []struct {
+		command  Command
+		expected string
+	}{
+		{Command{Type: "api_call", ID: "api", Payload: map[string]interface{}{
+			"url": "/items", "method": "GET", "query": map[string]interface{}{"tag": []interface{}{"a", "b"}},
+		}}, "tag=a&tag=b"},
+		{Command{Type: "http_request", ID: "http", Payload: map[string]interface{}{
+			"url": server.URL + "/items?page=2", "query": map[string]interface{}{"q": "x y"},
+		}}, "page=2&q=x+y"},
+	} {
+		if resp := c.processCommand(context.Background(), tt.command); !resp.Success {
+			t.Fatalf("%s failed: %+v", tt.command.Type, resp)
+		}
+		if got := <-queries; got != tt.expected {
+			t.Errorf("%s: expected query %q, got %q", tt.command.Type, tt.expected, got)
+		}
+	}
+}
+
+func TestAPICallSendsUserAgent(t *testing.T) {
+	var userAgents []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userAgents = append(userAgents, r.Header.Get("User-Agent"))
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	send := func(cfg *config.Config, headers map[string]interface{}) {
+		cfg.APIProxy.BaseURL = server.URL
+		cfg.EnabledCommands.APICall = true
+		payload := map[string]interface{}{"url": "/", "method": "GET"}
+		if headers != nil {
+			payload["headers"] = headers
+		}
+		resp := NewClient(cfg).processCommand(context.Background(), Command{Type: "api_call", ID: "ua", Payload: payload})
+		if !resp.Success {
+			t.Fatalf("api_call failed: %s", resp.Error)
+		}
+	}
+
+	cfg := &config.Config{}
+	cfg.WebSocket.ClientID = "edge-42"
+	send(cfg, nil)
+
+	cfg = &config.Config{}
+	cfg.APIProxy.UserAgent = "fleet-agent/7"
+	send(cfg, nil)
+
+	send(&config.Config{}, map[string]interface{}{"User-Agent": "custom/1.0"})
+
+	want := []string{"edge-agent/" + Version + " (edge-42)", "fleet-agent/7", "custom/1.0"}
+	if strings.Join(userAgents, "|") != strings.Join(want, "|") {
+		t.Errorf("Expected User-Agents %q, got %q", want, userAgents)
+	}
+}
@@ -0,0 +1,93 @@
+package client
+
+import (
+	"edge-agent/internal/local"
+	"edge-agent/internal/logging"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// auditRecord is one line of the audit log: what ran, for whom and how it
+// ended.
+type auditRecord struct {
+	Time       time.Time   `json:"time"`
+	ID         string      `json:"id"`
+	Type       string      `json:"type"`
+	TraceID    string      `json:"trace_id,omitempty"`
+	ClientID   string      `json:"client_id,omitempty"`
+	Protocol   string      `json:"protocol,omitempty"`
+	Connection string      `json:"connection,omitempty"` // server URL the command came from
+	Payload    interface{} `json:"payload,omitempty"`    // sensitive keys redacted
+	Success    bool        `json:"success"`
+	Error      string      `json:"error,omitempty"`
+	ExitCode   *int        `json:"exit_code,omitempty"` // local_command only
+	DurationMs int64       `json:"duration_ms"`
+}
+
+// auditLog appends newline-delimited JSON records to a file. A nil
+// auditLog records nothing.
+type auditLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openAuditLog opens path for appending, creating it readable only by the
+// agent's user.
+func openAuditLog(path string) (*auditLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open audit log: %w", err)
+	}
+	return &auditLog{file: file}, nil
+}
+
+// write appends record as a single line and flushes it to disk.
+func (a *auditLog) write(record auditRecord) {
+	if a == nil {
+		return
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		slog.Error("Failed to encode audit record", "command_id", record.ID, "error", err)
+		return
+	}
+	data = append(data, '\n')
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, err := a.file.Write(data); err != nil {
+		slog.Error("Failed to write audit record", "command_id", record.ID, "error", err)
+		return
+	}
+	a.file.Sync()
+}
+
+// auditCommand records command and its final response. It is deferred by
+// processCommand ahead of recoverCommand, so commands whose handler
+// panicked are recorded with the recovered response.
+func (c *Client) auditCommand(command Command, start time.Time, response *CommandResponse) {
+	if c.audit == nil {
+		return
+	}
+	record := auditRecord{
+		Time:       start.UTC(),
+		ID:         command.ID,
+		Type:       command.Type,
+		TraceID:    command.TraceID,
+		ClientID:   c.config.WebSocket.ClientID,
+		Protocol:   c.protocol,
+		Connection: c.activeURL(),
+		Payload:    logging.Redact(command.Payload),
+		Success:    response.Success,
+		Error:      response.Error,
+		DurationMs: time.Since(start).Milliseconds(),
+	}
+	if result, ok := response.Data.(*local.LocalResult); ok {
+		record.ExitCode = &result.ExitCode
+	}
+	c.audit.write(record)
+}
@@ -0,0 +1,81 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"edge-agent/internal/config"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func readAuditRecords(t *testing.T, path string) []map[string]interface{} {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var records []map[string]interface{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			t.Fatalf("Audit line is not JSON: %q", scanner.Text())
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestEveryProcessedCommandIsAudited(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	cfg := &config.Config{}
+	cfg.Audit.File = path
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.WebSocket.ClientID = "edge-1"
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws://server:9091"
+	c := NewClient(cfg)
+	c.RegisterHandler("explode", func(ctx context.Context, command Command) CommandResponse {
+		panic("boom")
+	})
+
+	c.processCommand(context.Background(), Command{Type: "local_command", ID: "ok", TraceID: "trace-ok", Payload: map[string]interface{}{
+		"command": "exit 2", "password": "hunter2",
+	}})
+	c.processCommand(context.Background(), Command{Type: "explode", ID: "panics"})
+	c.processCommand(context.Background(), Command{Type: "no_such_command", ID: "unknown"})
+
+	records := readAuditRecords(t, path)
+	if len(records) != 3 {
+		t.Fatalf("Expected one record per command, got %d: %v", len(records), records)
+	}
+
+	first := records[0]
+	if first["id"] != "ok" || first["type"] != "local_command" || first["trace_id"] != "trace-ok" {
+		t.Errorf("Unexpected identity fields in %v", first)
+	}
+	if first["client_id"] != "edge-1" || first["protocol"] != "websocket" || first["connection"] != "ws://server:9091" {
+		t.Errorf("Expected the originating connection in %v", first)
+	}
+	if first["success"] != false || first["exit_code"] != float64(2) {
+		t.Errorf("Expected the failed exit code in %v", first)
+	}
+	if _, ok := first["time"].(string); !ok {
+		t.Errorf("Expected a timestamp in %v", first)
+	}
+	payload := first["payload"].(map[string]interface{})
+	if payload["command"] != "exit 2" || payload["password"] != "[REDACTED]" {
+		t.Errorf("Expected the payload with the password redacted, got %v", payload)
+	}
+
+	if records[1]["id"] != "panics" || records[1]["success"] != false || records[1]["error"] == "" {
+		t.Errorf("Expected the panicking command to be audited as failed, got %v", records[1])
+	}
+	if records[2]["id"] != "unknown" || records[2]["success"] != false {
+		t.Errorf("Expected the unknown command to be audited as failed, got %v", records[2])
+	}
+}
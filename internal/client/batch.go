@@ -0,0 +1,140 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// batchOptions is the payload of a batch command. The payload may also be
+// a bare array of sub-commands, run in order with the default options.
+type batchOptions struct {
+	Commands []Command
+	// Concurrent runs every sub-command at once instead of in order
+	Concurrent bool
+	// StopOnError skips the remaining sub-commands after the first
+	// failure; with Concurrent, sub-commands still running are cancelled
+	StopOnError bool
+}
+
+func parseBatch(payload interface{}) (batchOptions, error) {
+	var opts batchOptions
+	var rawCommands interface{}
+	switch p := payload.(type) {
+	case []interface{}:
+		rawCommands = p
+	case map[string]interface{}:
+		rawCommands = p["commands"]
+		opts.Concurrent, _ = p["concurrent"].(bool)
+		opts.StopOnError, _ = p["stop_on_error"].(bool)
+	default:
+		return opts, fmt.Errorf("batch payload must be an array of commands or an object with commands")
+	}
+
+	list, ok := rawCommands.([]interface{})
+	if !ok || len(list) == 0 {
+		return opts, fmt.Errorf("batch has no commands")
+	}
+
+	seen := make(map[string]bool, len(list))
+	for i, raw := range list {
+		sub, ok := raw.(map[string]interface{})
+		if !ok {
+			return opts, fmt.Errorf("batch command %d is not an object", i)
+		}
+		cmdType, _ := sub["type"].(string)
+		cmdID, _ := sub["id"].(string)
+		switch {
+		case cmdType == "":
+			return opts, fmt.Errorf("batch command %d has no type", i)
+		case cmdType == "batch":
+			return opts, fmt.Errorf("batch command %d: batches cannot be nested", i)
+		case cmdID == "":
+			return opts, fmt.Errorf("batch command %d has no id", i)
+		case seen[cmdID]:
+			return opts, fmt.Errorf("batch command id %s is used more than once", cmdID)
+		}
+		seen[cmdID] = true
+		opts.Commands = append(opts.Commands, Command{Type: cmdType, ID: cmdID, Payload: sub["payload"]})
+	}
+	return opts, nil
+}
+
+// handleBatch runs several commands from one message and answers with
+// their responses in the order given. The batch succeeds only if every
+// sub-command does; the whole batch shares one commands.timeout.
+func (c *Client) handleBatch(ctx context.Context, command Command) CommandResponse {
+	opts, err := parseBatch(command.Payload)
+	if err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]CommandResponse, len(opts.Commands))
+	hooks := make([]func(), len(opts.Commands))
+	var failed sync.Once
+	run := func(i int) {
+		sub := opts.Commands[i]
+		if opts.StopOnError && ctx.Err() != nil {
+			results[i] = CommandResponse{ID: sub.ID, Success: false, Error: "skipped: an earlier command in the batch failed"}
+			return
+		}
+		response := c.processCommand(ctx, sub)
+		response.ID = sub.ID
+		hooks[i], response.afterSend = response.afterSend, nil
+		results[i] = response
+		if !response.Success && opts.StopOnError {
+			failed.Do(cancel)
+		}
+	}
+
+	if opts.Concurrent {
+		var wg sync.WaitGroup
+		for i := range opts.Commands {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				run(i)
+			}()
+		}
+		wg.Wait()
+	} else {
+		for i := range opts.Commands {
+			run(i)
+		}
+	}
+
+	succeeded := 0
+	for _, result := range results {
+		if result.Success {
+			succeeded++
+		}
+	}
+	response := CommandResponse{
+		ID:      command.ID,
+		Success: succeeded == len(results),
+		Data: map[string]interface{}{
+			"results":   results,
+			"succeeded": succeeded,
+			"failed":    len(results) - succeeded,
+		},
+	}
+	if !response.Success {
+		response.Error = fmt.Sprintf("%d of %d batch commands failed", len(results)-succeeded, len(results))
+	}
+
+	// Sub-commands such as reboot act only once the batch response is sent
+	if slices.ContainsFunc(hooks, func(hook func()) bool { return hook != nil }) {
+		response.afterSend = func() {
+			for _, hook := range hooks {
+				if hook != nil {
+					hook()
+				}
+			}
+		}
+	}
+	return response
+}
@@ -0,0 +1,177 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func batchPayload(concurrent, stopOnError bool, types ...string) map[string]interface{} {
+	var commands []interface{}
+	for i, cmdType := range types {
+		commands = append(commands, map[string]interface{}{
+			"id":   "sub-" + string(rune('a'+i)),
+			"type": cmdType,
+		})
+	}
+	return map[string]interface{}{
+		"commands":      commands,
+		"concurrent":    concurrent,
+		"stop_on_error": stopOnError,
+	}
+}
+
+func TestBatchSequentialStopsOnFirstError(t *testing.T) {
+	c := NewClient(&config.Config{})
+	var mu sync.Mutex
+	var ran []string
+	handler := func(ok bool) HandlerFunc {
+		return func(ctx context.Context, command Command) CommandResponse {
+			mu.Lock()
+			ran = append(ran, command.ID)
+			mu.Unlock()
+			if !ok {
+				return CommandResponse{ID: command.ID, Success: false, Error: "boom"}
+			}
+			return CommandResponse{ID: command.ID, Success: true}
+		}
+	}
+	c.RegisterHandler("ok", handler(true))
+	c.RegisterHandler("fail", handler(false))
+
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "batch",
+		ID:      "b1",
+		Payload: batchPayload(false, true, "ok", "fail", "ok"),
+	})
+	if resp.Success || resp.ID != "b1" {
+		t.Fatalf("Expected the batch to fail, got %+v", resp)
+	}
+
+	if strings.Join(ran, ",") != "sub-a,sub-b" {
+		t.Errorf("Expected execution to halt after sub-b, ran %v", ran)
+	}
+	data := resp.Data.(map[string]interface{})
+	results := data["results"].([]CommandResponse)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	if !results[0].Success || results[1].Success || results[1].Error != "boom" {
+		t.Errorf("Unexpected results for executed commands: %+v", results[:2])
+	}
+	if results[2].ID != "sub-c" || results[2].Success || !strings.Contains(results[2].Error, "skipped") {
+		t.Errorf("Expected sub-c to be skipped, got %+v", results[2])
+	}
+	if data["succeeded"] != 1 || data["failed"] != 2 {
+		t.Errorf("Expected 1 succeeded and 2 failed, got %v and %v", data["succeeded"], data["failed"])
+	}
+}
+
+func TestBatchConcurrentAggregatesAllResults(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	// Every sub-command waits for all of them to start, which only
+	// completes if they run concurrently
+	var started sync.WaitGroup
+	started.Add(3)
+	c.RegisterHandler("wait", func(ctx context.Context, command Command) CommandResponse {
+		started.Done()
+		started.Wait()
+		return CommandResponse{ID: command.ID, Success: command.ID != "sub-b", Data: command.ID}
+	})
+
+	done := make(chan CommandResponse, 1)
+	go func() {
+		done <- c.processCommand(context.Background(), Command{
+			Type:    "batch",
+			ID:      "b2",
+			Payload: batchPayload(true, false, "wait", "wait", "wait"),
+		})
+	}()
+
+	var resp CommandResponse
+	select {
+	case resp = <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Batch did not run its commands concurrently")
+	}
+
+	results := resp.Data.(map[string]interface{})["results"].([]CommandResponse)
+	if len(results) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(results))
+	}
+	for i, id := range []string{"sub-a", "sub-b", "sub-c"} {
+		if results[i].ID != id || results[i].Success != (id != "sub-b") {
+			t.Errorf("Result %d: expected %s success=%v, got %+v", i, id, id != "sub-b", results[i])
+		}
+	}
+	if resp.Success || !strings.Contains(resp.Error, "1 of 3") {
+		t.Errorf("Expected the batch to report 1 of 3 failed, got %+v", resp)
+	}
+}
+
+func TestBatchRejectsInvalidPayload(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	for name, payload := range map[string]interface{}{
+		"empty":     []interface{}{},
+		"no id":     []interface{}{map[string]interface{}{"type": "status"}},
+		"nested":    []interface{}{map[string]interface{}{"id": "x", "type": "batch"}},
+		"duplicate": []interface{}{map[string]interface{}{"id": "x", "type": "status"}, map[string]interface{}{"id": "x", "type": "status"}},
+	} {
+		resp := c.processCommand(context.Background(), Command{Type: "batch", ID: "bad", Payload: payload})
+		if resp.Success {
+			t.Errorf("%s: expected the batch to be rejected", name)
+		}
+	}
+
+	// A bare array runs in order with default options
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "batch",
+		ID:      "b3",
+		Payload: []interface{}{map[string]interface{}{"id": "s1", "type": "status"}},
+	})
+	if !resp.Success {
+		t.Errorf("Expected a bare array batch to succeed, got %+v", resp)
+	}
+}
+
+func TestBatchRebootRunsAfterBatchResponseIsSent(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.Reboot = true
+	cfg.Reboot.Command = "shutdown -r +5"
+	c := NewClient(cfg)
+
+	tr := &recordingTransport{}
+	c.transport = tr
+	rebooted := make(chan string, 1)
+	c.reboot = func(command string) error {
+		tr.record("reboot")
+		rebooted <- command
+		return nil
+	}
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	if resp := c.handleCommand(map[string]interface{}{
+		"type":    "batch",
+		"id":      "b-reboot",
+		"payload": batchPayload(false, false, "status", "reboot"),
+	}); resp != nil {
+		t.Fatalf("Expected the batch to be queued, got immediate response %v", resp)
+	}
+
+	select {
+	case <-rebooted:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reboot inside the batch was not executed")
+	}
+
+	events := tr.recorded()
+	if len(events) != 2 || events[0] != "send:command_response" || events[1] != "reboot" {
+		t.Errorf("Expected the batch response to be sent before rebooting, got %v", events)
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"log"
+)
+
+// trackCommand registers cancel under the command ID so a later cancel
+// command can stop it. It reports false if a command with that ID is
+// already running.
+func (c *Client) trackCommand(id string, cancel context.CancelFunc) bool {
+	c.commandsMux.Lock()
+	defer c.commandsMux.Unlock()
+
+	if _, exists := c.commands[id]; exists {
+		return false
+	}
+	c.commands[id] = cancel
+	return true
+}
+
+func (c *Client) untrackCommand(id string) {
+	c.commandsMux.Lock()
+	defer c.commandsMux.Unlock()
+	delete(c.commands, id)
+}
+
+// handleCancel cancels the running command named by the payload's "id".
+func (c *Client) handleCancel(ctx context.Context, command Command) CommandResponse {
+	payload, _ := command.Payload.(map[string]interface{})
+	target, _ := payload["id"].(string)
+	if target == "" {
+		return CommandResponse{ID: command.ID, Success: false, Error: "id of the command to cancel is required"}
+	}
+
+	c.commandsMux.Lock()
+	cancel, found := c.commands[target]
+	c.commandsMux.Unlock()
+
+	if found {
+		log.Printf("Cancelling command %s", target)
+		cancel()
+	}
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"id":        target,
+			"cancelled": found,
+		},
+	}
+}
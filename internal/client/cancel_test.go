@@ -0,0 +1,123 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCancelRunningCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+
+	done := make(chan CommandResponse, 1)
+	start := time.Now()
+	go func() {
+		done <- c.processWithDeadline(Command{Type: "local_command", ID: "long", Payload: map[string]interface{}{"command": "sleep 60", "timeout": "2m"}})
+	}()
+
+	// Wait for the command to be registered before cancelling it
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c.commandsMux.Lock()
+		_, running := c.commands["long"]
+		c.commandsMux.Unlock()
+		if running {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Command was never registered as running")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp := c.processWithDeadline(Command{Type: "cancel", ID: "stop", Payload: map[string]interface{}{"id": "long"}})
+	data, _ := resp.Data.(map[string]interface{})
+	if !resp.Success || data["cancelled"] != true {
+		t.Fatalf("Expected the command to be cancelled, got %+v", resp)
+	}
+
+	select {
+	case original := <-done:
+		if original.Success || !strings.Contains(original.Error, "cancelled") {
+			t.Errorf("Expected a cancellation error, got %+v", original)
+		}
+		if elapsed := time.Since(start); elapsed > 5*time.Second {
+			t.Errorf("Cancelled command took %s to return", elapsed)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Cancelled command did not return")
+	}
+
+	c.commandsMux.Lock()
+	remaining := len(c.commands)
+	c.commandsMux.Unlock()
+	if remaining != 0 {
+		t.Errorf("Expected no tracked commands after completion, got %d", remaining)
+	}
+}
+
+func TestCancelUnknownCommand(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	resp := c.processWithDeadline(Command{Type: "cancel", ID: "stop", Payload: map[string]interface{}{"id": "missing"}})
+	data, _ := resp.Data.(map[string]interface{})
+	if !resp.Success || data["cancelled"] != false {
+		t.Errorf("Expected cancelled=false for an unknown command, got %+v", resp)
+	}
+
+	resp = c.processWithDeadline(Command{Type: "cancel", ID: "stop", Payload: map[string]interface{}{}})
+	if resp.Success {
+		t.Errorf("Expected an error without a target id, got %+v", resp)
+	}
+}
+
+func TestCommandWithoutIDIsRejected(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	resp := c.processCommand(context.Background(), Command{Type: "status"})
+	if resp.Success || !strings.Contains(resp.Error, "no id") {
+		t.Errorf("Expected a command without id to be rejected, got %+v", resp)
+	}
+}
+
+func TestDuplicateInFlightIDIsRejected(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		close(started)
+		<-release
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+
+	first := make(chan CommandResponse, 1)
+	go func() {
+		first <- c.processWithDeadline(Command{Type: "slow", ID: "dup"})
+	}()
+	<-started
+
+	resp := c.processWithDeadline(Command{Type: "status", ID: "dup"})
+	if resp.Success || !strings.Contains(resp.Error, "already in flight") {
+		t.Errorf("Expected the duplicate id to be rejected, got %+v", resp)
+	}
+
+	close(release)
+	if resp := <-first; !resp.Success {
+		t.Errorf("Expected the original command to complete, got %+v", resp)
+	}
+
+	// Once the original finishes its id can be reused
+	if resp := c.processWithDeadline(Command{Type: "status", ID: "dup"}); !resp.Success {
+		t.Errorf("Expected the id to be free again, got %+v", resp)
+	}
+}
@@ -0,0 +1,149 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/transport"
+	"fmt"
+	"os"
+	"runtime"
+	"sort"
+)
+
+// builtinCommands are the command types processCommand handles itself.
+var builtinCommands = []string{
+	"api_call",
+	"http_request",
+	"local_command",
+	"interactive_shell_start",
+	"shell_input",
+	"shell_resize",
+	"quick_command",
+	"custom",
+	"snapshot_state",
+	"restore_state",
+	"output_fetch",
+	"heartbeat_history",
+	"cancel",
+	"status",
+	"capabilities",
+	"metrics",
+	"reboot",
+	"file_list",
+	"file_download",
+	"file_upload",
+	"file_delete",
+	"batch",
+}
+
+// ErrCodeCommandDisabled is the ErrorCode of a response refusing a command
+// type that the configuration disables.
+const ErrCodeCommandDisabled = "command_disabled"
+
+// commandEnabled reports whether the configuration, narrowed by any
+// runtime overrides, allows cmdType to run. The gate applies to registered
+// handlers as well as built-in ones.
+func (c *Client) commandEnabled(cmdType string) bool {
+	switch cmdType {
+	case "api_call", "http_request":
+		return c.gateEnabled(cmdType)
+	case "local_command", "interactive_shell_start", "shell_input", "shell_resize":
+		return c.gateEnabled("local_command")
+	case "reboot":
+		return c.config.EnabledCommands.Reboot
+	case "file_list", "file_download", "file_upload", "file_delete":
+		return c.gateEnabled("file_manager")
+	}
+	return true
+}
+
+// disabledError explains why a command of cmdType was refused.
+func disabledError(cmdType string) string {
+	switch cmdType {
+	case "interactive_shell_start", "shell_input", "shell_resize":
+		return "interactive_shell is disabled (depends on local_command)"
+	case "file_list", "file_download", "file_upload", "file_delete":
+		return "File manager is disabled"
+	}
+	return fmt.Sprintf("%s commands are disabled", cmdType)
+}
+
+// capabilities lists the command types the agent currently accepts:
+// enabled built-in commands followed by registered ones.
+func (c *Client) capabilities() []string {
+	var enabled []string
+	builtin := make(map[string]bool, len(builtinCommands))
+	for _, cmdType := range builtinCommands {
+		builtin[cmdType] = true
+		if c.commandEnabled(cmdType) {
+			enabled = append(enabled, cmdType)
+		}
+	}
+
+	for _, cmdType := range c.registeredTypes() {
+		if !builtin[cmdType] && c.commandEnabled(cmdType) {
+			enabled = append(enabled, cmdType)
+		}
+	}
+	return enabled
+}
+
+// registeredTypes returns the command types added with RegisterHandler.
+func (c *Client) registeredTypes() []string {
+	c.handlersMux.RLock()
+	types := make([]string, 0, len(c.handlers))
+	for cmdType := range c.handlers {
+		types = append(types, cmdType)
+	}
+	c.handlersMux.RUnlock()
+	sort.Strings(types)
+	return types
+}
+
+// handleCapabilities reports every command type the agent knows with
+// whether it is enabled, so a server need not probe for disabled ones.
+func (c *Client) handleCapabilities(ctx context.Context, command Command) CommandResponse {
+	registered := c.registeredTypes()
+	commands := make(map[string]bool, len(builtinCommands)+len(registered))
+	for _, cmdType := range builtinCommands {
+		commands[cmdType] = c.commandEnabled(cmdType)
+	}
+	for _, cmdType := range registered {
+		commands[cmdType] = c.commandEnabled(cmdType)
+	}
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"commands": commands,
+			"handlers": registered,
+		},
+	}
+}
+
+// identity describes the agent for the identification message.
+func (c *Client) identity() transport.Identity {
+	hostname, _ := os.Hostname()
+
+	// Stats are included so the server has them before the first heartbeat
+	metadata := map[string]interface{}{
+		"hostname": hostname,
+		"os":       runtime.GOOS,
+		"arch":     runtime.GOARCH,
+		"version":  Version,
+	}
+	for k, v := range c.GetStats() {
+		metadata["stats_"+k] = v
+	}
+
+	return transport.Identity{
+		Version:      Version,
+		OS:           runtime.GOOS,
+		Arch:         runtime.GOARCH,
+		Hostname:     hostname,
+		Capabilities: c.capabilities(),
+
+		RegistrationToken: c.config.WebSocket.RegistrationToken,
+		Metadata:          metadata,
+	}
+}
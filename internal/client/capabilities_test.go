@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCapabilitiesCommand(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+	c.RegisterHandler("open_cell", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+
+	resp := c.processCommand(context.Background(), Command{Type: "capabilities", ID: "caps-1"})
+	if !resp.Success {
+		t.Fatalf("Expected capabilities to succeed, got %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	commands := data["commands"].(map[string]bool)
+
+	expected := map[string]bool{
+		"local_command": true,
+		"shell_input":   true,
+		"status":        true,
+		"capabilities":  true,
+		"open_cell":     true,
+		"api_call":      false,
+		"http_request":  false,
+		"reboot":        false,
+		"file_list":     false,
+	}
+	for cmdType, enabled := range expected {
+		got, ok := commands[cmdType]
+		if !ok || got != enabled {
+			t.Errorf("Expected %s enabled=%v, got %v (present %v)", cmdType, enabled, got, ok)
+		}
+	}
+	if handlers := data["handlers"].([]string); !reflect.DeepEqual(handlers, []string{"open_cell"}) {
+		t.Errorf("Expected handlers [open_cell], got %v", handlers)
+	}
+}
+
+func TestDisabledCommandErrorCode(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	for _, cmdType := range []string{"api_call", "shell_input", "file_delete"} {
+		resp := c.processCommand(context.Background(), Command{Type: cmdType, ID: "d1"})
+		if resp.Success || resp.ErrorCode != ErrCodeCommandDisabled {
+			t.Errorf("Expected %s to be refused with %q, got %+v", cmdType, ErrCodeCommandDisabled, resp)
+		}
+	}
+
+	raw, err := json.Marshal(c.processCommand(context.Background(), Command{Type: "reboot", ID: "r1"}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded map[string]interface{}
+	json.Unmarshal(raw, &decoded)
+	if decoded["error_code"] != "command_disabled" {
+		t.Errorf("Expected error_code in the response JSON, got %s", raw)
+	}
+
+	// Other failures carry no code
+	resp := c.processCommand(context.Background(), Command{Type: "no_such_command", ID: "u1"})
+	if resp.ErrorCode != "" {
+		t.Errorf("Expected no error code for an unknown command, got %q", resp.ErrorCode)
+	}
+}
@@ -2,28 +2,38 @@ package client
 
 import (
 	"context"
+	"crypto/sha256"
 	"edge-agent/internal/config"
 	"edge-agent/internal/filemanager"
+	agentgrpc "edge-agent/internal/grpc"
+	"edge-agent/internal/health"
 	"edge-agent/internal/local"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/metrics"
+	"edge-agent/internal/mqtt"
+	"edge-agent/internal/output"
 	"edge-agent/internal/proxy"
+	"edge-agent/internal/scheduler"
 	"edge-agent/internal/tcp"
+	"edge-agent/internal/transport"
 	"edge-agent/internal/websocket"
 	"encoding/base64"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"io"
 	"log"
-	"math"
+	"log/slog"
 	"os"
 	"os/exec"
 	"path/filepath"
-	"runtime"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/creack/pty"
-	"github.com/shirou/gopsutil/v3/cpu"
-	"github.com/shirou/gopsutil/v3/disk"
-	"github.com/shirou/gopsutil/v3/mem"
 )
 
 type PTYSession struct {
@@ -36,6 +46,9 @@ type Command struct {
 	Type    string      `json:"type"`
 	Payload interface{} `json:"payload"`
 	ID      string      `json:"id"`
+	// TraceID correlates the command with the upstream calls it makes;
+	// it is the message's trace_id, or the command ID when none is given.
+	TraceID string `json:"trace_id,omitempty"`
 }
 
 type CommandResponse struct {
@@ -43,19 +56,98 @@ type CommandResponse struct {
 	ID      string      `json:"id"`
 	Error   string      `json:"error,omitempty"`
 	Success bool        `json:"success"`
+	// ErrorCode classifies some failures so the server can branch on
+	// them without parsing Error, e.g. "command_disabled".
+	ErrorCode string `json:"error_code,omitempty"`
+	TraceID   string `json:"trace_id,omitempty"`
+
+	// Set for api_call and http_request from the upstream response
+	StatusCode int               `json:"status_code,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"`
+
+	// afterSend, if set, runs once the response has been sent to the server
+	afterSend func()
 }
 
 type Client struct {
 	config      *config.Config
 	apiClient   *proxy.APIClient
-	wsClient    *websocket.WSClient
-	tcpClient   *tcp.TCPClient
-	protocol    string // "websocket" or "tcp"
+	transport   transport.Transport
+	protocol    string // "websocket", "tcp", "mqtt" or "grpc"
 	runningMux  sync.Mutex
 	running     bool
+	startedAt   time.Time
+	draining    bool            // set by Stop; new commands are refused
+	inflight    *sync.WaitGroup // accepted commands whose response is not sent yet
+	reconnects  atomic.Int64    // successful redials since start
+	health      *health.Server  // nil unless health.addr is set
 	ptySessions map[string]*PTYSession
 	ptyMux      sync.Mutex
 	fileMgr     filemanager.FileManager
+	outputStore *output.Store
+	localPolicy *local.Policy
+	localSlots  chan struct{} // bounds running local_command processes; nil = no limit
+	metrics     *metrics.CommandMetrics
+	scheduler   *scheduler.Scheduler
+	priorities  map[string]scheduler.Priority
+	rateLimits  map[string]*tokenBucket
+	dedup       *dedupCache // nil when commands.dedup_ttl is negative
+	signer      *signer     // nil when commands.signing_secret is empty
+	outbox      *outbox     // responses waiting for the connection to come back
+	audit       *auditLog   // nil when audit.file is empty
+
+	// systemMetrics collects device telemetry for the metrics command
+	systemMetrics metrics.SystemCollector
+	// instruments are the Prometheus metrics served on /metrics
+	instruments *instruments
+
+	// activity counters reported by Stats; failures are counted once the
+	// final response is known, so timeouts are included
+	commandsTotal  atomic.Uint64
+	commandsFailed atomic.Uint64
+	lastCommandAt  atomic.Int64 // unix nanoseconds, 0 before the first command
+
+	// connection history reported by Stats
+	lastConnectedAt    time.Time
+	lastDisconnectedAt time.Time
+	lastError          string
+	reconnectAttempts  int64
+	connMux            sync.Mutex
+
+	// listeners are registered with OnEvent
+	listeners    []func(Event)
+	listenersMux sync.RWMutex
+
+	// lastHook is closed when the most recently queued hook has run
+	lastHook chan struct{}
+	hooksMux sync.Mutex
+
+	// commands holds the cancel function of each running command by ID;
+	// delayed holds those of commands still waiting for their schedule
+	commands    map[string]context.CancelFunc
+	delayed     map[string]context.CancelFunc
+	commandsMux sync.Mutex
+
+	// handlers are commands registered with RegisterHandler
+	handlers    map[string]HandlerFunc
+	handlersMux sync.RWMutex
+
+	// process dispatches a command to its handler; tests swap it out
+	process func(ctx context.Context, command Command) CommandResponse
+	// reboot runs the configured reboot command; tests swap it out
+	reboot func(command string) error
+
+	heartbeatHook    HeartbeatHook
+	heartbeatSeq     uint64
+	missedHeartbeats int // consecutive heartbeats not acked within heartbeat.ack_timeout
+	heartbeats       []HeartbeatRecord
+	heartbeatMux     sync.Mutex
+
+	disabledCommands map[string]bool // runtime gates turned off by SetCommandEnabled
+	logLevel         string
+	reconnect        transport.ReconnectConfig
+	snapshots        map[string]RuntimeState
+	stateMux         sync.RWMutex
 }
 
 func NewClient(cfg *config.Config) *Client {
@@ -64,53 +156,254 @@ func NewClient(cfg *config.Config) *Client {
 		apiClient:   proxy.NewAPIClient(cfg),
 		protocol:    cfg.WebSocket.Protocol,
 		ptySessions: make(map[string]*PTYSession),
+		snapshots:   make(map[string]RuntimeState),
+		commands:    make(map[string]context.CancelFunc),
+		delayed:     make(map[string]context.CancelFunc),
+		handlers:    make(map[string]HandlerFunc),
+		inflight:    &sync.WaitGroup{},
+		metrics:     metrics.NewCommandMetrics(cfg.Metrics.CommandTypes),
+		priorities:  typePriorities(cfg.Scheduler.Priorities),
+		rateLimits:  newRateLimiters(cfg.RateLimits),
+		dedup:       newDedupCache(cfg.Commands.DedupTTL, cfg.Commands.DedupMaxEntries),
+		signer:      newSigner(cfg.Commands.SigningSecret, cfg.Commands.DedupTTL),
+		outbox:      newOutbox(cfg.Commands.OutboxSize, cfg.Commands.OutboxDir),
+
+		systemMetrics: metrics.NewSystemCollector(),
+		instruments:   newInstruments(metrics.NewRegistry()),
+
+		disabledCommands: make(map[string]bool),
+		logLevel:         cfg.Logging.Level,
+		reconnect: transport.ReconnectConfig{
+			Enabled:           cfg.WebSocket.Reconnect.Enabled,
+			MaxAttempts:       cfg.WebSocket.Reconnect.MaxAttempts,
+			InitialDelay:      cfg.WebSocket.Reconnect.InitialDelay,
+			MaxDelay:          cfg.WebSocket.Reconnect.MaxDelay,
+			BackoffMultiplier: cfg.WebSocket.Reconnect.BackoffMultiplier,
+		},
+	}
+	client.apiClient.SetUserAgent(fmt.Sprintf("edge-agent/%s (%s)", Version, cfg.WebSocket.ClientID))
+
+	workers := cfg.Scheduler.Workers
+	if workers <= 0 {
+		workers = defaultWorkers
+	}
+	client.scheduler = scheduler.New(workers)
+	if cfg.Scheduler.MaxQueueDepth > 0 {
+		overflow := scheduler.Block
+		if cfg.Scheduler.Overflow != "" {
+			var err error
+			if overflow, err = scheduler.ParseOverflow(cfg.Scheduler.Overflow); err != nil {
+				slog.Warn("using block overflow policy", "error", err)
+			}
+		}
+		client.scheduler.SetLimit(cfg.Scheduler.MaxQueueDepth, overflow)
 	}
+	client.process = client.processCommand
+	client.reboot = client.runRebootCommand
+	client.OnEvent(client.connectionHooks)
 
 	// Initialize file manager if configured and enabled
 	if cfg.FileManager.Enabled && cfg.FileManager.BasePath != "" {
-		fm, err := filemanager.NewFileManager(filemanager.Config{BasePath: cfg.FileManager.BasePath})
+		fm, err := filemanager.NewFileManager(filemanager.Config{
+			BasePath:     cfg.FileManager.BasePath,
+			MaxFileBytes: cfg.FileManager.MaxFileBytes,
+		})
 		if err != nil {
-			log.Printf("Warning: Failed to initialize file manager: %v", err)
+			slog.Warn("Failed to initialize file manager", "error", err)
 		} else {
 			client.fileMgr = fm
 		}
 	}
 
+	policy, err := local.NewPolicy(cfg.Local.AllowedCommands, cfg.Local.DeniedPatterns)
+	if err != nil {
+		slog.Warn("local command policy is invalid, denying all local commands", "error", err)
+	}
+	policy.AllowEnv(cfg.Local.AllowedEnv)
+	client.localPolicy = policy
+	client.localSlots = newLocalSlots(cfg.Local.MaxConcurrent)
+	switch cfg.Local.WhenBusy {
+	case "", "queue", "reject":
+	default:
+		slog.Warn("invalid local.when_busy, queueing local commands", "when_busy", cfg.Local.WhenBusy)
+	}
+
+	if cfg.Audit.File != "" {
+		audit, err := openAuditLog(cfg.Audit.File)
+		if err != nil {
+			slog.Error("Commands will not be audited", "file", cfg.Audit.File, "error", err)
+		} else {
+			client.audit = audit
+		}
+	}
+
+	// Initialize output store if configured
+	if cfg.CommandOutput.StoreDir != "" {
+		store, err := output.NewStore(output.Config{
+			Dir:            cfg.CommandOutput.StoreDir,
+			InlineMaxBytes: cfg.CommandOutput.InlineMaxBytes,
+			MaxAge:         cfg.CommandOutput.MaxAge,
+			MaxTotalBytes:  cfg.CommandOutput.MaxTotalBytes,
+		})
+		if err != nil {
+			slog.Warn("Failed to initialize output store", "error", err)
+		} else {
+			client.outputStore = store
+		}
+	}
+
 	// Initialize client if enabled
 	if cfg.WebSocket.Enabled {
-		if cfg.WebSocket.Protocol == "tcp" {
-			client.tcpClient = tcp.NewTCPClient()
-		} else {
-			client.wsClient = websocket.NewWSClient()
+		reconnect := client.reconnectConfig()
+		switch cfg.WebSocket.Protocol {
+		case "tcp":
+			tcpClient := tcp.NewTCPClient(cfg.TCP.MaxMessageBytes, reconnect)
+			tcpClient.SetConnectTimeout(cfg.TCP.ConnectTimeout)
+			tcpClient.SetIdentifyTimeout(cfg.WebSocket.IdentifyTimeout)
+			client.transport = tcpClient
+		case "mqtt":
+			client.transport = mqtt.NewMQTTClient(mqtt.Config{
+				CommandTopic:  cfg.MQTT.CommandTopic,
+				ResponseTopic: cfg.MQTT.ResponseTopic,
+				QoS:           byte(cfg.MQTT.QoS),
+				Username:      cfg.MQTT.Username,
+				Password:      cfg.MQTT.Password,
+
+				ConnectTimeout: cfg.MQTT.ConnectTimeout,
+			}, reconnect)
+		case "grpc":
+			grpcClient := agentgrpc.NewGRPCClient(reconnect)
+			grpcClient.SetConnectTimeout(cfg.GRPC.ConnectTimeout)
+			client.transport = grpcClient
+		default:
+			ws := websocket.NewWSClient(reconnect)
+			ws.SetSendConfig(sendConfig(cfg))
+			ws.SetConnectTimeout(cfg.WebSocket.ConnectTimeout)
+			ws.SetIdentifyTimeout(cfg.WebSocket.IdentifyTimeout)
+			client.transport = ws
+		}
+		if urls := endpoints(cfg); len(urls) > 1 {
+			addresses := make([]string, len(urls))
+			for i, url := range urls {
+				addresses[i] = connectionAddress(cfg.WebSocket.Protocol, url)
+			}
+			client.transport = transport.NewFailover(client.transport, addresses, reconnect)
 		}
 	}
 
 	return client
 }
 
+// endpoints returns the configured server URLs in order of preference:
+// websocket.urls if set, otherwise websocket.url.
+func endpoints(cfg *config.Config) []string {
+	if len(cfg.WebSocket.URLs) > 0 {
+		return cfg.WebSocket.URLs
+	}
+	if cfg.WebSocket.URL != "" {
+		return []string{cfg.WebSocket.URL}
+	}
+	return nil
+}
+
+// connectionAddress converts a configured URL into the address the
+// transport dials.
+func connectionAddress(protocol, url string) string {
+	// Extract host:port from URL for TCP connections
+	if protocol == "tcp" {
+		// Remove ws:// or wss:// prefix for TCP
+		if len(url) > 5 && url[:5] == "ws://" {
+			return url[5:]
+		} else if len(url) > 6 && url[:6] == "wss://" {
+			return url[6:]
+		}
+	}
+	return url
+}
+
+// activeURL returns the endpoint the transport is connected to, or was
+// last connected to when failing over between several.
+func (c *Client) activeURL() string {
+	if failover, ok := c.transport.(*transport.Failover); ok {
+		return failover.ActiveEndpoint()
+	}
+	if urls := endpoints(c.config); len(urls) > 0 {
+		return connectionAddress(c.protocol, urls[0])
+	}
+	return ""
+}
+
+func (c *Client) reconnectConfig() transport.ReconnectConfig {
+	c.stateMux.RLock()
+	reconnect := c.reconnect
+	c.stateMux.RUnlock()
+	reconnect.OnDialError = c.recordConnectionError
+	return reconnect
+}
+
+// sendConfig builds the WebSocket backpressure policies, keeping the
+// default for any policy that does not parse.
+func sendConfig(cfg *config.Config) websocket.SendConfig {
+	send := websocket.DefaultSendConfig()
+	if cfg.WebSocket.Send.Timeout > 0 {
+		send.Timeout = cfg.WebSocket.Send.Timeout
+	}
+	for _, p := range []struct {
+		key, name string
+		policy    *websocket.SendPolicy
+	}{
+		{"response_policy", cfg.WebSocket.Send.ResponsePolicy, &send.ResponsePolicy},
+		{"heartbeat_policy", cfg.WebSocket.Send.HeartbeatPolicy, &send.HeartbeatPolicy},
+	} {
+		if p.name == "" {
+			continue
+		}
+		policy, err := websocket.ParseSendPolicy(p.name)
+		if err != nil {
+			slog.Warn("ignoring websocket send policy", "key", p.key, "error", err)
+			continue
+		}
+		*p.policy = policy
+	}
+	return send
+}
+
 func (c *Client) Start(ctx context.Context) error {
+	if c.isRunning() {
+		return fmt.Errorf("client is already running")
+	}
+	if err := c.runHooks(ctx, "on_start", c.config.Hooks.OnStart); err != nil && c.config.Hooks.FailOnError {
+		return fmt.Errorf("on_start hook failed: %w", err)
+	}
+
 	c.runningMux.Lock()
 	if c.running {
 		c.runningMux.Unlock()
 		return fmt.Errorf("client is already running")
 	}
+	if err := c.startHealth(); err != nil {
+		c.runningMux.Unlock()
+		return err
+	}
 	c.running = true
+	c.startedAt = time.Now()
+	c.draining = false
+	c.inflight = &sync.WaitGroup{}
 	c.runningMux.Unlock()
 
 	log.Println("Starting socket proxy client...")
 
+	c.scheduler.Start()
+
 	// Start client if enabled
 	if c.config.WebSocket.Enabled {
 		// Set command handler
-		if c.protocol == "tcp" && c.tcpClient != nil {
-			c.tcpClient.SetCommandHandler(c.handleTCPCommand)
-		} else if c.wsClient != nil {
-			c.wsClient.SetCommandHandler(c.handleWebSocketCommand)
-		}
+		c.transport.SetHandler(c.handleCommand)
+		c.transport.SetIdentityProvider(c.identity)
 		log.Printf("Connecting using ClientID: %s", c.config.WebSocket.ClientID)
 		go c.startConnectionClient(ctx)
 	} else {
-		log.Println("Warning: Connection client is disabled, running in standalone mode")
+		slog.Warn("Connection client is disabled, running in standalone mode")
 	}
 
 	return nil
@@ -123,20 +416,43 @@ func (c *Client) Stop() error {
 		return nil
 	}
 	c.running = false
+	c.draining = true
+	inflight := c.inflight
 	c.runningMux.Unlock()
 
-	if c.wsClient != nil {
-		c.wsClient.Disconnect()
-	}
-	if c.tcpClient != nil {
-		c.tcpClient.Disconnect()
+	// Scheduled commands only fire while the agent runs
+	c.cancelDelayed()
+
+	// Let in-flight commands deliver their responses before disconnecting
+	c.drain(inflight)
+
+	if c.transport != nil {
+		c.transport.Disconnect()
 	}
+	c.scheduler.Stop()
+	c.stopHealth()
 
 	log.Println("Socket proxy client stopped")
 	return nil
 }
 
-func (c *Client) handleTCPCommand(message map[string]interface{}) map[string]interface{} {
+func (c *Client) isRunning() bool {
+	c.runningMux.Lock()
+	defer c.runningMux.Unlock()
+	return c.running
+}
+
+func (c *Client) handleCommand(message map[string]interface{}) map[string]interface{} {
+	if err := c.signer.verify(message); err != nil {
+		cmdID, _ := message["id"].(string)
+		slog.Warn("Rejecting command: signature check failed", "command_id", cmdID, "type", message["type"], "error", err)
+		return c.signer.sign(commandResponseMessage(CommandResponse{ID: cmdID, Success: false, Error: err.Error()}))
+	}
+	return c.signer.sign(c.compressResponse(c.receiveCommand(message)))
+}
+
+// receiveCommand handles a message whose signature, if required, checked out.
+func (c *Client) receiveCommand(message map[string]interface{}) map[string]interface{} {
 	// Extract command type and ID
 	cmdType, _ := message["type"].(string)
 	cmdID, _ := message["id"].(string)
@@ -148,255 +464,277 @@ func (c *Client) handleTCPCommand(message map[string]interface{}) map[string]int
 		Payload: payload,
 		ID:      cmdID,
 	}
+	if traceID, _ := message["trace_id"].(string); traceID != "" {
+		command.TraceID = traceID
+	} else {
+		command.TraceID = cmdID
+	}
 
-	// Process the command
-	ctx := context.Background()
-	response := c.processCommand(ctx, command)
+	if cmdType == "heartbeat_ack" {
+		if !c.recordHeartbeatAck(cmdID, time.Now()) {
+			slog.Warn("Ack for unknown heartbeat", "command_id", cmdID)
+		}
+		return nil
+	}
 
-	fmt.Printf("tcp processCommand %+v\n", response)
+	if cached, duplicate := c.dedup.begin(cmdID); duplicate {
+		if cached == nil {
+			slog.Warn("Duplicate command is still running, ignoring", "command_id", cmdID, "type", cmdType)
+			return nil
+		}
+		slog.Warn("Duplicate command, resending cached response", "command_id", cmdID, "type", cmdType)
+		return commandResponseMessage(*cached)
+	}
 
-	// Convert response back to map
-	return map[string]interface{}{
-		"type":    "command_response",
-		"payload": response,
-		"id":      response.ID,
-		"success": response.Success,
+	if raw, exists := message["schedule"]; exists && raw != nil {
+		delay, err := parseSchedule(raw, time.Now())
+		if err != nil {
+			c.dedup.forget(cmdID)
+			return commandResponseMessage(CommandResponse{ID: cmdID, Success: false, Error: err.Error()})
+		}
+		if delay > 0 {
+			return c.scheduleCommand(message, command, delay)
+		}
 	}
+
+	return c.dispatchCommand(message, command)
 }
 
-func (c *Client) handleWebSocketCommand(message websocket.WSMessage) websocket.WSMessage {
-	// Convert WebSocket message to internal Command format
-	command := Command{
-		Type:    message.Type,
-		Payload: message.Payload,
-		ID:      message.ID,
+// dispatchCommand runs an inline command right away or queues it on the
+// scheduler. A non-nil return value is the response to send back now.
+func (c *Client) dispatchCommand(message map[string]interface{}, command Command) map[string]interface{} {
+	cmdType, cmdID := command.Type, command.ID
+
+	inflight, ok := c.acceptCommand()
+	if !ok {
+		c.dedup.forget(cmdID)
+		return commandResponseMessage(CommandResponse{ID: cmdID, Success: false, Error: "agent is shutting down"})
 	}
 
-	// Process the command
-	ctx := context.Background()
-	response := c.processCommand(ctx, command)
+	if inlineCommands[cmdType] {
+		defer inflight.Done()
+		response := c.runCommand(command)
+		c.dedup.complete(cmdID, response)
+		return commandResponseMessage(response)
+	}
 
-	fmt.Printf("ws processCommand %+v\n", response)
+	priority, err := c.commandPriority(message)
+	if err != nil {
+		inflight.Done()
+		c.dedup.forget(cmdID)
+		return commandResponseMessage(CommandResponse{ID: cmdID, Success: false, Error: err.Error()})
+	}
+
+	// Let the server know the command arrived before it waits in the queue
+	c.sendAck(command, priority)
+
+	// Queue the command; its response is sent once a worker has run it.
+	// If a full queue later evicts it, the server is told it was busy.
+	err = c.scheduler.SubmitOrDrop(priority, func() {
+		defer inflight.Done()
+		response := c.runCommand(command)
+		c.dedup.complete(cmdID, response)
+		c.sendResponse(commandResponseMessage(response))
+		if response.afterSend != nil {
+			response.afterSend()
+		}
+	}, func() {
+		defer inflight.Done()
+		c.dedup.forget(cmdID)
+		c.sendResponse(c.busyResponse(command))
+	})
+	if errors.Is(err, scheduler.ErrQueueFull) {
+		inflight.Done()
+		c.dedup.forget(cmdID)
+		return c.busyResponse(command)
+	}
+	if err != nil {
+		inflight.Done()
+		c.dedup.forget(cmdID)
+		return commandResponseMessage(CommandResponse{ID: cmdID, Success: false, Error: err.Error()})
+	}
+	return nil
+}
+
+// busyResponse refuses a command that the full queue had no room for.
+func (c *Client) busyResponse(command Command) map[string]interface{} {
+	slog.Warn("Command queue full, refusing command", "command_id", command.ID, "type", command.Type)
+	return commandResponseMessage(CommandResponse{
+		ID:      command.ID,
+		Success: false,
+		Error:   errServerBusy,
+		TraceID: command.TraceID,
+	})
+}
+
+// runCommand processes command under the command deadline.
+func (c *Client) runCommand(command Command) CommandResponse {
+	start := time.Now()
+	response := c.processWithDeadline(command)
+	elapsed := time.Since(start)
+	if response.TraceID == "" {
+		response.TraceID = command.TraceID
+	}
+	c.observeCommand(command.Type, response.Success, elapsed)
+	c.emit(Event{
+		Type:        EventCommandCompleted,
+		CommandID:   command.ID,
+		CommandType: command.Type,
+		Success:     response.Success,
+		Error:       response.Error,
+		Duration:    elapsed,
+	})
+	if !response.Success {
+		c.metrics.IncFailed(command.Type)
+		c.commandsFailed.Add(1)
+	}
+
+	ctx := logging.WithTraceID(context.Background(), command.TraceID)
+	slog.InfoContext(ctx, "Command processed", "command_id", command.ID, "type", command.Type, "protocol", c.protocol,
+		"success", response.Success, "error", response.Error)
+
+	return response
+}
+
+// sendAck sends a command_ack for a queued command when commands.ack is set.
+func (c *Client) sendAck(command Command, priority scheduler.Priority) {
+	if !c.config.Commands.Ack || c.transport == nil {
+		return
+	}
+	ack := map[string]interface{}{
+		"type": "command_ack",
+		"id":   command.ID,
+		"payload": map[string]interface{}{
+			"id":       command.ID,
+			"type":     command.Type,
+			"status":   "accepted",
+			"priority": priority.String(),
+			"queued":   c.scheduler.Len(),
+		},
+	}
+	if err := c.send(ack); err != nil {
+		slog.Error("Failed to send ack", "command_id", command.ID, "protocol", c.protocol, "error", err)
+	}
+}
 
-	// Convert response back to WebSocket message
-	return websocket.WSMessage{
-		Type:    "command_response",
-		Payload: response,
-		ID:      message.ID,
-		Success: response.Success,
+func commandResponseMessage(response CommandResponse) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "command_response",
+		"payload": response,
+		"id":      response.ID,
+		"success": response.Success,
 	}
 }
 
 func (c *Client) startConnectionClient(ctx context.Context) {
-	if c.config.WebSocket.URL == "" {
-		log.Println("Connection URL not configured, skipping client")
+	urls := endpoints(c.config)
+	if len(urls) == 0 {
+		slog.Warn("Connection URL not configured, skipping client")
 		return
 	}
 
-	// Extract host:port from URL for TCP connections
-	address := c.config.WebSocket.URL
-	if c.protocol == "tcp" {
-		// Remove ws:// or wss:// prefix for TCP
-		if len(address) > 5 && address[:5] == "ws://" {
-			address = address[5:]
-		} else if len(address) > 6 && address[:6] == "wss://" {
-			address = address[6:]
+	// With several URLs the failover transport picks the endpoint itself
+	address := connectionAddress(c.protocol, urls[0])
+
+	slog.Info("Starting connection client", "protocol", c.protocol, "url", address, "endpoints", len(urls))
+
+	c.transport.OnDisconnect(func() {
+		c.recordDisconnected()
+		c.setConnected(false)
+		c.emitConnection(EventDisconnected, c.activeURL())
+		if c.reconnectConfig().Enabled && c.isRunning() {
+			c.recordReconnectAttempt()
+			c.emitConnection(EventReconnecting, c.activeURL())
 		}
+	})
+	c.transport.OnReconnect(func() {
+		c.reconnects.Add(1)
+		c.resetMissedHeartbeats()
+		c.recordConnected()
+		c.setConnected(true)
+		c.emitConnection(EventConnected, c.activeURL())
+		slog.Info("✅ Client reconnected", "protocol", c.protocol, "url", c.activeURL())
+		c.flushOutbox()
+	})
+
+	if err := c.transport.Connect(ctx, address, c.config.WebSocket.ClientID); err != nil {
+		c.recordConnectionError(err)
+		slog.Error("❌ Client giving up", "protocol", c.protocol, "url", address, "error", err)
+		return
 	}
 
-	log.Printf("Starting %s client to: %s", c.protocol, address)
+	c.recordConnected()
+	c.setConnected(true)
+	c.emitConnection(EventConnected, c.activeURL())
+	slog.Info("✅ Client connected successfully", "protocol", c.protocol, "url", c.activeURL())
+	c.flushOutbox()
 
-	reconnectAttempts := 0
-	maxReconnectAttempts := c.config.WebSocket.Reconnect.MaxAttempts
-	if maxReconnectAttempts == 0 {
-		maxReconnectAttempts = 5 // Default value
-	}
+	// Send periodic status while the transport keeps itself connected
+	ticker := time.NewTicker(c.heartbeatInterval())
+	defer ticker.Stop()
 
 	for {
 		select {
 		case <-ctx.Done():
+			c.transport.Disconnect()
 			return
-		default:
-			log.Printf("Attempting to connect to %s server (attempt %d/%d)...",
-				c.protocol, reconnectAttempts+1, maxReconnectAttempts)
-
-			metadata := c.getSystemMetadata()
-			var err error
-			if c.protocol == "tcp" {
-				err = c.tcpClient.Connect(ctx, address, c.config.WebSocket.ClientID, metadata)
-			} else {
-				err = c.wsClient.Connect(ctx, c.config.WebSocket.URL, c.config.WebSocket.ClientID, metadata)
-			}
-
-			if err != nil {
-				log.Printf("❌ Failed to connect %s: %v", c.protocol, err)
-
-				if !c.config.WebSocket.Reconnect.Enabled {
-					log.Printf("%s reconnection disabled, giving up", c.protocol)
-					return
-				}
-
-				reconnectAttempts++
-				if reconnectAttempts >= maxReconnectAttempts {
-					log.Printf("❌ Maximum reconnection attempts (%d) reached, giving up", maxReconnectAttempts)
-					return
-				}
-
-				// Calculate delay with exponential backoff
-				delay := c.config.WebSocket.Reconnect.InitialDelay
-				for i := 1; i < reconnectAttempts; i++ {
-					delay *= time.Duration(c.config.WebSocket.Reconnect.BackoffMultiplier)
-				}
-				if delay > c.config.WebSocket.Reconnect.MaxDelay {
-					delay = c.config.WebSocket.Reconnect.MaxDelay
-				}
-
-				log.Printf("⏳ Waiting %s before next reconnection attempt...", delay)
-				time.Sleep(delay)
+		case <-ticker.C:
+			if !c.transport.IsConnected() {
 				continue
 			}
-
-			log.Printf("✅ %s client connected successfully to %s", c.protocol, address)
-			reconnectAttempts = 0 // Reset counter on successful connection
-
-			// Keep connection alive
-			for {
-				if c.protocol == "tcp" {
-					if !c.tcpClient.IsConnected() {
-						break
-					}
-				} else {
-					if !c.wsClient.IsConnected() {
-						break
-					}
-				}
-
-				select {
-				case <-ctx.Done():
-					if c.protocol == "tcp" {
-						c.tcpClient.Disconnect()
-					} else {
-						c.wsClient.Disconnect()
-					}
-					return
-				case <-time.After(30 * time.Second):
-					// Send periodic status
-					if c.protocol == "tcp" {
-						c.tcpClient.SendCommand(map[string]interface{}{
-							"type": "heartbeat",
-							"payload": map[string]interface{}{
-								"status":       "active",
-								"client_stats": c.GetStats(),
-							},
-							"id": "heartbeat",
-						})
-					} else {
-						c.wsClient.SendCommand("heartbeat", map[string]interface{}{
-							"status":       "active",
-							"client_stats": c.GetStats(),
-							"timestamp":    time.Now().UnixNano(),
-						}, "heartbeat")
-					}
-				}
-			}
-
-			log.Printf("❌ %s connection lost", c.protocol)
-
-			// Reconnect if enabled
-			if c.config.WebSocket.Reconnect.Enabled {
-				log.Printf("🔄 %s disconnected, attempting to reconnect...", c.protocol)
-				time.Sleep(c.config.WebSocket.Reconnect.InitialDelay)
-			} else {
-				log.Printf("%s reconnection disabled, not attempting to reconnect", c.protocol)
-				return
-			}
+			c.sendHeartbeat()
 		}
 	}
 }
 
-func (c *Client) GetStats() map[string]interface{} {
-	connected := false
-	if c.protocol == "tcp" {
-		connected = c.tcpClient != nil && c.tcpClient.IsConnected()
-	} else {
-		connected = c.wsClient != nil && c.wsClient.IsConnected()
-	}
+func (c *Client) processCommand(ctx context.Context, command Command) (response CommandResponse) {
+	slog.DebugContext(ctx, "Processing command", "command_id", command.ID, "type", command.Type)
+	defer c.auditCommand(command, time.Now(), &response)
+	defer recoverCommand(ctx, command, &response)
+	c.emit(Event{Type: EventCommandReceived, CommandID: command.ID, CommandType: command.Type})
 
-	cpuPerc, _ := cpu.Percent(0, false)
-	var cpuVal float64
-	if len(cpuPerc) > 0 {
-		cpuVal = math.Round(cpuPerc[0]*100) / 100
-	}
+	c.metrics.Inc(command.Type)
+	c.commandsTotal.Add(1)
+	c.lastCommandAt.Store(time.Now().UnixNano())
 
-	vm, _ := mem.VirtualMemory()
-	var memVal float64
-	if vm != nil {
-		memVal = math.Round(vm.UsedPercent*100) / 100
+	if command.ID == "" {
+		return CommandResponse{
+			Success: false,
+			Error:   fmt.Sprintf("%s command has no id: every command needs a unique id to correlate its response", command.Type),
+		}
 	}
 
-	usage, _ := disk.Usage("/")
-	var diskFree float64
-	if usage != nil {
-		diskFree = math.Round(float64(usage.Free)/(1024*1024*1024)*100) / 100
+	if !c.allowCommand(command.Type) {
+		slog.WarnContext(ctx, "Rejecting command: rate limited", "command_id", command.ID, "type", command.Type)
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   fmt.Sprintf("%s commands are rate limited, try again later", command.Type),
+		}
 	}
 
-	return map[string]interface{}{
-		"running":   c.running,
-		"url":       c.config.WebSocket.URL,
-		"protocol":  c.protocol,
-		"connected": connected,
-		"cpu_usage": cpuVal,
-		"mem_usage": memVal,
-		"disk_free": diskFree, // in GB
-		"enabled_commands": map[string]bool{
-			"api_call":      c.config.EnabledCommands.APICall,
-			"http_request":  c.config.EnabledCommands.HTTPRequest,
-			"local_command": c.config.EnabledCommands.LocalCommand,
-			"file_manager":  c.config.FileManager.Enabled,
-		},
+	if !c.commandEnabled(command.Type) {
+		return CommandResponse{
+			ID:        command.ID,
+			Success:   false,
+			Error:     disabledError(command.Type),
+			ErrorCode: ErrCodeCommandDisabled,
+		}
 	}
-}
 
-func (c *Client) processCommand(ctx context.Context, command Command) CommandResponse {
-	log.Printf("Processing command: %s with ID: %s", command.Type, command.ID)
+	if handler, ok := c.registeredHandler(command.Type); ok {
+		return handler(ctx, command)
+	}
 
 	// Handle different command types
 	switch command.Type {
 	case "api_call":
-		if !c.config.EnabledCommands.APICall {
-			return CommandResponse{
-				ID:      command.ID,
-				Success: false,
-				Error:   "api_call commands are disabled",
-			}
-		}
 		return c.handleAPICall(ctx, command)
 	case "http_request":
-		if !c.config.EnabledCommands.HTTPRequest {
-			return CommandResponse{
-				ID:      command.ID,
-				Success: false,
-				Error:   "http_request commands are disabled",
-			}
-		}
 		return c.handleHTTPRequest(ctx, command)
 	case "local_command":
-		if !c.config.EnabledCommands.LocalCommand {
-			return CommandResponse{
-				ID:      command.ID,
-				Success: false,
-				Error:   "local_command commands are disabled",
-			}
-		}
 		return c.handleLocalCommand(ctx, command)
 	case "interactive_shell_start":
-		if !c.config.EnabledCommands.LocalCommand {
-			return CommandResponse{
-				ID:      command.ID,
-				Success: false,
-				Error:   "interactive_shell is disabled (depends on local_command)",
-			}
-		}
 		return c.handleInteractiveShellStart(ctx, command)
 	case "shell_input":
 		return c.handleShellInput(ctx, command)
@@ -406,31 +744,39 @@ func (c *Client) processCommand(ctx context.Context, command Command) CommandRes
 		return c.handleQuickCommand(ctx, command)
 	case "custom":
 		return c.handleCustom(ctx, command)
+	case "snapshot_state":
+		return c.handleSnapshotState(ctx, command)
+	case "restore_state":
+		return c.handleRestoreState(ctx, command)
+	case "output_fetch":
+		return c.handleOutputFetch(ctx, command)
+	case "heartbeat_history":
+		return c.handleHeartbeatHistory(ctx, command)
+	case "cancel":
+		return c.handleCancel(ctx, command)
+	case "status":
+		return c.handleStatus(ctx, command)
+	case "capabilities":
+		return c.handleCapabilities(ctx, command)
+	case "metrics":
+		return c.handleMetrics(ctx, command)
+	case "reboot":
+		return c.handleReboot(ctx, command)
 	case "file_list":
-		if !c.config.FileManager.Enabled {
-			return CommandResponse{ID: command.ID, Success: false, Error: "File manager is disabled"}
-		}
 		return c.handleFileList(ctx, command)
 	case "file_download":
-		if !c.config.FileManager.Enabled {
-			return CommandResponse{ID: command.ID, Success: false, Error: "File manager is disabled"}
-		}
 		return c.handleFileDownload(ctx, command)
 	case "file_upload":
-		if !c.config.FileManager.Enabled {
-			return CommandResponse{ID: command.ID, Success: false, Error: "File manager is disabled"}
-		}
 		return c.handleFileUpload(ctx, command)
 	case "file_delete":
-		if !c.config.FileManager.Enabled {
-			return CommandResponse{ID: command.ID, Success: false, Error: "File manager is disabled"}
-		}
 		return c.handleFileDelete(ctx, command)
+	case "batch":
+		return c.handleBatch(ctx, command)
 	default:
 		return CommandResponse{
 			ID:      command.ID,
 			Success: false,
-			Error:   fmt.Sprintf("Unknown command type: %s. Supported types: api_call, http_request, local_command, quick_command, open_cell, get_cell_status, add_key, delete_key, sync_keys, reboot, status, update, custom", command.Type),
+			Error:   fmt.Sprintf("Unknown command type: %s. Supported types: %s", command.Type, strings.Join(c.capabilities(), ", ")),
 		}
 	}
 }
@@ -447,6 +793,13 @@ func (c *Client) handleAPICall(ctx context.Context, command Command) CommandResp
 	}
 
 	url, _ := payload["url"].(string)
+	if query, ok := payload["query"].(map[string]interface{}); ok {
+		withQuery, err := proxy.WithQuery(url, query)
+		if err != nil {
+			return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+		}
+		url = withQuery
+	}
 	method, _ := payload["method"].(string)
 	if method == "" {
 		method = "POST"
@@ -469,10 +822,18 @@ func (c *Client) handleAPICall(ctx context.Context, command Command) CommandResp
 		body = bodyRaw
 	}
 
+	// Optional named upstream profile, falls back to api_proxy
+	profile, _ := payload["profile"].(string)
+
+	if c.dryRun(payload) {
+		plan, err := c.apiClient.PlanAPICall(profile, url, method, headers, body)
+		return dryRunResponse(command, plan, err)
+	}
+
 	// Make API call
-	result, executeErr := c.apiClient.ExecuteAPICall(ctx, url, method, headers, body)
+	result, executeErr := c.apiClient.ExecuteAPICall(ctx, profile, url, method, headers, body)
 	if executeErr != nil {
-		log.Printf("API call failed: %v", executeErr)
+		slog.ErrorContext(ctx, "API call failed", "command_id", command.ID, "error", executeErr)
 		return CommandResponse{
 			ID:      command.ID,
 			Success: false,
@@ -480,13 +841,15 @@ func (c *Client) handleAPICall(ctx context.Context, command Command) CommandResp
 		}
 	}
 
-	log.Printf("API call successful: %s %s", method, url)
+	slog.InfoContext(ctx, "API call completed", "command_id", command.ID, "method", method, "url", url, "status", result.StatusCode)
 
 	return CommandResponse{
-		ID:      command.ID,
-		Success: result.Success,
-		Data:    result.Data,
-		Error:   result.Error,
+		ID:         command.ID,
+		Success:    result.Success,
+		Data:       result.Data,
+		Error:      result.Error,
+		StatusCode: result.StatusCode,
+		Headers:    result.Headers,
 	}
 }
 
@@ -502,6 +865,13 @@ func (c *Client) handleHTTPRequest(ctx context.Context, command Command) Command
 	}
 
 	url, _ := payload["url"].(string)
+	if query, ok := payload["query"].(map[string]interface{}); ok {
+		withQuery, err := proxy.WithQuery(url, query)
+		if err != nil {
+			return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+		}
+		url = withQuery
+	}
 	method, _ := payload["method"].(string)
 	if method == "" {
 		method = "GET"
@@ -524,10 +894,15 @@ func (c *Client) handleHTTPRequest(ctx context.Context, command Command) Command
 		body = bodyRaw
 	}
 
+	if c.dryRun(payload) {
+		plan, err := c.apiClient.PlanHTTPRequest(url, method, headers, body)
+		return dryRunResponse(command, plan, err)
+	}
+
 	// Make HTTP request
 	result, err := c.apiClient.ExecuteHTTPRequest(ctx, url, method, headers, body)
 	if err != nil {
-		log.Printf("HTTP request failed: %v", err)
+		slog.ErrorContext(ctx, "HTTP request failed", "command_id", command.ID, "error", err)
 		return CommandResponse{
 			ID:      command.ID,
 			Success: false,
@@ -535,13 +910,15 @@ func (c *Client) handleHTTPRequest(ctx context.Context, command Command) Command
 		}
 	}
 
-	log.Printf("HTTP request successful: %s %s", method, url)
+	slog.InfoContext(ctx, "HTTP request completed", "command_id", command.ID, "method", method, "url", url, "status", result.StatusCode)
 
 	return CommandResponse{
-		ID:      command.ID,
-		Success: result.Success,
-		Data:    result.Data,
-		Error:   result.Error,
+		ID:         command.ID,
+		Success:    result.Success,
+		Data:       result.Data,
+		Error:      result.Error,
+		StatusCode: result.StatusCode,
+		Headers:    result.Headers,
 	}
 }
 
@@ -566,6 +943,15 @@ func (c *Client) handleLocalCommand(ctx context.Context, command Command) Comman
 		}
 	}
 
+	if err := c.localPolicy.Permit(commandStr); err != nil {
+		slog.WarnContext(ctx, "Refusing local command", "command_id", command.ID, "command", commandStr, "error", err)
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
 	// Extract optional parameters
 	var env map[string]string
 	if envData, exists := payload["env"]; exists {
@@ -579,7 +965,25 @@ func (c *Client) handleLocalCommand(ctx context.Context, command Command) Comman
 		}
 	}
 
+	if err := c.localPolicy.PermitEnv(env); err != nil {
+		slog.WarnContext(ctx, "Refusing local command environment", "command_id", command.ID, "error", err)
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
 	workDir, _ := payload["work_dir"].(string)
+	createWorkDir, _ := payload["create_work_dir"].(bool)
+	var inheritEnv *bool
+	if inherit, ok := payload["inherit_env"].(bool); ok {
+		inheritEnv = &inherit
+	}
+	stdin, _ := payload["stdin"].(string)
+	combineOutput, _ := payload["combine_output"].(bool)
+	runAsUser, _ := payload["run_as_user"].(string)
+	runAsGroup, _ := payload["run_as_group"].(string)
 	var timeout time.Duration
 	if timeoutStr, exists := payload["timeout"]; exists {
 		if str, ok := timeoutStr.(string); ok {
@@ -589,28 +993,87 @@ func (c *Client) handleLocalCommand(ctx context.Context, command Command) Comman
 		}
 	}
 
+	switch mode, _ := payload["mode"].(string); mode {
+	case "", "local":
+	case "remote":
+		return c.handleRemoteCommand(ctx, command, payload, &local.RemoteCommand{
+			Command: commandStr,
+			Env:     env,
+			Stdin:   stdin,
+			Timeout: timeout,
+		})
+	default:
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   fmt.Sprintf("unknown local_command mode %q, expected \"local\" or \"remote\"", mode),
+		}
+	}
+
 	// Create local client
 	localClient := local.NewLocalClient()
 
 	// Execute command locally
 	localCmd := &local.LocalCommand{
-		Command: commandStr,
-		Env:     env,
-		WorkDir: workDir,
-		Timeout: timeout,
+		Command:    commandStr,
+		Env:        env,
+		InheritEnv: inheritEnv,
+		WorkDir:    workDir,
+		Timeout:    timeout,
+		Stdin:      stdin,
+		Shell:      c.config.Local.Shell,
+
+		CreateWorkDir:  createWorkDir,
+		RunAsUser:      runAsUser,
+		RunAsGroup:     runAsGroup,
+		CombineOutput:  combineOutput,
+		MaxOutputBytes: c.config.Local.MaxOutputBytes,
+	}
+
+	if c.dryRun(payload) {
+		plan, err := localClient.Plan(localCmd)
+		return dryRunResponse(command, plan, err)
+	}
+
+	// Optionally forward output lines to the server as they are produced
+	if stream, _ := payload["stream"].(bool); stream && c.transport != nil {
+		localCmd.StreamFunc = func(streamName string, line string) {
+			c.send(map[string]interface{}{
+				"type": "command_output",
+				"id":   command.ID,
+				"payload": map[string]interface{}{
+					"stream": streamName,
+					"line":   line,
+				},
+			})
+		}
 	}
 
+	release, err := c.acquireLocalSlot(ctx, command)
+	if err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+	}
+	defer release()
+
 	result, err := localClient.ExecuteCommand(ctx, localCmd)
 	if err != nil {
-		log.Printf("Failed to execute local command: %v", err)
-		return CommandResponse{
+		slog.ErrorContext(ctx, "Failed to execute local command", "command_id", command.ID, "error", err)
+		response := CommandResponse{
 			ID:      command.ID,
 			Success: false,
 			Error:   fmt.Sprintf("Command execution failed: %v", err),
 		}
+		// A killed command still reports its output and signal
+		if result != nil {
+			c.storeLargeOutput(result)
+			response.Data = result
+		}
+		return response
 	}
 
-	log.Printf("Local command executed successfully: %s", commandStr)
+	slog.InfoContext(ctx, "Local command executed successfully", "command_id", command.ID, "command", commandStr)
+
+	c.storeLargeOutput(result)
 
 	return CommandResponse{
 		ID:      command.ID,
@@ -695,11 +1158,22 @@ func (c *Client) handleQuickCommand(ctx context.Context, command Command) Comman
 		cmdPayload = map[string]interface{}{}
 	}
 
+	// Fill {{.param}} placeholders from the invoking payload
+	cmdPayload, err := renderQuickCommand(commandNameStr, cmdTypeStr, cmdPayload, payload)
+	if err != nil {
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   err.Error(),
+		}
+	}
+
 	// Create new command with quick command payload
 	newCommand := Command{
 		Type:    cmdTypeStr,
 		ID:      command.ID,
 		Payload: cmdPayload,
+		TraceID: command.TraceID,
 	}
 
 	log.Printf("Executing quick command '%s' as %s", commandNameStr, cmdTypeStr)
@@ -722,7 +1196,7 @@ func (c *Client) handleQuickCommand(ctx context.Context, command Command) Comman
 }
 
 func (c *Client) handleCustom(ctx context.Context, command Command) CommandResponse {
-	log.Printf("Custom command received: %+v", command.Payload)
+	slog.DebugContext(ctx, "Custom command received", "command_id", command.ID, "payload", logging.Redact(command.Payload))
 
 	return CommandResponse{
 		ID:      command.ID,
@@ -779,25 +1253,18 @@ func (c *Client) handleInteractiveShellStart(ctx context.Context, command Comman
 			n, err := f.Read(buf)
 			if n > 0 {
 				output := string(buf[:n])
-				if c.protocol == "tcp" {
-					c.tcpClient.SendCommand(map[string]interface{}{
-						"type": "shell_output",
-						"payload": map[string]interface{}{
-							"session_id": sessionID,
-							"output":     output,
-						},
-						"id": "shell_output_" + sessionID,
-					})
-				} else {
-					c.wsClient.SendCommand("shell_output", map[string]interface{}{
+				c.send(map[string]interface{}{
+					"type": "shell_output",
+					"payload": map[string]interface{}{
 						"session_id": sessionID,
 						"output":     output,
-					}, "shell_output_"+sessionID)
-				}
+					},
+					"id": "shell_output_" + sessionID,
+				})
 			}
 			if err != nil {
 				if err != io.EOF {
-					log.Printf("PTY read error for session %s: %v", sessionID, err)
+					slog.Warn("PTY read error", "session_id", sessionID, "error", err)
 				}
 				break
 			}
@@ -893,11 +1360,23 @@ func (c *Client) handleFileDownload(ctx context.Context, command Command) Comman
 		return CommandResponse{ID: command.ID, Success: false, Error: "Invalid payload"}
 	}
 	path, _ := payload["path"].(string)
-	data, err := c.fileMgr.DownloadFile(path)
+	if path == "" {
+		return CommandResponse{ID: command.ID, Success: false, Error: "path is required"}
+	}
+	file, err := c.fileMgr.DownloadFile(path)
 	if err != nil {
 		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
 	}
-	return CommandResponse{ID: command.ID, Success: true, Data: data}
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"path":     file.Path,
+			"data":     base64.StdEncoding.EncodeToString(file.Data),
+			"size":     file.Size,
+			"mod_time": file.ModTime.UTC().Format(time.RFC3339),
+		},
+	}
 }
 
 func (c *Client) handleFileUpload(ctx context.Context, command Command) CommandResponse {
@@ -909,27 +1388,45 @@ func (c *Client) handleFileUpload(ctx context.Context, command Command) CommandR
 		return CommandResponse{ID: command.ID, Success: false, Error: "Invalid payload"}
 	}
 	path, _ := payload["path"].(string)
+	if path == "" {
+		return CommandResponse{ID: command.ID, Success: false, Error: "path is required"}
+	}
 
-	// Data can be string (if base64 or text) or []uint8 (if binary)
+	// Data is base64 encoded, or raw bytes from a binary transport
 	var data []byte
 	if d, ok := payload["data"].([]byte); ok {
 		data = d
 	} else if s, ok := payload["data"].(string); ok {
-		// Test server sends it as base64
 		decoded, err := base64.StdEncoding.DecodeString(s)
-		if err == nil {
-			data = decoded
-		} else {
-			// Fallback to raw bytes if not valid base64
-			data = []byte(s)
+		if err != nil {
+			return CommandResponse{ID: command.ID, Success: false, Error: fmt.Sprintf("data is not valid base64: %v", err)}
 		}
+		data = decoded
 	}
 
-	err := c.fileMgr.UploadFile(path, data)
-	if err != nil {
+	mode := os.FileMode(0644)
+	if modeStr, ok := payload["mode"].(string); ok && modeStr != "" {
+		parsed, err := strconv.ParseUint(modeStr, 8, 32)
+		if err != nil || parsed > 0777 {
+			return CommandResponse{ID: command.ID, Success: false, Error: fmt.Sprintf("invalid mode %q: must be octal permissions such as \"0644\"", modeStr)}
+		}
+		mode = os.FileMode(parsed)
+	}
+
+	if err := c.fileMgr.UploadFile(path, data, mode); err != nil {
 		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
 	}
-	return CommandResponse{ID: command.ID, Success: true}
+
+	sum := sha256.Sum256(data)
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"path":          path,
+			"bytes_written": len(data),
+			"sha256":        hex.EncodeToString(sum[:]),
+		},
+	}
 }
 
 func (c *Client) handleFileDelete(ctx context.Context, command Command) CommandResponse {
@@ -952,6 +1449,67 @@ func (c *Client) handleFileDelete(ctx context.Context, command Command) CommandR
 	return CommandResponse{ID: command.ID, Success: true}
 }
 
+// storeLargeOutput moves stdout/stderr above the inline threshold into the
+// output store, leaving a reference the server can fetch with output_fetch.
+func (c *Client) storeLargeOutput(result *local.LocalResult) {
+	if c.outputStore == nil {
+		return
+	}
+
+	if !c.outputStore.Inline(result.Stdout) {
+		ref, err := c.outputStore.Save("stdout", result.Stdout)
+		if err != nil {
+			slog.Warn("Failed to store stdout, sending inline", "error", err)
+		} else {
+			result.Stdout = ""
+			result.StdoutRef = ref
+		}
+	}
+
+	if !c.outputStore.Inline(result.Combined) {
+		ref, err := c.outputStore.Save("combined", result.Combined)
+		if err != nil {
+			slog.Warn("Failed to store combined output, sending inline", "error", err)
+		} else {
+			result.Combined = ""
+			result.CombinedRef = ref
+		}
+	}
+
+	if !c.outputStore.Inline(result.Stderr) {
+		ref, err := c.outputStore.Save("stderr", result.Stderr)
+		if err != nil {
+			slog.Warn("Failed to store stderr, sending inline", "error", err)
+		} else {
+			result.Stderr = ""
+			result.StderrRef = ref
+		}
+	}
+}
+
+func (c *Client) handleOutputFetch(ctx context.Context, command Command) CommandResponse {
+	if c.outputStore == nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: "Output store not configured on agent"}
+	}
+	payload, ok := command.Payload.(map[string]interface{})
+	if !ok {
+		return CommandResponse{ID: command.ID, Success: false, Error: "Invalid payload"}
+	}
+	ref, _ := payload["ref"].(string)
+	data, err := c.outputStore.Load(ref)
+	if err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+	}
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"ref":     ref,
+			"content": string(data),
+		},
+	}
+}
+
 func (c *Client) cleanupPTYSession(sessionID string) {
 	c.ptyMux.Lock()
 	session, ok := c.ptySessions[sessionID]
@@ -965,19 +1523,3 @@ func (c *Client) cleanupPTYSession(sessionID string) {
 		session.Command.Process.Kill()
 	}
 }
-
-func (c *Client) getSystemMetadata() map[string]interface{} {
-	hostname, _ := os.Hostname()
-	stats := c.GetStats()
-	meta := map[string]interface{}{
-		"hostname": hostname,
-		"os":       runtime.GOOS,
-		"arch":     runtime.GOARCH,
-		"version":  "1.0.0",
-	}
-	// Add stats to metadata so they are available immediately
-	for k, v := range stats {
-		meta["stats_"+k] = v
-	}
-	return meta
-}
@@ -0,0 +1,40 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"log/slog"
+)
+
+// compressResponse gzips the Data of a command_response whose JSON
+// encoding is at least commands.compress_threshold bytes. The compressed
+// bytes replace Data (as base64, like any []byte) and the message is
+// marked with content_encoding "gzip". Other messages pass through.
+func (c *Client) compressResponse(message map[string]interface{}) map[string]interface{} {
+	threshold := c.config.Commands.CompressThreshold
+	if threshold <= 0 || message == nil || message["type"] != "command_response" {
+		return message
+	}
+	response, ok := message["payload"].(CommandResponse)
+	if !ok || response.Data == nil {
+		return message
+	}
+
+	data, err := json.Marshal(response.Data)
+	if err != nil || len(data) < threshold {
+		return message
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(data)
+	if err := zw.Close(); err != nil {
+		slog.Warn("Failed to compress response, sending it uncompressed", "command_id", response.ID, "error", err)
+		return message
+	}
+
+	response.Data = buf.Bytes()
+	message["payload"] = response
+	message["content_encoding"] = "gzip"
+	return message
+}
@@ -0,0 +1,117 @@
+package client
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"edge-agent/internal/config"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+// decodeResponse round-trips message through JSON as the server sees it
+// and returns the response data, decompressed if it was gzipped.
+func decodeResponse(t *testing.T, message map[string]interface{}) (encoding string, data interface{}) {
+	t.Helper()
+	raw, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wire struct {
+		ContentEncoding string `json:"content_encoding"`
+		Payload         struct {
+			Data json.RawMessage `json:"data"`
+		} `json:"payload"`
+	}
+	if err := json.Unmarshal(raw, &wire); err != nil {
+		t.Fatal(err)
+	}
+
+	plain := []byte(wire.Payload.Data)
+	if wire.ContentEncoding == "gzip" {
+		var encoded string
+		if err := json.Unmarshal(wire.Payload.Data, &encoded); err != nil {
+			t.Fatalf("Expected compressed data to be a base64 string: %v", err)
+		}
+		compressed, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			t.Fatal(err)
+		}
+		zr, err := gzip.NewReader(bytes.NewReader(compressed))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if plain, err = io.ReadAll(zr); err != nil {
+			t.Fatal(err)
+		}
+		if len(compressed) >= len(plain) {
+			t.Errorf("Expected compression to shrink %d bytes, got %d", len(plain), len(compressed))
+		}
+	}
+	if err := json.Unmarshal(plain, &data); err != nil {
+		t.Fatal(err)
+	}
+	return wire.ContentEncoding, data
+}
+
+func TestCompressLargeResponseRoundTrip(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.CompressThreshold = 1024
+	c := NewClient(cfg)
+	output := strings.Repeat("kernel: eth0 link up\n", 500)
+	c.RegisterHandler("dump", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: true, Data: map[string]interface{}{"stdout": output}}
+	})
+
+	tr := &recordingTransport{}
+	c.transport = tr
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	c.handleCommand(map[string]interface{}{"type": "dump", "id": "d1"})
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tr.mu.Lock()
+	if len(tr.sent) != 1 {
+		tr.mu.Unlock()
+		t.Fatalf("Expected one response, got %v", tr.events)
+	}
+	message := tr.sent[0]
+	tr.mu.Unlock()
+
+	encoding, data := decodeResponse(t, message)
+	if encoding != "gzip" {
+		t.Fatalf("Expected content_encoding gzip, got %q", encoding)
+	}
+	if data.(map[string]interface{})["stdout"] != output {
+		t.Error("Decompressed data does not match the original")
+	}
+}
+
+func TestCompressThreshold(t *testing.T) {
+	small := commandResponseMessage(CommandResponse{ID: "s1", Success: true, Data: map[string]interface{}{"stdout": "ok"}})
+	large := commandResponseMessage(CommandResponse{ID: "l1", Success: true, Data: strings.Repeat("x", 2048)})
+
+	cfg := &config.Config{}
+	cfg.Commands.CompressThreshold = 1024
+	c := NewClient(cfg)
+	if encoding, data := decodeResponse(t, c.compressResponse(small)); encoding != "" || data.(map[string]interface{})["stdout"] != "ok" {
+		t.Errorf("Expected a small response to stay uncompressed, got %q %v", encoding, data)
+	}
+	if encoding, _ := decodeResponse(t, c.compressResponse(large)); encoding != "gzip" {
+		t.Errorf("Expected a large response to be compressed, got %q", encoding)
+	}
+
+	// Compression is off by default
+	off := NewClient(&config.Config{})
+	large = commandResponseMessage(CommandResponse{ID: "l2", Success: true, Data: strings.Repeat("x", 2048)})
+	if encoding, _ := decodeResponse(t, off.compressResponse(large)); encoding != "" {
+		t.Errorf("Expected no compression without compress_threshold, got %q", encoding)
+	}
+}
@@ -0,0 +1,63 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/logging"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// defaultCommandTimeout is used when commands.timeout is not configured.
+const defaultCommandTimeout = 5 * time.Minute
+
+// commandTimeout is the upper bound on how long a single command may run.
+func (c *Client) commandTimeout() time.Duration {
+	if c.config.Commands.Timeout > 0 {
+		return c.config.Commands.Timeout
+	}
+	return defaultCommandTimeout
+}
+
+// processWithDeadline runs command under the overall command timeout. A
+// handler that ignores its context is abandoned once the deadline passes so
+// the server still gets a timely response. While it runs, the command can
+// be stopped by a cancel command naming its ID.
+func (c *Client) processWithDeadline(command Command) CommandResponse {
+	timeout := c.commandTimeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	ctx = logging.WithTraceID(ctx, command.TraceID)
+	if command.ID != "" {
+		if !c.trackCommand(command.ID, cancel) {
+			slog.WarnContext(ctx, "Rejecting command: ID is already in flight", "command_id", command.ID, "type", command.Type)
+			return CommandResponse{
+				ID:      command.ID,
+				Success: false,
+				Error:   fmt.Sprintf("a command with id %s is already in flight", command.ID),
+			}
+		}
+		defer c.untrackCommand(command.ID)
+	}
+
+	done := make(chan CommandResponse, 1)
+	go func() {
+		done <- c.process(ctx, command)
+	}()
+
+	select {
+	case response := <-done:
+		return response
+	case <-ctx.Done():
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return CommandResponse{ID: command.ID, Success: false, Error: "command cancelled"}
+		}
+		slog.WarnContext(ctx, "Command timed out", "command_id", command.ID, "type", command.Type, "timeout", timeout)
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   fmt.Sprintf("command timed out after %s", timeout),
+		}
+	}
+}
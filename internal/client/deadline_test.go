@@ -0,0 +1,83 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandDeadlineAbandonsHungHandler(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.Timeout = 50 * time.Millisecond
+	c := NewClient(cfg)
+
+	release := make(chan struct{})
+	defer close(release)
+	c.process = func(ctx context.Context, command Command) CommandResponse {
+		<-release // ignores ctx entirely
+		return CommandResponse{ID: command.ID, Success: true}
+	}
+
+	start := time.Now()
+	resp := c.processWithDeadline(Command{Type: "custom", ID: "hung"})
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("Expected the deadline to end the command, took %s", elapsed)
+	}
+	if resp.Success || resp.ID != "hung" || !strings.Contains(resp.Error, "timed out") {
+		t.Errorf("Expected a timeout error response, got %+v", resp)
+	}
+}
+
+func TestCommandDeadlineCancelsHTTPRequest(t *testing.T) {
+	cancelled := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+			close(cancelled)
+		case <-time.After(5 * time.Second):
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.Commands.Timeout = 100 * time.Millisecond
+	cfg.EnabledCommands.HTTPRequest = true
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+	c := NewClient(cfg)
+
+	resp := c.processWithDeadline(Command{Type: "http_request", ID: "slow", Payload: map[string]interface{}{"url": server.URL}})
+	if resp.Success || resp.Error == "" {
+		t.Errorf("Expected the request to fail, got %+v", resp)
+	}
+
+	select {
+	case <-cancelled:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Upstream request was not cancelled at the deadline")
+	}
+}
+
+func TestCommandDeadlineBoundsLocalCommand(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep")
+	}
+
+	cfg := &config.Config{}
+	cfg.Commands.Timeout = 200 * time.Millisecond
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+
+	start := time.Now()
+	resp := c.processWithDeadline(Command{Type: "local_command", ID: "sleep", Payload: map[string]interface{}{"command": "sleep 10"}})
+	if elapsed := time.Since(start); elapsed > 3*time.Second {
+		t.Fatalf("Expected the deadline to stop the command, took %s", elapsed)
+	}
+	if resp.Success || !strings.Contains(resp.Error, "timed out") {
+		t.Errorf("Expected a timeout error, got %+v", resp)
+	}
+}
@@ -0,0 +1,124 @@
+package client
+
+import (
+	"sync"
+	"time"
+)
+
+// defaultDedupTTL is used when commands.dedup_ttl is not set.
+const defaultDedupTTL = 5 * time.Minute
+
+// defaultDedupMaxEntries is used when commands.dedup_max_entries is not set.
+const defaultDedupMaxEntries = 1000
+
+// dedupCache remembers recently seen command IDs so that a command the
+// server redelivers (e.g. after a network retry) is answered with the
+// original response instead of running twice.
+type dedupCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	max     int
+	entries map[string]*dedupEntry
+	order   []dedupExpiry // completed entries, oldest first
+	now     func() time.Time
+}
+
+type dedupEntry struct {
+	response *CommandResponse // nil while the command is still running
+	expires  time.Time
+}
+
+type dedupExpiry struct {
+	id      string
+	expires time.Time
+}
+
+// newDedupCache returns nil, which disables deduplication, when ttl is
+// negative. A zero ttl means commands.dedup_ttl was not set.
+func newDedupCache(ttl time.Duration, max int) *dedupCache {
+	if ttl < 0 {
+		return nil
+	}
+	if ttl == 0 {
+		ttl = defaultDedupTTL
+	}
+	if max <= 0 {
+		max = defaultDedupMaxEntries
+	}
+	return &dedupCache{
+		ttl:     ttl,
+		max:     max,
+		entries: make(map[string]*dedupEntry),
+		now:     time.Now,
+	}
+}
+
+// begin records that the command with the given ID is starting. If the ID
+// was seen before, it reports true along with the cached response, which
+// is nil while the first delivery is still running.
+func (d *dedupCache) begin(id string) (*CommandResponse, bool) {
+	if d == nil || id == "" {
+		return nil, false
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.expire()
+	if entry, ok := d.entries[id]; ok {
+		return entry.response, true
+	}
+	d.entries[id] = &dedupEntry{}
+	return nil, false
+}
+
+// complete caches the response of a command started with begin.
+func (d *dedupCache) complete(id string, response CommandResponse) {
+	if d == nil || id == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	// The closure run by afterSend must not be replayed
+	response.afterSend = nil
+	expires := d.now().Add(d.ttl)
+	d.entries[id] = &dedupEntry{response: &response, expires: expires}
+	d.order = append(d.order, dedupExpiry{id: id, expires: expires})
+
+	// Evict the oldest responses beyond the size bound
+	for len(d.order) > d.max {
+		d.remove(d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// forget drops a command that was rejected before it ran, so that a retry
+// of it is executed.
+func (d *dedupCache) forget(id string) {
+	if d == nil || id == "" {
+		return
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if entry, ok := d.entries[id]; ok && entry.response == nil {
+		delete(d.entries, id)
+	}
+}
+
+// expire drops completed entries older than the TTL. Every entry shares the
+// TTL, so they expire in completion order.
+func (d *dedupCache) expire() {
+	now := d.now()
+	for len(d.order) > 0 && !now.Before(d.order[0].expires) {
+		d.remove(d.order[0])
+		d.order = d.order[1:]
+	}
+}
+
+// remove deletes the entry for e unless the ID has been reused since.
+func (d *dedupCache) remove(e dedupExpiry) {
+	if entry, ok := d.entries[e.id]; ok && entry.response != nil && entry.expires.Equal(e.expires) {
+		delete(d.entries, e.id)
+	}
+}
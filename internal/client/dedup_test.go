@@ -0,0 +1,139 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDuplicateCommandReturnsCachedResponse(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.DedupTTL = time.Minute
+	c := NewClient(cfg)
+	tr := &recordingTransport{}
+	c.transport = tr
+
+	var runs atomic.Int32
+	c.RegisterHandler("reboot_like", func(ctx context.Context, command Command) CommandResponse {
+		n := runs.Add(1)
+		return CommandResponse{ID: command.ID, Success: true, Data: fmt.Sprintf("run %d", n)}
+	})
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	if resp := c.handleCommand(map[string]interface{}{"type": "reboot_like", "id": "r1"}); resp != nil {
+		t.Fatalf("Expected the first delivery to be queued, got %v", resp)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	resp := c.handleCommand(map[string]interface{}{"type": "reboot_like", "id": "r1"})
+	if resp == nil {
+		t.Fatal("Expected the duplicate to be answered with the cached response")
+	}
+	cached := resp["payload"].(CommandResponse)
+	if !cached.Success || cached.ID != "r1" || cached.Data != "run 1" {
+		t.Errorf("Expected the first run's response, got %+v", cached)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("Expected the command to run once, ran %d times", n)
+	}
+}
+
+func TestDuplicateOfRunningCommandIsIgnored(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.DedupTTL = time.Minute
+	c := NewClient(cfg)
+	c.transport = &recordingTransport{}
+
+	release := make(chan struct{})
+	var runs atomic.Int32
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		runs.Add(1)
+		<-release
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	c.handleCommand(map[string]interface{}{"type": "slow", "id": "s1"})
+	if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": "s1"}); resp != nil {
+		t.Errorf("Expected no response to a duplicate of a running command, got %v", resp)
+	}
+	close(release)
+
+	time.Sleep(50 * time.Millisecond)
+	if n := runs.Load(); n != 1 {
+		t.Errorf("Expected the command to run once, ran %d times", n)
+	}
+}
+
+func TestDedupCacheExpiresAndIsBounded(t *testing.T) {
+	d := newDedupCache(time.Minute, 2)
+	now := time.Now()
+	d.now = func() time.Time { return now }
+
+	for _, id := range []string{"a", "b", "c"} {
+		if _, duplicate := d.begin(id); duplicate {
+			t.Fatalf("%s is not a duplicate", id)
+		}
+		d.complete(id, CommandResponse{ID: id, Success: true})
+	}
+
+	// "a" was evicted to keep the cache at two entries
+	if _, duplicate := d.begin("a"); duplicate {
+		t.Error("Expected the oldest entry to be evicted")
+	}
+	if cached, duplicate := d.begin("c"); !duplicate || cached == nil || cached.ID != "c" {
+		t.Errorf("Expected the cached response for c, got %v %v", cached, duplicate)
+	}
+
+	now = now.Add(time.Minute)
+	if _, duplicate := d.begin("c"); duplicate {
+		t.Error("Expected the entry to expire after the TTL")
+	}
+
+	// A rejected command can be retried
+	d.forget("c")
+	if _, duplicate := d.begin("c"); duplicate {
+		t.Error("Expected a forgotten command to run again")
+	}
+}
+
+func TestDedupDisabledWithNegativeTTL(t *testing.T) {
+	if d := newDedupCache(-time.Second, 10); d != nil {
+		t.Fatal("Expected a negative TTL to disable deduplication")
+	}
+	var d *dedupCache
+	if _, duplicate := d.begin("x"); duplicate {
+		t.Error("A disabled cache reports no duplicates")
+	}
+}
+
+func TestDedupOnByDefault(t *testing.T) {
+	cfg, _, err := config.Parse([]byte("version: 1\ncommands:\n  dedup_max_entries: 10\n"), true)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	c := NewClient(cfg)
+	if c.dedup == nil {
+		t.Fatal("Expected deduplication to be on without commands.dedup_ttl")
+	}
+	if c.dedup.ttl != defaultDedupTTL {
+		t.Errorf("Expected the default TTL %v, got %v", defaultDedupTTL, c.dedup.ttl)
+	}
+	if _, duplicate := c.dedup.begin("r1"); duplicate {
+		t.Fatal("Expected the first delivery to run")
+	}
+	c.dedup.complete("r1", CommandResponse{ID: "r1", Success: true})
+	if _, duplicate := c.dedup.begin("r1"); !duplicate {
+		t.Error("Expected a redelivered command to be recognised")
+	}
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+// defaultShutdownGrace is used when commands.shutdown_grace_period is not configured.
+const defaultShutdownGrace = 30 * time.Second
+
+func (c *Client) shutdownGrace() time.Duration {
+	if c.config.Commands.ShutdownGracePeriod > 0 {
+		return c.config.Commands.ShutdownGracePeriod
+	}
+	return defaultShutdownGrace
+}
+
+// acceptCommand registers an incoming command with the in-flight group.
+// It reports false once Stop has begun draining; otherwise the caller must
+// call Done on the returned group after the response has been sent.
+func (c *Client) acceptCommand() (*sync.WaitGroup, bool) {
+	c.runningMux.Lock()
+	defer c.runningMux.Unlock()
+
+	if c.draining {
+		return nil, false
+	}
+	c.inflight.Add(1)
+	return c.inflight, true
+}
+
+// drain waits up to the grace period for in-flight commands to finish and
+// send their responses. Commands still running afterwards are cancelled
+// and given a moment to report the cancellation.
+func (c *Client) drain(inflight *sync.WaitGroup) {
+	done := make(chan struct{})
+	go func() {
+		inflight.Wait()
+		close(done)
+	}()
+
+	grace := c.shutdownGrace()
+	select {
+	case <-done:
+		return
+	case <-time.After(grace):
+	}
+
+	c.commandsMux.Lock()
+	running := len(c.commands)
+	for _, cancel := range c.commands {
+		cancel()
+	}
+	c.commandsMux.Unlock()
+	slog.Warn("Shutdown grace period expired, cancelled running commands", "grace", grace, "cancelled", running)
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+	}
+}
@@ -0,0 +1,79 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"testing"
+	"time"
+)
+
+func TestStopWaitsForInFlightCommands(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+	started := make(chan struct{})
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": "slow-1"}); resp != nil {
+		t.Fatalf("Expected the command to be queued, got %v", resp)
+	}
+	<-started
+	c.Stop()
+
+	events := tr.recorded()
+	if len(events) != 2 || events[0] != "send:command_response" || events[1] != "disconnect" {
+		t.Fatalf("Expected the response to be sent before disconnecting, got %v", events)
+	}
+
+	resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": "slow-2"})
+	if resp == nil {
+		t.Fatal("Expected a command arriving after Stop to be rejected")
+	}
+	payload := resp["payload"].(CommandResponse)
+	if payload.Success || payload.Error != "agent is shutting down" {
+		t.Errorf("Expected a shutting down error, got %+v", payload)
+	}
+}
+
+func TestStopCancelsCommandsAfterGracePeriod(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.ShutdownGracePeriod = 50 * time.Millisecond
+	c := NewClient(cfg)
+	tr := &recordingTransport{}
+	c.transport = tr
+	started := make(chan struct{})
+	c.RegisterHandler("stuck", func(ctx context.Context, command Command) CommandResponse {
+		close(started)
+		<-ctx.Done()
+		return CommandResponse{ID: command.ID, Success: false, Error: ctx.Err().Error()}
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	c.handleCommand(map[string]interface{}{"type": "stuck", "id": "stuck-1"})
+	<-started
+
+	stopped := time.Now()
+	c.Stop()
+	if elapsed := time.Since(stopped); elapsed > time.Second {
+		t.Errorf("Expected Stop to give up after the grace period, took %s", elapsed)
+	}
+
+	tr.mu.Lock()
+	defer tr.mu.Unlock()
+	if len(tr.sent) != 1 {
+		t.Fatalf("Expected the cancelled command to still respond, got %v", tr.events)
+	}
+	payload := tr.sent[0]["payload"].(CommandResponse)
+	if payload.Success || payload.Error != "command cancelled" {
+		t.Errorf("Expected a cancelled response, got %+v", payload)
+	}
+}
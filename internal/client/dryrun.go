@@ -0,0 +1,31 @@
+package client
+
+// dryRun reports whether a command should only be validated: set
+// globally by commands.dry_run or per command by "dry_run" in its payload.
+func (c *Client) dryRun(payload map[string]interface{}) bool {
+	if c.config.Commands.DryRun {
+		return true
+	}
+	dryRun, _ := payload["dry_run"].(bool)
+	return dryRun
+}
+
+// dryRunResponse reports the plan of a dry run, or why the command would
+// be rejected.
+func dryRunResponse(command Command, plan interface{}, err error) CommandResponse {
+	if err != nil {
+		return CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   "dry run: " + err.Error(),
+		}
+	}
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"dry_run": true,
+			"plan":    plan,
+		},
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/local"
+	"edge-agent/internal/proxy"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestDryRunMakesNoHTTPCalls(t *testing.T) {
+	var hits int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&hits, 1)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.Auth.Token = "secret-token"
+	cfg.APIProxy.Auth.Type = "Bearer"
+	cfg.EnabledCommands.APICall = true
+	cfg.EnabledCommands.HTTPRequest = true
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{Type: "api_call", ID: "api", Payload: map[string]interface{}{
+		"url": "/devices", "method": "PUT", "body": map[string]interface{}{"name": "cell"}, "dry_run": true,
+	}})
+	if !resp.Success {
+		t.Fatalf("Expected dry run to succeed, got %q", resp.Error)
+	}
+	plan := resp.Data.(map[string]interface{})["plan"].(*proxy.PlannedRequest)
+	if plan.Method != "PUT" || plan.URL != server.URL+"/devices" || plan.Body != `{"name":"cell"}` {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+	if plan.Headers["Authorization"] != "[REDACTED]" || plan.Headers["Content-Type"] != "application/json" {
+		t.Errorf("Expected resolved headers with the token redacted, got %v", plan.Headers)
+	}
+
+	resp = c.processCommand(context.Background(), Command{Type: "http_request", ID: "http", Payload: map[string]interface{}{
+		"url": server.URL + "/status", "dry_run": true,
+	}})
+	if !resp.Success {
+		t.Fatalf("Expected dry run to succeed, got %q", resp.Error)
+	}
+
+	resp = c.processCommand(context.Background(), Command{Type: "http_request", ID: "bad", Payload: map[string]interface{}{
+		"url": "not a url", "dry_run": true,
+	}})
+	if resp.Success || !strings.HasPrefix(resp.Error, "dry run:") {
+		t.Errorf("Expected an invalid URL to be rejected, got %+v", resp)
+	}
+
+	if n := atomic.LoadInt32(&hits); n != 0 {
+		t.Errorf("Expected no upstream requests in dry run, got %d", n)
+	}
+}
+
+func TestDryRunSpawnsNoProcess(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ran")
+
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Commands.DryRun = true
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{Type: "local_command", ID: "touch", Payload: map[string]interface{}{
+		"command": "touch " + marker,
+		"env":     map[string]interface{}{"API_KEY": "secret"},
+		"timeout": "10s",
+	}})
+	if !resp.Success {
+		t.Fatalf("Expected dry run to succeed, got %q", resp.Error)
+	}
+	plan := resp.Data.(map[string]interface{})["plan"].(*local.LocalPlan)
+	if len(plan.Args) == 0 || plan.Args[len(plan.Args)-1] != "touch "+marker || plan.Timeout != "10s" {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+	if len(plan.EnvKeys) != 1 || plan.EnvKeys[0] != "API_KEY" {
+		t.Errorf("Expected only env keys in the plan, got %v", plan.EnvKeys)
+	}
+
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Errorf("Expected the command not to run in dry run, stat returned %v", err)
+	}
+}
@@ -0,0 +1,55 @@
+package client
+
+import "time"
+
+// EventType identifies a connection or command lifecycle event.
+type EventType string
+
+const (
+	EventConnected        EventType = "connected"
+	EventDisconnected     EventType = "disconnected"
+	EventReconnecting     EventType = "reconnecting"
+	EventCommandReceived  EventType = "command_received"
+	EventCommandCompleted EventType = "command_completed"
+)
+
+// Event describes something that happened to the client. Connection
+// events carry Protocol and URL; command events carry the command fields,
+// and command_completed also its outcome and duration.
+type Event struct {
+	Type EventType
+	Time time.Time
+
+	Protocol string
+	URL      string
+
+	CommandID   string
+	CommandType string
+	Success     bool
+	Error       string
+	Duration    time.Duration
+}
+
+// OnEvent registers fn to be called for every event. Listeners run
+// synchronously on the goroutine that raised the event and must not block.
+func (c *Client) OnEvent(fn func(Event)) {
+	c.listenersMux.Lock()
+	defer c.listenersMux.Unlock()
+	c.listeners = append(c.listeners, fn)
+}
+
+func (c *Client) emit(event Event) {
+	event.Time = time.Now()
+
+	c.listenersMux.RLock()
+	listeners := append([]func(Event){}, c.listeners...)
+	c.listenersMux.RUnlock()
+	for _, fn := range listeners {
+		fn(event)
+	}
+}
+
+// emitConnection raises a connection event for the configured transport.
+func (c *Client) emitConnection(eventType EventType, address string) {
+	c.emit(Event{Type: eventType, Protocol: c.protocol, URL: address})
+}
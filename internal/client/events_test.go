@@ -0,0 +1,87 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestEventsReportConnectionFlap(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	// Accept each connection, wait for identification and drop it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.ReadMessage()
+		conn.Close()
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	cfg.WebSocket.Reconnect.Enabled = true
+	cfg.WebSocket.Reconnect.InitialDelay = 10 * time.Millisecond
+	c := NewClient(cfg)
+
+	events := make(chan Event, 32)
+	c.OnEvent(func(event Event) {
+		select {
+		case events <- event:
+		default:
+		}
+	})
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	var got []EventType
+	timeout := time.After(5 * time.Second)
+	for len(got) < 3 {
+		select {
+		case event := <-events:
+			if event.Protocol != "websocket" || event.URL != cfg.WebSocket.URL {
+				t.Errorf("Expected connection metadata on %s, got %+v", event.Type, event)
+			}
+			got = append(got, event.Type)
+		case <-timeout:
+			t.Fatalf("Timed out waiting for events, got %v", got)
+		}
+	}
+
+	if got[0] != EventConnected || got[1] != EventDisconnected || got[2] != EventReconnecting {
+		t.Errorf("Expected connected, disconnected, reconnecting; got %v", got)
+	}
+}
+
+func TestEventsReportCommandLifecycle(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.RegisterHandler("echo", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+
+	var events []Event
+	c.OnEvent(func(event Event) { events = append(events, event) })
+	c.runCommand(Command{Type: "echo", ID: "echo-1"})
+
+	if len(events) != 2 {
+		t.Fatalf("Expected received and completed events, got %+v", events)
+	}
+	if events[0].Type != EventCommandReceived || events[0].CommandID != "echo-1" || events[0].CommandType != "echo" {
+		t.Errorf("Unexpected received event %+v", events[0])
+	}
+	if events[1].Type != EventCommandCompleted || !events[1].Success || events[1].CommandID != "echo-1" {
+		t.Errorf("Unexpected completed event %+v", events[1])
+	}
+}
@@ -0,0 +1,73 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestClientFailsOverFromDeadPrimary(t *testing.T) {
+	// The primary is gone before the agent starts
+	dead := httptest.NewServer(http.NotFoundHandler())
+	deadURL := "ws" + strings.TrimPrefix(dead.URL, "http")
+	dead.Close()
+
+	responses := make(chan map[string]interface{}, 10)
+	upgrader := websocket.Upgrader{}
+	live := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		var identification map[string]interface{}
+		if conn.ReadJSON(&identification) != nil {
+			return
+		}
+		conn.WriteJSON(map[string]interface{}{"type": "status", "id": "via-secondary"})
+		for {
+			var message map[string]interface{}
+			if conn.ReadJSON(&message) != nil {
+				return
+			}
+			if message["type"] == "command_response" {
+				responses <- message
+			}
+		}
+	}))
+	defer live.Close()
+	liveURL := "ws" + strings.TrimPrefix(live.URL, "http")
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URLs = []string{deadURL, liveURL}
+	cfg.WebSocket.Reconnect.Enabled = true
+	cfg.WebSocket.Reconnect.MaxAttempts = 3
+	cfg.WebSocket.Reconnect.InitialDelay = 10 * time.Millisecond
+	c := NewClient(cfg)
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	select {
+	case response := <-responses:
+		if response["id"] != "via-secondary" || response["success"] != true {
+			t.Errorf("Unexpected response %v", response)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the command response from the secondary")
+	}
+
+	if stats := c.Stats(); stats.ActiveURL != liveURL || !stats.Connected {
+		t.Errorf("Expected to be connected to %s, got active_url %q connected %v", liveURL, stats.ActiveURL, stats.Connected)
+	}
+}
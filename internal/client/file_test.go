@@ -0,0 +1,151 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func newFileClient(t *testing.T, configure func(cfg *config.Config)) (*Client, string) {
+	t.Helper()
+	root := t.TempDir()
+	cfg := &config.Config{}
+	cfg.FileManager.Enabled = true
+	cfg.FileManager.BasePath = root
+	if configure != nil {
+		configure(cfg)
+	}
+	c := NewClient(cfg)
+	if c.fileMgr == nil {
+		t.Fatal("File manager was not initialized")
+	}
+	return c, root
+}
+
+func TestFileDownload(t *testing.T) {
+	c, root := newFileClient(t, nil)
+	if err := os.MkdirAll(filepath.Join(root, "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(root, "logs", "agent.log"), []byte("line 1\nline 2\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := c.processCommand(context.Background(), Command{Type: "file_download", ID: "dl", Payload: map[string]interface{}{"path": "logs/agent.log"}})
+	if !resp.Success {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	content, err := base64.StdEncoding.DecodeString(data["data"].(string))
+	if err != nil || string(content) != "line 1\nline 2\n" {
+		t.Errorf("Unexpected content %q (decode error %v)", content, err)
+	}
+	if data["size"] != int64(14) || data["path"] != "logs/agent.log" || data["mod_time"] == "" {
+		t.Errorf("Unexpected metadata: %v", data)
+	}
+}
+
+func TestFileDownloadRejectsOversizedFile(t *testing.T) {
+	c, root := newFileClient(t, func(cfg *config.Config) { cfg.FileManager.MaxFileBytes = 16 })
+	if err := os.WriteFile(filepath.Join(root, "big.bin"), make([]byte, 17), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	resp := c.processCommand(context.Background(), Command{Type: "file_download", ID: "dl", Payload: map[string]interface{}{"path": "big.bin"}})
+	if resp.Success || !strings.Contains(resp.Error, "download limit") {
+		t.Errorf("Expected the oversized file to be refused, got %+v", resp)
+	}
+}
+
+func TestFileDownloadRejectsTraversal(t *testing.T) {
+	c, root := newFileClient(t, nil)
+
+	paths := []string{"../etc/passwd", "logs/../../etc/passwd"}
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(outside, filepath.Join(root, "outside-link")); err == nil {
+		paths = append(paths, "outside-link/secret")
+	}
+
+	for _, path := range paths {
+		resp := c.processCommand(context.Background(), Command{Type: "file_download", ID: "dl", Payload: map[string]interface{}{"path": path}})
+		if resp.Success || !strings.Contains(resp.Error, "outside") {
+			t.Errorf("%s: expected the path to be rejected, got %+v", path, resp)
+		}
+	}
+}
+
+func TestFileUpload(t *testing.T) {
+	c, root := newFileClient(t, nil)
+
+	content := []byte("#!/bin/sh\necho ok\n")
+	resp := c.processCommand(context.Background(), Command{Type: "file_upload", ID: "up", Payload: map[string]interface{}{
+		"path": "bin/check.sh",
+		"data": base64.StdEncoding.EncodeToString(content),
+		"mode": "0750",
+	}})
+	if !resp.Success {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+	if data["bytes_written"] != len(content) {
+		t.Errorf("Expected %d bytes written, got %v", len(content), data["bytes_written"])
+	}
+	if data["sha256"] != "b4d644d4279594903f1a9911956432d9473041f2984fc6014c14d7402c7d126c" {
+		t.Errorf("Expected a sha256 checksum, got %v", data["sha256"])
+	}
+
+	written, err := os.ReadFile(filepath.Join(root, "bin", "check.sh"))
+	if err != nil || string(written) != string(content) {
+		t.Fatalf("Unexpected file content %q (error %v)", written, err)
+	}
+	if runtime.GOOS != "windows" {
+		info, _ := os.Stat(filepath.Join(root, "bin", "check.sh"))
+		if info.Mode().Perm() != 0750 {
+			t.Errorf("Expected mode 0750, got %v", info.Mode().Perm())
+		}
+	}
+
+	// No temp files are left behind
+	entries, _ := os.ReadDir(filepath.Join(root, "bin"))
+	if len(entries) != 1 {
+		t.Errorf("Expected only the uploaded file, found %d entries", len(entries))
+	}
+}
+
+func TestFileUploadRejectsTooLargePayload(t *testing.T) {
+	c, root := newFileClient(t, func(cfg *config.Config) { cfg.FileManager.MaxFileBytes = 8 })
+
+	resp := c.processCommand(context.Background(), Command{Type: "file_upload", ID: "up", Payload: map[string]interface{}{
+		"path": "big.bin",
+		"data": base64.StdEncoding.EncodeToString(make([]byte, 9)),
+	}})
+	if resp.Success || !strings.Contains(resp.Error, "upload limit") {
+		t.Errorf("Expected the payload to be refused, got %+v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(root, "big.bin")); !os.IsNotExist(err) {
+		t.Errorf("Expected no file to be written, stat error %v", err)
+	}
+}
+
+func TestFileUploadRejectsPathOutsideRoot(t *testing.T) {
+	c, root := newFileClient(t, nil)
+
+	resp := c.processCommand(context.Background(), Command{Type: "file_upload", ID: "up", Payload: map[string]interface{}{
+		"path": "../escaped.txt",
+		"data": base64.StdEncoding.EncodeToString([]byte("x")),
+	}})
+	if resp.Success || !strings.Contains(resp.Error, "outside") {
+		t.Errorf("Expected the path to be rejected, got %+v", resp)
+	}
+	if _, err := os.Stat(filepath.Join(filepath.Dir(root), "escaped.txt")); !os.IsNotExist(err) {
+		t.Errorf("Expected nothing to be written outside the root, stat error %v", err)
+	}
+}
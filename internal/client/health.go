@@ -0,0 +1,31 @@
+package client
+
+import (
+	"edge-agent/internal/health"
+	"fmt"
+)
+
+// startHealth starts the local health server when health.addr is set.
+func (c *Client) startHealth() error {
+	if c.config.Health.Addr == "" {
+		return nil
+	}
+	server := health.NewServer(c.config.Health.Addr, c.metrics, c.instruments.registry, c.isConnected)
+	if err := server.Start(); err != nil {
+		return fmt.Errorf("failed to start health server: %w", err)
+	}
+	c.health = server
+	return nil
+}
+
+func (c *Client) stopHealth() {
+	if c.health == nil {
+		return
+	}
+	c.health.Stop()
+	c.health = nil
+}
+
+func (c *Client) isConnected() bool {
+	return c.transport != nil && c.transport.IsConnected()
+}
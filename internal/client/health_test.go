@@ -0,0 +1,32 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"testing"
+)
+
+func TestHealthServerFollowsClientLifecycle(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Health.Addr = "127.0.0.1:0"
+	c := NewClient(cfg)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	url := "http://" + c.health.Addr() + "/healthz"
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("Health endpoint unreachable while running: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected 200 from /healthz, got %d", resp.StatusCode)
+	}
+
+	c.Stop()
+	if _, err := http.Get(url); err == nil {
+		t.Error("Expected the health server to stop with the client")
+	}
+}
@@ -0,0 +1,237 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// HeartbeatHook returns extra fields to merge into every heartbeat payload,
+// e.g. device-specific readings such as battery level or signal strength.
+type HeartbeatHook func() map[string]interface{}
+
+// SetHeartbeatHook registers a callback that contributes fields to heartbeats.
+// Fields returned by the hook take precedence over heartbeat.extra_fields.
+func (c *Client) SetHeartbeatHook(hook HeartbeatHook) {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+	c.heartbeatHook = hook
+}
+
+// buildHeartbeatPayload assembles the periodic heartbeat payload from the
+// built-in status fields, the static extra fields from config and the hook.
+// Stats are only included with heartbeat.include_stats.
+func (c *Client) buildHeartbeatPayload() map[string]interface{} {
+	payload := map[string]interface{}{
+		"status":    "active",
+		"timestamp": time.Now().UnixNano(),
+		"client_id": c.config.WebSocket.ClientID,
+	}
+	if c.config.Heartbeat.IncludeStats {
+		payload["client_stats"] = c.GetStats()
+	}
+
+	for k, v := range c.config.Heartbeat.ExtraFields {
+		payload[k] = v
+	}
+
+	c.heartbeatMux.Lock()
+	hook := c.heartbeatHook
+	c.heartbeatMux.Unlock()
+
+	if hook != nil {
+		for k, v := range hook() {
+			payload[k] = v
+		}
+	}
+
+	return payload
+}
+
+// heartbeatHistorySize is the number of recent heartbeats kept for heartbeat_history.
+const heartbeatHistorySize = 50
+
+// HeartbeatRecord is one heartbeat round-trip. Acked stays false until the
+// server answers with a heartbeat_ack carrying the same ID.
+type HeartbeatRecord struct {
+	ID      string     `json:"id"`
+	SentAt  time.Time  `json:"sent_at"`
+	AckedAt *time.Time `json:"acked_at,omitempty"`
+	RTTMs   float64    `json:"rtt_ms,omitempty"`
+	Acked   bool       `json:"acked"`
+}
+
+// sendHeartbeat sends a heartbeat with a unique ID and records it in the history.
+func (c *Client) sendHeartbeat() {
+	c.heartbeatMux.Lock()
+	c.heartbeatSeq++
+	id := fmt.Sprintf("heartbeat-%d", c.heartbeatSeq)
+	c.heartbeatMux.Unlock()
+
+	// Record before sending: the ack can arrive before Send returns
+	c.recordHeartbeatSent(id, time.Now())
+	err := c.send(map[string]interface{}{
+		"type":    "heartbeat",
+		"payload": c.buildHeartbeatPayload(),
+		"id":      id,
+	})
+	if err != nil {
+		slog.Warn("Failed to send heartbeat", "error", err)
+		c.forgetHeartbeat(id)
+		return
+	}
+
+	if timeout := c.heartbeatAckTimeout(); timeout > 0 {
+		time.AfterFunc(timeout, func() { c.checkHeartbeatAck(id) })
+	}
+}
+
+// maxMissedHeartbeats is the number of consecutive heartbeats left
+// unacknowledged before the connection is considered dead and redialed.
+const maxMissedHeartbeats = 2
+
+// heartbeatInterval returns heartbeat.interval, defaulting to 30 seconds.
+func (c *Client) heartbeatInterval() time.Duration {
+	if interval := c.config.Heartbeat.Interval; interval > 0 {
+		return interval
+	}
+	return 30 * time.Second
+}
+
+// heartbeatAckTimeout returns heartbeat.ack_timeout, defaulting to 10
+// seconds. A negative value turns the ack check off and returns 0.
+func (c *Client) heartbeatAckTimeout() time.Duration {
+	switch timeout := c.config.Heartbeat.AckTimeout; {
+	case timeout < 0:
+		return 0
+	case timeout > 0:
+		return timeout
+	}
+	return 10 * time.Second
+}
+
+// checkHeartbeatAck runs heartbeat.ack_timeout after the heartbeat was sent.
+// A missing ack counts as a miss; after maxMissedHeartbeats in a row the
+// connection is dropped so the transport reconnects. A half-open TCP
+// connection otherwise looks healthy until the OS gives up on it.
+func (c *Client) checkHeartbeatAck(id string) {
+	c.heartbeatMux.Lock()
+	acked := false
+	for i := len(c.heartbeats) - 1; i >= 0; i-- {
+		if c.heartbeats[i].ID == id {
+			acked = c.heartbeats[i].Acked
+			break
+		}
+	}
+	if acked {
+		c.heartbeatMux.Unlock()
+		return
+	}
+	c.missedHeartbeats++
+	missed := c.missedHeartbeats
+	if missed >= maxMissedHeartbeats {
+		c.missedHeartbeats = 0
+	}
+	c.heartbeatMux.Unlock()
+
+	if !c.isRunning() || !c.transport.IsConnected() {
+		return
+	}
+	slog.Warn("Heartbeat not acknowledged", "heartbeat_id", id, "missed", missed, "timeout", c.heartbeatAckTimeout())
+	if missed >= maxMissedHeartbeats {
+		slog.Warn("Server stopped acknowledging heartbeats, reconnecting", "protocol", c.protocol)
+		c.transport.DropConnection()
+	}
+}
+
+// resetMissedHeartbeats clears the miss count, e.g. after an ack or a redial.
+func (c *Client) resetMissedHeartbeats() {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+	c.missedHeartbeats = 0
+}
+
+func (c *Client) recordHeartbeatSent(id string, at time.Time) {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+
+	c.heartbeats = append(c.heartbeats, HeartbeatRecord{ID: id, SentAt: at})
+	if len(c.heartbeats) > heartbeatHistorySize {
+		c.heartbeats = append([]HeartbeatRecord(nil), c.heartbeats[len(c.heartbeats)-heartbeatHistorySize:]...)
+	}
+}
+
+// forgetHeartbeat drops the record of a heartbeat that could not be sent.
+func (c *Client) forgetHeartbeat(id string) {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+
+	for i := len(c.heartbeats) - 1; i >= 0; i-- {
+		if c.heartbeats[i].ID == id {
+			c.heartbeats = append(c.heartbeats[:i], c.heartbeats[i+1:]...)
+			return
+		}
+	}
+}
+
+// recordHeartbeatAck marks the heartbeat with the given ID as acknowledged.
+// It reports false if the heartbeat is unknown or was already acknowledged.
+func (c *Client) recordHeartbeatAck(id string, at time.Time) bool {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+
+	for i := len(c.heartbeats) - 1; i >= 0; i-- {
+		record := &c.heartbeats[i]
+		if record.ID != id {
+			continue
+		}
+		if record.Acked {
+			return false
+		}
+		record.Acked = true
+		c.missedHeartbeats = 0
+		record.AckedAt = &at
+		record.RTTMs = float64(at.Sub(record.SentAt).Microseconds()) / 1000
+		return true
+	}
+	return false
+}
+
+// heartbeatHistory returns up to limit most recent heartbeats, oldest first.
+// A limit of 0 or less returns the whole history.
+func (c *Client) heartbeatHistory(limit int) []HeartbeatRecord {
+	c.heartbeatMux.Lock()
+	defer c.heartbeatMux.Unlock()
+
+	records := c.heartbeats
+	if limit > 0 && limit < len(records) {
+		records = records[len(records)-limit:]
+	}
+	return append([]HeartbeatRecord(nil), records...)
+}
+
+func (c *Client) handleHeartbeatHistory(ctx context.Context, command Command) CommandResponse {
+	payload, _ := command.Payload.(map[string]interface{})
+	limit := 0
+	if l, ok := payload["limit"].(float64); ok {
+		limit = int(l)
+	}
+
+	history := c.heartbeatHistory(limit)
+	unacked := 0
+	for _, record := range history {
+		if !record.Acked {
+			unacked++
+		}
+	}
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"heartbeats":     history,
+			"unacknowledged": unacked,
+		},
+	}
+}
@@ -0,0 +1,300 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"edge-agent/internal/config"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestHeartbeatPayloadCustomFields(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Heartbeat.IncludeStats = true
+	cfg.Heartbeat.ExtraFields = map[string]interface{}{
+		"site":           "warehouse-7",
+		"signal_quality": "static",
+	}
+
+	c := NewClient(cfg)
+	c.SetHeartbeatHook(func() map[string]interface{} {
+		return map[string]interface{}{
+			"battery_level":  87,
+			"signal_quality": -61,
+		}
+	})
+
+	payload := c.buildHeartbeatPayload()
+
+	if payload["status"] != "active" {
+		t.Errorf("Expected status 'active', got %v", payload["status"])
+	}
+	if _, ok := payload["client_stats"]; !ok {
+		t.Error("Heartbeat payload is missing client_stats")
+	}
+	if payload["site"] != "warehouse-7" {
+		t.Errorf("Expected static field site=warehouse-7, got %v", payload["site"])
+	}
+	if payload["battery_level"] != 87 {
+		t.Errorf("Expected hook field battery_level=87, got %v", payload["battery_level"])
+	}
+	if payload["signal_quality"] != -61 {
+		t.Errorf("Expected hook to override signal_quality, got %v", payload["signal_quality"])
+	}
+}
+
+func TestHeartbeatOmitsStatsByDefault(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.WebSocket.ClientID = "edge-agent-001"
+	cfg.WebSocket.URL = "wss://server.example.com/ws"
+	c := NewClient(cfg)
+
+	payload := c.buildHeartbeatPayload()
+
+	if len(payload) != 3 || payload["status"] != "active" || payload["client_id"] != "edge-agent-001" {
+		t.Errorf("Expected only status, timestamp and client_id, got %v", payload)
+	}
+	if _, ok := payload["timestamp"].(int64); !ok {
+		t.Errorf("Expected a timestamp, got %v", payload["timestamp"])
+	}
+	if _, ok := payload["client_stats"]; ok {
+		t.Error("Expected client_stats to be omitted without heartbeat.include_stats")
+	}
+}
+
+func TestHeartbeatHistory(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	start := time.Now()
+	for i := 1; i <= 4; i++ {
+		c.recordHeartbeatSent(fmt.Sprintf("heartbeat-%d", i), start.Add(time.Duration(i)*time.Second))
+	}
+
+	// Acks for heartbeats 1 and 3 arrive via the command handler; 2 and 4 are lost
+	for _, ack := range []struct {
+		id    string
+		delay time.Duration
+	}{{"heartbeat-1", 1*time.Second + 40*time.Millisecond}, {"heartbeat-3", 3*time.Second + 250*time.Millisecond}} {
+		if !c.recordHeartbeatAck(ack.id, start.Add(ack.delay)) {
+			t.Fatalf("Ack for %s was not recorded", ack.id)
+		}
+	}
+	if c.recordHeartbeatAck("heartbeat-1", start.Add(5*time.Second)) {
+		t.Error("Duplicate ack should not be recorded")
+	}
+	if response := c.handleCommand(map[string]interface{}{"type": "heartbeat_ack", "id": "heartbeat-99"}); response != nil {
+		t.Errorf("Expected no response to heartbeat_ack, got %v", response)
+	}
+
+	resp := c.processCommand(context.Background(), Command{Type: "heartbeat_history", ID: "h1"})
+	if !resp.Success {
+		t.Fatalf("heartbeat_history failed: %s", resp.Error)
+	}
+	data := resp.Data.(map[string]interface{})
+	history := data["heartbeats"].([]HeartbeatRecord)
+	if len(history) != 4 {
+		t.Fatalf("Expected 4 heartbeats, got %d", len(history))
+	}
+	if data["unacknowledged"] != 2 {
+		t.Errorf("Expected 2 unacknowledged heartbeats, got %v", data["unacknowledged"])
+	}
+
+	expected := []struct {
+		acked bool
+		rtt   float64
+	}{{true, 40}, {false, 0}, {true, 250}, {false, 0}}
+	for i, record := range history {
+		if record.ID != fmt.Sprintf("heartbeat-%d", i+1) {
+			t.Errorf("Record %d: expected ID heartbeat-%d, got %s", i, i+1, record.ID)
+		}
+		if record.Acked != expected[i].acked || record.RTTMs != expected[i].rtt {
+			t.Errorf("Record %s: expected acked=%v rtt=%v, got acked=%v rtt=%v",
+				record.ID, expected[i].acked, expected[i].rtt, record.Acked, record.RTTMs)
+		}
+		if record.Acked == (record.AckedAt == nil) {
+			t.Errorf("Record %s: acked_at does not match acked=%v", record.ID, record.Acked)
+		}
+	}
+
+	// limit returns only the most recent heartbeats
+	resp = c.processCommand(context.Background(), Command{
+		Type:    "heartbeat_history",
+		ID:      "h2",
+		Payload: map[string]interface{}{"limit": float64(2)},
+	})
+	history = resp.Data.(map[string]interface{})["heartbeats"].([]HeartbeatRecord)
+	if len(history) != 2 || history[0].ID != "heartbeat-3" || history[1].ID != "heartbeat-4" {
+		t.Errorf("Expected heartbeat-3 and heartbeat-4 with limit 2, got %+v", history)
+	}
+}
+
+func TestHeartbeatHistoryIsBounded(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	for i := 1; i <= heartbeatHistorySize+10; i++ {
+		c.recordHeartbeatSent(fmt.Sprintf("heartbeat-%d", i), time.Now())
+	}
+
+	history := c.heartbeatHistory(0)
+	if len(history) != heartbeatHistorySize {
+		t.Fatalf("Expected %d heartbeats, got %d", heartbeatHistorySize, len(history))
+	}
+	if history[0].ID != "heartbeat-11" {
+		t.Errorf("Expected oldest kept heartbeat to be heartbeat-11, got %s", history[0].ID)
+	}
+}
+
+func TestUnacknowledgedHeartbeatsForceTCPReconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The server acks the first heartbeat and then goes silent while
+	// keeping the connection open, like a half-open peer.
+	var connections, acks atomic.Int32
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			connections.Add(1)
+			accepted <- struct{}{}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					var header [4]byte
+					if _, err := io.ReadFull(reader, header[:]); err != nil {
+						return
+					}
+					data := make([]byte, binary.BigEndian.Uint32(header[:]))
+					if _, err := io.ReadFull(reader, data); err != nil {
+						return
+					}
+					var message map[string]interface{}
+					json.Unmarshal(data, &message)
+					if message["type"] != "heartbeat" || !acks.CompareAndSwap(0, 1) {
+						continue
+					}
+					ack, _ := json.Marshal(map[string]interface{}{"type": "heartbeat_ack", "id": message["id"]})
+					frame := binary.BigEndian.AppendUint32(nil, uint32(len(ack)))
+					conn.Write(append(frame, ack...))
+				}
+			}()
+		}
+	}()
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "tcp"
+	cfg.WebSocket.URL = listener.Addr().String()
+	cfg.WebSocket.Reconnect.Enabled = true
+	cfg.WebSocket.Reconnect.InitialDelay = 10 * time.Millisecond
+	cfg.Heartbeat.Interval = 30 * time.Millisecond
+	cfg.Heartbeat.AckTimeout = 20 * time.Millisecond
+	c := NewClient(cfg)
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-accepted:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for connection %d, acks sent: %d", i+1, acks.Load())
+		}
+	}
+
+	if connections.Load() < 2 {
+		t.Errorf("Expected the client to reconnect, got %d connections", connections.Load())
+	}
+	history := c.heartbeatHistory(0)
+	if len(history) < 3 || !history[0].Acked {
+		t.Errorf("Expected an acked heartbeat followed by unacked ones, got %+v", history)
+	}
+}
+
+func TestHeartbeatAckResetsMissCount(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Heartbeat.AckTimeout = time.Second
+	c := NewClient(cfg)
+	c.transport = &recordingTransport{}
+
+	c.recordHeartbeatSent("heartbeat-1", time.Now())
+	c.checkHeartbeatAck("heartbeat-1")
+	c.recordHeartbeatSent("heartbeat-2", time.Now())
+	c.recordHeartbeatAck("heartbeat-2", time.Now())
+	c.checkHeartbeatAck("heartbeat-2")
+
+	c.heartbeatMux.Lock()
+	missed := c.missedHeartbeats
+	c.heartbeatMux.Unlock()
+	if missed != 0 {
+		t.Errorf("Expected an ack to reset the miss count, got %d", missed)
+	}
+}
+
+// instantAckTransport answers every heartbeat before Send returns, like a
+// server on a fast link.
+type instantAckTransport struct {
+	recordingTransport
+	client *Client
+}
+
+func (t *instantAckTransport) Send(message map[string]interface{}) error {
+	if message["type"] == "heartbeat" {
+		t.client.handleCommand(map[string]interface{}{"type": "heartbeat_ack", "id": message["id"]})
+	}
+	return t.recordingTransport.Send(message)
+}
+
+func TestHeartbeatAckedBeforeSendReturns(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.transport = &instantAckTransport{client: c}
+
+	c.sendHeartbeat()
+
+	history := c.heartbeatHistory(0)
+	if len(history) != 1 || !history[0].Acked {
+		t.Errorf("Expected the heartbeat to be recorded as acknowledged, got %+v", history)
+	}
+}
+
+func TestFailedHeartbeatIsNotRecorded(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.transport = &flakyTransport{}
+
+	c.sendHeartbeat()
+
+	if history := c.heartbeatHistory(0); len(history) != 0 {
+		t.Errorf("Expected a heartbeat that was not sent to be dropped, got %+v", history)
+	}
+}
+
+func TestHeartbeatAckTimeoutDefault(t *testing.T) {
+	cfg, _, err := config.Parse([]byte("version: 1\nheartbeat:\n  interval: \"15s\"\n"), true)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	c := NewClient(cfg)
+	if timeout := c.heartbeatAckTimeout(); timeout != 10*time.Second {
+		t.Errorf("Expected acks to be required within 10s without heartbeat.ack_timeout, got %v", timeout)
+	}
+
+	cfg.Heartbeat.AckTimeout = -time.Second
+	if timeout := c.heartbeatAckTimeout(); timeout != 0 {
+		t.Errorf("Expected a negative ack_timeout to turn the check off, got %v", timeout)
+	}
+}
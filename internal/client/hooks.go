@@ -0,0 +1,75 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/local"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// defaultHookTimeout is used when hooks.timeout is not configured.
+const defaultHookTimeout = 30 * time.Second
+
+// runHooks runs the hook commands in order and logs their output. It
+// stops at the first command that fails and returns its error.
+func (c *Client) runHooks(ctx context.Context, hook string, commands []string) error {
+	timeout := c.config.Hooks.Timeout
+	if timeout <= 0 {
+		timeout = defaultHookTimeout
+	}
+
+	for _, command := range commands {
+		result, err := local.NewLocalClient().ExecuteCommand(ctx, &local.LocalCommand{
+			Command:        command,
+			Timeout:        timeout,
+			Shell:          c.config.Local.Shell,
+			MaxOutputBytes: c.config.Local.MaxOutputBytes,
+		})
+		if err == nil && result.ExitCode != 0 {
+			err = fmt.Errorf("%q exited with code %d", command, result.ExitCode)
+		}
+
+		attrs := []any{"hook", hook, "command", command}
+		if result != nil {
+			attrs = append(attrs, "exit_code", result.ExitCode, "stdout", result.Stdout, "stderr", result.Stderr, "duration", result.Duration)
+		}
+		if err != nil {
+			slog.Error("Hook failed", append(attrs, "error", err)...)
+			return err
+		}
+		slog.Info("Hook finished", attrs...)
+	}
+	return nil
+}
+
+// connectionHooks runs on_connect and on_disconnect for connection events.
+// Hooks run in the background, one at a time in event order, so a slow
+// hook neither blocks the transport nor lets a later event overtake it.
+func (c *Client) connectionHooks(event Event) {
+	var hook string
+	var commands []string
+	switch event.Type {
+	case EventConnected:
+		hook, commands = "on_connect", c.config.Hooks.OnConnect
+	case EventDisconnected:
+		hook, commands = "on_disconnect", c.config.Hooks.OnDisconnect
+	}
+	if len(commands) == 0 {
+		return
+	}
+
+	c.hooksMux.Lock()
+	previous := c.lastHook
+	done := make(chan struct{})
+	c.lastHook = done
+	c.hooksMux.Unlock()
+
+	go func() {
+		defer close(done)
+		if previous != nil {
+			<-previous
+		}
+		c.runHooks(context.Background(), hook, commands)
+	}()
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// waitForFile waits until the file at path holds want.
+func waitForFile(t *testing.T, path, want string) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, _ := os.ReadFile(path)
+		if string(data) == want {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %q in %s, got %q", want, path, data)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestHooksRunAtLifecyclePoints(t *testing.T) {
+	trace := filepath.Join(t.TempDir(), "hooks.log")
+
+	upgrader := websocket.Upgrader{}
+	atConnect := make(chan string, 1)
+	hangUp := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := os.ReadFile(trace)
+		atConnect <- string(data)
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-hangUp
+		conn.Close()
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	cfg.Hooks.OnStart = []string{"echo start >> " + trace}
+	cfg.Hooks.OnConnect = []string{"echo connect >> " + trace}
+	cfg.Hooks.OnDisconnect = []string{"echo disconnect >> " + trace}
+	c := NewClient(cfg)
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	select {
+	case got := <-atConnect:
+		if got != "start\n" {
+			t.Errorf("Expected on_start to run before connecting, the log held %q", got)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the agent to connect")
+	}
+	waitForFile(t, trace, "start\nconnect\n")
+
+	close(hangUp)
+	waitForFile(t, trace, "start\nconnect\ndisconnect\n")
+}
+
+func TestOnStartFailureAbortsStartup(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Hooks.OnStart = []string{"exit 3"}
+
+	c := NewClient(cfg)
+	if err := c.Start(context.Background()); err != nil {
+		t.Errorf("Expected a failing on_start hook only to be logged, got %v", err)
+	}
+	c.Stop()
+
+	cfg.Hooks.FailOnError = true
+	c = NewClient(cfg)
+	err := c.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "on_start") {
+		t.Fatalf("Expected startup to be aborted, got %v", err)
+	}
+	if c.isRunning() {
+		t.Error("Expected the client not to be running after an aborted start")
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"edge-agent/internal/metrics"
+	"time"
+)
+
+// instruments are the Prometheus metrics the client maintains.
+type instruments struct {
+	registry  *metrics.Registry
+	commands  *metrics.CounterVec
+	duration  *metrics.HistogramVec
+	connected *metrics.Gauge
+}
+
+func newInstruments(registry *metrics.Registry) *instruments {
+	return &instruments{
+		registry:  registry,
+		commands:  registry.NewCounterVec("edge_agent_commands_total", "Commands processed, by command type and result.", "type", "result"),
+		duration:  registry.NewHistogramVec("edge_agent_command_duration_seconds", "Time taken to process a command, by command type.", metrics.DefaultBuckets, "type"),
+		connected: registry.NewGauge("edge_agent_transport_connected", "1 while the transport is connected to the control server, otherwise 0."),
+	}
+}
+
+// SetMetricsRegistry registers the client's metrics with registry instead
+// of the one created by NewClient. Call it before Start.
+func (c *Client) SetMetricsRegistry(registry *metrics.Registry) {
+	c.instruments = newInstruments(registry)
+}
+
+// MetricsRegistry returns the registry holding the client's metrics.
+func (c *Client) MetricsRegistry() *metrics.Registry {
+	return c.instruments.registry
+}
+
+// observeCommand records the result and duration of a processed command.
+func (c *Client) observeCommand(cmdType string, success bool, elapsed time.Duration) {
+	label := c.metrics.Label(cmdType)
+	result := "success"
+	if !success {
+		result = "error"
+	}
+	c.instruments.commands.Inc(label, result)
+	c.instruments.duration.Observe(elapsed.Seconds(), label)
+}
+
+func (c *Client) setConnected(connected bool) {
+	value := 0.0
+	if connected {
+		value = 1
+	}
+	c.instruments.connected.Set(value)
+}
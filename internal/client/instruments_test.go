@@ -0,0 +1,60 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/metrics"
+	"strings"
+	"testing"
+)
+
+func TestCommandMetricsIncrementAfterCommandRuns(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Metrics.CommandTypes = []string{"echo"}
+	c := NewClient(cfg)
+	registry := metrics.NewRegistry()
+	c.SetMetricsRegistry(registry)
+	c.RegisterHandler("echo", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: command.Payload == nil}
+	})
+
+	c.runCommand(Command{Type: "echo", ID: "echo-1"})
+	c.runCommand(Command{Type: "echo", ID: "echo-2"})
+	c.runCommand(Command{Type: "echo", ID: "echo-3", Payload: map[string]interface{}{"fail": true}})
+	c.runCommand(Command{Type: "unlisted", ID: "other-1"})
+
+	if got := c.instruments.commands.Value("echo", "success"); got != 2 {
+		t.Errorf("Expected 2 successful echo commands, got %v", got)
+	}
+	if got := c.instruments.commands.Value("echo", "error"); got != 1 {
+		t.Errorf("Expected 1 failed echo command, got %v", got)
+	}
+	if got := c.instruments.commands.Value(metrics.OtherLabel, "error"); got != 1 {
+		t.Errorf("Expected the unknown command under %q, got %v", metrics.OtherLabel, got)
+	}
+	if got := c.instruments.duration.Count("echo"); got != 3 {
+		t.Errorf("Expected 3 duration observations, got %d", got)
+	}
+
+	var buf bytes.Buffer
+	registry.WritePrometheus(&buf)
+	if !strings.Contains(buf.String(), `edge_agent_commands_total{type="echo",result="success"} 2`) {
+		t.Errorf("Injected registry does not expose the counters:\n%s", buf.String())
+	}
+}
+
+func TestTransportConnectedGauge(t *testing.T) {
+	c := NewClient(&config.Config{})
+	if got := c.instruments.connected.Value(); got != 0 {
+		t.Errorf("Expected the gauge to start at 0, got %v", got)
+	}
+	c.setConnected(true)
+	if got := c.instruments.connected.Value(); got != 1 {
+		t.Errorf("Expected 1 after connecting, got %v", got)
+	}
+	c.setConnected(false)
+	if got := c.instruments.connected.Value(); got != 0 {
+		t.Errorf("Expected 0 after disconnecting, got %v", got)
+	}
+}
@@ -0,0 +1,153 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLocalCommandPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Local.AllowedCommands = []string{`echo \w+`}
+	cfg.Local.DeniedPatterns = []string{"secret"}
+	c := NewClient(cfg)
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"echo hello", true},
+		{"echo secret", false}, // denied pattern
+		{"id", false},          // not in the allowlist
+	}
+	for _, tt := range tests {
+		resp := c.processCommand(context.Background(), Command{
+			Type:    "local_command",
+			ID:      "policy",
+			Payload: map[string]interface{}{"command": tt.command},
+		})
+		if tt.allowed && !resp.Success {
+			t.Errorf("Expected %q to run, got error %q", tt.command, resp.Error)
+		}
+		if !tt.allowed && (resp.Success || !strings.Contains(resp.Error, "command not permitted")) {
+			t.Errorf("Expected %q to be refused, got %+v", tt.command, resp)
+		}
+	}
+}
+
+func TestLocalCommandRejectsProtectedEnv(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{
+		Type: "local_command",
+		ID:   "env",
+		Payload: map[string]interface{}{
+			"command": "echo hello",
+			"env":     map[string]interface{}{"LD_PRELOAD": "/tmp/evil.so"},
+		},
+	})
+	if resp.Success || !strings.Contains(resp.Error, "LD_PRELOAD not permitted") {
+		t.Errorf("Expected LD_PRELOAD to be refused, got %+v", resp)
+	}
+}
+
+// saturateLocalSlots takes every local command slot and returns a function
+// giving them back.
+func saturateLocalSlots(t *testing.T, c *Client) func() {
+	t.Helper()
+	var releases []func()
+	for i := 0; i < cap(c.localSlots); i++ {
+		release, err := c.acquireLocalSlot(context.Background(), Command{ID: "hold"})
+		if err != nil {
+			t.Fatalf("Failed to take slot %d: %v", i, err)
+		}
+		releases = append(releases, release)
+	}
+	return func() {
+		for _, release := range releases {
+			release()
+		}
+	}
+}
+
+func TestLocalCommandConcurrencyLimitRejects(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Local.WhenBusy = "reject"
+	c := NewClient(cfg)
+	if cap(c.localSlots) != defaultMaxConcurrentLocal {
+		t.Fatalf("Expected %d slots by default, got %d", defaultMaxConcurrentLocal, cap(c.localSlots))
+	}
+
+	release := saturateLocalSlots(t, c)
+	command := Command{Type: "local_command", ID: "busy", Payload: map[string]interface{}{"command": "echo hello"}}
+	resp := c.processCommand(context.Background(), command)
+	if resp.Success || !strings.Contains(resp.Error, "too many concurrent commands") {
+		t.Errorf("Expected the command to be refused at the limit, got %+v", resp)
+	}
+
+	// Remote commands share the limit
+	remote := Command{Type: "local_command", ID: "busy-remote", Payload: map[string]interface{}{
+		"command": "uptime", "mode": "remote", "host": "127.0.0.1:1", "user": "admin", "password": "secret", "host_key": "x",
+	}}
+	if resp := c.processCommand(context.Background(), remote); !strings.Contains(resp.Error, "too many concurrent commands") {
+		t.Errorf("Expected the remote command to be refused at the limit, got %+v", resp)
+	}
+
+	release()
+	if resp := c.processCommand(context.Background(), command); !resp.Success {
+		t.Errorf("Expected the command to run once a slot is free, got %+v", resp)
+	}
+}
+
+func TestLocalCommandConcurrencyLimitQueues(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Local.MaxConcurrent = 1
+	c := NewClient(cfg)
+
+	release := saturateLocalSlots(t, c)
+	done := make(chan CommandResponse, 1)
+	go func() {
+		done <- c.processCommand(context.Background(), Command{Type: "local_command", ID: "queued", Payload: map[string]interface{}{"command": "echo hello"}})
+	}()
+
+	select {
+	case resp := <-done:
+		t.Fatalf("Expected the command to wait for a slot, got %+v", resp)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	release()
+	select {
+	case resp := <-done:
+		if !resp.Success {
+			t.Errorf("Expected the queued command to run, got %+v", resp)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Queued command never ran")
+	}
+	if len(c.localSlots) != 0 {
+		t.Errorf("Expected the slot to be freed, %d still taken", len(c.localSlots))
+	}
+}
+
+func TestLocalCommandConcurrencyLimitHonoursCancel(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Local.MaxConcurrent = 1
+	c := NewClient(cfg)
+	defer saturateLocalSlots(t, c)()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	resp := c.processCommand(ctx, Command{Type: "local_command", ID: "waiting", Payload: map[string]interface{}{"command": "echo hello"}})
+	if resp.Success || !strings.Contains(resp.Error, "free local command slot") {
+		t.Errorf("Expected the wait to end with the context, got %+v", resp)
+	}
+}
@@ -0,0 +1,49 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+)
+
+// defaultMaxConcurrentLocal is used when local.max_concurrent is not set.
+const defaultMaxConcurrentLocal = 2
+
+// newLocalSlots returns the semaphore bounding how many local_command
+// processes (local or over SSH) run at once, or nil when unbounded.
+func newLocalSlots(max int) chan struct{} {
+	if max < 0 {
+		return nil
+	}
+	if max == 0 {
+		max = defaultMaxConcurrentLocal
+	}
+	return make(chan struct{}, max)
+}
+
+// acquireLocalSlot reserves a slot for a local_command process. When all
+// are taken it waits for one, or with local.when_busy "reject" fails
+// straight away. The returned function frees the slot.
+func (c *Client) acquireLocalSlot(ctx context.Context, command Command) (func(), error) {
+	if c.localSlots == nil {
+		return func() {}, nil
+	}
+	release := func() { <-c.localSlots }
+
+	select {
+	case c.localSlots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if c.config.Local.WhenBusy == "reject" {
+		return nil, fmt.Errorf("too many concurrent commands: %d local commands already running", cap(c.localSlots))
+	}
+
+	slog.InfoContext(ctx, "Waiting for a free local command slot", "command_id", command.ID, "limit", cap(c.localSlots))
+	select {
+	case c.localSlots <- struct{}{}:
+		return release, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("gave up waiting for a free local command slot: %w", ctx.Err())
+	}
+}
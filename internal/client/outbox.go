@@ -0,0 +1,196 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// defaultOutboxSize is used when commands.outbox_size is not configured.
+const defaultOutboxSize = 100
+
+// outboxFile is the name of the spill file inside commands.outbox_dir.
+const outboxFile = "outbox.json"
+
+// outbox holds command responses that could not be delivered because the
+// connection was down, and replays them once it is back. The oldest
+// response is dropped when the outbox is full. With a directory set, the
+// queue is also written to disk so it survives a restart.
+type outbox struct {
+	mu      sync.Mutex
+	max     int
+	path    string // empty keeps the queue in memory only
+	entries []outboxEntry
+
+	// flushMu keeps one flush at a time so responses go out in order
+	flushMu sync.Mutex
+}
+
+type outboxEntry struct {
+	ID      string          `json:"id"`
+	Message json.RawMessage `json:"message"`
+}
+
+// newOutbox creates an outbox, loading any responses left on disk by a
+// previous run.
+func newOutbox(max int, dir string) *outbox {
+	if max <= 0 {
+		max = defaultOutboxSize
+	}
+	o := &outbox{max: max}
+	if dir == "" {
+		return o
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		slog.Warn("Outbox directory unavailable, keeping undelivered responses in memory", "dir", dir, "error", err)
+		return o
+	}
+	o.path = filepath.Join(dir, outboxFile)
+
+	data, err := os.ReadFile(o.path)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			slog.Warn("Failed to read outbox", "path", o.path, "error", err)
+		}
+		return o
+	}
+	if err := json.Unmarshal(data, &o.entries); err != nil {
+		slog.Warn("Discarding unreadable outbox", "path", o.path, "error", err)
+		o.entries = nil
+	}
+	if len(o.entries) > max {
+		o.entries = o.entries[len(o.entries)-max:]
+	}
+	return o
+}
+
+// push queues message under id, replacing an earlier response with the
+// same ID so a command is never answered twice.
+func (o *outbox) push(id string, message map[string]interface{}) error {
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to encode response: %w", err)
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for i, entry := range o.entries {
+		if entry.ID == id {
+			o.entries[i].Message = data
+			o.save()
+			return nil
+		}
+	}
+	o.entries = append(o.entries, outboxEntry{ID: id, Message: data})
+	if len(o.entries) > o.max {
+		dropped := o.entries[0]
+		o.entries = o.entries[1:]
+		slog.Warn("Outbox full, dropped oldest undelivered response", "command_id", dropped.ID, "size", o.max)
+	}
+	o.save()
+	return nil
+}
+
+// Len returns the number of undelivered responses.
+func (o *outbox) Len() int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.entries)
+}
+
+// flush sends queued responses in order until one fails; the rest stay
+// queued for the next flush.
+func (o *outbox) flush(send func(message map[string]interface{}) error) {
+	o.flushMu.Lock()
+	defer o.flushMu.Unlock()
+
+	for {
+		o.mu.Lock()
+		if len(o.entries) == 0 {
+			o.mu.Unlock()
+			return
+		}
+		entry := o.entries[0]
+		o.mu.Unlock()
+
+		var message map[string]interface{}
+		if err := json.Unmarshal(entry.Message, &message); err == nil {
+			if err := send(message); err != nil {
+				return
+			}
+			slog.Info("Delivered queued response", "command_id", entry.ID)
+		}
+
+		o.mu.Lock()
+		if len(o.entries) > 0 && o.entries[0].ID == entry.ID {
+			o.entries = o.entries[1:]
+			o.save()
+		}
+		o.mu.Unlock()
+	}
+}
+
+// save writes the queue to disk; the caller holds o.mu.
+func (o *outbox) save() {
+	if o.path == "" {
+		return
+	}
+	if len(o.entries) == 0 {
+		if err := os.Remove(o.path); err != nil && !os.IsNotExist(err) {
+			slog.Warn("Failed to clear outbox", "path", o.path, "error", err)
+		}
+		return
+	}
+
+	data, err := json.Marshal(o.entries)
+	if err != nil {
+		slog.Warn("Failed to encode outbox", "error", err)
+		return
+	}
+	// Write then rename so a crash never leaves a truncated file
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		slog.Warn("Failed to write outbox", "path", tmp, "error", err)
+		return
+	}
+	if err := os.Rename(tmp, o.path); err != nil {
+		slog.Warn("Failed to write outbox", "path", o.path, "error", err)
+	}
+}
+
+// sendResponse delivers a response produced after handleCommand returned.
+// If the connection is down, or earlier responses are still waiting, it is
+// queued in the outbox and delivered on reconnect.
+func (c *Client) sendResponse(response map[string]interface{}) {
+	if c.transport == nil {
+		return
+	}
+	response = c.signer.sign(c.compressResponse(response))
+	if c.outbox.Len() == 0 && c.transport.IsConnected() {
+		err := c.transport.Send(response)
+		if err == nil {
+			return
+		}
+		slog.Warn("Failed to send response, queueing it for redelivery", "command_id", response["id"], "protocol", c.protocol, "error", err)
+	}
+
+	id, _ := response["id"].(string)
+	if err := c.outbox.push(id, response); err != nil {
+		slog.Error("Failed to queue response", "command_id", id, "error", err)
+		return
+	}
+	c.flushOutbox()
+}
+
+// flushOutbox sends queued responses if the transport is connected.
+func (c *Client) flushOutbox() {
+	if c.transport == nil || !c.transport.IsConnected() {
+		return
+	}
+	c.outbox.flush(c.transport.Send)
+}
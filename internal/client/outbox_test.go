@@ -0,0 +1,156 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// flakyTransport is a recordingTransport whose connection can be dropped
+// and restored; sends fail while it is down.
+type flakyTransport struct {
+	recordingTransport
+	up          bool
+	onReconnect []func()
+}
+
+func (t *flakyTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.up
+}
+
+func (t *flakyTransport) Send(message map[string]interface{}) error {
+	if !t.IsConnected() {
+		return fmt.Errorf("not connected")
+	}
+	return t.recordingTransport.Send(message)
+}
+
+func (t *flakyTransport) OnReconnect(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onReconnect = append(t.onReconnect, fn)
+}
+
+func (t *flakyTransport) setUp(up bool) {
+	t.mu.Lock()
+	t.up = up
+	callbacks := append([]func(){}, t.onReconnect...)
+	t.mu.Unlock()
+	if up {
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+func (t *flakyTransport) sentIDs() []interface{} {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	var ids []interface{}
+	for _, message := range t.sent {
+		ids = append(ids, message["id"])
+	}
+	return ids
+}
+
+func TestResponseCompletedWhileDisconnectedIsDeliveredOnReconnect(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.URL = "ws://edge.test"
+	c := NewClient(cfg)
+	tr := &flakyTransport{up: true}
+	c.transport = tr
+
+	release := make(chan struct{})
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		<-release
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	c.handleCommand(map[string]interface{}{"type": "slow", "id": "s1"})
+	c.handleCommand(map[string]interface{}{"type": "slow", "id": "s2"})
+
+	// The connection drops while the commands run
+	tr.setUp(false)
+	close(release)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for c.outbox.Len() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := c.outbox.Len(); n != 2 {
+		t.Fatalf("Expected 2 queued responses, got %d", n)
+	}
+	if ids := tr.sentIDs(); len(ids) != 0 {
+		t.Fatalf("Expected nothing delivered while disconnected, got %v", ids)
+	}
+
+	tr.setUp(true)
+	ids := tr.sentIDs()
+	if len(ids) != 2 {
+		t.Fatalf("Expected both responses after reconnect, got %v", ids)
+	}
+	if c.outbox.Len() != 0 {
+		t.Errorf("Expected the outbox to be empty, got %d", c.outbox.Len())
+	}
+}
+
+func TestOutboxDropsOldestAndDeduplicates(t *testing.T) {
+	o := newOutbox(2, "")
+	o.push("a", map[string]interface{}{"id": "a"})
+	o.push("b", map[string]interface{}{"id": "b"})
+	o.push("b", map[string]interface{}{"id": "b", "retry": true})
+	o.push("c", map[string]interface{}{"id": "c"})
+
+	var mu sync.Mutex
+	var sent []map[string]interface{}
+	o.flush(func(message map[string]interface{}) error {
+		mu.Lock()
+		defer mu.Unlock()
+		sent = append(sent, message)
+		return nil
+	})
+
+	if len(sent) != 2 || sent[0]["id"] != "b" || sent[1]["id"] != "c" {
+		t.Fatalf("Expected b and c after dropping a, got %v", sent)
+	}
+	if sent[0]["retry"] != true {
+		t.Errorf("Expected the later response for b to replace the earlier one, got %v", sent[0])
+	}
+}
+
+func TestOutboxSpillsToDisk(t *testing.T) {
+	dir := t.TempDir()
+	o := newOutbox(10, dir)
+	o.push("a", map[string]interface{}{"id": "a", "success": true})
+
+	restored := newOutbox(10, dir)
+	if restored.Len() != 1 {
+		t.Fatalf("Expected the queued response to survive a restart, got %d", restored.Len())
+	}
+
+	failing := true
+	restored.flush(func(message map[string]interface{}) error {
+		if failing {
+			return fmt.Errorf("still down")
+		}
+		return nil
+	})
+	if restored.Len() != 1 {
+		t.Fatal("A failed flush must keep the response queued")
+	}
+	failing = false
+	restored.flush(func(message map[string]interface{}) error { return nil })
+	if again := newOutbox(10, dir); again.Len() != 0 {
+		t.Errorf("Expected the delivered response to be removed from disk, got %d", again.Len())
+	}
+}
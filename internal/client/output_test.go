@@ -0,0 +1,90 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/local"
+	"strings"
+	"testing"
+)
+
+func newOutputTestClient(t *testing.T) *Client {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.CommandOutput.StoreDir = t.TempDir()
+	cfg.CommandOutput.InlineMaxBytes = 1024
+	return NewClient(cfg)
+}
+
+func TestLocalCommandSmallOutputStaysInline(t *testing.T) {
+	c := newOutputTestClient(t)
+
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "local_command",
+		ID:      "small",
+		Payload: map[string]interface{}{"command": "echo hello"},
+	})
+
+	result, ok := resp.Data.(*local.LocalResult)
+	if !ok {
+		t.Fatalf("Unexpected response data: %+v", resp)
+	}
+	if result.Stdout != "hello\n" || result.StdoutRef != "" {
+		t.Errorf("Expected inline stdout, got stdout=%q ref=%q", result.Stdout, result.StdoutRef)
+	}
+}
+
+func TestLocalCommandLargeOutputStoredByReference(t *testing.T) {
+	c := newOutputTestClient(t)
+
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "local_command",
+		ID:      "large",
+		Payload: map[string]interface{}{"command": "head -c 4096 /dev/zero | tr '\\0' a"},
+	})
+
+	result, ok := resp.Data.(*local.LocalResult)
+	if !ok {
+		t.Fatalf("Unexpected response data: %+v", resp)
+	}
+	if result.Stdout != "" || result.StdoutRef == "" {
+		t.Fatalf("Expected stdout reference, got stdout=%d bytes ref=%q", len(result.Stdout), result.StdoutRef)
+	}
+
+	fetched := c.processCommand(context.Background(), Command{
+		Type:    "output_fetch",
+		ID:      "fetch",
+		Payload: map[string]interface{}{"ref": result.StdoutRef},
+	})
+	if !fetched.Success {
+		t.Fatalf("output_fetch failed: %s", fetched.Error)
+	}
+	data := fetched.Data.(map[string]interface{})
+	if data["content"] != strings.Repeat("a", 4096) {
+		t.Errorf("Fetched content mismatch: got %d bytes", len(data["content"].(string)))
+	}
+}
+
+func TestTimedOutCommandLargeOutputStoredByReference(t *testing.T) {
+	c := newOutputTestClient(t)
+
+	resp := c.processCommand(context.Background(), Command{
+		Type: "local_command",
+		ID:   "large-timeout",
+		Payload: map[string]interface{}{
+			"command": "head -c 4096 /dev/zero | tr '\\0' a; sleep 5",
+			"timeout": "500ms",
+		},
+	})
+
+	if resp.Success {
+		t.Fatalf("Expected the command to time out, got %+v", resp)
+	}
+	result, ok := resp.Data.(*local.LocalResult)
+	if !ok {
+		t.Fatalf("Expected the timed out command to report its output: %+v", resp)
+	}
+	if result.Stdout != "" || result.StdoutRef == "" {
+		t.Errorf("Expected stdout reference, got stdout=%d bytes ref=%q", len(result.Stdout), result.StdoutRef)
+	}
+}
@@ -0,0 +1,65 @@
+package client
+
+import (
+	"edge-agent/internal/scheduler"
+	"fmt"
+	"log/slog"
+)
+
+// defaultWorkers is used when scheduler.workers is not configured.
+const defaultWorkers = 4
+
+// errServerBusy is the error returned for commands refused by a full queue.
+const errServerBusy = "server busy: command queue is full, try again later"
+
+// defaultPriorities apply to command types not listed in scheduler.priorities;
+// everything else runs at normal priority.
+var defaultPriorities = map[string]scheduler.Priority{
+	"snapshot_state": scheduler.High,
+	"restore_state":  scheduler.High,
+	"file_download":  scheduler.Low,
+	"file_upload":    scheduler.Low,
+}
+
+// inlineCommands bypass the scheduler: keystrokes must reach the PTY in
+// order, and a cancel must not wait behind the command it targets.
+var inlineCommands = map[string]bool{
+	"shell_input":  true,
+	"shell_resize": true,
+	"cancel":       true,
+}
+
+// typePriorities merges the configured per-type priorities over the defaults.
+func typePriorities(configured map[string]string) map[string]scheduler.Priority {
+	priorities := make(map[string]scheduler.Priority, len(defaultPriorities)+len(configured))
+	for cmdType, p := range defaultPriorities {
+		priorities[cmdType] = p
+	}
+	for cmdType, name := range configured {
+		p, err := scheduler.ParsePriority(name)
+		if err != nil {
+			slog.Warn("ignoring scheduler priority", "type", cmdType, "error", err)
+			continue
+		}
+		priorities[cmdType] = p
+	}
+	return priorities
+}
+
+// commandPriority returns the priority of an incoming message: its own
+// "priority" field if present, otherwise the default for its type.
+func (c *Client) commandPriority(message map[string]interface{}) (scheduler.Priority, error) {
+	if raw, exists := message["priority"]; exists && raw != nil {
+		name, ok := raw.(string)
+		if !ok {
+			return scheduler.Normal, fmt.Errorf("invalid priority %v: must be one of low, normal, high", raw)
+		}
+		return scheduler.ParsePriority(name)
+	}
+
+	cmdType, _ := message["type"].(string)
+	if p, ok := c.priorities[cmdType]; ok {
+		return p, nil
+	}
+	return scheduler.Normal, nil
+}
@@ -0,0 +1,78 @@
+package client
+
+import (
+	"edge-agent/internal/config"
+	"edge-agent/internal/scheduler"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestExplicitPriorityJumpsQueue(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Scheduler.Workers = 1
+	cfg.Scheduler.Priorities = map[string]string{"local_command": "low"}
+	c := NewClient(cfg)
+
+	// Occupy the only worker so the rest of the commands queue up
+	block := make(chan struct{})
+	c.scheduler.Submit(scheduler.High, func() { <-block })
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+
+	messages := []map[string]interface{}{
+		{"type": "local_command", "id": "low-1"},
+		{"type": "local_command", "id": "low-2"},
+		{"type": "custom", "id": "normal-1"},
+		{"type": "local_command", "id": "urgent", "priority": "high"},
+	}
+	for _, message := range messages {
+		priority, err := c.commandPriority(message)
+		if err != nil {
+			t.Fatalf("commandPriority(%v) failed: %v", message, err)
+		}
+		id := message["id"].(string)
+		wg.Add(1)
+		c.scheduler.Submit(priority, func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, id)
+			mu.Unlock()
+		})
+	}
+
+	close(block)
+	wg.Wait()
+
+	expected := []string{"urgent", "normal-1", "low-1", "low-2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected order %v, got %v", expected, order)
+	}
+}
+
+func TestInvalidPriorityRejected(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	for _, priority := range []interface{}{"urgent", 5.0} {
+		response := c.handleCommand(map[string]interface{}{
+			"type":     "custom",
+			"id":       "cmd-1",
+			"priority": priority,
+		})
+		if response == nil {
+			t.Fatalf("Expected an immediate error response for priority %v", priority)
+		}
+		result := response["payload"].(CommandResponse)
+		if result.Success || !strings.Contains(result.Error, "invalid priority") {
+			t.Errorf("Expected invalid priority error for %v, got %+v", priority, result)
+		}
+	}
+	if c.scheduler.Len() != 0 {
+		t.Errorf("Expected nothing to be queued, got %d jobs", c.scheduler.Len())
+	}
+}
@@ -0,0 +1,159 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"fmt"
+	"testing"
+	"time"
+)
+
+// newFloodClient returns a client with one worker and room for two
+// waiting commands. Its "slow" handler blocks until release is closed.
+func newFloodClient(t *testing.T, overflow string) (*Client, *recordingTransport, chan struct{}) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Scheduler.Workers = 1
+	cfg.Scheduler.MaxQueueDepth = 2
+	cfg.Scheduler.Overflow = overflow
+	c := NewClient(cfg)
+	tr := &recordingTransport{}
+	c.transport = tr
+
+	release := make(chan struct{})
+	started := make(chan struct{}, 16)
+	c.RegisterHandler("slow", func(ctx context.Context, command Command) CommandResponse {
+		started <- struct{}{}
+		<-release
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	c.scheduler.Start()
+	t.Cleanup(c.scheduler.Stop)
+
+	// Occupy the only worker so the flood has to queue
+	if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": "running"}); resp != nil {
+		t.Fatalf("Expected the first command to be queued, got %v", resp)
+	}
+	<-started
+	return c, tr, release
+}
+
+// responses waits for n command responses and returns them by command ID.
+func responses(t *testing.T, tr *recordingTransport, n int) map[string]CommandResponse {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		byID := map[string]CommandResponse{}
+		tr.mu.Lock()
+		for _, message := range tr.sent {
+			if message["type"] == "command_response" {
+				response := message["payload"].(CommandResponse)
+				byID[response.ID] = response
+			}
+		}
+		tr.mu.Unlock()
+		if len(byID) >= n || time.Now().After(deadline) {
+			return byID
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestQueueRejectNewestUnderFlood(t *testing.T) {
+	c, tr, release := newFloodClient(t, "reject_newest")
+
+	busy := 0
+	for i := 0; i < 10; i++ {
+		resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": fmt.Sprintf("cmd-%d", i)})
+		if resp == nil {
+			continue
+		}
+		result := resp["payload"].(CommandResponse)
+		if result.Success || result.Error != errServerBusy {
+			t.Errorf("Expected a server busy response, got %+v", result)
+		}
+		busy++
+	}
+	if busy != 8 {
+		t.Errorf("Expected 8 commands to be refused, got %d", busy)
+	}
+	if depth := c.GetStats()["queue_depth"]; depth != 2 {
+		t.Errorf("Expected queue_depth 2, got %v", depth)
+	}
+
+	close(release)
+	got := responses(t, tr, 3)
+	for _, id := range []string{"running", "cmd-0", "cmd-1"} {
+		if !got[id].Success {
+			t.Errorf("Expected %s to run, got %+v", id, got[id])
+		}
+	}
+}
+
+func TestQueueRejectOldestUnderFlood(t *testing.T) {
+	c, tr, release := newFloodClient(t, "reject_oldest")
+
+	for i := 0; i < 10; i++ {
+		if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": fmt.Sprintf("cmd-%d", i)}); resp != nil {
+			t.Fatalf("Expected cmd-%d to be queued, got %v", i, resp)
+		}
+	}
+	if depth := c.GetStats()["queue_depth"]; depth != 2 {
+		t.Errorf("Expected queue_depth 2, got %v", depth)
+	}
+
+	got := responses(t, tr, 8)
+	for i := 0; i < 8; i++ {
+		id := fmt.Sprintf("cmd-%d", i)
+		if got[id].Success || got[id].Error != errServerBusy {
+			t.Errorf("Expected %s to be evicted as busy, got %+v", id, got[id])
+		}
+	}
+
+	close(release)
+	got = responses(t, tr, 11)
+	for _, id := range []string{"running", "cmd-8", "cmd-9"} {
+		if !got[id].Success {
+			t.Errorf("Expected %s to run, got %+v", id, got[id])
+		}
+	}
+}
+
+func TestQueueBlockUnderFlood(t *testing.T) {
+	c, tr, release := newFloodClient(t, "block")
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 10; i++ {
+			if resp := c.handleCommand(map[string]interface{}{"type": "slow", "id": fmt.Sprintf("cmd-%d", i)}); resp != nil {
+				t.Errorf("Expected cmd-%d to be queued, got %v", i, resp)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		t.Fatal("Expected the flood to block on the full queue")
+	case <-time.After(50 * time.Millisecond):
+	}
+	if depth := c.GetStats()["queue_depth"]; depth != 2 {
+		t.Errorf("Expected queue_depth 2, got %v", depth)
+	}
+
+	close(release)
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Flood still blocked after the queue drained")
+	}
+	got := responses(t, tr, 11)
+	for id, response := range got {
+		if !response.Success {
+			t.Errorf("Expected %s to succeed, got %+v", id, response)
+		}
+	}
+	if len(got) != 11 {
+		t.Errorf("Expected every command to run, got %d responses", len(got))
+	}
+}
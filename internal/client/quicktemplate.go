@@ -0,0 +1,116 @@
+package client
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// shellSafe matches the parameter values that may be substituted into a
+// local_command line: nothing sh, cmd or PowerShell would interpret.
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_.,:/@+=-]*$`)
+
+// unsafeMarker brackets the name of a parameter whose value is not
+// shellSafe while a command line is checked.
+const unsafeMarker = "\x00"
+
+// renderQuickCommand renders a quick command's payload like
+// renderQuickPayload. For a local_command it also refuses parameters
+// that would reach the shell command line with characters such as ; | $
+// or spaces, so "x; reboot" cannot run a second command.
+func renderQuickCommand(name, cmdType string, payload interface{}, params map[string]interface{}) (interface{}, error) {
+	rendered, err := renderQuickPayload(name, payload, params)
+	if err != nil || cmdType != "local_command" {
+		return rendered, err
+	}
+	fields, _ := payload.(map[string]interface{})
+	command, ok := fields["command"].(string)
+	if !ok {
+		return rendered, nil
+	}
+
+	// Render the line again with unsafe values swapped for markers: a
+	// marker in the result names a parameter the line actually uses
+	line, err := renderQuickPayload(name, command, guardParams("", params).(map[string]interface{}))
+	if err != nil {
+		return nil, err
+	}
+	if _, rest, found := strings.Cut(line.(string), unsafeMarker); found {
+		param, _, _ := strings.Cut(rest, unsafeMarker)
+		return nil, fmt.Errorf("quick command '%s': parameter %q may only contain letters, digits and _ . , : / @ + = - in a local_command", name, param)
+	}
+	return rendered, nil
+}
+
+// guardParams returns a copy of value with every scalar that is not
+// shellSafe replaced by its path between unsafeMarkers.
+func guardParams(path string, value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		guarded := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			itemPath := key
+			if path != "" {
+				itemPath = path + "." + key
+			}
+			guarded[key] = guardParams(itemPath, item)
+		}
+		return guarded
+	case []interface{}:
+		guarded := make([]interface{}, len(v))
+		for i, item := range v {
+			guarded[i] = guardParams(fmt.Sprintf("%s[%d]", path, i), item)
+		}
+		return guarded
+	default:
+		if shellSafe.MatchString(fmt.Sprint(v)) {
+			return v
+		}
+		return unsafeMarker + path + unsafeMarker
+	}
+}
+
+// renderQuickPayload returns a copy of a quick command's configured
+// payload with every string rendered as a text/template against params,
+// the payload of the invoking quick_command. A placeholder without a
+// matching parameter is an error rather than an empty string.
+func renderQuickPayload(name string, payload interface{}, params map[string]interface{}) (interface{}, error) {
+	switch value := payload.(type) {
+	case string:
+		if !strings.Contains(value, "{{") {
+			return value, nil
+		}
+		tmpl, err := template.New(name).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template in quick command '%s': %w", name, err)
+		}
+		var out strings.Builder
+		if err := tmpl.Execute(&out, params); err != nil {
+			return nil, fmt.Errorf("quick command '%s': %w", name, err)
+		}
+		return out.String(), nil
+	case map[string]interface{}:
+		rendered := make(map[string]interface{}, len(value))
+		for key, item := range value {
+			r, err := renderQuickPayload(name, item, params)
+			if err != nil {
+				return nil, err
+			}
+			rendered[key] = r
+		}
+		return rendered, nil
+	case []interface{}:
+		rendered := make([]interface{}, len(value))
+		for i, item := range value {
+			r, err := renderQuickPayload(name, item, params)
+			if err != nil {
+				return nil, err
+			}
+			rendered[i] = r
+		}
+		return rendered, nil
+	default:
+		return value, nil
+	}
+}
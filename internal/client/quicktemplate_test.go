@@ -0,0 +1,111 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/local"
+	"strings"
+	"testing"
+)
+
+func newQuickCommandClient() *Client {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.QuickCommands = map[string]interface{}{
+		"restart_service": map[string]interface{}{
+			"type": "local_command",
+			"payload": map[string]interface{}{
+				"command": "echo restarting {{.service}}",
+				"env":     map[string]interface{}{"APP_PORT": "{{.port}}"},
+			},
+		},
+	}
+	return NewClient(cfg)
+}
+
+func TestQuickCommandRendersParameters(t *testing.T) {
+	c := newQuickCommandClient()
+
+	resp := c.processCommand(context.Background(), Command{
+		Type: "quick_command",
+		ID:   "q1",
+		Payload: map[string]interface{}{
+			"command": "restart_service",
+			"service": "nginx",
+			"port":    float64(8080),
+		},
+	})
+	result, ok := resp.Data.(*local.LocalResult)
+	if !resp.Success || !ok {
+		t.Fatalf("Expected the rendered command to run, got %+v", resp)
+	}
+	if result.Stdout != "restarting nginx\n" {
+		t.Errorf("Expected the service substituted, got %q", result.Stdout)
+	}
+
+	// The configured template is left untouched for the next invocation
+	configured := c.config.QuickCommands["restart_service"].(map[string]interface{})["payload"].(map[string]interface{})
+	if configured["command"] != "echo restarting {{.service}}" {
+		t.Errorf("Expected the config template to be kept, got %v", configured["command"])
+	}
+}
+
+func TestQuickCommandMissingParameter(t *testing.T) {
+	c := newQuickCommandClient()
+
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "quick_command",
+		ID:      "q2",
+		Payload: map[string]interface{}{"command": "restart_service", "port": float64(8080)},
+	})
+	if resp.Success || !strings.Contains(resp.Error, "restart_service") || !strings.Contains(resp.Error, `"service"`) {
+		t.Errorf("Expected a missing-parameter error naming service, got %+v", resp)
+	}
+}
+
+func TestQuickCommandRefusesShellInjection(t *testing.T) {
+	c := newQuickCommandClient()
+
+	for _, service := range []string{"x; reboot", "x && id", "$(id)", "`id`", "x | sh", "a b", "x\nid"} {
+		resp := c.processCommand(context.Background(), Command{
+			Type:    "quick_command",
+			ID:      "q3",
+			Payload: map[string]interface{}{"command": "restart_service", "service": service, "port": float64(8080)},
+		})
+		if resp.Success || !strings.Contains(resp.Error, `parameter "service"`) {
+			t.Errorf("Expected service %q to be refused, got %+v", service, resp)
+		}
+	}
+
+	// Values outside the command line, such as env, are not restricted
+	resp := c.processCommand(context.Background(), Command{
+		Type:    "quick_command",
+		ID:      "q4",
+		Payload: map[string]interface{}{"command": "restart_service", "service": "nginx", "port": "80 80; id"},
+	})
+	if !resp.Success {
+		t.Errorf("Expected an env value with spaces to be accepted, got %+v", resp)
+	}
+}
+
+func TestRenderQuickPayloadNested(t *testing.T) {
+	rendered, err := renderQuickPayload("deploy", map[string]interface{}{
+		"args":    []interface{}{"--tag={{.tag}}", "--force"},
+		"timeout": "30s",
+		"retries": float64(3),
+	}, map[string]interface{}{"tag": "v1.2"})
+	if err != nil {
+		t.Fatalf("Render failed: %v", err)
+	}
+	payload := rendered.(map[string]interface{})
+	if args := payload["args"].([]interface{}); args[0] != "--tag=v1.2" || args[1] != "--force" {
+		t.Errorf("Unexpected args %v", args)
+	}
+	if payload["timeout"] != "30s" || payload["retries"] != float64(3) {
+		t.Errorf("Expected non-template values unchanged, got %v", payload)
+	}
+
+	if _, err := renderQuickPayload("deploy", "{{.tag", nil); err == nil || !strings.Contains(err.Error(), "invalid template") {
+		t.Errorf("Expected a parse error, got %v", err)
+	}
+}
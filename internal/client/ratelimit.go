@@ -0,0 +1,67 @@
+package client
+
+import (
+	"edge-agent/internal/config"
+	"sync"
+	"time"
+)
+
+// tokenBucket allows bursts of up to burst commands and refills at rate
+// tokens per second.
+type tokenBucket struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int, now time.Time) *tokenBucket {
+	if burst < 1 {
+		burst = 1
+	}
+	return &tokenBucket{rate: rate, burst: float64(burst), tokens: float64(burst), last: now}
+}
+
+// allow takes a token if one is available at time now.
+func (b *tokenBucket) allow(now time.Time) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if elapsed := now.Sub(b.last).Seconds(); elapsed > 0 {
+		b.tokens += elapsed * b.rate
+		if b.tokens > b.burst {
+			b.tokens = b.burst
+		}
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// newRateLimiters builds a bucket for every command type with a positive
+// requests_per_second; other types are not limited.
+func newRateLimiters(limits map[string]config.RateLimit) map[string]*tokenBucket {
+	buckets := make(map[string]*tokenBucket, len(limits))
+	now := time.Now()
+	for cmdType, limit := range limits {
+		if limit.RequestsPerSecond <= 0 {
+			continue
+		}
+		buckets[cmdType] = newTokenBucket(limit.RequestsPerSecond, limit.Burst, now)
+	}
+	return buckets
+}
+
+// allowCommand reports whether a command of the given type is within its rate limit.
+func (c *Client) allowCommand(cmdType string) bool {
+	bucket, ok := c.rateLimits[cmdType]
+	if !ok {
+		return true
+	}
+	return bucket.allow(time.Now())
+}
@@ -0,0 +1,62 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTokenBucketRefills(t *testing.T) {
+	start := time.Now()
+	bucket := newTokenBucket(2, 3, start)
+
+	for i := 0; i < 3; i++ {
+		if !bucket.allow(start) {
+			t.Fatalf("Expected burst command %d to be allowed", i+1)
+		}
+	}
+	if bucket.allow(start) {
+		t.Error("Expected the command past the burst to be rejected")
+	}
+	if !bucket.allow(start.Add(500 * time.Millisecond)) {
+		t.Error("Expected a token after half a second at 2 per second")
+	}
+	if bucket.allow(start.Add(500 * time.Millisecond)) {
+		t.Error("Expected only one token to be refilled")
+	}
+}
+
+func TestProcessCommandRejectsOverLimit(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.RateLimits = map[string]config.RateLimit{
+		"custom":       {RequestsPerSecond: 0.01, Burst: 2},
+		"http_request": {RequestsPerSecond: 0.01, Burst: 1},
+	}
+	c := NewClient(cfg)
+
+	var rejected int
+	for i := 0; i < 5; i++ {
+		resp := c.processCommand(context.Background(), Command{Type: "custom", ID: "burst"})
+		if !resp.Success {
+			if !strings.Contains(resp.Error, "rate limited") {
+				t.Fatalf("Expected a rate limit error, got %q", resp.Error)
+			}
+			rejected++
+		}
+	}
+	if rejected != 3 {
+		t.Errorf("Expected 3 of 5 commands to be rate limited, got %d", rejected)
+	}
+
+	// Other command types have their own buckets or none at all
+	resp := c.processCommand(context.Background(), Command{Type: "http_request", ID: "other"})
+	if strings.Contains(resp.Error, "rate limited") {
+		t.Errorf("http_request should not share the custom bucket, got %q", resp.Error)
+	}
+	resp = c.processCommand(context.Background(), Command{Type: "heartbeat_history", ID: "unlimited"})
+	if !resp.Success {
+		t.Errorf("Unlimited command type was rejected: %+v", resp)
+	}
+}
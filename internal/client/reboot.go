@@ -0,0 +1,59 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/local"
+	"fmt"
+	"log/slog"
+	"os/exec"
+	"strings"
+)
+
+// defaultRebootCommand gives the agent a minute to deliver the response
+// and shut down cleanly before the system goes down.
+const defaultRebootCommand = "shutdown -r +1"
+
+// runRebootCommand executes the reboot command through the local shell.
+func (c *Client) runRebootCommand(command string) error {
+	shell := c.config.Local.Shell
+	if len(shell) == 0 {
+		shell = local.DefaultShell()
+	}
+	args := append(append([]string{}, shell[1:]...), command)
+	out, err := exec.Command(shell[0], args...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("%s: %w: %s", command, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// handleReboot acknowledges the command and schedules the reboot to run
+// once the acknowledgment has been sent.
+func (c *Client) handleReboot(ctx context.Context, command Command) CommandResponse {
+	rebootCmd := c.config.Reboot.Command
+	if rebootCmd == "" {
+		rebootCmd = defaultRebootCommand
+	}
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"message": "reboot scheduled",
+			"command": rebootCmd,
+		},
+		afterSend: func() {
+			slog.Warn("Rebooting", "command", rebootCmd)
+			if err := c.reboot(rebootCmd); err != nil {
+				slog.Error("Reboot failed", "error", err)
+				if c.transport != nil {
+					c.send(map[string]interface{}{
+						"type":    "reboot_failed",
+						"id":      command.ID,
+						"payload": map[string]interface{}{"error": err.Error()},
+					})
+				}
+			}
+		},
+	}
+}
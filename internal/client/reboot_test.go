@@ -0,0 +1,97 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/transport"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingTransport records sent messages, and any other events the test
+// notes, in order.
+type recordingTransport struct {
+	mu     sync.Mutex
+	events []string
+	sent   []map[string]interface{}
+}
+
+func (t *recordingTransport) Connect(ctx context.Context, address, clientID string) error { return nil }
+func (t *recordingTransport) Disconnect() error                                           { t.record("disconnect"); return nil }
+func (t *recordingTransport) IsConnected() bool                                           { return true }
+func (t *recordingTransport) DropConnection()                                             {}
+func (t *recordingTransport) SetHandler(handler transport.Handler)                        {}
+func (t *recordingTransport) SetIdentityProvider(provider func() transport.Identity)      {}
+func (t *recordingTransport) OnReconnect(fn func())                                       {}
+func (t *recordingTransport) OnDisconnect(fn func())                                      {}
+func (t *recordingTransport) SetReconnect(reconnect transport.ReconnectConfig)            {}
+
+func (t *recordingTransport) Send(message map[string]interface{}) error {
+	t.mu.Lock()
+	t.sent = append(t.sent, message)
+	t.mu.Unlock()
+	t.record("send:" + message["type"].(string))
+	return nil
+}
+
+func (t *recordingTransport) record(event string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.events = append(t.events, event)
+}
+
+func (t *recordingTransport) recorded() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return append([]string{}, t.events...)
+}
+
+func TestRebootRunsAfterResponseIsSent(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.Reboot = true
+	cfg.Reboot.Command = "shutdown -r +5"
+	c := NewClient(cfg)
+
+	tr := &recordingTransport{}
+	c.transport = tr
+	rebooted := make(chan string, 1)
+	c.reboot = func(command string) error {
+		tr.record("reboot")
+		rebooted <- command
+		return nil
+	}
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	if resp := c.handleCommand(map[string]interface{}{"type": "reboot", "id": "reboot-1"}); resp != nil {
+		t.Fatalf("Expected the reboot to be queued, got immediate response %v", resp)
+	}
+
+	select {
+	case command := <-rebooted:
+		if command != "shutdown -r +5" {
+			t.Errorf("Expected configured reboot command, got %q", command)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Reboot executor was not invoked")
+	}
+
+	events := tr.recorded()
+	if len(events) != 2 || events[0] != "send:command_response" || events[1] != "reboot" {
+		t.Errorf("Expected the response to be sent before rebooting, got %v", events)
+	}
+}
+
+func TestRebootDisabledByDefault(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.reboot = func(command string) error {
+		t.Errorf("Reboot executor called while reboot is disabled")
+		return nil
+	}
+
+	resp := c.processCommand(context.Background(), Command{Type: "reboot", ID: "reboot-1"})
+	if resp.Success || resp.afterSend != nil {
+		t.Errorf("Expected reboot to be refused, got %+v", resp)
+	}
+}
@@ -0,0 +1,25 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"runtime/debug"
+)
+
+// recoverCommand turns a panic in a command handler into a failed response
+// so one bad command cannot take the agent down. It must be deferred
+// directly by the function whose response it replaces.
+func recoverCommand(ctx context.Context, command Command, response *CommandResponse) {
+	r := recover()
+	if r == nil {
+		return
+	}
+	slog.ErrorContext(ctx, "Command handler panicked", "command_id", command.ID, "type", command.Type, "panic", r)
+	slog.DebugContext(ctx, "Command handler panic stack", "command_id", command.ID, "stack", string(debug.Stack()))
+	*response = CommandResponse{
+		ID:      command.ID,
+		Success: false,
+		Error:   fmt.Sprintf("%s command failed: handler panicked: %v", command.Type, r),
+	}
+}
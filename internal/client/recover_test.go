@@ -0,0 +1,65 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPanickingHandlerReturnsErrorResponse(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.RegisterHandler("explode", func(ctx context.Context, command Command) CommandResponse {
+		payload := command.Payload.(map[string]interface{}) // panics on a string payload
+		return CommandResponse{ID: command.ID, Success: true, Data: payload}
+	})
+
+	resp := c.processCommand(context.Background(), Command{Type: "explode", ID: "boom-1", Payload: "not a map"})
+	if resp.Success || resp.ID != "boom-1" {
+		t.Fatalf("Expected a failed response for boom-1, got %+v", resp)
+	}
+	if !strings.Contains(resp.Error, "panicked") || !strings.Contains(resp.Error, "interface conversion") {
+		t.Errorf("Expected the recovered panic in the error, got %q", resp.Error)
+	}
+
+	// The agent keeps serving commands afterwards
+	if resp := c.processCommand(context.Background(), Command{Type: "status", ID: "status-1"}); !resp.Success {
+		t.Errorf("Expected status to succeed after the panic, got %+v", resp)
+	}
+}
+
+func TestPanicInQueuedCommandIsReportedToServer(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+	c.RegisterHandler("explode", func(ctx context.Context, command Command) CommandResponse {
+		panic("boom")
+	})
+	c.scheduler.Start()
+	defer c.scheduler.Stop()
+
+	if resp := c.handleCommand(map[string]interface{}{"type": "explode", "id": "boom-2"}); resp != nil {
+		t.Fatalf("Expected the command to be queued, got immediate response %v", resp)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		tr.mu.Lock()
+		sent := append([]map[string]interface{}{}, tr.sent...)
+		tr.mu.Unlock()
+		for _, message := range sent {
+			if message["type"] == "command_response" && message["id"] == "boom-2" {
+				response := message["payload"].(CommandResponse)
+				if response.Success || !strings.Contains(response.Error, "boom") {
+					t.Fatalf("Expected a failed response carrying the panic, got %v", message)
+				}
+				return
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Timed out waiting for the response to the panicking command")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,34 @@
+package client
+
+import (
+	"context"
+	"log/slog"
+)
+
+// HandlerFunc processes a command and returns its response.
+type HandlerFunc func(ctx context.Context, command Command) CommandResponse
+
+// RegisterHandler makes h handle commands of cmdType, taking precedence
+// over any built-in handler for that type. The enabled_commands gates and
+// rate limits still apply. Registering a nil handler removes the
+// registration.
+func (c *Client) RegisterHandler(cmdType string, h HandlerFunc) {
+	c.handlersMux.Lock()
+	defer c.handlersMux.Unlock()
+
+	if h == nil {
+		delete(c.handlers, cmdType)
+		return
+	}
+	if _, exists := c.handlers[cmdType]; exists {
+		slog.Warn("Replacing registered handler", "type", cmdType)
+	}
+	c.handlers[cmdType] = h
+}
+
+func (c *Client) registeredHandler(cmdType string) (HandlerFunc, bool) {
+	c.handlersMux.RLock()
+	defer c.handlersMux.RUnlock()
+	h, ok := c.handlers[cmdType]
+	return h, ok
+}
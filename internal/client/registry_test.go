@@ -0,0 +1,60 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"strings"
+	"testing"
+)
+
+func TestRegisteredHandlerIsDispatched(t *testing.T) {
+	c := NewClient(&config.Config{})
+	c.RegisterHandler("open_cell", func(ctx context.Context, command Command) CommandResponse {
+		payload, _ := command.Payload.(map[string]interface{})
+		return CommandResponse{ID: command.ID, Success: true, Data: map[string]interface{}{"opened": payload["cell"]}}
+	})
+
+	resp := c.processCommand(context.Background(), Command{Type: "open_cell", ID: "cell-1", Payload: map[string]interface{}{"cell": 7}})
+	if !resp.Success || resp.ID != "cell-1" {
+		t.Fatalf("Expected the registered handler to succeed, got %+v", resp)
+	}
+	if data := resp.Data.(map[string]interface{}); data["opened"] != 7 {
+		t.Errorf("Unexpected handler data %v", data)
+	}
+
+	found := false
+	for _, cmdType := range c.capabilities() {
+		found = found || cmdType == "open_cell"
+	}
+	if !found {
+		t.Errorf("Expected open_cell in capabilities %v", c.capabilities())
+	}
+
+	c.RegisterHandler("open_cell", nil)
+	resp = c.processCommand(context.Background(), Command{Type: "open_cell", ID: "cell-2"})
+	if resp.Success || !strings.Contains(resp.Error, "Unknown command type") {
+		t.Errorf("Expected open_cell to be unknown after unregistering, got %+v", resp)
+	}
+}
+
+func TestRegisteredHandlerRespectsEnabledGate(t *testing.T) {
+	cfg := &config.Config{}
+	c := NewClient(cfg)
+
+	calls := 0
+	c.RegisterHandler("api_call", func(ctx context.Context, command Command) CommandResponse {
+		calls++
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+
+	resp := c.processCommand(context.Background(), Command{Type: "api_call", ID: "a1"})
+	if resp.Success || resp.Error != "api_call commands are disabled" || calls != 0 {
+		t.Errorf("Expected disabled api_call to be refused without calling the handler, got %+v (calls %d)", resp, calls)
+	}
+
+	cfg.EnabledCommands.APICall = true
+	resp = c.processCommand(context.Background(), Command{Type: "api_call", ID: "a2"})
+	if !resp.Success || calls != 1 {
+		t.Errorf("Expected the registered handler to override the built-in, got %+v (calls %d)", resp, calls)
+	}
+}
@@ -0,0 +1,69 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/local"
+	"fmt"
+	"log/slog"
+)
+
+// remoteHost reads an SSH host and its credentials from a local_command
+// payload or its "jump" object.
+func remoteHost(payload map[string]interface{}) local.RemoteHost {
+	host, _ := payload["host"].(string)
+	user, _ := payload["user"].(string)
+	key, _ := payload["key"].(string)
+	password, _ := payload["password"].(string)
+	hostKey, _ := payload["host_key"].(string)
+	return local.RemoteHost{Host: host, User: user, Key: key, Password: password, HostKey: hostKey}
+}
+
+// handleRemoteCommand runs a local_command with "mode": "remote" on
+// another host over SSH. The command and env have already passed the
+// local policy.
+func (c *Client) handleRemoteCommand(ctx context.Context, command Command, payload map[string]interface{}, remoteCmd *local.RemoteCommand) CommandResponse {
+	remoteCmd.Target = remoteHost(payload)
+	if jump, ok := payload["jump"].(map[string]interface{}); ok {
+		jumpHost := remoteHost(jump)
+		remoteCmd.Jump = &jumpHost
+	}
+	remoteCmd.KnownHostsFile = c.config.Local.Remote.KnownHostsFile
+	remoteCmd.MaxOutputBytes = c.config.Local.MaxOutputBytes
+
+	localClient := local.NewLocalClient()
+	if c.dryRun(payload) {
+		plan, err := localClient.PlanRemote(remoteCmd)
+		return dryRunResponse(command, plan, err)
+	}
+
+	release, err := c.acquireLocalSlot(ctx, command)
+	if err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: err.Error()}
+	}
+	defer release()
+
+	result, err := localClient.ExecuteRemote(ctx, remoteCmd)
+	if err != nil {
+		slog.ErrorContext(ctx, "Failed to execute remote command", "command_id", command.ID, "host", remoteCmd.Target.Host, "error", err)
+		response := CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   fmt.Sprintf("Command execution failed: %v", err),
+		}
+		if result != nil {
+			c.storeLargeOutput(result)
+			response.Data = result
+		}
+		return response
+	}
+
+	slog.InfoContext(ctx, "Remote command executed", "command_id", command.ID, "host", remoteCmd.Target.Host, "exit_code", result.ExitCode)
+
+	c.storeLargeOutput(result)
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: result.ExitCode == 0,
+		Data:    result,
+	}
+}
@@ -0,0 +1,53 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/local"
+	"strings"
+	"testing"
+)
+
+func TestRemoteLocalCommandDryRun(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Local.Remote.KnownHostsFile = "/dev/null"
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{Type: "local_command", ID: "remote", Payload: map[string]interface{}{
+		"mode":     "remote",
+		"command":  "uptime",
+		"host":     "10.0.0.5",
+		"user":     "deploy",
+		"password": "secret",
+		"jump":     map[string]interface{}{"host": "bastion.example.com:2222", "user": "jump", "password": "secret"},
+		"dry_run":  true,
+	}})
+	if !resp.Success {
+		t.Fatalf("Expected dry run to succeed, got %q", resp.Error)
+	}
+	plan := resp.Data.(map[string]interface{})["plan"].(*local.RemotePlan)
+	if plan.Host != "10.0.0.5:22" || plan.User != "deploy" || plan.Jump != "jump@bastion.example.com:2222" || plan.Command != "uptime" {
+		t.Errorf("Unexpected plan %+v", plan)
+	}
+
+	resp = c.processCommand(context.Background(), Command{Type: "local_command", ID: "nocreds", Payload: map[string]interface{}{
+		"mode": "remote", "command": "uptime", "host": "10.0.0.5", "user": "deploy", "dry_run": true,
+	}})
+	if resp.Success || !strings.Contains(resp.Error, "key or password") {
+		t.Errorf("Expected missing credentials to be rejected, got %+v", resp)
+	}
+}
+
+func TestLocalCommandRejectsUnknownMode(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+
+	resp := c.processCommand(context.Background(), Command{Type: "local_command", ID: "bad", Payload: map[string]interface{}{
+		"mode": "telnet", "command": "uptime",
+	}})
+	if resp.Success || !strings.Contains(resp.Error, "unknown local_command mode") {
+		t.Errorf("Expected an unknown mode to be rejected, got %+v", resp)
+	}
+}
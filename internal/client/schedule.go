@@ -0,0 +1,109 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// parseSchedule reads the "schedule" field of a command envelope, either
+// {"delay": "10m"} or {"at": "2024-05-01T02:00:00Z"}, and returns how long
+// to wait before running the command. A time in the past is due now.
+func parseSchedule(raw interface{}, now time.Time) (time.Duration, error) {
+	schedule, ok := raw.(map[string]interface{})
+	if !ok {
+		return 0, fmt.Errorf("invalid schedule %v: must be an object with delay or at", raw)
+	}
+	delayRaw, hasDelay := schedule["delay"]
+	atRaw, hasAt := schedule["at"]
+
+	switch {
+	case hasDelay && hasAt:
+		return 0, fmt.Errorf("invalid schedule: set either delay or at, not both")
+	case hasDelay:
+		s, _ := delayRaw.(string)
+		delay, err := time.ParseDuration(s)
+		if err != nil || delay < 0 {
+			return 0, fmt.Errorf("invalid schedule delay %v: must be a non-negative duration such as \"10m\"", delayRaw)
+		}
+		return delay, nil
+	case hasAt:
+		s, _ := atRaw.(string)
+		at, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return 0, fmt.Errorf("invalid schedule at %v: must be an RFC3339 time", atRaw)
+		}
+		if delay := at.Sub(now); delay > 0 {
+			return delay, nil
+		}
+		return 0, nil
+	}
+	return 0, fmt.Errorf("invalid schedule: delay or at is required")
+}
+
+// scheduleCommand holds a command until it is due and then dispatches it
+// as if it had just arrived; its response is sent then. Until it fires it
+// can be cancelled by ID. Nothing is persisted: a schedule is lost when
+// the agent stops.
+func (c *Client) scheduleCommand(message map[string]interface{}, command Command, delay time.Duration) map[string]interface{} {
+	ctx, cancel := context.WithCancel(context.Background())
+	if !c.trackCommand(command.ID, cancel) {
+		cancel()
+		c.dedup.forget(command.ID)
+		return commandResponseMessage(CommandResponse{
+			ID:      command.ID,
+			Success: false,
+			Error:   fmt.Sprintf("a command with id %s is already in flight", command.ID),
+		})
+	}
+	c.commandsMux.Lock()
+	c.delayed[command.ID] = cancel
+	c.commandsMux.Unlock()
+
+	slog.Info("Command scheduled", "command_id", command.ID, "type", command.Type, "due", time.Now().Add(delay).Format(time.RFC3339))
+
+	go c.runWhenDue(ctx, message, command, delay)
+	return nil
+}
+
+func (c *Client) runWhenDue(ctx context.Context, message map[string]interface{}, command Command, delay time.Duration) {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+	case <-ctx.Done():
+	}
+
+	c.commandsMux.Lock()
+	delete(c.commands, command.ID)
+	delete(c.delayed, command.ID)
+	c.commandsMux.Unlock()
+
+	if ctx.Err() != nil {
+		if !c.isRunning() {
+			// Dropped by Stop; a retry after restart runs normally
+			c.dedup.forget(command.ID)
+			return
+		}
+		slog.Info("Scheduled command cancelled before it ran", "command_id", command.ID, "type", command.Type)
+		response := CommandResponse{ID: command.ID, Success: false, Error: "command cancelled"}
+		c.dedup.complete(command.ID, response)
+		c.sendResponse(commandResponseMessage(response))
+		return
+	}
+
+	if response := c.dispatchCommand(message, command); response != nil {
+		c.sendResponse(response)
+	}
+}
+
+// cancelDelayed drops every command still waiting for its schedule.
+func (c *Client) cancelDelayed() {
+	c.commandsMux.Lock()
+	defer c.commandsMux.Unlock()
+	for _, cancel := range c.delayed {
+		cancel()
+	}
+}
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDelayedCommandFiresWhenDue(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+
+	var ranAt atomic.Int64
+	c.RegisterHandler("window_reboot", func(ctx context.Context, command Command) CommandResponse {
+		ranAt.Store(time.Now().UnixNano())
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	start := time.Now()
+	resp := c.handleCommand(map[string]interface{}{
+		"type":     "window_reboot",
+		"id":       "w1",
+		"schedule": map[string]interface{}{"delay": "100ms"},
+	})
+	if resp != nil {
+		t.Fatalf("Expected the command to be scheduled, got %v", resp)
+	}
+	if ranAt.Load() != 0 {
+		t.Fatal("Scheduled command ran immediately")
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if events := tr.recorded(); len(events) != 1 || events[0] != "send:command_response" {
+		t.Fatalf("Expected one command_response once due, got %v", events)
+	}
+	if ran := time.Unix(0, ranAt.Load()); ran.Sub(start) < 100*time.Millisecond {
+		t.Errorf("Expected the command to wait for its delay, ran after %s", ran.Sub(start))
+	}
+	tr.mu.Lock()
+	sent := tr.sent[0]
+	tr.mu.Unlock()
+	if sent["id"] != "w1" || sent["success"] != true {
+		t.Errorf("Unexpected response %v", sent)
+	}
+}
+
+func TestDelayedCommandCanBeCancelled(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+
+	var runs atomic.Int32
+	c.RegisterHandler("window_reboot", func(ctx context.Context, command Command) CommandResponse {
+		runs.Add(1)
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	at := time.Now().Add(time.Hour).Format(time.RFC3339)
+	if resp := c.handleCommand(map[string]interface{}{
+		"type":     "window_reboot",
+		"id":       "w2",
+		"schedule": map[string]interface{}{"at": at},
+	}); resp != nil {
+		t.Fatalf("Expected the command to be scheduled, got %v", resp)
+	}
+
+	resp := c.handleCommand(map[string]interface{}{
+		"type":    "cancel",
+		"id":      "c1",
+		"payload": map[string]interface{}{"id": "w2"},
+	})
+	data := resp["payload"].(CommandResponse).Data.(map[string]interface{})
+	if data["cancelled"] != true {
+		t.Fatalf("Expected the scheduled command to be cancelled, got %v", data)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(tr.recorded()) < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	tr.mu.Lock()
+	sent := append([]map[string]interface{}(nil), tr.sent...)
+	tr.mu.Unlock()
+	if len(sent) != 1 || sent[0]["id"] != "w2" || sent[0]["payload"].(CommandResponse).Error != "command cancelled" {
+		t.Fatalf("Expected a cancelled response for w2, got %v", sent)
+	}
+	if runs.Load() != 0 {
+		t.Error("Cancelled command ran")
+	}
+}
+
+func TestParseSchedule(t *testing.T) {
+	now := time.Date(2024, 5, 1, 1, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		raw   interface{}
+		delay time.Duration
+		ok    bool
+	}{
+		{map[string]interface{}{"delay": "10m"}, 10 * time.Minute, true},
+		{map[string]interface{}{"at": "2024-05-01T02:00:00Z"}, time.Hour, true},
+		{map[string]interface{}{"at": "2024-04-30T00:00:00Z"}, 0, true},
+		{map[string]interface{}{"delay": "soon"}, 0, false},
+		{map[string]interface{}{"delay": "-1m"}, 0, false},
+		{map[string]interface{}{"at": "tomorrow"}, 0, false},
+		{map[string]interface{}{"delay": "1m", "at": "2024-05-01T02:00:00Z"}, 0, false},
+		{map[string]interface{}{}, 0, false},
+		{"10m", 0, false},
+	} {
+		delay, err := parseSchedule(tc.raw, now)
+		if (err == nil) != tc.ok || delay != tc.delay {
+			t.Errorf("parseSchedule(%v) = %s, %v; want %s, ok=%v", tc.raw, delay, err, tc.delay, tc.ok)
+		}
+	}
+}
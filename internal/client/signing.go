@@ -0,0 +1,154 @@
+package client
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// signatureField carries the hex HMAC-SHA256 of the rest of a message.
+const signatureField = "signature"
+
+// seqField is numbered by the transport as the message goes out, after it
+// was signed, so it is left out of the signature.
+const seqField = "seq"
+
+// signedAtField carries the Unix time in seconds at which a message was
+// signed. It is covered by the signature so a captured command cannot be
+// replayed once it is stale.
+const signedAtField = "signed_at"
+
+// signer signs outgoing messages and verifies incoming ones with a shared
+// secret. A nil signer, used when commands.signing_secret is empty, signs
+// nothing and accepts everything.
+type signer struct {
+	secret  []byte
+	maxSkew time.Duration
+	now     func() time.Time
+}
+
+// newSigner accepts commands signed up to half of dedupTTL away from the
+// agent's clock. A replay that passes that check then arrives while the
+// original's response is still in the dedup cache, so it is answered from
+// the cache instead of running again. With deduplication off the window
+// stays the same but such a replay runs.
+func newSigner(secret string, dedupTTL time.Duration) *signer {
+	if secret == "" {
+		return nil
+	}
+	if dedupTTL <= 0 {
+		dedupTTL = defaultDedupTTL
+	}
+	return &signer{secret: []byte(secret), maxSkew: dedupTTL / 2, now: time.Now}
+}
+
+// canonicalJSON encodes message without its signature and seq as compact
+// JSON with object keys sorted and numbers in their float64 form, so both
+// ends get the same bytes whatever order or types the message was built with.
+func canonicalJSON(message map[string]interface{}) ([]byte, error) {
+	unsigned := make(map[string]interface{}, len(message))
+	for key, value := range message {
+		if key != signatureField && key != seqField {
+			unsigned[key] = value
+		}
+	}
+
+	// Round-trip through generic values so structs are encoded like maps
+	data, err := json.Marshal(unsigned)
+	if err != nil {
+		return nil, err
+	}
+	var generic interface{}
+	if err := json.Unmarshal(data, &generic); err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(generic); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+func (s *signer) mac(message map[string]interface{}) ([]byte, error) {
+	data, err := canonicalJSON(message)
+	if err != nil {
+		return nil, err
+	}
+	h := hmac.New(sha256.New, s.secret)
+	h.Write(data)
+	return h.Sum(nil), nil
+}
+
+// sign adds the signed_at and signature fields to message and returns it.
+// A signed_at already set is kept.
+func (s *signer) sign(message map[string]interface{}) map[string]interface{} {
+	if s == nil || message == nil {
+		return message
+	}
+	if _, ok := message[signedAtField]; !ok {
+		message[signedAtField] = s.now().Unix()
+	}
+	sum, err := s.mac(message)
+	if err != nil {
+		slog.Error("Failed to sign message", "command_id", message["id"], "error", err)
+		return message
+	}
+	message[signatureField] = hex.EncodeToString(sum)
+	return message
+}
+
+// verify checks that message carries a valid signature and was signed
+// within maxSkew of now.
+func (s *signer) verify(message map[string]interface{}) error {
+	if s == nil {
+		return nil
+	}
+	signature, _ := message[signatureField].(string)
+	if signature == "" {
+		return fmt.Errorf("command rejected: missing signature")
+	}
+	got, err := hex.DecodeString(signature)
+	if err != nil {
+		return fmt.Errorf("command rejected: malformed signature")
+	}
+	want, err := s.mac(message)
+	if err != nil {
+		return fmt.Errorf("command rejected: cannot encode for verification: %w", err)
+	}
+	if !hmac.Equal(got, want) {
+		return fmt.Errorf("command rejected: invalid signature")
+	}
+
+	signedAt, ok := unixSeconds(message[signedAtField])
+	if !ok {
+		return fmt.Errorf("command rejected: missing signed_at")
+	}
+	if skew := s.now().Sub(signedAt); skew > s.maxSkew || skew < -s.maxSkew {
+		return fmt.Errorf("command rejected: signed_at is %s off the agent clock, more than %s allowed", skew.Round(time.Second), s.maxSkew)
+	}
+	return nil
+}
+
+// unixSeconds reads a signed_at value, a float64 once decoded from JSON.
+func unixSeconds(value interface{}) (time.Time, bool) {
+	switch v := value.(type) {
+	case float64:
+		return time.Unix(int64(v), 0), true
+	case int64:
+		return time.Unix(v, 0), true
+	}
+	return time.Time{}, false
+}
+
+// send signs message and hands it to the transport.
+func (c *Client) send(message map[string]interface{}) error {
+	return c.transport.Send(c.signer.sign(message))
+}
@@ -0,0 +1,214 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func newSigningClient(t *testing.T, secret string) (*Client, *atomic.Int32) {
+	t.Helper()
+	cfg := &config.Config{}
+	cfg.Commands.SigningSecret = secret
+	c := NewClient(cfg)
+
+	var calls atomic.Int32
+	c.RegisterHandler("echo", func(ctx context.Context, command Command) CommandResponse {
+		calls.Add(1)
+		return CommandResponse{ID: command.ID, Success: true, Data: command.Payload}
+	})
+	tr := &recordingTransport{}
+	c.transport = tr
+	c.scheduler.Start()
+	t.Cleanup(c.scheduler.Stop)
+	return c, &calls
+}
+
+func TestSignedCommandIsAcceptedAndResponseSigned(t *testing.T) {
+	c, _ := newSigningClient(t, "s3cret")
+	server := newSigner("s3cret", 0)
+
+	message := server.sign(map[string]interface{}{
+		"type":    "cancel",
+		"id":      "cancel-1",
+		"payload": map[string]interface{}{"command_id": "nothing-running", "note": "<&>"},
+	})
+
+	response := c.handleCommand(message)
+	if response == nil {
+		t.Fatal("Expected an inline response")
+	}
+	if payload := response["payload"].(CommandResponse); strings.Contains(payload.Error, "command rejected") {
+		t.Fatalf("Expected the signed command to be accepted, got %+v", payload)
+	}
+	if err := server.verify(response); err != nil {
+		t.Errorf("Expected the response to carry a valid signature: %v", err)
+	}
+}
+
+func TestTamperedCommandIsRejected(t *testing.T) {
+	c, calls := newSigningClient(t, "s3cret")
+	server := newSigner("s3cret", 0)
+
+	message := server.sign(map[string]interface{}{
+		"type":    "echo",
+		"id":      "echo-1",
+		"payload": map[string]interface{}{"amount": 10},
+	})
+	message["payload"] = map[string]interface{}{"amount": 1000}
+
+	response := c.handleCommand(message)
+	payload, _ := response["payload"].(CommandResponse)
+	if payload.Success || payload.Error != "command rejected: invalid signature" {
+		t.Fatalf("Expected the tampered command to be rejected, got %v", response)
+	}
+	if server.verify(response) != nil {
+		t.Error("Expected the rejection to be signed")
+	}
+
+	unsigned := map[string]interface{}{"type": "echo", "id": "echo-2"}
+	response = c.handleCommand(unsigned)
+	if payload, _ := response["payload"].(CommandResponse); payload.Error != "command rejected: missing signature" {
+		t.Errorf("Expected an unsigned command to be rejected, got %v", response)
+	}
+
+	forged := newSigner("guess", 0).sign(map[string]interface{}{"type": "echo", "id": "echo-3"})
+	if response := c.handleCommand(forged); response == nil {
+		t.Error("Expected a command signed with the wrong secret to be rejected")
+	}
+
+	if n := calls.Load(); n != 0 {
+		t.Errorf("Expected no rejected command to reach its handler, ran %d", n)
+	}
+}
+
+func TestStaleSignedCommandIsRejected(t *testing.T) {
+	c, calls := newSigningClient(t, "s3cret")
+	server := newSigner("s3cret", 0)
+
+	for _, tc := range []struct {
+		name     string
+		signedAt interface{}
+		errText  string
+	}{
+		{"captured earlier", time.Now().Add(-10 * time.Minute).Unix(), "more than 2m30s allowed"},
+		{"from the future", time.Now().Add(10 * time.Minute).Unix(), "more than 2m30s allowed"},
+		{"not a time", "yesterday", "missing signed_at"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			message := server.sign(map[string]interface{}{"type": "echo", "id": "echo-" + tc.name, signedAtField: tc.signedAt})
+			payload, _ := c.handleCommand(message)["payload"].(CommandResponse)
+			if payload.Success || !strings.Contains(payload.Error, tc.errText) {
+				t.Errorf("Expected a rejection mentioning %q, got %+v", tc.errText, payload)
+			}
+		})
+	}
+
+	if n := calls.Load(); n != 0 {
+		t.Errorf("Expected no stale command to reach its handler, ran %d", n)
+	}
+}
+
+func TestReplayedSignedCommandRunsOnce(t *testing.T) {
+	c, calls := newSigningClient(t, "s3cret")
+	server := newSigner("s3cret", 0)
+	message := server.sign(map[string]interface{}{"type": "echo", "id": "echo-replayed"})
+	captured, err := json.Marshal(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.handleCommand(message)
+	deadline := time.Now().Add(2 * time.Second)
+	for calls.Load() < 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var replayed map[string]interface{}
+	if err := json.Unmarshal(captured, &replayed); err != nil {
+		t.Fatal(err)
+	}
+	// Wait for the first response to be cached
+	for time.Now().Before(deadline) {
+		if cached, duplicate := c.dedup.begin("echo-replayed"); duplicate && cached != nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	response := c.handleCommand(replayed)
+	if payload, _ := response["payload"].(CommandResponse); !payload.Success {
+		t.Errorf("Expected the replay to be answered with the cached response, got %v", response)
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("Expected the replayed command to run once, ran %d times", n)
+	}
+}
+
+func TestCanonicalJSONIgnoresKeyOrderAndTypes(t *testing.T) {
+	a, err := canonicalJSON(map[string]interface{}{
+		"type":      "echo",
+		"payload":   CommandResponse{ID: "x", Success: true},
+		"signature": "ignored",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := canonicalJSON(map[string]interface{}{
+		"payload": map[string]interface{}{"success": true, "id": "x"},
+		"type":    "echo",
+	})
+	if string(a) != string(b) {
+		t.Errorf("Expected equal canonical forms:\n%s\n%s", a, b)
+	}
+}
+
+func TestSignatureSurvivesSequenceStamp(t *testing.T) {
+	s := newSigner("s3cret", 0)
+	var sequence transport.Sequence
+
+	signed := s.sign(map[string]interface{}{"type": "heartbeat", "payload": map[string]interface{}{"status": "ok"}})
+	data, err := json.Marshal(signed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sequence.Stamp(data) // numbering continues past the first message
+	stamped := sequence.Stamp(data)
+
+	var received map[string]interface{}
+	if err := json.Unmarshal(stamped, &received); err != nil {
+		t.Fatalf("Invalid stamped message %s: %v", stamped, err)
+	}
+	if received["seq"] != float64(2) {
+		t.Fatalf("Expected seq 2 in %s", stamped)
+	}
+	if err := s.verify(received); err != nil {
+		t.Errorf("Expected the signature to verify after stamping, got %v", err)
+	}
+}
+
+func TestSignedCommandOverWebSocket(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Commands.SigningSecret = "s3cret"
+	server := newSigner("s3cret", 0)
+
+	response := exchangeOverWebSocket(t, cfg, server.sign(map[string]interface{}{
+		"type":    "cancel",
+		"id":      "cancel-ws",
+		"payload": map[string]interface{}{"id": "nothing-running"},
+	}))
+
+	payload, _ := response["payload"].(map[string]interface{})
+	if errText, _ := payload["error"].(string); strings.Contains(errText, "signature") {
+		t.Fatalf("Expected the signed command to be accepted, got %v", response)
+	}
+	if err := server.verify(response); err != nil {
+		t.Errorf("Expected a signed response, got %v: %v", response, err)
+	}
+}
@@ -0,0 +1,226 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
+	"fmt"
+	"slices"
+	"time"
+)
+
+// runtimeGates are the enabled_commands gates that can be turned off while
+// the agent runs. A gate disabled in the configuration stays disabled.
+var runtimeGates = []string{"api_call", "http_request", "local_command", "file_manager"}
+
+// RuntimeState is the set of settings that can change while the agent runs.
+// Snapshots of it let the server try a change and roll it back later.
+type RuntimeState struct {
+	EnabledCommands map[string]bool `json:"enabled_commands"`
+	LogLevel        string          `json:"log_level"`
+	Reconnect       ReconnectState  `json:"reconnect"`
+}
+
+// ReconnectState mirrors websocket.reconnect with durations as strings.
+type ReconnectState struct {
+	Enabled           bool    `json:"enabled"`
+	MaxAttempts       int     `json:"max_attempts"`
+	InitialDelay      string  `json:"initial_delay"`
+	MaxDelay          string  `json:"max_delay"`
+	BackoffMultiplier float64 `json:"backoff_multiplier"`
+}
+
+var validLogLevels = map[string]bool{"": true, "debug": true, "info": true, "warn": true, "error": true}
+
+// Validate checks that the state can be applied.
+func (s RuntimeState) Validate() error {
+	if !validLogLevels[s.LogLevel] {
+		return fmt.Errorf("invalid log_level %q", s.LogLevel)
+	}
+	for name := range s.EnabledCommands {
+		if !slices.Contains(runtimeGates, name) {
+			return fmt.Errorf("unknown command type %q in enabled_commands", name)
+		}
+	}
+	if s.Reconnect.MaxAttempts < 0 {
+		return fmt.Errorf("reconnect.max_attempts must not be negative")
+	}
+	if s.Reconnect.BackoffMultiplier < 0 {
+		return fmt.Errorf("reconnect.backoff_multiplier must not be negative")
+	}
+	for field, value := range map[string]string{
+		"initial_delay": s.Reconnect.InitialDelay,
+		"max_delay":     s.Reconnect.MaxDelay,
+	} {
+		if d, err := time.ParseDuration(value); err != nil || d < 0 {
+			return fmt.Errorf("invalid reconnect.%s %q", field, value)
+		}
+	}
+	return nil
+}
+
+// configGate reports whether the configuration enables the gate name.
+func (c *Client) configGate(name string) bool {
+	switch name {
+	case "api_call":
+		return c.config.EnabledCommands.APICall
+	case "http_request":
+		return c.config.EnabledCommands.HTTPRequest
+	case "local_command":
+		return c.config.EnabledCommands.LocalCommand
+	case "file_manager":
+		return c.config.FileManager.Enabled
+	}
+	return false
+}
+
+// gateEnabled reports whether the gate name is enabled in the
+// configuration and not turned off at runtime.
+func (c *Client) gateEnabled(name string) bool {
+	c.stateMux.RLock()
+	defer c.stateMux.RUnlock()
+	return c.configGate(name) && !c.disabledCommands[name]
+}
+
+// enabledCommands returns the effective state of every runtime gate.
+func (c *Client) enabledCommands() map[string]bool {
+	c.stateMux.RLock()
+	defer c.stateMux.RUnlock()
+	return c.enabledCommandsLocked()
+}
+
+func (c *Client) enabledCommandsLocked() map[string]bool {
+	enabled := make(map[string]bool, len(runtimeGates))
+	for _, name := range runtimeGates {
+		enabled[name] = c.configGate(name) && !c.disabledCommands[name]
+	}
+	return enabled
+}
+
+// SetCommandEnabled turns the gate name (api_call, http_request,
+// local_command or file_manager) off or back on. Enabling only lifts a
+// runtime override: a gate disabled in the configuration stays disabled.
+func (c *Client) SetCommandEnabled(name string, enabled bool) error {
+	if !slices.Contains(runtimeGates, name) {
+		return fmt.Errorf("unknown command type %q", name)
+	}
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	if enabled {
+		delete(c.disabledCommands, name)
+	} else {
+		c.disabledCommands[name] = true
+	}
+	return nil
+}
+
+// SetLogLevel changes the level of the running logger.
+func (c *Client) SetLogLevel(name string) error {
+	if err := logging.SetLevel(name); err != nil {
+		return err
+	}
+	c.stateMux.Lock()
+	defer c.stateMux.Unlock()
+	c.logLevel = name
+	return nil
+}
+
+// SetReconnect replaces the reconnect policy used for future redials.
+func (c *Client) SetReconnect(reconnect transport.ReconnectConfig) {
+	c.stateMux.Lock()
+	c.reconnect = reconnect
+	c.stateMux.Unlock()
+	if c.transport != nil {
+		c.transport.SetReconnect(c.reconnectConfig())
+	}
+}
+
+// captureState returns the current runtime-mutable settings.
+func (c *Client) captureState() RuntimeState {
+	c.stateMux.RLock()
+	defer c.stateMux.RUnlock()
+
+	return RuntimeState{
+		EnabledCommands: c.enabledCommandsLocked(),
+		LogLevel:        c.logLevel,
+		Reconnect: ReconnectState{
+			Enabled:           c.reconnect.Enabled,
+			MaxAttempts:       c.reconnect.MaxAttempts,
+			InitialDelay:      c.reconnect.InitialDelay.String(),
+			MaxDelay:          c.reconnect.MaxDelay.String(),
+			BackoffMultiplier: c.reconnect.BackoffMultiplier,
+		},
+	}
+}
+
+// applyState validates state and applies it to the running agent. Gates
+// enabled in state only lift runtime overrides, so a restore can never
+// enable a command the configuration disables.
+func (c *Client) applyState(state RuntimeState) error {
+	if err := state.Validate(); err != nil {
+		return err
+	}
+
+	initialDelay, _ := time.ParseDuration(state.Reconnect.InitialDelay)
+	maxDelay, _ := time.ParseDuration(state.Reconnect.MaxDelay)
+
+	for name, enabled := range state.EnabledCommands {
+		if err := c.SetCommandEnabled(name, enabled); err != nil {
+			return err
+		}
+	}
+	if err := c.SetLogLevel(state.LogLevel); err != nil {
+		return err
+	}
+	c.SetReconnect(transport.ReconnectConfig{
+		Enabled:           state.Reconnect.Enabled,
+		MaxAttempts:       state.Reconnect.MaxAttempts,
+		InitialDelay:      initialDelay,
+		MaxDelay:          maxDelay,
+		BackoffMultiplier: state.Reconnect.BackoffMultiplier,
+	})
+	return nil
+}
+
+func (c *Client) handleSnapshotState(ctx context.Context, command Command) CommandResponse {
+	state := c.captureState()
+	snapshotID := fmt.Sprintf("snap-%d", time.Now().UnixNano())
+
+	c.stateMux.Lock()
+	c.snapshots[snapshotID] = state
+	c.stateMux.Unlock()
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"snapshot_id": snapshotID,
+			"state":       state,
+		},
+	}
+}
+
+func (c *Client) handleRestoreState(ctx context.Context, command Command) CommandResponse {
+	payload, ok := command.Payload.(map[string]interface{})
+	if !ok {
+		return CommandResponse{ID: command.ID, Success: false, Error: "Invalid payload"}
+	}
+
+	// Only snapshots the agent took itself can be restored
+	snapshotID, _ := payload["snapshot_id"].(string)
+	if snapshotID == "" {
+		return CommandResponse{ID: command.ID, Success: false, Error: "snapshot_id is required: only snapshots taken by snapshot_state can be restored"}
+	}
+	c.stateMux.RLock()
+	state, exists := c.snapshots[snapshotID]
+	c.stateMux.RUnlock()
+	if !exists {
+		return CommandResponse{ID: command.ID, Success: false, Error: fmt.Sprintf("Snapshot '%s' not found", snapshotID)}
+	}
+
+	if err := c.applyState(state); err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: fmt.Sprintf("Invalid state: %v", err)}
+	}
+
+	return CommandResponse{ID: command.ID, Success: true, Data: c.captureState()}
+}
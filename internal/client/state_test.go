@@ -0,0 +1,145 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/transport"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// snapshotState takes a snapshot through the snapshot_state command.
+func snapshotState(t *testing.T, c *Client) string {
+	t.Helper()
+	resp := c.processCommand(context.Background(), Command{Type: "snapshot_state", ID: "snap"})
+	if !resp.Success {
+		t.Fatalf("snapshot_state failed: %s", resp.Error)
+	}
+	snapshotID, _ := resp.Data.(map[string]interface{})["snapshot_id"].(string)
+	if snapshotID == "" {
+		t.Fatalf("snapshot_state returned no snapshot_id: %+v", resp.Data)
+	}
+	return snapshotID
+}
+
+func restoreState(c *Client, payload map[string]interface{}) CommandResponse {
+	return c.processCommand(context.Background(), Command{Type: "restore_state", ID: "restore", Payload: payload})
+}
+
+func TestSnapshotAndRestoreState(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.APICall = true
+	cfg.EnabledCommands.LocalCommand = true
+	cfg.Logging.Level = "info"
+	cfg.WebSocket.Reconnect.Enabled = true
+	cfg.WebSocket.Reconnect.InitialDelay = 5 * time.Second
+	cfg.WebSocket.Reconnect.MaxDelay = time.Minute
+	cfg.WebSocket.Reconnect.BackoffMultiplier = 2
+
+	c := NewClient(cfg)
+	original := c.captureState()
+	snapshotID := snapshotState(t, c)
+
+	// Mutate the runtime state
+	if err := c.SetCommandEnabled("local_command", false); err != nil {
+		t.Fatalf("SetCommandEnabled failed: %v", err)
+	}
+	if err := c.SetLogLevel("debug"); err != nil {
+		t.Fatalf("SetLogLevel failed: %v", err)
+	}
+	t.Cleanup(func() { c.SetLogLevel("info") })
+	c.SetReconnect(transport.ReconnectConfig{Enabled: true, MaxAttempts: 3, InitialDelay: time.Second, MaxDelay: time.Second})
+
+	if reflect.DeepEqual(c.captureState(), original) {
+		t.Fatal("State did not change after mutation")
+	}
+	if c.commandEnabled("local_command") {
+		t.Error("Expected local_command to be disabled at runtime")
+	}
+
+	if resp := restoreState(c, map[string]interface{}{"snapshot_id": snapshotID}); !resp.Success {
+		t.Fatalf("restore_state failed: %s", resp.Error)
+	}
+
+	if restored := c.captureState(); !reflect.DeepEqual(restored, original) {
+		t.Errorf("Restored state mismatch:\nexpected %+v\ngot      %+v", original, restored)
+	}
+	if !c.commandEnabled("local_command") {
+		t.Error("Expected local_command to be enabled after restore")
+	}
+	if !cfg.EnabledCommands.LocalCommand || cfg.Logging.Level != "info" {
+		t.Error("Expected the configuration to be left untouched")
+	}
+}
+
+func TestRestoreStateRejectsStateBody(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	resp := restoreState(c, map[string]interface{}{
+		"state": map[string]interface{}{
+			"enabled_commands": map[string]interface{}{"local_command": true},
+			"log_level":        "debug",
+			"reconnect":        map[string]interface{}{"initial_delay": "1s", "max_delay": "1m"},
+		},
+	})
+	if resp.Success {
+		t.Error("Expected restore_state to reject a state it did not snapshot")
+	}
+	if c.commandEnabled("local_command") {
+		t.Error("Expected local_command to stay disabled")
+	}
+}
+
+func TestRestoreStateCannotEnableConfigDisabledCommand(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	// Forge a snapshot that claims local_command was on
+	state := c.captureState()
+	state.EnabledCommands["local_command"] = true
+	c.stateMux.Lock()
+	c.snapshots["snap-forged"] = state
+	c.stateMux.Unlock()
+
+	if resp := restoreState(c, map[string]interface{}{"snapshot_id": "snap-forged"}); !resp.Success {
+		t.Fatalf("restore_state failed: %s", resp.Error)
+	}
+	if c.commandEnabled("local_command") {
+		t.Error("Expected restore_state not to enable a command disabled in the configuration")
+	}
+	if err := c.SetCommandEnabled("local_command", true); err != nil {
+		t.Fatalf("SetCommandEnabled failed: %v", err)
+	}
+	if c.commandEnabled("local_command") {
+		t.Error("Expected SetCommandEnabled not to enable a command disabled in the configuration")
+	}
+}
+
+func TestRestoreStateUnknownSnapshot(t *testing.T) {
+	c := NewClient(&config.Config{})
+
+	if resp := restoreState(c, map[string]interface{}{"snapshot_id": "snap-missing"}); resp.Success {
+		t.Error("Expected restore_state to fail for an unknown snapshot")
+	}
+}
+
+func TestRestoreStateWhileCommandsRun(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.LocalCommand = true
+	c := NewClient(cfg)
+	snapshotID := snapshotState(t, c)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 100; i++ {
+			c.SetCommandEnabled("local_command", false)
+			restoreState(c, map[string]interface{}{"snapshot_id": snapshotID})
+		}
+	}()
+	for i := 0; i < 100; i++ {
+		c.commandEnabled("local_command")
+		c.reconnectConfig()
+	}
+	<-done
+}
@@ -0,0 +1,193 @@
+package client
+
+import (
+	"math"
+	"time"
+
+	"github.com/shirou/gopsutil/v3/cpu"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/mem"
+)
+
+// Stats is a snapshot of the client's state and activity.
+type Stats struct {
+	Running   bool   `json:"running"`
+	Connected bool   `json:"connected"`
+	Protocol  string `json:"protocol"`
+	URL       string `json:"url"`
+	// ActiveURL is the endpoint in use, which differs from URL after
+	// failing over to another of websocket.urls.
+	ActiveURL string `json:"active_url"`
+
+	// ReconnectState is "connected", "reconnecting", "disconnected" or
+	// "disabled"; see reconnectState.
+	ReconnectState string `json:"reconnect_state"`
+	// LastConnectedAt is when the transport last (re)connected, zero if
+	// it never has; ConnectionUptimeSeconds is how long ago that was
+	// while the connection is still up.
+	LastConnectedAt         time.Time `json:"last_connected_at"`
+	ConnectionUptimeSeconds int64     `json:"connection_uptime_seconds"`
+	// LastDisconnectedAt is when the connection was last lost or closed,
+	// zero if it never was.
+	LastDisconnectedAt time.Time `json:"last_disconnected_at"`
+	// LastError is the most recent failure to connect, empty if none.
+	LastError string `json:"last_error,omitempty"`
+	// ReconnectAttempts counts connection losses after which the
+	// transport tried to reconnect; Reconnects counts those that succeeded.
+	ReconnectAttempts int64 `json:"reconnect_attempts"`
+	Reconnects        int64 `json:"reconnects"`
+	// MessagesDropped counts outgoing messages discarded because the
+	// send queue stayed full (WebSocket only).
+	MessagesDropped uint64 `json:"messages_dropped"`
+	// MalformedMessages counts incoming messages that were not valid
+	// JSON; each was answered with an "invalid json" error.
+	MalformedMessages uint64 `json:"malformed_messages"`
+	// SequenceGaps counts incoming messages whose seq skipped ahead or
+	// went backwards (tcp and websocket protocols).
+	SequenceGaps uint64 `json:"sequence_gaps"`
+	// QueuedResponses is how many command responses wait for the
+	// connection to come back.
+	QueuedResponses int `json:"queued_responses"`
+	// QueueDepth is how many commands wait for a free worker.
+	QueueDepth int `json:"queue_depth"`
+
+	CommandsProcessed uint64            `json:"commands_processed"`
+	CommandsFailed    uint64            `json:"commands_failed"`
+	CommandsByType    map[string]uint64 `json:"commands_by_type"`
+	FailuresByType    map[string]uint64 `json:"failures_by_type"`
+	// LastCommandAt is when the last command arrived, zero if none has.
+	LastCommandAt   time.Time       `json:"last_command_at"`
+	EnabledCommands map[string]bool `json:"enabled_commands"`
+
+	CPUUsage float64 `json:"cpu_usage"` // percent
+	MemUsage float64 `json:"mem_usage"` // percent
+	DiskFree float64 `json:"disk_free"` // GB free on /
+}
+
+// Stats returns a snapshot of the client's state.
+func (c *Client) Stats() Stats {
+	connected := c.isConnected()
+
+	c.connMux.Lock()
+	lastConnectedAt, reconnectAttempts := c.lastConnectedAt, c.reconnectAttempts
+	lastDisconnectedAt, lastError := c.lastDisconnectedAt, c.lastError
+	c.connMux.Unlock()
+
+	stats := Stats{
+		Running:            c.isRunning(),
+		Connected:          connected,
+		Protocol:           c.protocol,
+		URL:                c.config.WebSocket.URL,
+		ActiveURL:          c.activeURL(),
+		ReconnectState:     c.reconnectState(connected),
+		LastConnectedAt:    lastConnectedAt,
+		LastDisconnectedAt: lastDisconnectedAt,
+		LastError:          lastError,
+		ReconnectAttempts:  reconnectAttempts,
+		Reconnects:         c.reconnects.Load(),
+		QueuedResponses:    c.outbox.Len(),
+		QueueDepth:         c.scheduler.Len(),
+		CommandsProcessed:  c.commandsTotal.Load(),
+		CommandsFailed:     c.commandsFailed.Load(),
+		CommandsByType:     c.metrics.Counts(),
+		FailuresByType:     c.metrics.FailedCounts(),
+		EnabledCommands:    c.enabledCommands(),
+	}
+
+	if connected && !lastConnectedAt.IsZero() {
+		stats.ConnectionUptimeSeconds = int64(time.Since(lastConnectedAt).Seconds())
+	}
+	if counter, ok := c.transport.(interface{ Dropped() uint64 }); ok {
+		stats.MessagesDropped = counter.Dropped()
+	}
+	if counter, ok := c.transport.(interface{ MalformedMessages() uint64 }); ok {
+		stats.MalformedMessages = counter.MalformedMessages()
+	}
+	if counter, ok := c.transport.(interface{ SequenceGaps() uint64 }); ok {
+		stats.SequenceGaps = counter.SequenceGaps()
+	}
+	if last := c.lastCommandAt.Load(); last != 0 {
+		stats.LastCommandAt = time.Unix(0, last)
+	}
+
+	if cpuPerc, _ := cpu.Percent(0, false); len(cpuPerc) > 0 {
+		stats.CPUUsage = math.Round(cpuPerc[0]*100) / 100
+	}
+	if vm, _ := mem.VirtualMemory(); vm != nil {
+		stats.MemUsage = math.Round(vm.UsedPercent*100) / 100
+	}
+	if usage, _ := disk.Usage("/"); usage != nil {
+		stats.DiskFree = math.Round(float64(usage.Free)/(1024*1024*1024)*100) / 100
+	}
+	return stats
+}
+
+// GetStats returns Stats as a map, as sent in heartbeats and the
+// identification metadata.
+//
+// Deprecated: use Stats.
+func (c *Client) GetStats() map[string]interface{} {
+	stats := c.Stats()
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return ""
+		}
+		return t.Format(time.RFC3339)
+	}
+
+	return map[string]interface{}{
+		"running":                   stats.Running,
+		"url":                       stats.URL,
+		"active_url":                stats.ActiveURL,
+		"protocol":                  stats.Protocol,
+		"connected":                 stats.Connected,
+		"reconnect_state":           stats.ReconnectState,
+		"reconnects":                stats.Reconnects,
+		"reconnect_attempts":        stats.ReconnectAttempts,
+		"messages_dropped":          stats.MessagesDropped,
+		"malformed_messages":        stats.MalformedMessages,
+		"sequence_gaps":             stats.SequenceGaps,
+		"queued_responses":          stats.QueuedResponses,
+		"queue_depth":               stats.QueueDepth,
+		"last_connected_at":         formatTime(stats.LastConnectedAt),
+		"connection_uptime_seconds": stats.ConnectionUptimeSeconds,
+		"last_disconnected_at":      formatTime(stats.LastDisconnectedAt),
+		"last_error":                stats.LastError,
+		"commands_processed":        stats.CommandsProcessed,
+		"commands_failed":           stats.CommandsFailed,
+		"last_command_at":           formatTime(stats.LastCommandAt),
+		"cpu_usage":                 stats.CPUUsage,
+		"mem_usage":                 stats.MemUsage,
+		"disk_free":                 stats.DiskFree, // in GB
+		"commands_by_type":          stats.CommandsByType,
+		"failures_by_type":          stats.FailuresByType,
+		"enabled_commands":          stats.EnabledCommands,
+	}
+}
+
+func (c *Client) recordConnected() {
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	c.lastConnectedAt = time.Now()
+}
+
+func (c *Client) recordDisconnected() {
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	c.lastDisconnectedAt = time.Now()
+}
+
+// recordConnectionError keeps err as the last connection error; it is
+// called for every failed dial.
+func (c *Client) recordConnectionError(err error) {
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	c.lastError = err.Error()
+}
+
+func (c *Client) recordReconnectAttempt() {
+	c.connMux.Lock()
+	defer c.connMux.Unlock()
+	c.reconnectAttempts++
+}
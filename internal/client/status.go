@@ -0,0 +1,102 @@
+package client
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"runtime"
+	"time"
+)
+
+// Version is the agent version reported in identification metadata and by
+// the status command. Override at build time with
+// -ldflags "-X edge-agent/internal/client.Version=1.2.3".
+var Version = "1.0.0"
+
+// handleStatus reports live runtime information about the agent.
+func (c *Client) handleStatus(ctx context.Context, command Command) CommandResponse {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		hostname = "unknown"
+	}
+
+	c.runningMux.Lock()
+	running, startedAt := c.running, c.startedAt
+	c.runningMux.Unlock()
+
+	var uptime time.Duration
+	var started interface{}
+	if running && !startedAt.IsZero() {
+		uptime = time.Since(startedAt)
+		started = startedAt.UTC().Format(time.RFC3339)
+	}
+
+	return CommandResponse{
+		ID:      command.ID,
+		Success: true,
+		Data: map[string]interface{}{
+			"version":        Version,
+			"hostname":       hostname,
+			"os":             runtime.GOOS,
+			"arch":           runtime.GOARCH,
+			"go_version":     runtime.Version(),
+			"num_cpu":        runtime.NumCPU(),
+			"goroutines":     runtime.NumGoroutine(),
+			"running":        running,
+			"started_at":     started,
+			"uptime":         uptime.Round(time.Second).String(),
+			"uptime_seconds": int64(uptime.Seconds()),
+			"memory": map[string]interface{}{
+				"alloc_bytes":       mem.Alloc,
+				"total_alloc_bytes": mem.TotalAlloc,
+				"sys_bytes":         mem.Sys,
+				"heap_inuse_bytes":  mem.HeapInuse,
+				"num_gc":            mem.NumGC,
+			},
+			"connection": map[string]interface{}{
+				"protocol":  c.protocol,
+				"url":       c.config.WebSocket.URL,
+				"enabled":   c.config.WebSocket.Enabled,
+				"connected": c.transport != nil && c.transport.IsConnected(),
+			},
+		},
+	}
+}
+
+// reconnectState describes the connection for status reporting:
+// "disabled" when no transport is configured, "connected", "reconnecting"
+// while the transport is redialling, or "disconnected" when reconnection
+// is turned off.
+func (c *Client) reconnectState(connected bool) string {
+	switch {
+	case !c.config.WebSocket.Enabled:
+		return "disabled"
+	case connected:
+		return "connected"
+	case c.reconnectConfig().Enabled:
+		return "reconnecting"
+	default:
+		return "disconnected"
+	}
+}
+
+// defaultMetricsMounts is used when metrics.mounts is not configured.
+var defaultMetricsMounts = []string{"/"}
+
+// handleMetrics reports device telemetry: load, memory, disk usage of the
+// configured mounts and network interface counters.
+func (c *Client) handleMetrics(ctx context.Context, command Command) CommandResponse {
+	mounts := c.config.Metrics.Mounts
+	if len(mounts) == 0 {
+		mounts = defaultMetricsMounts
+	}
+
+	systemMetrics, err := c.systemMetrics.Collect(mounts)
+	if err != nil {
+		return CommandResponse{ID: command.ID, Success: false, Error: fmt.Sprintf("failed to collect metrics: %v", err)}
+	}
+	return CommandResponse{ID: command.ID, Success: true, Data: systemMetrics}
+}
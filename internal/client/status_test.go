@@ -0,0 +1,354 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"edge-agent/internal/metrics"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestStatusReportsRuntimeData(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.WebSocket.Protocol = "websocket"
+	c := NewClient(cfg)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	time.Sleep(1100 * time.Millisecond)
+
+	resp := c.processCommand(context.Background(), Command{Type: "status", ID: "status-1"})
+	if !resp.Success {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	data := resp.Data.(map[string]interface{})
+
+	if data["uptime_seconds"].(int64) < 1 {
+		t.Errorf("Expected uptime of at least a second, got %v", data["uptime_seconds"])
+	}
+	if data["started_at"] == nil {
+		t.Error("Expected started_at to be set")
+	}
+	if data["num_cpu"] != runtime.NumCPU() {
+		t.Errorf("Expected num_cpu %d, got %v", runtime.NumCPU(), data["num_cpu"])
+	}
+	if hostname, _ := data["hostname"].(string); hostname == "" || hostname == "unknown" {
+		t.Errorf("Expected a real hostname, got %q", hostname)
+	}
+	if data["version"] != Version || data["go_version"] != runtime.Version() {
+		t.Errorf("Unexpected version fields: %v %v", data["version"], data["go_version"])
+	}
+
+	memory := data["memory"].(map[string]interface{})
+	if memory["sys_bytes"].(uint64) == 0 || memory["alloc_bytes"].(uint64) == 0 {
+		t.Errorf("Expected non-zero memory stats, got %v", memory)
+	}
+
+	connection := data["connection"].(map[string]interface{})
+	if connection["connected"] != false || connection["protocol"] != "websocket" {
+		t.Errorf("Unexpected connection state: %v", connection)
+	}
+}
+
+type stubCollector struct {
+	mounts []string
+}
+
+func (s *stubCollector) Collect(mounts []string) (*metrics.SystemMetrics, error) {
+	s.mounts = mounts
+	return &metrics.SystemMetrics{Load: metrics.LoadAverage{Load1: 0.5}}, nil
+}
+
+func TestMetricsCommandUsesConfiguredMounts(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Metrics.Mounts = []string{"/", "/data"}
+	c := NewClient(cfg)
+	stub := &stubCollector{}
+	c.systemMetrics = stub
+
+	resp := c.processCommand(context.Background(), Command{Type: "metrics", ID: "m1"})
+	if !resp.Success {
+		t.Fatalf("Expected success, got %+v", resp)
+	}
+	if m, ok := resp.Data.(*metrics.SystemMetrics); !ok || m.Load.Load1 != 0.5 {
+		t.Errorf("Expected the collected metrics, got %#v", resp.Data)
+	}
+	if len(stub.mounts) != 2 || stub.mounts[1] != "/data" {
+		t.Errorf("Expected configured mounts, got %v", stub.mounts)
+	}
+}
+
+func TestIdentityListsEnabledCommands(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.EnabledCommands.APICall = true
+	cfg.EnabledCommands.LocalCommand = false
+	cfg.FileManager.Enabled = false
+	c := NewClient(cfg)
+
+	identity := c.identity()
+	if identity.Version != Version || identity.OS != runtime.GOOS || identity.Arch != runtime.GOARCH {
+		t.Errorf("Unexpected identity %+v", identity)
+	}
+
+	capabilities := make(map[string]bool)
+	for _, cmdType := range identity.Capabilities {
+		capabilities[cmdType] = true
+	}
+	for _, cmdType := range []string{"api_call", "status", "metrics", "cancel"} {
+		if !capabilities[cmdType] {
+			t.Errorf("Expected %s in capabilities %v", cmdType, identity.Capabilities)
+		}
+	}
+	for _, cmdType := range []string{"http_request", "local_command", "interactive_shell_start", "reboot", "file_download"} {
+		if capabilities[cmdType] {
+			t.Errorf("Disabled command %s advertised in capabilities", cmdType)
+		}
+	}
+}
+
+func TestStatsReportReconnectStateAndCommandsProcessed(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Reconnect.Enabled = true
+	c := NewClient(cfg)
+	c.processCommand(context.Background(), Command{Type: "status", ID: "status-1"})
+
+	stats := c.GetStats()
+	if stats["reconnect_state"] != "reconnecting" {
+		t.Errorf("Expected a disconnected transport to be reconnecting, got %v", stats["reconnect_state"])
+	}
+	if stats["commands_processed"] != uint64(1) {
+		t.Errorf("Expected 1 processed command, got %v", stats["commands_processed"])
+	}
+
+	cfg.WebSocket.Enabled = false
+	if state := c.GetStats()["reconnect_state"]; state != "disabled" {
+		t.Errorf("Expected disabled when the transport is off, got %v", state)
+	}
+}
+
+func TestStatsReflectStateTransitions(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws://server"
+	cfg.EnabledCommands.APICall = true
+	c := NewClient(cfg)
+
+	stats := c.Stats()
+	if stats.Running || stats.Connected || !stats.LastConnectedAt.IsZero() {
+		t.Errorf("Expected a fresh client to be stopped and never connected, got %+v", stats)
+	}
+	if stats.Protocol != "websocket" || stats.URL != "ws://server" || !stats.EnabledCommands["api_call"] {
+		t.Errorf("Expected configuration in stats, got %+v", stats)
+	}
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	if !c.Stats().Running {
+		t.Error("Expected Running after Start")
+	}
+
+	before := time.Now()
+	c.recordConnected()
+	c.recordReconnectAttempt()
+	c.processCommand(context.Background(), Command{Type: "status", ID: "status-1"})
+
+	stats = c.Stats()
+	if stats.LastConnectedAt.Before(before) {
+		t.Errorf("Expected LastConnectedAt to be updated, got %v", stats.LastConnectedAt)
+	}
+	if stats.ReconnectAttempts != 1 || stats.CommandsProcessed != 1 {
+		t.Errorf("Expected 1 reconnect attempt and 1 command, got %+v", stats)
+	}
+
+	c.Stop()
+	if c.Stats().Running {
+		t.Error("Expected Running to be false after Stop")
+	}
+}
+
+func TestStatsCountCommandActivity(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Metrics.CommandTypes = []string{"ok", "broken"}
+	c := NewClient(cfg)
+	c.RegisterHandler("ok", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: true}
+	})
+	c.RegisterHandler("broken", func(ctx context.Context, command Command) CommandResponse {
+		return CommandResponse{ID: command.ID, Success: false, Error: "broken"}
+	})
+
+	if !c.Stats().LastCommandAt.IsZero() {
+		t.Error("Expected no last command time before any command")
+	}
+
+	before := time.Now()
+	for i, cmdType := range []string{"ok", "ok", "broken", "ok", "broken"} {
+		c.runCommand(Command{Type: cmdType, ID: fmt.Sprintf("cmd-%d", i)})
+	}
+
+	stats := c.Stats()
+	if stats.CommandsProcessed != 5 || stats.CommandsFailed != 2 {
+		t.Errorf("Expected 5 commands with 2 failures, got %d and %d", stats.CommandsProcessed, stats.CommandsFailed)
+	}
+	if stats.CommandsByType["ok"] != 3 || stats.CommandsByType["broken"] != 2 {
+		t.Errorf("Unexpected per-type counts %v", stats.CommandsByType)
+	}
+	if stats.FailuresByType["broken"] != 2 || stats.FailuresByType["ok"] != 0 {
+		t.Errorf("Unexpected per-type failures %v", stats.FailuresByType)
+	}
+	if stats.LastCommandAt.Before(before) {
+		t.Errorf("Expected LastCommandAt to be updated, got %v", stats.LastCommandAt)
+	}
+}
+
+func TestStatsRecordConnectionErrorsAndUptime(t *testing.T) {
+	var attempts atomic.Int32
+	dropped := make(chan struct{})
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Refuse the first dial, then accept and hang up once asked to
+		if attempts.Add(1) == 1 {
+			http.Error(w, "not yet", http.StatusServiceUnavailable)
+			return
+		}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		<-dropped
+		conn.Close()
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	cfg.WebSocket.Reconnect.Enabled = true
+	cfg.WebSocket.Reconnect.MaxAttempts = 3
+	cfg.WebSocket.Reconnect.InitialDelay = 10 * time.Millisecond
+	c := NewClient(cfg)
+
+	before := time.Now()
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	waitFor := func(what string, cond func(Stats) bool) Stats {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for time.Now().Before(deadline) {
+			if stats := c.Stats(); cond(stats) {
+				return stats
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Timed out waiting for %s, stats %+v", what, c.Stats())
+		return Stats{}
+	}
+
+	stats := waitFor("the connection", func(s Stats) bool { return s.Connected })
+	if !strings.Contains(stats.LastError, "bad handshake") {
+		t.Errorf("Expected the failed first dial as last_error, got %q", stats.LastError)
+	}
+	if stats.LastConnectedAt.Before(before) || !stats.LastDisconnectedAt.IsZero() {
+		t.Errorf("Expected a fresh connection and no disconnect yet, got %+v", stats)
+	}
+
+	disconnectedAfter := time.Now()
+	close(dropped)
+	stats = waitFor("the disconnect", func(s Stats) bool { return !s.LastDisconnectedAt.IsZero() })
+	if stats.LastDisconnectedAt.Before(disconnectedAfter) {
+		t.Errorf("Expected last_disconnected_at after the drop, got %v", stats.LastDisconnectedAt)
+	}
+	if legacy := c.GetStats(); legacy["last_error"] == "" || legacy["last_disconnected_at"] == "" {
+		t.Errorf("Expected GetStats to include the new fields, got %v", legacy)
+	}
+}
+
+func TestRegistrationTokenOnlyInIdentification(t *testing.T) {
+	const token = "onboard-7f3a"
+	identification := make(chan []byte, 1)
+	traffic := make(chan []byte, 10)
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, data, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		identification <- data
+		conn.WriteJSON(map[string]interface{}{"type": "status", "id": "status-1"})
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			traffic <- data
+		}
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	cfg.WebSocket.RegistrationToken = token
+	c := NewClient(cfg)
+
+	if got := c.identity().RegistrationToken; got != token {
+		t.Errorf("Expected the token in the identity every transport sends, got %q", got)
+	}
+
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer c.Stop()
+
+	select {
+	case data := <-identification:
+		var message struct {
+			Payload struct {
+				RegistrationToken string `json:"registration_token"`
+			} `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil || message.Payload.RegistrationToken != token {
+			t.Errorf("Expected registration_token in the identification, got %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the identification")
+	}
+
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case data := <-traffic:
+			if strings.Contains(string(data), token) || strings.Contains(string(data), "registration_token") {
+				t.Fatalf("Registration token leaked into regular traffic: %s", data)
+			}
+			if strings.Contains(string(data), `"command_response"`) {
+				return
+			}
+		case <-deadline:
+			t.Fatal("Timed out waiting for the command response")
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package client
+
+import (
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+var traceparentPattern = regexp.MustCompile(`^00-[0-9a-f]{32}-[0-9a-f]{16}-01$`)
+
+func TestTraceIDReachesUpstream(t *testing.T) {
+	headers := make(chan http.Header, 2)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header.Clone()
+		w.Write([]byte(`{"ok":true}`))
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.EnabledCommands.HTTPRequest = true
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+	c := NewClient(cfg)
+
+	// Without a trace_id the command ID is used
+	response := c.runCommand(Command{
+		Type:    "http_request",
+		ID:      "cmd-42",
+		TraceID: "cmd-42",
+		Payload: map[string]interface{}{"url": upstream.URL},
+	})
+	if response.StatusCode != http.StatusOK {
+		t.Fatalf("http_request failed: %+v", response)
+	}
+	got := <-headers
+	if got.Get("X-Request-ID") != "cmd-42" {
+		t.Errorf("Expected X-Request-ID cmd-42, got %q", got.Get("X-Request-ID"))
+	}
+	if !traceparentPattern.MatchString(got.Get("traceparent")) {
+		t.Errorf("Expected a W3C traceparent, got %q", got.Get("traceparent"))
+	}
+	if response.TraceID != "cmd-42" {
+		t.Errorf("Expected the trace ID echoed in the response, got %q", response.TraceID)
+	}
+
+	// A W3C trace ID from the server is kept as the traceparent trace-id
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	response = c.runCommand(Command{
+		Type:    "http_request",
+		ID:      "cmd-43",
+		TraceID: traceID,
+		Payload: map[string]interface{}{"url": upstream.URL},
+	})
+	got = <-headers
+	if got.Get("X-Request-ID") != traceID || got.Get("traceparent")[3:35] != traceID {
+		t.Errorf("Expected trace %s propagated, got X-Request-ID %q traceparent %q", traceID, got.Get("X-Request-ID"), got.Get("traceparent"))
+	}
+	if response.TraceID != traceID {
+		t.Errorf("Expected trace ID %s in the response, got %q", traceID, response.TraceID)
+	}
+}
+
+func TestCommandTraceIDDefaultsToCommandID(t *testing.T) {
+	c := NewClient(&config.Config{})
+	tr := &recordingTransport{}
+	c.transport = tr
+
+	response := c.handleCommand(map[string]interface{}{"type": "cancel", "id": "cancel-1", "payload": map[string]interface{}{}})
+	if payload := response["payload"].(CommandResponse); payload.TraceID != "cancel-1" {
+		t.Errorf("Expected the command ID as trace ID, got %q", payload.TraceID)
+	}
+
+	response = c.handleCommand(map[string]interface{}{"type": "cancel", "id": "cancel-2", "trace_id": "req-9", "payload": map[string]interface{}{}})
+	if payload := response["payload"].(CommandResponse); payload.TraceID != "req-9" {
+		t.Errorf("Expected the message's trace_id, got %q", payload.TraceID)
+	}
+}
+
+func TestTraceIDOverWebSocket(t *testing.T) {
+	response := exchangeOverWebSocket(t, &config.Config{}, map[string]interface{}{
+		"type":     "cancel",
+		"id":       "cancel-ws",
+		"trace_id": "req-ws-7",
+		"payload":  map[string]interface{}{"id": "nothing-running"},
+	})
+
+	payload, _ := response["payload"].(map[string]interface{})
+	if payload["trace_id"] != "req-ws-7" {
+		t.Errorf("Expected the server's trace_id in the response, got %v", response)
+	}
+}
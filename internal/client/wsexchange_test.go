@@ -0,0 +1,67 @@
+package client
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// exchangeOverWebSocket starts the agent against a WebSocket server that
+// sends command once the agent has identified, and returns the agent's
+// command_response to it as the server decoded it.
+func exchangeOverWebSocket(t *testing.T, cfg *config.Config, command map[string]interface{}) map[string]interface{} {
+	t.Helper()
+	data, err := json.Marshal(command)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	upgrader := websocket.Upgrader{}
+	responses := make(chan map[string]interface{}, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage() // identification
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"identification_success"}`))
+		conn.WriteMessage(websocket.TextMessage, data)
+		for {
+			_, raw, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			var message map[string]interface{}
+			if json.Unmarshal(raw, &message) == nil && message["type"] == "command_response" && message["id"] == command["id"] {
+				responses <- message
+				return
+			}
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	cfg.WebSocket.Enabled = true
+	cfg.WebSocket.Protocol = "websocket"
+	cfg.WebSocket.URL = "ws" + strings.TrimPrefix(server.URL, "http")
+	c := NewClient(cfg)
+	if err := c.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(func() { c.Stop() })
+
+	select {
+	case response := <-responses:
+		return response
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the command response")
+		return nil
+	}
+}
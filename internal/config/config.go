@@ -3,94 +3,409 @@ package config
 import (
 	"flag"
 	"log"
+	"log/slog"
 	"os"
 	"sync"
 	"time"
-
-	"gopkg.in/yaml.v3"
 )
 
 type Config struct {
+	// Version is the config schema version; see CurrentVersion.
+	Version int `yaml:"version"`
+
 	QuickCommands map[string]interface{} `yaml:"quick_commands"`
 
-	APIProxy struct {
-		Headers map[string]string `yaml:"headers"`
-		Auth    struct {
-			Token string `yaml:"token"`
-			Type  string `yaml:"type" env-default:"Bearer"`
-		} `yaml:"auth"`
-		BaseURL string        `yaml:"base_url" env-required:"true"`
-		Timeout time.Duration `yaml:"timeout" env-default:"30s"`
-	} `yaml:"api_proxy" env-required:"true"`
+	APIProxy APIProxy `yaml:"api_proxy" env-required:"true"`
+
+	// APICall and HTTPRequest limit the hosts api_call and http_request
+	// may reach. Entries are host names, "*.example.com" or CIDRs. An
+	// empty allowlist permits any host not denied, except loopback,
+	// link-local and cloud metadata addresses, which must be allowed
+	// explicitly. api_call may always reach its profile's base URL.
+	APICall     HostRules `yaml:"api_call"`
+	HTTPRequest HostRules `yaml:"http_request"`
+
+	// APIProfiles are additional named upstreams an api_call can select
+	// with its "profile" field; api_proxy is used when none is given.
+	APIProfiles map[string]APIProxy `yaml:"api_profiles"`
 
 	WebSocket struct {
-		Protocol  string `yaml:"protocol" env-default:"websocket"` // "websocket" or "tcp"
-		ClientID  string `yaml:"client_id" env-default:"socket-proxy-client"`
-		URL       string `yaml:"url" env-default:""`
+		Protocol string `yaml:"protocol" env-default:"websocket"` // "websocket", "tcp", "mqtt" or "grpc"
+		ClientID string `yaml:"client_id" env-default:"socket-proxy-client"`
+		// RegistrationToken is a pre-shared secret sent only in the
+		// identification message, for the server to admit the agent.
+		// It is separate from api_proxy.auth.token.
+		RegistrationToken string `yaml:"registration_token"`
+		URL               string `yaml:"url" env-default:""`
+		// URLs lists equivalent servers in order of preference; the agent
+		// connects to the first reachable one and fails over to the next
+		// when the connection is lost. It takes precedence over URL.
+		URLs      []string `yaml:"urls"`
 		Reconnect struct {
 			BackoffMultiplier float64       `yaml:"backoff_multiplier"`
 			MaxDelay          time.Duration `yaml:"max_delay"`
 			InitialDelay      time.Duration `yaml:"initial_delay" env-default:"5s"`
-			MaxAttempts       int           `yaml:"max_attempts" env-default:"5"`
+			MaxAttempts       int           `yaml:"max_attempts" env-default:"0"` // 0 = retry forever
 			Enabled           bool          `yaml:"enabled" env-default:"true"`
 		} `yaml:"reconnect"`
-		Enabled bool `yaml:"enabled" env-default:"false"`
+		// Send controls what happens when the outgoing queue is full:
+		// "block", "timeout" (fail after Timeout) or "drop".
+		Send struct {
+			Timeout         time.Duration `yaml:"timeout" env-default:"5s"`
+			ResponsePolicy  string        `yaml:"response_policy" env-default:"block"`
+			HeartbeatPolicy string        `yaml:"heartbeat_policy" env-default:"drop"`
+		} `yaml:"send"`
+		// ConnectTimeout bounds each connection attempt of the websocket
+		// protocol, including the handshake.
+		ConnectTimeout time.Duration `yaml:"connect_timeout" env-default:"10s"`
+		// IdentifyTimeout is how long the websocket and tcp protocols wait
+		// for identification_success after connecting before they drop
+		// the connection and redial.
+		IdentifyTimeout time.Duration `yaml:"identify_timeout" env-default:"30s"`
+		Enabled         bool          `yaml:"enabled" env-default:"false"`
 	} `yaml:"websocket"  env-required:"true"`
 
+	Heartbeat struct {
+		Interval    time.Duration          `yaml:"interval" env-default:"30s"`
+		AckTimeout  time.Duration          `yaml:"ack_timeout" env-default:"10s"` // default 10s; negative = don't require acks
+		ExtraFields map[string]interface{} `yaml:"extra_fields"`
+		// IncludeStats adds the full client_stats to every heartbeat. Off by
+		// default: a heartbeat then only carries status, timestamp and
+		// client_id, which is cheaper on metered links.
+		IncludeStats bool `yaml:"include_stats"`
+	} `yaml:"heartbeat"`
+
+	TCP struct {
+		MaxMessageBytes int64         `yaml:"max_message_bytes" env-default:"67108864"`
+		ConnectTimeout  time.Duration `yaml:"connect_timeout" env-default:"10s"`
+	} `yaml:"tcp"`
+
+	// MQTT applies when websocket.protocol is "mqtt"; websocket.url is the
+	// broker (e.g. tcp://broker:1883) and websocket.client_id the MQTT
+	// client ID. {client_id} in a topic is replaced with the client ID.
+	MQTT struct {
+		CommandTopic  string `yaml:"command_topic" env-default:"edge-agent/{client_id}/commands"`
+		ResponseTopic string `yaml:"response_topic" env-default:"edge-agent/{client_id}/responses"`
+		QoS           int    `yaml:"qos" env-default:"1"`
+		Username      string `yaml:"username"`
+		Password      string `yaml:"password"`
+		// ConnectTimeout bounds each connection attempt, until the
+		// broker acknowledges it.
+		ConnectTimeout time.Duration `yaml:"connect_timeout" env-default:"10s"`
+	} `yaml:"mqtt"`
+
+	// GRPC applies when websocket.protocol is "grpc".
+	GRPC struct {
+		// ConnectTimeout bounds each connection attempt, until the
+		// channel is ready.
+		ConnectTimeout time.Duration `yaml:"connect_timeout" env-default:"10s"`
+	} `yaml:"grpc"`
+
 	EnabledCommands struct {
 		HTTPRequest  bool `yaml:"http_request" env-default:"true"`
 		APICall      bool `yaml:"api_call" env-default:"true"`
 		LocalCommand bool `yaml:"local_command" env-default:"true"`
+		Reboot       bool `yaml:"reboot" env-default:"false"`
 	} `yaml:"enabled_commands"`
 
+	Reboot struct {
+		// Command is run through the local shell to reboot the device.
+		Command string `yaml:"command" env-default:"shutdown -r +1"`
+	} `yaml:"reboot"`
+
+	Commands struct {
+		// Timeout bounds how long any single command may run.
+		Timeout time.Duration `yaml:"timeout" env-default:"5m"`
+		// Ack sends a command_ack as soon as a queued command is received.
+		Ack bool `yaml:"ack"`
+		// ShutdownGracePeriod is how long Stop waits for running commands
+		// to finish and respond before cancelling them and disconnecting.
+		ShutdownGracePeriod time.Duration `yaml:"shutdown_grace_period" env-default:"30s"`
+		// ShutdownTimeout bounds the whole shutdown, grace period
+		// included; past it the process exits non-zero without waiting.
+		ShutdownTimeout time.Duration `yaml:"shutdown_timeout" env-default:"60s"`
+		// DryRun makes api_call, http_request and local_command validate
+		// and report what they would do instead of doing it.
+		DryRun bool `yaml:"dry_run"`
+		// DedupTTL is how long a completed command's response is kept so
+		// that a redelivered command with the same ID gets it back instead
+		// of running again. Defaults to 5 minutes; a negative value
+		// disables deduplication.
+		DedupTTL time.Duration `yaml:"dedup_ttl" env-default:"5m"`
+		// DedupMaxEntries bounds how many responses are kept.
+		DedupMaxEntries int `yaml:"dedup_max_entries" env-default:"1000"`
+		// OutboxSize bounds how many responses are kept for redelivery
+		// while the connection is down; the oldest is dropped beyond it.
+		OutboxSize int `yaml:"outbox_size" env-default:"100"`
+		// OutboxDir, if set, persists undelivered responses there so they
+		// survive a restart.
+		OutboxDir string `yaml:"outbox_dir"`
+		// SigningSecret, if set, requires every incoming command to carry
+		// an HMAC-SHA256 "signature" made with it and signs every message
+		// the agent sends the same way. Commands signed more than half of
+		// DedupTTL away from the agent's clock are rejected as replays.
+		SigningSecret string `yaml:"signing_secret"`
+		// CompressThreshold gzips the data of a command response whose
+		// JSON is at least this many bytes. 0 disables compression.
+		CompressThreshold int `yaml:"compress_threshold"`
+	} `yaml:"commands"`
+
+	// RateLimits caps how often each command type may run, keyed by
+	// command type (e.g. api_call, http_request, local_command).
+	RateLimits map[string]RateLimit `yaml:"rate_limits"`
+
+	Local struct {
+		// Shell runs local_command commands, e.g. ["bash", "-lc"].
+		// Defaults to ["sh", "-c"], or ["cmd", "/C"] on Windows.
+		Shell []string `yaml:"shell"`
+		// MaxOutputBytes caps the captured stdout and stderr of each
+		// local_command; output beyond it is discarded.
+		MaxOutputBytes int64 `yaml:"max_output_bytes" env-default:"1048576"`
+		// AllowedCommands, when not empty, lists the only commands
+		// local_command may run: exact commands or regexes matched
+		// against the whole command.
+		AllowedCommands []string `yaml:"allowed_commands"`
+		// DeniedPatterns are regexes; a command matching any is refused.
+		DeniedPatterns []string `yaml:"denied_patterns"`
+		// AllowedEnv, when not empty, lists the only environment variables
+		// a local_command may set. PATH, IFS, ENV, BASH_ENV, LD_* and
+		// DYLD_* can only be set when listed here.
+		AllowedEnv []string `yaml:"allowed_env"`
+		// MaxConcurrent caps how many local_command processes, local or
+		// remote, run at once, independent of scheduler.workers. Defaults
+		// to 2; a negative value removes the cap.
+		MaxConcurrent int `yaml:"max_concurrent" env-default:"2"`
+		// WhenBusy is what a local_command does when MaxConcurrent are
+		// already running: "queue" (default) waits for one to finish,
+		// "reject" fails with a "too many concurrent commands" error.
+		WhenBusy string `yaml:"when_busy" env-default:"queue"`
+		// Remote configures local_command with "mode": "remote", which
+		// runs the command on another host over SSH.
+		Remote struct {
+			// KnownHostsFile verifies SSH host keys not pinned with
+			// host_key in the command. Without either, remote commands
+			// are refused.
+			KnownHostsFile string `yaml:"known_hosts_file"`
+		} `yaml:"remote"`
+	} `yaml:"local"`
+
+	// Hooks are commands run through the local executor at lifecycle
+	// points, in order, with their output logged.
+	Hooks struct {
+		OnStart      []string `yaml:"on_start"`      // before the first connection
+		OnConnect    []string `yaml:"on_connect"`    // after every (re)connect
+		OnDisconnect []string `yaml:"on_disconnect"` // after every lost or closed connection
+		// FailOnError makes a failing on_start command abort startup.
+		// Other hook failures are only logged.
+		FailOnError bool `yaml:"fail_on_error"`
+		// Timeout bounds each hook command. Defaults to 30s.
+		Timeout time.Duration `yaml:"timeout" env-default:"30s"`
+	} `yaml:"hooks"`
+
 	Logging struct {
 		File   string `yaml:"file"`
 		Format string `yaml:"format" env-default:"text"`
 		Level  string `yaml:"level" env-default:"info"`
+
+		// StatusInterval is how often the agent logs its status line.
+		StatusInterval time.Duration `yaml:"status_interval" env-default:"30s"`
+
+		// RedactKeys lists keys whose values are masked in logged messages.
+		RedactKeys []string `yaml:"redact_keys"`
+
+		// TraceMessages logs every message sent and received by the
+		// transports, at info level. Off by default whatever the level.
+		TraceMessages bool `yaml:"trace_messages"`
 	} `yaml:"logging"`
 
+	// Audit records every processed command as a line of JSON, separate
+	// from the application log.
+	Audit struct {
+		// File is appended to; empty disables the audit log.
+		File string `yaml:"file"`
+	} `yaml:"audit"`
+
+	CommandOutput struct {
+		StoreDir       string `yaml:"store_dir"`
+		InlineMaxBytes int    `yaml:"inline_max_bytes" env-default:"65536"`
+		// MaxAge and MaxTotalBytes bound the stored outputs; older ones are
+		// deleted first. Zero means 24h and 100 MiB.
+		MaxAge        time.Duration `yaml:"max_age" env-default:"24h"`
+		MaxTotalBytes int64         `yaml:"max_total_bytes" env-default:"104857600"`
+	} `yaml:"command_output"`
+
+	Scheduler struct {
+		Workers int `yaml:"workers" env-default:"4"`
+		// Priorities override the default priority ("low", "normal" or
+		// "high") of a command type; a command's own "priority" field wins.
+		Priorities map[string]string `yaml:"priorities"`
+		// MaxQueueDepth bounds how many commands may wait for a worker;
+		// 0 leaves the queue unbounded.
+		MaxQueueDepth int `yaml:"max_queue_depth"`
+		// Overflow is what happens to a command arriving at a full queue:
+		// "block" (default) stops reading until a worker is free,
+		// "reject_newest" refuses it and "reject_oldest" refuses the
+		// longest-waiting command to make room.
+		Overflow string `yaml:"overflow" env-default:"block"`
+	} `yaml:"scheduler"`
+
+	Metrics struct {
+		// CommandTypes get their own metrics label; others are bucketed
+		// into "other". Empty uses the built-in command types.
+		CommandTypes []string `yaml:"command_types"`
+		// Mounts are the filesystems whose usage the metrics command
+		// reports. Defaults to "/".
+		Mounts []string `yaml:"mounts"`
+	} `yaml:"metrics"`
+
+	Health struct {
+		// Addr is the listen address of the local /healthz, /readyz and
+		// /metrics endpoints. Empty disables the server.
+		Addr string `yaml:"addr"`
+	} `yaml:"health"`
+
 	FileManager struct {
 		BasePath string `yaml:"base_path"`
 		Enabled  bool   `yaml:"enabled" env-default:"true"`
+		// MaxFileBytes caps the size of a file returned by file_download
+		// or written by file_upload.
+		MaxFileBytes int64 `yaml:"max_file_bytes" env-default:"10485760"`
 	} `yaml:"file_manager"`
 }
 
+// RateLimit is a token bucket: bursts of up to Burst commands, refilled at
+// RequestsPerSecond. A zero RequestsPerSecond disables the limit.
+type RateLimit struct {
+	RequestsPerSecond float64 `yaml:"requests_per_second"`
+	Burst             int     `yaml:"burst" env-default:"1"`
+}
+
+// HostRules lists the destination hosts a request kind may or may not reach.
+type HostRules struct {
+	AllowedHosts []string `yaml:"allowed_hosts"`
+	DeniedHosts  []string `yaml:"denied_hosts"`
+}
+
+type APIProxy struct {
+	Headers map[string]string `yaml:"headers"`
+	Auth    struct {
+		Token string `yaml:"token"`
+		Type  string `yaml:"type" env-default:"Bearer"`
+	} `yaml:"auth"`
+	TLS struct {
+		CAFile string `yaml:"ca_file"`
+		// CertFile and KeyFile hold the client certificate for mutual TLS.
+		CertFile           string `yaml:"cert_file"`
+		KeyFile            string `yaml:"key_file"`
+		InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+	} `yaml:"tls"`
+	Retry struct {
+		// MaxAttempts includes the first try; 0 or 1 disables retries.
+		MaxAttempts       int           `yaml:"max_attempts" env-default:"1"`
+		InitialDelay      time.Duration `yaml:"initial_delay" env-default:"500ms"`
+		BackoffMultiplier float64       `yaml:"backoff_multiplier" env-default:"2"`
+		// RetryableStatusCodes defaults to 502, 503 and 504.
+		RetryableStatusCodes []int `yaml:"retryable_status_codes"`
+		// RetryNonIdempotent also retries POST and PATCH requests.
+		RetryNonIdempotent bool `yaml:"retry_non_idempotent"`
+	} `yaml:"retry"`
+	CircuitBreaker struct {
+		// FailureThreshold consecutive failures open the circuit; 0 disables it.
+		FailureThreshold int           `yaml:"failure_threshold" env-default:"0"`
+		Cooldown         time.Duration `yaml:"cooldown" env-default:"30s"`
+	} `yaml:"circuit_breaker"`
+	// MaxResponseBytes caps how much of an upstream response is read.
+	MaxResponseBytes int64 `yaml:"max_response_bytes" env-default:"10485760"`
+	// TruncateResponse returns a preview of an oversized response with its
+	// content length instead of failing the request.
+	TruncateResponse bool `yaml:"truncate_response"`
+	// Connection pool tuning; zero values use the defaults (100 idle
+	// connections, no per-host limit, 90s idle timeout).
+	MaxIdleConns    int           `yaml:"max_idle_conns" env-default:"100"`
+	MaxConnsPerHost int           `yaml:"max_conns_per_host" env-default:"0"`
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout" env-default:"90s"`
+	// LogRequests logs every upstream request and its outcome. Authorization
+	// and RedactHeaders values are masked.
+	LogRequests   bool     `yaml:"log_requests"`
+	RedactHeaders []string `yaml:"redact_headers"`
+	// ProxyURL routes requests through an http://, https:// or socks5://
+	// proxy. Empty falls back to the HTTP_PROXY/HTTPS_PROXY environment.
+	ProxyURL string `yaml:"proxy_url"`
+	// UserAgent is sent with every request that does not set its own
+	// User-Agent header. Empty means "edge-agent/<version> (<client_id>)".
+	UserAgent string `yaml:"user_agent"`
+	// MaxRedirects caps how many redirects are followed, each checked
+	// against the api_call/http_request host lists; 0 returns the
+	// redirect response as is. Unset means 10.
+	MaxRedirects *int          `yaml:"max_redirects"`
+	BaseURL      string        `yaml:"base_url" env-required:"true"`
+	Timeout      time.Duration `yaml:"timeout" env-default:"30s"`
+}
+
 type Logging struct {
 	File   string `yaml:"file"`
 	Format string `yaml:"format" env-default:"text"`
 	Level  string `yaml:"level" env-default:"info"`
+
+	// StatusInterval is how often the agent logs its status line.
+	StatusInterval time.Duration `yaml:"status_interval" env-default:"30s"`
+
+	// RedactKeys lists keys whose values are masked in logged messages.
+	RedactKeys []string `yaml:"redact_keys"`
+
+	// TraceMessages logs every message sent and received by the
+	// transports, at info level. Off by default whatever the level.
+	TraceMessages bool `yaml:"trace_messages"`
 }
 
 var instance *Config
 var once sync.Once
 var configFile string
+var lenientConfig bool
 
 func init() {
 	flag.StringVar(&configFile, "config", "config.yml", "Path to configuration file")
+	flag.BoolVar(&lenientConfig, "lenient-config", false, "Warn about unknown config keys instead of refusing to load the config")
 }
 
 func GetConfig() *Config {
 	once.Do(func() {
-		instance = &Config{}
-		loadConfig()
+		cfg, err := loadConfig(configFile, lenientConfig)
+		if err != nil {
+			log.Fatalf("Failed to load config %s: %v", configFile, err)
+		}
+		instance = cfg
 	})
 	return instance
 }
 
-func loadConfig() {
+// loadConfig reads and parses the config file. A missing file yields the
+// defaults; an unreadable or invalid one is an error, so the agent never
+// runs on a config it only partly understood.
+func loadConfig(path string, lenient bool) (*Config, error) {
 	// Check if config file exists
-	if _, err := os.Stat(configFile); os.IsNotExist(err) {
-		log.Printf("Config file %s not found, using defaults", configFile)
-	} else {
-		data, err := os.ReadFile(configFile)
-		if err != nil {
-			log.Printf("Error reading config file: %v", err)
-			return
-		}
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		slog.Warn("Config file not found, using defaults", "file", path)
+		return &Config{}, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
 
-		if err := yaml.Unmarshal(data, instance); err != nil {
-			log.Printf("Error parsing YAML config: %v", err)
-			return
+	cfg, report, err := Parse(data, !lenient)
+	if err != nil {
+		return nil, err
+	}
+	if len(report.Migrations) > 0 {
+		slog.Warn("config uses an older schema and was migrated", "file", path, "version", CurrentVersion)
+		for _, change := range report.Migrations {
+			slog.Warn("config migration", "change", change)
 		}
 	}
+	for _, key := range report.UnknownKeys {
+		slog.Warn("ignoring unknown config key", "key", key)
+	}
+	return cfg, nil
 }
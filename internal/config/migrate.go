@@ -0,0 +1,138 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the config schema version understood by this release.
+// Files without a version are treated as version 0.
+const CurrentVersion = 1
+
+// migrations[i] upgrades a raw config from version i to i+1 and returns a
+// description of every change it made.
+var migrations = []func(raw map[string]interface{}) []string{
+	migrateV0,
+}
+
+// migrateV0 upgrades unversioned configs: ssh_command was renamed to
+// local_command when commands moved to local execution, and sections added
+// since then get their defaults filled in.
+func migrateV0(raw map[string]interface{}) []string {
+	var changes []string
+
+	if enabled, ok := raw["enabled_commands"].(map[string]interface{}); ok {
+		if change := renameKey(enabled, "ssh_command", "local_command"); change != "" {
+			changes = append(changes, "enabled_commands."+change)
+		}
+	}
+
+	defaults := []struct {
+		section string
+		key     string
+		value   interface{}
+	}{
+		{"tcp", "max_message_bytes", 64 * 1024 * 1024},
+		{"local", "max_output_bytes", 1024 * 1024},
+		{"command_output", "inline_max_bytes", 64 * 1024},
+		{"scheduler", "workers", 4},
+	}
+	for _, d := range defaults {
+		section, ok := raw[d.section].(map[string]interface{})
+		if !ok {
+			section = map[string]interface{}{}
+			raw[d.section] = section
+		}
+		if _, exists := section[d.key]; !exists {
+			section[d.key] = d.value
+			changes = append(changes, fmt.Sprintf("set %s.%s to default %v", d.section, d.key, d.value))
+		}
+	}
+
+	return changes
+}
+
+// renameKey moves m[oldKey] to m[newKey]. If both are present the new key
+// wins and the old one is dropped.
+func renameKey(m map[string]interface{}, oldKey, newKey string) string {
+	value, exists := m[oldKey]
+	if !exists {
+		return ""
+	}
+	delete(m, oldKey)
+	if _, taken := m[newKey]; taken {
+		return fmt.Sprintf("%s dropped in favour of %s", oldKey, newKey)
+	}
+	m[newKey] = value
+	return fmt.Sprintf("%s renamed to %s", oldKey, newKey)
+}
+
+// Migrate upgrades a raw config map in place to CurrentVersion and returns
+// the changes made. Configs from a newer release are rejected.
+func Migrate(raw map[string]interface{}) ([]string, error) {
+	version := 0
+	if v, exists := raw["version"]; exists {
+		n, ok := v.(int)
+		if !ok || n < 0 {
+			return nil, fmt.Errorf("invalid config version %v", v)
+		}
+		version = n
+	}
+	if version > CurrentVersion {
+		return nil, fmt.Errorf("config version %d is newer than supported version %d", version, CurrentVersion)
+	}
+
+	var changes []string
+	for ; version < CurrentVersion; version++ {
+		for _, change := range migrations[version](raw) {
+			changes = append(changes, fmt.Sprintf("v%d->v%d: %s", version, version+1, change))
+		}
+	}
+	raw["version"] = CurrentVersion
+	return changes, nil
+}
+
+// Report lists what Parse had to do to load a config file.
+type Report struct {
+	Migrations  []string // schema migrations that were applied
+	UnknownKeys []string // unrecognized keys ignored in lenient mode
+}
+
+// Parse decodes YAML config data, migrating older schema versions to the
+// current one. Unknown keys (typically typos) are an error in strict mode
+// and are ignored and reported otherwise.
+func Parse(data []byte, strict bool) (*Config, Report, error) {
+	var report Report
+
+	raw := map[string]interface{}{}
+	if err := yaml.Unmarshal(data, &raw); err != nil {
+		return nil, report, err
+	}
+
+	changes, err := Migrate(raw)
+	if err != nil {
+		return nil, report, err
+	}
+	report.Migrations = changes
+
+	if unknown := unknownKeys(raw, reflect.TypeOf(Config{})); len(unknown) > 0 {
+		if strict {
+			return nil, report, fmt.Errorf("unknown config keys: %s", strings.Join(unknown, ", "))
+		}
+		report.UnknownKeys = unknown
+	}
+
+	migrated, err := yaml.Marshal(raw)
+	if err != nil {
+		return nil, report, err
+	}
+
+	cfg := &Config{}
+	if err := yaml.Unmarshal(migrated, cfg); err != nil {
+		return nil, report, err
+	}
+	return cfg, report, nil
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const legacyConfig = `
+api_proxy:
+  base_url: "http://localhost:8089"
+  timeout: "15s"
+websocket:
+  enabled: true
+  url: "ws://127.0.0.1:8081"
+  reconnect:
+    initial_delay: "2s"
+enabled_commands:
+  api_call: true
+  http_request: false
+  ssh_command: true
+local:
+  max_output_bytes: 2048
+`
+
+func TestParseMigratesLegacyConfig(t *testing.T) {
+	cfg, report, err := Parse([]byte(legacyConfig), true)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+
+	if cfg.Version != CurrentVersion {
+		t.Errorf("Expected version %d, got %d", CurrentVersion, cfg.Version)
+	}
+	if !cfg.EnabledCommands.LocalCommand {
+		t.Error("Expected ssh_command to be migrated to local_command")
+	}
+	if cfg.EnabledCommands.HTTPRequest {
+		t.Error("Expected http_request to stay disabled")
+	}
+	if cfg.APIProxy.Timeout != 15*time.Second || cfg.WebSocket.Reconnect.InitialDelay != 2*time.Second {
+		t.Errorf("Expected durations to survive migration, got timeout=%v initial_delay=%v",
+			cfg.APIProxy.Timeout, cfg.WebSocket.Reconnect.InitialDelay)
+	}
+	if cfg.TCP.MaxMessageBytes != 64*1024*1024 || cfg.Scheduler.Workers != 4 || cfg.CommandOutput.InlineMaxBytes != 64*1024 {
+		t.Errorf("Expected new defaults to be filled in, got tcp=%d workers=%d inline=%d",
+			cfg.TCP.MaxMessageBytes, cfg.Scheduler.Workers, cfg.CommandOutput.InlineMaxBytes)
+	}
+	if cfg.Local.MaxOutputBytes != 2048 {
+		t.Errorf("Expected explicit local.max_output_bytes to be kept, got %d", cfg.Local.MaxOutputBytes)
+	}
+
+	migrations := strings.Join(report.Migrations, "\n")
+	for _, expected := range []string{"ssh_command renamed to local_command", "tcp.max_message_bytes", "scheduler.workers"} {
+		if !strings.Contains(migrations, expected) {
+			t.Errorf("Expected migration report to mention %q, got:\n%s", expected, migrations)
+		}
+	}
+	if strings.Contains(migrations, "local.max_output_bytes") {
+		t.Errorf("Explicit local.max_output_bytes should not be reported as migrated:\n%s", migrations)
+	}
+}
+
+func TestParseCurrentConfigIsUnchanged(t *testing.T) {
+	_, report, err := Parse([]byte(fmt.Sprintf("version: %d\nenabled_commands:\n  local_command: false\n", CurrentVersion)), true)
+	if err != nil {
+		t.Fatalf("Parse failed: %v", err)
+	}
+	if len(report.Migrations) != 0 {
+		t.Errorf("Expected no migrations for a current config, got %v", report.Migrations)
+	}
+}
+
+func TestParseRejectsNewerVersion(t *testing.T) {
+	if _, _, err := Parse([]byte("version: 99\n"), true); err == nil {
+		t.Error("Expected an error for a config from a newer release")
+	}
+}
@@ -0,0 +1,69 @@
+package config
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// unknownKeys returns the dotted paths of keys in raw that do not map to a
+// field of t, e.g. "websocket.recconnect". Keys under map-typed fields are
+// free-form, but struct values inside such maps are still checked.
+func unknownKeys(raw map[string]interface{}, t reflect.Type) []string {
+	var keys []string
+	collectUnknownKeys(raw, t, "", &keys)
+	sort.Strings(keys)
+	return keys
+}
+
+func collectUnknownKeys(value interface{}, t reflect.Type, path string, keys *[]string) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	m, ok := value.(map[string]interface{})
+	if !ok {
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		fields := yamlFields(t)
+		for key, child := range m {
+			childPath := key
+			if path != "" {
+				childPath = path + "." + key
+			}
+			field, known := fields[key]
+			if !known {
+				*keys = append(*keys, childPath)
+				continue
+			}
+			collectUnknownKeys(child, field.Type, childPath, keys)
+		}
+	case reflect.Map:
+		for key, child := range m {
+			collectUnknownKeys(child, t.Elem(), path+"."+key, keys)
+		}
+	}
+}
+
+// yamlFields indexes the fields of struct type t by their YAML key.
+func yamlFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name := strings.Split(field.Tag.Get("yaml"), ",")[0]
+		if name == "-" {
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		fields[name] = field
+	}
+	return fields
+}
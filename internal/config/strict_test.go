@@ -0,0 +1,93 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const misspelledConfig = `
+version: 1
+websocket:
+  enabled: true
+  recconnect:
+    enabled: false
+api_profiles:
+  billing:
+    base_url: "http://billing.local"
+    timout: "5s"
+quick_commands:
+  anything_goes:
+    type: "local_command"
+looging:
+  level: "debug"
+`
+
+func TestParseStrictRejectsUnknownKeys(t *testing.T) {
+	_, _, err := Parse([]byte(misspelledConfig), true)
+	if err == nil {
+		t.Fatal("Expected misspelled keys to be rejected in strict mode")
+	}
+	for _, path := range []string{"websocket.recconnect", "api_profiles.billing.timout", "looging"} {
+		if !strings.Contains(err.Error(), path) {
+			t.Errorf("Expected error to name %s, got: %v", path, err)
+		}
+	}
+	if strings.Contains(err.Error(), "anything_goes") {
+		t.Errorf("Free-form quick_commands keys should not be reported: %v", err)
+	}
+}
+
+func TestParseLenientReportsUnknownKeys(t *testing.T) {
+	cfg, report, err := Parse([]byte(misspelledConfig), false)
+	if err != nil {
+		t.Fatalf("Parse failed in lenient mode: %v", err)
+	}
+	expected := []string{"api_profiles.billing.timout", "looging", "websocket.recconnect"}
+	if !reflect.DeepEqual(report.UnknownKeys, expected) {
+		t.Errorf("Expected unknown keys %v, got %v", expected, report.UnknownKeys)
+	}
+	if !cfg.WebSocket.Enabled {
+		t.Error("Expected known keys to still be applied in lenient mode")
+	}
+}
+
+func TestShippedConfigsAreStrictlyValid(t *testing.T) {
+	for _, path := range []string{"../../config.yml", "../../config.example.yml"} {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		if _, _, err := Parse(data, true); err != nil {
+			t.Errorf("%s does not parse strictly: %v", path, err)
+		}
+	}
+}
+
+func TestLoadConfigFailsOnUnknownKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yml")
+	if err := os.WriteFile(path, []byte(misspelledConfig), 0600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+
+	if _, err := loadConfig(path, false); err == nil || !strings.Contains(err.Error(), "websocket.recconnect") {
+		t.Errorf("Expected loading to fail naming websocket.recconnect, got %v", err)
+	}
+
+	cfg, err := loadConfig(path, true)
+	if err != nil {
+		t.Fatalf("Expected -lenient-config to load the config, got %v", err)
+	}
+	if !cfg.WebSocket.Enabled {
+		t.Error("Expected known keys to be applied in lenient mode")
+	}
+}
+
+func TestLoadConfigMissingFileUsesDefaults(t *testing.T) {
+	cfg, err := loadConfig(filepath.Join(t.TempDir(), "missing.yml"), false)
+	if err != nil || cfg == nil {
+		t.Errorf("Expected defaults for a missing config file, got %v, %v", cfg, err)
+	}
+}
@@ -2,15 +2,35 @@ package filemanager
 
 import (
 	"errors"
+	"fmt"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 )
 
+// DefaultMaxFileBytes is the largest file DownloadFile reads or UploadFile
+// writes when Config.MaxFileBytes is not set.
+const DefaultMaxFileBytes = 10 * 1024 * 1024
+
+// ErrOutsideBasePath is returned for paths that resolve outside BasePath.
+var ErrOutsideBasePath = errors.New("path is outside the file manager base path")
+
 // Config holds configuration for the file manager.
 type Config struct {
 	BasePath string `yaml:"base_path"`
+	// MaxFileBytes caps the size of a downloaded or uploaded file.
+	// Defaults to DefaultMaxFileBytes.
+	MaxFileBytes int64 `yaml:"max_file_bytes"`
+}
+
+// File is the content and metadata of a downloaded file.
+type File struct {
+	Path    string
+	Data    []byte
+	Size    int64
+	ModTime time.Time
 }
 
 // FileNode represents a file or directory in the file system tree.
@@ -27,17 +47,19 @@ type FileNode struct {
 type FileManager interface {
 	// ListDevFolders returns a tree of files and folders starting from BasePath.
 	ListDevFolders() ([]FileNode, error)
-	// DownloadFile reads the file at the given relative path and returns its bytes.
-	DownloadFile(relPath string) ([]byte, error)
-	// UploadFile writes data to the given relative path, creating directories as needed.
-	UploadFile(relPath string, data []byte) error
+	// DownloadFile reads the file at the given relative path.
+	DownloadFile(relPath string) (*File, error)
+	// UploadFile atomically writes data with the given mode to the relative
+	// path, creating directories as needed.
+	UploadFile(relPath string, data []byte, mode os.FileMode) error
 	// DeleteFile removes the file or directory at the given relative path.
 	DeleteFile(relPath string) error
 }
 
 // LocalFileManager implements FileManager using the local filesystem.
 type LocalFileManager struct {
-	basePath string
+	basePath     string
+	maxFileBytes int64
 }
 
 // NewFileManager creates a new FileManager based on the provided configuration.
@@ -53,7 +75,50 @@ func NewFileManager(cfg Config) (FileManager, error) {
 	if !info.IsDir() {
 		return nil, errors.New("base_path is not a directory")
 	}
-	return &LocalFileManager{basePath: cfg.BasePath}, nil
+	basePath, err := filepath.Abs(cfg.BasePath)
+	if err != nil {
+		return nil, err
+	}
+	maxFileBytes := cfg.MaxFileBytes
+	if maxFileBytes <= 0 {
+		maxFileBytes = DefaultMaxFileBytes
+	}
+	return &LocalFileManager{basePath: basePath, maxFileBytes: maxFileBytes}, nil
+}
+
+// resolve maps a path relative to the base path to an absolute path,
+// rejecting anything that escapes the base path, including via symlinks.
+func (fm *LocalFileManager) resolve(relPath string) (string, error) {
+	cleanPath := filepath.Clean(relPath)
+	if cleanPath == ".." || strings.HasPrefix(cleanPath, ".."+string(filepath.Separator)) {
+		return "", ErrOutsideBasePath
+	}
+	absPath := filepath.Join(fm.basePath, cleanPath)
+
+	// Follow symlinks on the longest existing prefix of the path
+	realBase, err := filepath.EvalSymlinks(fm.basePath)
+	if err != nil {
+		return "", err
+	}
+	existing := absPath
+	for {
+		if _, err := os.Lstat(existing); err == nil {
+			break
+		}
+		parent := filepath.Dir(existing)
+		if parent == existing {
+			break
+		}
+		existing = parent
+	}
+	realPath, err := filepath.EvalSymlinks(existing)
+	if err != nil {
+		return "", err
+	}
+	if realPath != realBase && !strings.HasPrefix(realPath, realBase+string(filepath.Separator)) {
+		return "", ErrOutsideBasePath
+	}
+	return absPath, nil
 }
 
 // ListDevFolders returns a tree of files and folders starting from BasePath.
@@ -99,29 +164,76 @@ func (fm *LocalFileManager) listRecursive(absRoot, relPath string, depth int) ([
 	return nodes, nil
 }
 
-// DownloadFile reads the file at the relative path.
-func (fm *LocalFileManager) DownloadFile(relPath string) ([]byte, error) {
-	// Prevent path traversal.
-	cleanPath := filepath.Clean(relPath)
-	if cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
-		return nil, errors.New("invalid relative path")
+// DownloadFile reads the file at the relative path, refusing files larger
+// than the configured maximum.
+func (fm *LocalFileManager) DownloadFile(relPath string) (*File, error) {
+	absPath, err := fm.resolve(relPath)
+	if err != nil {
+		return nil, err
 	}
-	absPath := filepath.Join(fm.basePath, cleanPath)
-	return os.ReadFile(absPath)
+	info, err := os.Stat(absPath)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("%s is a directory", relPath)
+	}
+	if info.Size() > fm.maxFileBytes {
+		return nil, fmt.Errorf("file is %d bytes, larger than the %d byte download limit", info.Size(), fm.maxFileBytes)
+	}
+
+	data, err := os.ReadFile(absPath)
+	if err != nil {
+		return nil, err
+	}
+	return &File{
+		Path:    filepath.ToSlash(filepath.Clean(relPath)),
+		Data:    data,
+		Size:    int64(len(data)),
+		ModTime: info.ModTime(),
+	}, nil
 }
 
-// UploadFile writes data to the given relative path, creating directories as needed.
-func (fm *LocalFileManager) UploadFile(relPath string, data []byte) error {
-	cleanPath := filepath.Clean(relPath)
-	if cleanPath == ".." || strings.HasPrefix(cleanPath, "../") {
+// UploadFile writes data to a temporary file next to the destination and
+// renames it into place, so readers never see a partially written file.
+func (fm *LocalFileManager) UploadFile(relPath string, data []byte, mode os.FileMode) error {
+	if int64(len(data)) > fm.maxFileBytes {
+		return fmt.Errorf("file is %d bytes, larger than the %d byte upload limit", len(data), fm.maxFileBytes)
+	}
+	absPath, err := fm.resolve(relPath)
+	if err != nil {
+		return err
+	}
+	if absPath == fm.basePath {
 		return errors.New("invalid relative path")
 	}
-	absPath := filepath.Join(fm.basePath, cleanPath)
 	dir := filepath.Dir(absPath)
 	if err := os.MkdirAll(dir, 0755); err != nil {
 		return err
 	}
-	return os.WriteFile(absPath, data, 0644)
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(absPath)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op once renamed
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(mode); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), absPath)
 }
 
 // DeleteFile removes the file or directory at the given relative path.
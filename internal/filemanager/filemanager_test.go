@@ -20,7 +20,7 @@ func TestLocalFileManager(t *testing.T) {
 	// Test Upload
 	testData := []byte("hello world")
 	testPath := "subdir/test.txt"
-	err = fm.UploadFile(testPath, testData)
+	err = fm.UploadFile(testPath, testData, 0644)
 	if err != nil {
 		t.Errorf("UploadFile failed: %v", err)
 	}
@@ -28,10 +28,10 @@ func TestLocalFileManager(t *testing.T) {
 	// Test Download
 	downloaded, err := fm.DownloadFile(testPath)
 	if err != nil {
-		t.Errorf("DownloadFile failed: %v", err)
+		t.Fatalf("DownloadFile failed: %v", err)
 	}
-	if string(downloaded) != string(testData) {
-		t.Errorf("Downloaded data mismatch: expected %s, got %s", string(testData), string(downloaded))
+	if string(downloaded.Data) != string(testData) || downloaded.Size != int64(len(testData)) {
+		t.Errorf("Downloaded data mismatch: expected %s, got %+v", string(testData), downloaded)
 	}
 
 	// Test List
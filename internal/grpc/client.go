@@ -0,0 +1,404 @@
+package grpc
+
+import (
+	"context"
+	"crypto/tls"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+var _ transport.Transport = (*GRPCClient)(nil)
+
+// GRPCClient runs the agent session over a bidirectional AgentStream.Connect
+// stream. Addresses are host:port, optionally prefixed with grpc:// or, for
+// TLS with the system roots, grpcs://.
+type GRPCClient struct {
+	commandHandler transport.Handler
+	conn           *grpcgo.ClientConn
+	stream         grpcgo.ClientStream // per connection, replaced on every dial
+	mu             sync.RWMutex
+	sendMu         sync.Mutex // a stream allows one sender at a time
+	connected      bool
+	connectTimeout time.Duration
+
+	reconnect    transport.ReconnectConfig
+	onReconnect  []func()
+	onDisconnect []func()
+	stopped      bool
+	cancel       context.CancelFunc
+	lost         chan struct{} // closed when the current stream ends
+
+	address  string
+	clientID string
+	identity func() transport.Identity
+}
+
+func NewGRPCClient(reconnect transport.ReconnectConfig) *GRPCClient {
+	return &GRPCClient{reconnect: reconnect, connectTimeout: transport.DefaultConnectTimeout}
+}
+
+// SetConnectTimeout bounds each connection attempt; 0 restores
+// transport.DefaultConnectTimeout.
+func (c *GRPCClient) SetConnectTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connectTimeout = transport.ConnectTimeout(timeout)
+}
+
+func (c *GRPCClient) Connect(ctx context.Context, address, clientID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	// Stop supervising any previous session before starting a new one
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.address = address
+	c.clientID = clientID
+	c.stopped = false
+	c.cancel = cancel
+	reconnect := c.reconnect
+	c.mu.Unlock()
+
+	if err := transport.Retry(ctx, reconnect, "gRPC", func() error { return c.dial(ctx) }); err != nil {
+		cancel()
+		return err
+	}
+
+	go c.supervise(ctx)
+
+	return nil
+}
+
+// target splits an address into the dial target and its credentials.
+func target(address string) (string, credentials.TransportCredentials) {
+	if rest, ok := strings.CutPrefix(address, "grpcs://"); ok {
+		return rest, credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+	return strings.TrimPrefix(address, "grpc://"), insecure.NewCredentials()
+}
+
+// waitReady connects conn and waits up to timeout for it to become ready.
+// If the attempt fails outright it returns nil and leaves opening the
+// stream to report why.
+func waitReady(ctx context.Context, conn *grpcgo.ClientConn, timeout time.Duration) error {
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	conn.Connect()
+	for {
+		state := conn.GetState()
+		if state == connectivity.Ready || state == connectivity.TransientFailure || state == connectivity.Shutdown {
+			return nil
+		}
+		if !conn.WaitForStateChange(dialCtx, state) {
+			if transport.DialTimedOut(ctx, dialCtx, nil) {
+				return fmt.Errorf("failed to connect to gRPC server: timed out after %s", timeout)
+			}
+			return fmt.Errorf("failed to connect to gRPC server: %w", ctx.Err())
+		}
+	}
+}
+
+// dial opens a new stream, identifies the client and starts the reader.
+func (c *GRPCClient) dial(ctx context.Context) error {
+	c.mu.RLock()
+	address, clientID, identityFn, timeout := c.address, c.clientID, c.identity, c.connectTimeout
+	c.mu.RUnlock()
+
+	slog.Info("Connecting to gRPC server", "protocol", "grpc", "url", address, "client_id", clientID)
+
+	addr, creds := target(address)
+	conn, err := grpcgo.NewClient(addr, grpcgo.WithTransportCredentials(creds))
+	if err != nil {
+		return fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+	if err := waitReady(ctx, conn, timeout); err != nil {
+		conn.Close()
+		return err
+	}
+
+	// The stream lives as long as the session. Opening it fails fast when
+	// the server cannot be reached, leaving retries to the reconnect policy.
+	streamCtx, cancelStream := context.WithCancel(ctx)
+	stream, err := conn.NewStream(streamCtx, &ServiceDesc.Streams[0], connectMethod)
+	if err != nil {
+		cancelStream()
+		conn.Close()
+		return fmt.Errorf("failed to open gRPC stream: %w", err)
+	}
+
+	lost := make(chan struct{})
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		cancelStream()
+		conn.Close()
+		return fmt.Errorf("gRPC client stopped")
+	}
+	// Tear down a connection left over from a concurrent dial
+	if c.connected && c.conn != nil {
+		c.conn.Close()
+		close(c.lost)
+	}
+	c.conn = conn
+	c.stream = stream
+	c.connected = true
+	c.lost = lost
+	c.mu.Unlock()
+
+	log.Printf("gRPC connected successfully")
+
+	// Send identification message immediately after connection
+	identification := transport.Identify(identityFn, clientID)
+
+	if err := c.Send(map[string]interface{}{
+		"type":    "identification",
+		"id":      "init",
+		"payload": identification,
+		"success": true,
+	}); err != nil {
+		slog.Error("Failed to send identification", "protocol", "grpc", "error", err)
+	}
+
+	go func() {
+		defer cancelStream()
+		c.recvPump(conn, stream)
+	}()
+
+	return nil
+}
+
+// supervise waits for the stream to end and reopens it until Disconnect
+// is called, the context ends or the reconnect policy gives up.
+func (c *GRPCClient) supervise(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		lost := c.lost
+		c.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			c.Disconnect()
+			c.disconnected()
+			return
+		case <-lost:
+		}
+		c.disconnected()
+
+		c.mu.RLock()
+		stopped := c.stopped
+		reconnect := c.reconnect
+		c.mu.RUnlock()
+		if stopped {
+			return
+		}
+
+		slog.Warn("❌ gRPC stream lost")
+
+		if !reconnect.Enabled {
+			slog.Warn("gRPC reconnection disabled, not attempting to reconnect")
+			return
+		}
+
+		log.Printf("🔄 gRPC disconnected, attempting to reconnect...")
+		if err := transport.Redial(ctx, reconnect, "gRPC", func() error { return c.dial(ctx) }); err != nil {
+			slog.Error("❌ gRPC reconnection failed, giving up", "error", err)
+			return
+		}
+
+		log.Printf("✅ gRPC reconnected successfully")
+
+		c.mu.RLock()
+		callbacks := append([]func(){}, c.onReconnect...)
+		c.mu.RUnlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// dropConn tears down conn if it is still the active connection.
+func (c *GRPCClient) dropConn(conn *grpcgo.ClientConn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn == nil || c.conn != conn || !c.connected {
+		return
+	}
+
+	c.connected = false
+	conn.Close()
+	close(c.lost)
+}
+
+func (c *GRPCClient) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if !c.connected {
+		return nil
+	}
+
+	c.connected = false
+	// Closing the connection ends the stream on both sides
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	close(c.lost)
+
+	log.Printf("gRPC disconnected")
+	return nil
+}
+
+// DropConnection closes the current connection without stopping the
+// client, so supervise treats it as lost and redials.
+func (c *GRPCClient) DropConnection() {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	c.dropConn(conn)
+}
+
+func (c *GRPCClient) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
+// SetReconnect replaces the reconnect policy used for future redials.
+func (c *GRPCClient) SetReconnect(reconnect transport.ReconnectConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = reconnect
+}
+
+// OnReconnect registers fn to be called after each successful redial.
+func (c *GRPCClient) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// OnDisconnect registers fn to be called whenever the connection drops or
+// is closed.
+func (c *GRPCClient) OnDisconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDisconnect = append(c.onDisconnect, fn)
+}
+
+// disconnected runs the OnDisconnect callbacks.
+func (c *GRPCClient) disconnected() {
+	c.mu.RLock()
+	callbacks := append([]func(){}, c.onDisconnect...)
+	c.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// Send writes message to the stream as a Struct. It goes through JSON so
+// that Go structs in the payload are encoded as on the other transports.
+func (c *GRPCClient) Send(message map[string]interface{}) error {
+	c.mu.RLock()
+	connected, stream := c.connected, c.stream
+	c.mu.RUnlock()
+	if !connected {
+		return fmt.Errorf("gRPC not connected")
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+	logging.TraceMessage("Sending message", data, "protocol", "grpc")
+
+	envelope := new(structpb.Struct)
+	if err := envelope.UnmarshalJSON(data); err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	c.sendMu.Lock()
+	defer c.sendMu.Unlock()
+	if err := stream.SendMsg(envelope); err != nil {
+		return fmt.Errorf("failed to send on gRPC stream: %w", err)
+	}
+	return nil
+}
+
+func (c *GRPCClient) recvPump(conn *grpcgo.ClientConn, stream grpcgo.ClientStream) {
+	defer c.dropConn(conn)
+
+	for {
+		envelope := new(structpb.Struct)
+		if err := stream.RecvMsg(envelope); err != nil {
+			slog.Warn("gRPC stream closed", "protocol", "grpc", "error", err)
+			return
+		}
+		c.handleMessage(envelope.AsMap())
+	}
+}
+
+func (c *GRPCClient) handleMessage(message map[string]interface{}) {
+	if logging.TracingMessages() {
+		slog.Info("Received message", "protocol", "grpc", "message", logging.Redact(message))
+	}
+
+	switch message["type"] {
+	case "identification_success":
+		slog.Debug("Identification successful", "message", logging.Redact(message))
+		return
+	case "ping":
+		c.Send(map[string]interface{}{
+			"type":      "pong",
+			"id":        message["id"],
+			"timestamp": time.Now().Unix(),
+		})
+		return
+	}
+
+	c.mu.RLock()
+	handler := c.commandHandler
+	c.mu.RUnlock()
+	if handler == nil {
+		return
+	}
+	if response := handler(message); response != nil {
+		if err := c.Send(response); err != nil {
+			slog.Error("Failed to send response", "protocol", "grpc", "command_id", message["id"], "error", err)
+		}
+	}
+}
+
+func (c *GRPCClient) SetHandler(handler transport.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commandHandler = handler
+}
+
+// SetIdentityProvider sets the source of the identification message.
+func (c *GRPCClient) SetIdentityProvider(provider func() transport.Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = provider
+}
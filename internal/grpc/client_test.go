@@ -0,0 +1,201 @@
+package grpc
+
+import (
+	"context"
+	"edge-agent/internal/transport"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// testServer sends each command in commands once the agent has identified
+// itself and reports what the agent sends. With endAfter set, it ends each
+// stream after that many messages from the agent.
+type testServer struct {
+	commands chan map[string]interface{}
+	received chan map[string]interface{}
+	endAfter int
+}
+
+func (s *testServer) Connect(stream AgentStream_ConnectServer) error {
+	ctx := stream.Context()
+
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case command := <-s.commands:
+				envelope, _ := structpb.NewStruct(command)
+				if stream.Send(envelope) != nil {
+					return
+				}
+			}
+		}
+	}()
+
+	for n := 1; ; n++ {
+		envelope, err := stream.Recv()
+		if err != nil {
+			return err
+		}
+		s.received <- envelope.AsMap()
+		if s.endAfter > 0 && n >= s.endAfter {
+			return nil
+		}
+	}
+}
+
+func startTestServer(t *testing.T, srv *testServer) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpcgo.NewServer()
+	RegisterAgentStreamServer(server, srv)
+	go server.Serve(listener)
+	t.Cleanup(server.Stop)
+	return "grpc://" + listener.Addr().String()
+}
+
+func newTestServer() *testServer {
+	return &testServer{
+		commands: make(chan map[string]interface{}, 10),
+		received: make(chan map[string]interface{}, 100),
+	}
+}
+
+func (s *testServer) next(t *testing.T) map[string]interface{} {
+	t.Helper()
+	select {
+	case message := <-s.received:
+		return message
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for a message from the agent")
+		return nil
+	}
+}
+
+func echoHandler(message map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "command_response",
+		"id":      message["id"],
+		"success": true,
+		"payload": message["payload"],
+	}
+}
+
+func TestGRPCClientCommandDispatch(t *testing.T) {
+	srv := newTestServer()
+	address := startTestServer(t, srv)
+
+	client := NewGRPCClient(transport.ReconnectConfig{})
+	client.SetHandler(echoHandler)
+	client.SetIdentityProvider(func() transport.Identity {
+		return transport.Identity{Version: "1.2.3"}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, address, "edge-9"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	identification := srv.next(t)
+	payload, _ := identification["payload"].(map[string]interface{})
+	if identification["type"] != "identification" || payload["client_id"] != "edge-9" || payload["version"] != "1.2.3" {
+		t.Fatalf("Unexpected identification %v", identification)
+	}
+
+	srv.commands <- map[string]interface{}{
+		"type":    "status",
+		"id":      "cmd-1",
+		"payload": map[string]interface{}{"verbose": true},
+	}
+	response := srv.next(t)
+	if response["id"] != "cmd-1" || response["success"] != true {
+		t.Fatalf("Unexpected response %v", response)
+	}
+	if echoed, _ := response["payload"].(map[string]interface{}); echoed["verbose"] != true {
+		t.Errorf("Expected the payload to round-trip, got %v", response["payload"])
+	}
+}
+
+func TestGRPCClientReopensStreamAfterDisconnect(t *testing.T) {
+	srv := newTestServer()
+	srv.endAfter = 1 // end every stream after the identification
+	address := startTestServer(t, srv)
+
+	client := NewGRPCClient(transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	client.SetHandler(echoHandler)
+
+	reconnected := make(chan struct{}, 10)
+	disconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	client.OnDisconnect(func() { disconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.Connect(ctx, address, "edge-10"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-disconnected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for disconnect %d", i+1)
+		}
+		select {
+		case <-reconnected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for reconnect %d", i+1)
+		}
+	}
+	// Every reopened stream starts with its own identification
+	for i := 0; i < 3; i++ {
+		if message := srv.next(t); message["type"] != "identification" {
+			t.Fatalf("Expected an identification on stream %d, got %v", i+1, message)
+		}
+	}
+}
+
+func TestGRPCClientConnectTimeout(t *testing.T) {
+	// Accept connections but never speak HTTP/2
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	client := NewGRPCClient(transport.ReconnectConfig{Enabled: false})
+	client.SetConnectTimeout(200 * time.Millisecond)
+
+	start := time.Now()
+	err = client.Connect(context.Background(), listener.Addr().String(), "test-client")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 200ms") {
+		t.Fatalf("Expected a connect timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Expected the attempt to give up after about 200ms, took %s", elapsed)
+	}
+}
@@ -0,0 +1,64 @@
+package grpc
+
+import (
+	grpcgo "google.golang.org/grpc"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// This file is the Go binding of agent.proto, written out by hand in the
+// shape protoc-gen-go-grpc produces since the messages are well-known
+// Structs and need no generated types.
+
+const connectMethod = "/edgeagent.v1.AgentStream/Connect"
+
+// AgentStreamServer is the server side of the AgentStream service.
+type AgentStreamServer interface {
+	Connect(AgentStream_ConnectServer) error
+}
+
+// AgentStream_ConnectServer is the server's end of a Connect stream.
+type AgentStream_ConnectServer interface {
+	Send(*structpb.Struct) error
+	Recv() (*structpb.Struct, error)
+	grpcgo.ServerStream
+}
+
+// ServiceDesc describes the AgentStream service for grpc.Server.
+var ServiceDesc = grpcgo.ServiceDesc{
+	ServiceName: "edgeagent.v1.AgentStream",
+	HandlerType: (*AgentStreamServer)(nil),
+	Streams: []grpcgo.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       connectHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
+
+// RegisterAgentStreamServer registers srv with s.
+func RegisterAgentStreamServer(s grpcgo.ServiceRegistrar, srv AgentStreamServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+func connectHandler(srv interface{}, stream grpcgo.ServerStream) error {
+	return srv.(AgentStreamServer).Connect(&connectServer{stream})
+}
+
+type connectServer struct {
+	grpcgo.ServerStream
+}
+
+func (s *connectServer) Send(m *structpb.Struct) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *connectServer) Recv() (*structpb.Struct, error) {
+	m := new(structpb.Struct)
+	if err := s.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
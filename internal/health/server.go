@@ -0,0 +1,119 @@
+package health
+
+import (
+	"context"
+	"edge-agent/internal/metrics"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"time"
+)
+
+// Server exposes liveness, readiness and Prometheus metrics over HTTP so
+// the agent can be monitored without going through the control server.
+type Server struct {
+	addr      string
+	commands  *metrics.CommandMetrics
+	registry  *metrics.Registry
+	connected func() bool
+	server    *http.Server
+	listener  net.Listener
+}
+
+// NewServer creates a server listening on addr once started. Metrics in
+// registry, if not nil, are served alongside the command counters;
+// connected reports whether the transport is currently connected.
+func NewServer(addr string, commands *metrics.CommandMetrics, registry *metrics.Registry, connected func() bool) *Server {
+	s := &Server{addr: addr, commands: commands, registry: registry, connected: connected}
+	s.server = &http.Server{Handler: s.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	return s
+}
+
+// Handler serves /healthz, /readyz and /metrics.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		if !s.connected() {
+			http.Error(w, "transport not connected", http.StatusServiceUnavailable)
+			return
+		}
+		io.WriteString(w, "ok\n")
+	})
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		s.writeMetrics(w)
+	})
+	return mux
+}
+
+// Start binds the listen address and serves in the background.
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.addr, err)
+	}
+	s.listener = listener
+
+	go func() {
+		if err := s.server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("Health server failed", "addr", s.addr, "error", err)
+		}
+	}()
+	slog.Info("Health server listening", "addr", listener.Addr().String())
+	return nil
+}
+
+// Addr returns the bound address, which differs from the configured one
+// when port 0 was requested.
+func (s *Server) Addr() string {
+	if s.listener == nil {
+		return s.addr
+	}
+	return s.listener.Addr().String()
+}
+
+// Stop shuts the server down, waiting briefly for open requests.
+func (s *Server) Stop() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.server.Shutdown(ctx)
+}
+
+// writeMetrics renders the counters in the Prometheus text format.
+func (s *Server) writeMetrics(w io.Writer) {
+	writeCounter(w, "edge_agent_commands_processed_total", "Commands processed, by command type.", s.commands.Counts())
+	writeCounter(w, "edge_agent_commands_failed_total", "Commands that returned an error, by command type.", s.commands.FailedCounts())
+
+	connected := 0
+	if s.connected() {
+		connected = 1
+	}
+	fmt.Fprintf(w, "# HELP edge_agent_connected Whether the transport is connected to the control server.\n")
+	fmt.Fprintf(w, "# TYPE edge_agent_connected gauge\n")
+	fmt.Fprintf(w, "edge_agent_connected %d\n", connected)
+
+	if s.registry != nil {
+		s.registry.WritePrometheus(w)
+	}
+}
+
+func writeCounter(w io.Writer, name, help string, counts map[string]uint64) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, help)
+	fmt.Fprintf(w, "# TYPE %s counter\n", name)
+
+	types := make([]string, 0, len(counts))
+	for cmdType := range counts {
+		types = append(types, cmdType)
+	}
+	sort.Strings(types)
+	for _, cmdType := range types {
+		fmt.Fprintf(w, "%s{type=%q} %d\n", name, cmdType, counts[cmdType])
+	}
+}
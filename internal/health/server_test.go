@@ -0,0 +1,85 @@
+package health
+
+import (
+	"edge-agent/internal/metrics"
+	"io"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func get(t *testing.T, url string) (int, string) {
+	t.Helper()
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatalf("GET %s failed: %v", url, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func TestServerEndpoints(t *testing.T) {
+	commands := metrics.NewCommandMetrics([]string{"api_call", "local_command"})
+	commands.Inc("api_call")
+	commands.Inc("api_call")
+	commands.Inc("local_command")
+	commands.IncFailed("local_command")
+
+	registry := metrics.NewRegistry()
+	registry.NewGauge("edge_agent_transport_connected", "Transport connected.").Set(1)
+
+	var connected atomic.Bool
+	server := NewServer("127.0.0.1:0", commands, registry, connected.Load)
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	defer server.Stop()
+	base := "http://" + server.Addr()
+
+	if status, _ := get(t, base+"/healthz"); status != http.StatusOK {
+		t.Errorf("Expected /healthz to be 200, got %d", status)
+	}
+
+	if status, _ := get(t, base+"/readyz"); status != http.StatusServiceUnavailable {
+		t.Errorf("Expected /readyz to be 503 while disconnected, got %d", status)
+	}
+	connected.Store(true)
+	if status, _ := get(t, base+"/readyz"); status != http.StatusOK {
+		t.Errorf("Expected /readyz to be 200 once connected, got %d", status)
+	}
+
+	status, body := get(t, base+"/metrics")
+	if status != http.StatusOK {
+		t.Fatalf("Expected /metrics to be 200, got %d", status)
+	}
+	for _, line := range []string{
+		"# TYPE edge_agent_commands_processed_total counter",
+		`edge_agent_commands_processed_total{type="api_call"} 2`,
+		`edge_agent_commands_processed_total{type="local_command"} 1`,
+		`edge_agent_commands_failed_total{type="local_command"} 1`,
+		"# TYPE edge_agent_connected gauge",
+		"edge_agent_connected 1",
+		"edge_agent_transport_connected 1",
+	} {
+		if !strings.Contains(body, line+"\n") {
+			t.Errorf("Expected %q in metrics output:\n%s", line, body)
+		}
+	}
+}
+
+func TestServerStopReleasesAddress(t *testing.T) {
+	server := NewServer("127.0.0.1:0", metrics.NewCommandMetrics(nil), nil, func() bool { return true })
+	if err := server.Start(); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	addr := server.Addr()
+	if err := server.Stop(); err != nil {
+		t.Fatalf("Stop failed: %v", err)
+	}
+
+	if _, err := http.Get("http://" + addr + "/healthz"); err == nil {
+		t.Error("Expected the server to be unreachable after Stop")
+	}
+}
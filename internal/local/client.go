@@ -3,25 +3,90 @@ package local
 import (
 	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"os/exec"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
 type LocalClient struct{}
 
+// DefaultMaxOutputBytes is the per-stream capture limit used when
+// LocalCommand.MaxOutputBytes is not set.
+const DefaultMaxOutputBytes = 1024 * 1024
+
+// DefaultShell returns the interpreter used when neither the command nor the
+// config sets one: cmd /C on Windows, sh -c everywhere else.
+func DefaultShell() []string {
+	if runtime.GOOS == "windows" {
+		return []string{"cmd", "/C"}
+	}
+	return []string{"sh", "-c"}
+}
+
 type LocalCommand struct {
 	Command string            `json:"command"`
 	Env     map[string]string `json:"env"`
-	WorkDir string            `json:"work_dir"`
-	Timeout time.Duration     `json:"timeout"`
+	// InheritEnv controls whether the command starts from the agent's
+	// environment, with Env added on top. When false it gets only Env
+	// plus a minimal PATH. Defaults to true.
+	InheritEnv *bool         `json:"inherit_env,omitempty"`
+	WorkDir    string        `json:"work_dir"`
+	Timeout    time.Duration `json:"timeout"`
+	Stdin      string        `json:"stdin,omitempty"`
+
+	// CreateWorkDir creates WorkDir, and any missing parents, before the
+	// command runs. Otherwise a missing WorkDir fails the command.
+	CreateWorkDir bool `json:"create_work_dir,omitempty"`
+
+	// RunAsUser and RunAsGroup drop the command to another identity
+	// (Unix only, requires the agent to run as root). RunAsGroup defaults
+	// to the user's primary group. Unset, the agent's identity is kept.
+	RunAsUser  string `json:"run_as_user,omitempty"`
+	RunAsGroup string `json:"run_as_group,omitempty"`
+
+	// Shell is the interpreter and its arguments; Command is appended as
+	// the final argument. Defaults to DefaultShell().
+	Shell []string `json:"shell,omitempty"`
+
+	// CombineOutput captures stdout and stderr interleaved into
+	// LocalResult.Combined, leaving Stdout and Stderr empty. Streamed
+	// lines are reported as the "combined" stream.
+	CombineOutput bool `json:"combine_output,omitempty"`
+
+	// MaxOutputBytes caps how much of stdout and of stderr is captured;
+	// the rest is discarded. Defaults to DefaultMaxOutputBytes.
+	MaxOutputBytes int64 `json:"max_output_bytes,omitempty"`
+
+	// StreamFunc, if set, receives each stdout/stderr line as it is
+	// produced. The full output is still returned in LocalResult.
+	StreamFunc func(stream string, line string) `json:"-"`
 }
 
 type LocalResult struct {
-	Stdout   string `json:"stdout"`
-	Stderr   string `json:"stderr"`
-	Duration string `json:"duration"`
-	ExitCode int    `json:"exit_code"`
+	Stdout      string `json:"stdout"`
+	Stderr      string `json:"stderr"`
+	Combined    string `json:"combined,omitempty"`     // interleaved output when CombineOutput is set
+	StdoutRef   string `json:"stdout_ref,omitempty"`   // set when stdout was stored instead of inlined
+	StderrRef   string `json:"stderr_ref,omitempty"`   // set when stderr was stored instead of inlined
+	CombinedRef string `json:"combined_ref,omitempty"` // set when combined output was stored instead of inlined
+	Duration    string `json:"duration"`               // human-readable, e.g. "1.503s"
+	DurationMs  int64  `json:"duration_ms"`            // the same elapsed time in milliseconds
+	ExitCode    int    `json:"exit_code"`
+	Truncated   bool   `json:"truncated"` // captured output exceeded MaxOutputBytes
+
+	// Signal names the signal that ended the command, e.g. "SIGKILL"
+	// (Unix only). TimedOut and Cancelled tell whether the agent sent it
+	// because the timeout expired or the command was cancelled.
+	Signal    string `json:"signal,omitempty"`
+	TimedOut  bool   `json:"timed_out,omitempty"`
+	Cancelled bool   `json:"cancelled,omitempty"`
 }
 
 func NewLocalClient() *LocalClient {
@@ -32,13 +97,33 @@ func (c *LocalClient) ExecuteCommand(ctx context.Context, cmd *LocalCommand) (*L
 	if cmd.Timeout == 0 {
 		cmd.Timeout = 30 * time.Second
 	}
+	if cmd.MaxOutputBytes <= 0 {
+		cmd.MaxOutputBytes = DefaultMaxOutputBytes
+	}
 
-	// Create command with context
-	execCmd := exec.CommandContext(ctx, "sh", "-c", cmd.Command)
+	shellPath, args, err := shellInvocation(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmd.Timeout)
+	defer cancel()
 
-	// Set environment variables
-	if cmd.Env != nil {
-		env := execCmd.Env
+	// Run the command in its own process group so a timeout can take
+	// down any children it spawned along with the shell
+	execCmd := exec.Command(shellPath, args...)
+	setProcessGroup(execCmd)
+	execCmd.WaitDelay = time.Second
+	if err := setCredential(execCmd, cmd.RunAsUser, cmd.RunAsGroup); err != nil {
+		return nil, err
+	}
+
+	// Set environment variables; later entries override earlier ones
+	if cmd.Env != nil || !cmd.inheritEnv() {
+		env := minimalEnv()
+		if cmd.inheritEnv() {
+			env = os.Environ()
+		}
 		for key, value := range cmd.Env {
 			env = append(env, fmt.Sprintf("%s=%s", key, value))
 		}
@@ -47,17 +132,62 @@ func (c *LocalClient) ExecuteCommand(ctx context.Context, cmd *LocalCommand) (*L
 
 	// Set working directory
 	if cmd.WorkDir != "" {
+		if cmd.CreateWorkDir {
+			if err := os.MkdirAll(cmd.WorkDir, 0755); err != nil {
+				return nil, fmt.Errorf("failed to create work_dir %q: %w", cmd.WorkDir, err)
+			}
+		}
+		if err := checkWorkDir(cmd.WorkDir); err != nil {
+			return nil, err
+		}
 		execCmd.Dir = cmd.WorkDir
 	}
 
+	if cmd.Stdin != "" {
+		execCmd.Stdin = strings.NewReader(cmd.Stdin)
+	}
+
 	// Execute command with timeout
-	var stdout, stderr bytes.Buffer
-	execCmd.Stdout = &stdout
-	execCmd.Stderr = &stderr
+	stdout := &limitedBuffer{max: cmd.MaxOutputBytes}
+	stderr := &limitedBuffer{max: cmd.MaxOutputBytes}
+	var stdoutW, stderrW io.Writer = stdout, stderr
+
+	// Sharing one writer between both streams makes exec hand the command
+	// a single pipe, so writes stay in the order the command made them
+	var combined *limitedBuffer
+	if cmd.CombineOutput {
+		combined = &limitedBuffer{max: cmd.MaxOutputBytes}
+		stdoutW, stderrW = combined, combined
+	}
+
+	var stdoutLines, stderrLines *lineWriter
+	if cmd.StreamFunc != nil {
+		var streamMu sync.Mutex
+		emit := func(stream, line string) {
+			streamMu.Lock()
+			defer streamMu.Unlock()
+			cmd.StreamFunc(stream, line)
+		}
+		if combined != nil {
+			stdoutLines = &lineWriter{stream: "combined", emit: emit, max: cmd.MaxOutputBytes}
+			w := io.MultiWriter(combined, stdoutLines)
+			stdoutW, stderrW = w, w
+		} else {
+			stdoutLines = &lineWriter{stream: "stdout", emit: emit, max: cmd.MaxOutputBytes}
+			stderrLines = &lineWriter{stream: "stderr", emit: emit, max: cmd.MaxOutputBytes}
+			stdoutW = io.MultiWriter(stdout, stdoutLines)
+			stderrW = io.MultiWriter(stderr, stderrLines)
+		}
+	}
+	execCmd.Stdout = stdoutW
+	execCmd.Stderr = stderrW
 
 	start := time.Now()
-	err := execCmd.Start()
+	err = execCmd.Start()
 	if err != nil {
+		if (cmd.RunAsUser != "" || cmd.RunAsGroup != "") && errors.Is(err, os.ErrPermission) {
+			return nil, fmt.Errorf("agent lacks permission to run commands as user %q group %q: %w", cmd.RunAsUser, cmd.RunAsGroup, err)
+		}
 		return nil, fmt.Errorf("failed to start command: %w", err)
 	}
 
@@ -67,30 +197,218 @@ func (c *LocalClient) ExecuteCommand(ctx context.Context, cmd *LocalCommand) (*L
 		done <- execCmd.Wait()
 	}()
 
+	var killed error
 	select {
 	case <-ctx.Done():
-		execCmd.Process.Kill()
-		return nil, fmt.Errorf("command timed out")
-	case err := <-done:
-		duration := time.Since(start)
-
-		result := &LocalResult{
-			Stdout:   stdout.String(),
-			Stderr:   stderr.String(),
-			Duration: duration.String(),
-		}
+		killProcessGroup(execCmd)
+		err = <-done
+		killed = ctx.Err()
+	case err = <-done:
+	}
+	duration := time.Since(start)
 
-		if err != nil {
-			if exitError, ok := err.(*exec.ExitError); ok {
-				result.ExitCode = exitError.ExitCode()
+	if stdoutLines != nil {
+		stdoutLines.Flush()
+	}
+	if stderrLines != nil {
+		stderrLines.Flush()
+	}
+
+	result := &LocalResult{
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		Duration:   duration.String(),
+		DurationMs: duration.Milliseconds(),
+		Truncated:  stdout.dropped > 0 || stderr.dropped > 0,
+	}
+	if combined != nil {
+		result.Combined = combined.String()
+		result.Truncated = combined.dropped > 0
+	}
+
+	if err != nil {
+		if exitError, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitError.ExitCode()
+			result.Signal = exitSignal(exitError)
+		} else {
+			result.ExitCode = -1
+			if combined != nil {
+				result.Combined += fmt.Sprintf("\nExecution error: %v", err)
 			} else {
-				result.ExitCode = -1
 				result.Stderr += fmt.Sprintf("\nExecution error: %v", err)
 			}
-		} else {
-			result.ExitCode = 0
 		}
+	} else {
+		result.ExitCode = 0
+	}
+
+	// A killed command still returns what it printed and how it ended
+	switch {
+	case killed == context.DeadlineExceeded:
+		result.TimedOut = true
+		return result, fmt.Errorf("command timed out")
+	case killed != nil:
+		result.Cancelled = true
+		return result, fmt.Errorf("command cancelled")
+	}
+	return result, nil
+}
+
+func (cmd *LocalCommand) inheritEnv() bool {
+	return cmd.InheritEnv == nil || *cmd.InheritEnv
+}
+
+// shellInvocation resolves the interpreter for cmd and the arguments it
+// is run with, ending with the command itself.
+func shellInvocation(cmd *LocalCommand) (string, []string, error) {
+	shell := cmd.Shell
+	if len(shell) == 0 {
+		shell = DefaultShell()
+	}
+	shellPath, err := exec.LookPath(shell[0])
+	if err != nil {
+		return "", nil, fmt.Errorf("shell %q not found: %w", shell[0], err)
+	}
+	return shellPath, append(append([]string{}, shell[1:]...), cmd.Command), nil
+}
+
+// LocalPlan describes how ExecuteCommand would run a command, for dry runs.
+type LocalPlan struct {
+	Path       string   `json:"path"` // resolved interpreter
+	Args       []string `json:"args"` // ending with the command
+	WorkDir    string   `json:"work_dir,omitempty"`
+	EnvKeys    []string `json:"env_keys,omitempty"` // values are not reported
+	InheritEnv bool     `json:"inherit_env"`
+	Timeout    string   `json:"timeout"`
+	RunAsUser  string   `json:"run_as_user,omitempty"`
+	RunAsGroup string   `json:"run_as_group,omitempty"`
+}
+
+// Plan validates cmd as ExecuteCommand would and reports the invocation
+// without starting a process.
+func (c *LocalClient) Plan(cmd *LocalCommand) (*LocalPlan, error) {
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+
+	shellPath, args, err := shellInvocation(cmd)
+	if err != nil {
+		return nil, err
+	}
+	if err := setCredential(exec.Command(shellPath), cmd.RunAsUser, cmd.RunAsGroup); err != nil {
+		return nil, err
+	}
+	if cmd.WorkDir != "" {
+		// A directory that would be created is fine; anything else in its
+		// place is not
+		if err := checkWorkDir(cmd.WorkDir); err != nil && !(cmd.CreateWorkDir && errors.Is(err, os.ErrNotExist)) {
+			return nil, err
+		}
+	}
+
+	envKeys := sortedKeys(cmd.Env)
+
+	return &LocalPlan{
+		Path:       shellPath,
+		Args:       args,
+		WorkDir:    cmd.WorkDir,
+		EnvKeys:    envKeys,
+		InheritEnv: cmd.inheritEnv(),
+		Timeout:    timeout.String(),
+		RunAsUser:  cmd.RunAsUser,
+		RunAsGroup: cmd.RunAsGroup,
+	}, nil
+}
+
+// sortedKeys returns the names of env in order; values are never reported.
+func sortedKeys(env map[string]string) []string {
+	var keys []string
+	for key := range env {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// checkWorkDir returns an error unless dir exists and is a directory.
+func checkWorkDir(dir string) error {
+	info, err := os.Stat(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("work_dir %q does not exist: %w", dir, err)
+	}
+	if err != nil {
+		return fmt.Errorf("work_dir %q: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("work_dir %q is not a directory", dir)
+	}
+	return nil
+}
+
+// limitedBuffer captures up to max bytes and counts the rest as dropped.
+// Writes never fail, so the command keeps running instead of hitting a
+// broken pipe once the cap is reached.
+type limitedBuffer struct {
+	buf     bytes.Buffer
+	max     int64
+	dropped int64
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	remaining := b.max - int64(b.buf.Len())
+	if remaining < 0 {
+		remaining = 0
+	}
+	if int64(len(p)) > remaining {
+		b.buf.Write(p[:remaining])
+		b.dropped += int64(len(p)) - remaining
+	} else {
+		b.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+// String returns the captured output with a marker if anything was dropped.
+func (b *limitedBuffer) String() string {
+	if b.dropped == 0 {
+		return b.buf.String()
+	}
+	return fmt.Sprintf("%s...[truncated %d bytes]", b.buf.String(), b.dropped)
+}
+
+// lineWriter splits written output into lines and hands each complete line
+// to emit. A line longer than max is emitted in pieces of max bytes, so
+// output without newlines is not buffered without limit. A trailing
+// partial line is emitted by Flush.
+type lineWriter struct {
+	stream  string
+	emit    func(stream, line string)
+	max     int64
+	pending []byte
+}
+
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.emit(w.stream, string(w.pending[:i]))
+		w.pending = w.pending[i+1:]
+	}
+	for w.max > 0 && int64(len(w.pending)) >= w.max {
+		w.emit(w.stream, string(w.pending[:w.max]))
+		w.pending = w.pending[w.max:]
+	}
+	return len(p), nil
+}
 
-		return result, nil
+// Flush emits any buffered partial line.
+func (w *lineWriter) Flush() {
+	if len(w.pending) > 0 {
+		w.emit(w.stream, string(w.pending))
+		w.pending = nil
 	}
 }
@@ -0,0 +1,82 @@
+package local
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestExecuteCommandCustomShell(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not available")
+	}
+
+	cmd := &LocalCommand{
+		Command: `arr=(alpha beta); if [[ ${#arr[@]} -eq 2 ]]; then echo "${arr[1]}"; fi`,
+		Shell:   []string{"bash", "-c"},
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.ExitCode != 0 || result.Stdout != "beta\n" {
+		t.Errorf("Expected bash output 'beta', got exit=%d stdout=%q stderr=%q", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecuteCommandMissingShell(t *testing.T) {
+	cmd := &LocalCommand{
+		Command: "echo hello",
+		Shell:   []string{"/nonexistent/shell", "-c"},
+	}
+
+	_, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "/nonexistent/shell") {
+		t.Errorf("Expected error naming the missing shell, got %v", err)
+	}
+}
+
+func TestExecuteCommandMissingWorkDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	cmd := &LocalCommand{Command: "echo hello", WorkDir: dir}
+
+	_, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "does not exist") {
+		t.Errorf("Expected a missing work_dir error, got %v", err)
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Errorf("Expected %s not to be created, stat returned %v", dir, err)
+	}
+}
+
+func TestExecuteCommandWorkDirIsFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err := NewLocalClient().ExecuteCommand(context.Background(), &LocalCommand{Command: "echo hello", WorkDir: file})
+	if err == nil || !strings.Contains(err.Error(), "not a directory") {
+		t.Errorf("Expected a not-a-directory error, got %v", err)
+	}
+}
+
+func TestExecuteCommandCreatesWorkDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "a", "b")
+	cmd := &LocalCommand{Command: "echo hello > out.txt", WorkDir: dir, CreateWorkDir: true}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Fatalf("Expected exit 0, got %d: %s", result.ExitCode, result.Stderr)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "out.txt")); err != nil {
+		t.Errorf("Expected the command to run in the created directory: %v", err)
+	}
+}
@@ -0,0 +1,274 @@
+//go:build !windows
+
+package local
+
+import (
+	"context"
+	"os"
+	"os/user"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExecuteCommandStreamsLines(t *testing.T) {
+	type streamed struct {
+		stream string
+		line   string
+		at     time.Time
+	}
+	var lines []streamed
+
+	cmd := &LocalCommand{
+		Command: "for i in 1 2 3; do echo line$i; sleep 1; done; echo oops >&2",
+		StreamFunc: func(stream string, line string) {
+			lines = append(lines, streamed{stream, line, time.Now()})
+		},
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	finished := time.Now()
+
+	expected := []streamed{{stream: "stdout", line: "line1"}, {stream: "stdout", line: "line2"}, {stream: "stdout", line: "line3"}, {stream: "stderr", line: "oops"}}
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d streamed lines, got %d: %+v", len(expected), len(lines), lines)
+	}
+	for i, want := range expected {
+		if lines[i].stream != want.stream || lines[i].line != want.line {
+			t.Errorf("Line %d: expected %s %q, got %s %q", i, want.stream, want.line, lines[i].stream, lines[i].line)
+		}
+	}
+
+	// The first line must arrive well before the command finishes
+	if finished.Sub(lines[0].at) < 2*time.Second {
+		t.Errorf("First line was not streamed incrementally (arrived %s before exit)", finished.Sub(lines[0].at))
+	}
+
+	if result.Stdout != "line1\nline2\nline3\n" {
+		t.Errorf("Expected full stdout in result, got %q", result.Stdout)
+	}
+}
+
+func TestExecuteCommandStreamsOutputWithoutNewlines(t *testing.T) {
+	var longest, total int
+	cmd := &LocalCommand{
+		Command:        "head -c 1048576 /dev/zero | tr '\\0' a",
+		MaxOutputBytes: 4096,
+		StreamFunc: func(stream string, line string) {
+			longest = max(longest, len(line))
+			total += len(line)
+		},
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if longest > 4096 {
+		t.Errorf("Expected streamed pieces of at most max_output_bytes, got %d bytes", longest)
+	}
+	if total != 1048576 {
+		t.Errorf("Expected all %d bytes to be streamed, got %d", 1048576, total)
+	}
+	if !result.Truncated {
+		t.Error("Expected the captured stdout to be truncated")
+	}
+}
+
+func TestExecuteCommandTruncatesOutput(t *testing.T) {
+	cmd := &LocalCommand{
+		Command:        "yes | head -c 100000; echo err >&2",
+		MaxOutputBytes: 1000,
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+
+	if !result.Truncated {
+		t.Error("Expected result to be marked as truncated")
+	}
+	expected := strings.Repeat("y\n", 500) + "...[truncated 99000 bytes]"
+	if result.Stdout != expected {
+		t.Errorf("Expected 1000 captured bytes and a truncation marker, got %d bytes ending in %q",
+			len(result.Stdout), result.Stdout[len(result.Stdout)-40:])
+	}
+	if result.Stderr != "err\n" {
+		t.Errorf("Expected stderr under the cap to be kept intact, got %q", result.Stderr)
+	}
+}
+
+func TestExecuteCommandStdin(t *testing.T) {
+	cmd := &LocalCommand{
+		Command: "cat",
+		Stdin:   "line one\nline two\n",
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.ExitCode != 0 || result.Stdout != "line one\nline two\n" {
+		t.Errorf("Expected stdin echoed by cat, got exit=%d stdout=%q stderr=%q", result.ExitCode, result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecuteCommandCombinedOutput(t *testing.T) {
+	cmd := &LocalCommand{
+		Command:       "echo out1; echo err1 >&2; echo out2; echo err2 >&2",
+		CombineOutput: true,
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Combined != "out1\nerr1\nout2\nerr2\n" {
+		t.Errorf("Expected interleaved output, got %q", result.Combined)
+	}
+	if result.Stdout != "" || result.Stderr != "" {
+		t.Errorf("Expected separate streams to be empty, got stdout=%q stderr=%q", result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecuteCommandRunAsUser(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("requires root")
+	}
+	nobody, err := user.Lookup("nobody")
+	if err != nil {
+		t.Skip("user nobody not available")
+	}
+
+	cmd := &LocalCommand{
+		Command:   "id -u; id -g",
+		RunAsUser: "nobody",
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	expected := nobody.Uid + "\n" + nobody.Gid + "\n"
+	if result.Stdout != expected {
+		t.Errorf("Expected command to run as uid/gid %q, got %q (stderr %q)", expected, result.Stdout, result.Stderr)
+	}
+}
+
+func TestExecuteCommandRunAsUnknownUser(t *testing.T) {
+	cmd := &LocalCommand{
+		Command:   "id -u",
+		RunAsUser: "no-such-user-edge-agent",
+	}
+
+	_, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "no-such-user-edge-agent") {
+		t.Errorf("Expected error naming the unknown user, got %v", err)
+	}
+}
+
+func TestExecuteCommandReportsDurationMs(t *testing.T) {
+	start := time.Now()
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), &LocalCommand{Command: "sleep 0.2"})
+	elapsed := time.Since(start)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+
+	if result.DurationMs < 200 || result.DurationMs > elapsed.Milliseconds() {
+		t.Errorf("Expected duration_ms between 200 and %d, got %d", elapsed.Milliseconds(), result.DurationMs)
+	}
+	if parsed, err := time.ParseDuration(result.Duration); err != nil || parsed.Milliseconds() != result.DurationMs {
+		t.Errorf("Expected duration %q to match duration_ms %d", result.Duration, result.DurationMs)
+	}
+}
+
+func TestExecuteCommandReportsTimeoutKill(t *testing.T) {
+	cmd := &LocalCommand{Command: "echo started; sleep 5", Timeout: 200 * time.Millisecond}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected a timeout error, got %v", err)
+	}
+	if result == nil {
+		t.Fatal("Expected a result for the killed command")
+	}
+	if !result.TimedOut || result.Cancelled || result.Signal != "SIGKILL" || result.ExitCode != -1 {
+		t.Errorf("Expected a SIGKILL timeout, got %+v", result)
+	}
+	if result.Stdout != "started\n" {
+		t.Errorf("Expected the output printed before the kill, got %q", result.Stdout)
+	}
+}
+
+func TestExecuteCommandReportsCancelKill(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(200*time.Millisecond, cancel)
+
+	result, err := NewLocalClient().ExecuteCommand(ctx, &LocalCommand{Command: "sleep 5"})
+	if err == nil || !strings.Contains(err.Error(), "cancelled") {
+		t.Fatalf("Expected a cancellation error, got %v", err)
+	}
+	if result == nil || !result.Cancelled || result.TimedOut || result.Signal != "SIGKILL" {
+		t.Errorf("Expected a SIGKILL cancellation, got %+v", result)
+	}
+}
+
+func TestExecuteCommandDistinguishesSignalFromExitCode(t *testing.T) {
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), &LocalCommand{Command: "exit 3"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.ExitCode != 3 || result.Signal != "" || result.TimedOut || result.Cancelled {
+		t.Errorf("Expected a plain exit code 3, got %+v", result)
+	}
+
+	result, err = NewLocalClient().ExecuteCommand(context.Background(), &LocalCommand{Command: "kill -TERM $$"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Signal != "SIGTERM" || result.TimedOut || result.Cancelled {
+		t.Errorf("Expected the command to report SIGTERM, got %+v", result)
+	}
+}
+
+func TestExecuteCommandCleanEnv(t *testing.T) {
+	t.Setenv("EDGE_AGENT_TEST_PARENT", "inherited")
+	inherit := false
+	cmd := &LocalCommand{
+		Command:    `echo "[$EDGE_AGENT_TEST_PARENT][$EXTRA]"; command -v ls >/dev/null`,
+		Env:        map[string]string{"EXTRA": "given"},
+		InheritEnv: &inherit,
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Stdout != "[][given]\n" {
+		t.Errorf("Expected only the given variables, got %q", result.Stdout)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("Expected ls to be found on the minimal PATH, exit %d: %s", result.ExitCode, result.Stderr)
+	}
+}
+
+func TestExecuteCommandInheritsEnvByDefault(t *testing.T) {
+	t.Setenv("EDGE_AGENT_TEST_PARENT", "inherited")
+	cmd := &LocalCommand{
+		Command: `echo "[$EDGE_AGENT_TEST_PARENT][$EXTRA]"`,
+		Env:     map[string]string{"EXTRA": "given"},
+	}
+
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.Stdout != "[inherited][given]\n" {
+		t.Errorf("Expected the agent's environment plus the given variables, got %q", result.Stdout)
+	}
+}
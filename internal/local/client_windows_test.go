@@ -0,0 +1,22 @@
+//go:build windows
+
+package local
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestExecuteCommandWindowsEcho(t *testing.T) {
+	result, err := NewLocalClient().ExecuteCommand(context.Background(), &LocalCommand{Command: "echo hello"})
+	if err != nil {
+		t.Fatalf("ExecuteCommand failed: %v", err)
+	}
+	if result.ExitCode != 0 {
+		t.Errorf("Expected exit code 0, got %d (stderr: %q)", result.ExitCode, result.Stderr)
+	}
+	if strings.TrimSpace(result.Stdout) != "hello" {
+		t.Errorf("Expected stdout 'hello', got %q", result.Stdout)
+	}
+}
@@ -0,0 +1,119 @@
+package local
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Policy decides which commands local_command may run. A command must match
+// an entry of the allowlist (when it is not empty) and no deny pattern.
+type Policy struct {
+	allowed    []*regexp.Regexp
+	denied     []*regexp.Regexp
+	allowedEnv map[string]bool
+	err        error
+}
+
+// NewPolicy compiles the allow and deny lists. Allowlist entries match the
+// whole command, exactly or as a regular expression, so an allowed command
+// cannot be chained with another under sh -c; deny patterns match anywhere
+// in the command. If a pattern is invalid the returned policy denies every
+// command and the error is also returned.
+func NewPolicy(allowed, denied []string) (*Policy, error) {
+	p := &Policy{}
+	for _, entry := range allowed {
+		re, err := regexp.Compile("^(?:" + entry + ")$")
+		if err != nil {
+			p.err = fmt.Errorf("invalid allowed_commands entry %q: %w", entry, err)
+			return p, p.err
+		}
+		p.allowed = append(p.allowed, re)
+	}
+	for _, pattern := range denied {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			p.err = fmt.Errorf("invalid denied_patterns entry %q: %w", pattern, err)
+			return p, p.err
+		}
+		p.denied = append(p.denied, re)
+	}
+	return p, nil
+}
+
+// Permit returns an error describing why command may not run, or nil.
+func (p *Policy) Permit(command string) error {
+	if p == nil {
+		return nil
+	}
+	if p.err != nil {
+		return fmt.Errorf("command not permitted: %v", p.err)
+	}
+	for _, re := range p.denied {
+		if re.MatchString(command) {
+			return fmt.Errorf("command not permitted: matches denied pattern %q", re.String())
+		}
+	}
+	if len(p.allowed) == 0 {
+		return nil
+	}
+	for _, re := range p.allowed {
+		if re.MatchString(command) {
+			return nil
+		}
+	}
+	return fmt.Errorf("command not permitted: not in allowed_commands")
+}
+
+// envName is the portable syntax of an environment variable name.
+var envName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// protectedEnv reports whether name controls which programs or libraries a
+// command loads, so that setting it could run code the command never meant
+// to. Names are compared case-insensitively as on Windows.
+func protectedEnv(name string) bool {
+	upper := strings.ToUpper(name)
+	switch upper {
+	case "PATH", "IFS", "ENV", "BASH_ENV":
+		return true
+	}
+	return strings.HasPrefix(upper, "LD_") || strings.HasPrefix(upper, "DYLD_")
+}
+
+// AllowEnv restricts the environment variables a command may set to names.
+// With no names any validly named variable is accepted except protected
+// ones such as PATH and LD_PRELOAD, which must be listed to be set.
+func (p *Policy) AllowEnv(names []string) {
+	p.allowedEnv = make(map[string]bool, len(names))
+	for _, name := range names {
+		p.allowedEnv[name] = true
+	}
+}
+
+// PermitEnv returns an error naming the first variable of env that may not
+// be set, or nil.
+func (p *Policy) PermitEnv(env map[string]string) error {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !envName.MatchString(name) {
+			return fmt.Errorf("environment variable %q not permitted: invalid name", name)
+		}
+		if strings.ContainsRune(env[name], 0) {
+			return fmt.Errorf("environment variable %s not permitted: value contains a NUL byte", name)
+		}
+		listed := p != nil && p.allowedEnv[name]
+		if p != nil && len(p.allowedEnv) > 0 && !listed {
+			return fmt.Errorf("environment variable %s not permitted: not in allowed_env", name)
+		}
+		if protectedEnv(name) && !listed {
+			return fmt.Errorf("environment variable %s not permitted: overriding it requires listing it in allowed_env", name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package local
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestPolicy(t *testing.T) {
+	policy, err := NewPolicy(
+		[]string{"uptime", `df -h`, `systemctl (status|restart) nginx`},
+		[]string{`rm\s+-rf`, `[;&|]`},
+	)
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		command string
+		allowed bool
+	}{
+		{"uptime", true},
+		{"df -h", true},
+		{"df -h /var", false}, // entries match the whole command
+		{"systemctl restart nginx", true},
+		{"systemctl stop nginx", false}, // not in the allowlist
+		{"reboot", false},               // not in the allowlist
+		{"df -h; rm -rf /", false},      // allowed prefix but denied pattern
+		{"uptime && reboot", false},     // allowed prefix but denied pattern
+	}
+	for _, tt := range tests {
+		err := policy.Permit(tt.command)
+		if tt.allowed && err != nil {
+			t.Errorf("Expected %q to be permitted, got %v", tt.command, err)
+		}
+		if !tt.allowed && (err == nil || !strings.Contains(err.Error(), "command not permitted")) {
+			t.Errorf("Expected %q to be denied, got %v", tt.command, err)
+		}
+	}
+}
+
+func TestPolicyAllowlistMatchesWholeCommand(t *testing.T) {
+	policy, err := NewPolicy([]string{"uptime", `systemctl status \w+`}, nil)
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	for _, command := range []string{"uptime", "systemctl status nginx"} {
+		if err := policy.Permit(command); err != nil {
+			t.Errorf("Expected %q to be permitted, got %v", command, err)
+		}
+	}
+	for _, command := range []string{
+		"uptime; id",
+		"uptime && id",
+		"uptime | sh",
+		"uptime\nid",
+		"systemctl status nginx; reboot",
+	} {
+		if err := policy.Permit(command); err == nil {
+			t.Errorf("Expected %q to be denied", command)
+		}
+	}
+}
+
+func TestPolicyDenyOnly(t *testing.T) {
+	policy, err := NewPolicy(nil, []string{`^reboot\b`})
+	if err != nil {
+		t.Fatalf("NewPolicy failed: %v", err)
+	}
+
+	if err := policy.Permit("ls -la"); err != nil {
+		t.Errorf("Expected commands to be allowed without an allowlist, got %v", err)
+	}
+	if err := policy.Permit("reboot now"); err == nil {
+		t.Error("Expected reboot to be denied")
+	}
+}
+
+func TestPolicyInvalidPatternDeniesAll(t *testing.T) {
+	policy, err := NewPolicy(nil, []string{"("})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid pattern")
+	}
+	if err := policy.Permit("ls"); err == nil {
+		t.Error("Expected an invalid policy to deny every command")
+	}
+}
+
+func TestPolicyPermitEnv(t *testing.T) {
+	open, _ := NewPolicy(nil, nil)
+	listed, _ := NewPolicy(nil, nil)
+	listed.AllowEnv([]string{"LANG", "LD_LIBRARY_PATH"})
+
+	tests := []struct {
+		name    string
+		policy  *Policy
+		env     map[string]string
+		allowed bool
+	}{
+		{"plain variable", open, map[string]string{"APP_MODE": "debug"}, true},
+		{"LD_PRELOAD", open, map[string]string{"LD_PRELOAD": "/tmp/evil.so"}, false},
+		{"PATH", open, map[string]string{"PATH": "/tmp"}, false},
+		{"lowercase path", open, map[string]string{"Path": "/tmp"}, false},
+		{"invalid name", open, map[string]string{"BAD-NAME": "x"}, false},
+		{"name with equals", open, map[string]string{"A=B": "x"}, false},
+		{"leading digit", open, map[string]string{"1ABC": "x"}, false},
+		{"NUL in value", open, map[string]string{"APP_MODE": "a\x00b"}, false},
+		{"listed variable", listed, map[string]string{"LANG": "C"}, true},
+		{"unlisted variable", listed, map[string]string{"APP_MODE": "debug"}, false},
+		{"listed protected variable", listed, map[string]string{"LD_LIBRARY_PATH": "/opt/lib"}, true},
+		{"unlisted protected variable", listed, map[string]string{"LD_PRELOAD": "/tmp/evil.so"}, false},
+	}
+	for _, tt := range tests {
+		err := tt.policy.PermitEnv(tt.env)
+		if tt.allowed && err != nil {
+			t.Errorf("%s: expected %v to be permitted, got %v", tt.name, tt.env, err)
+		}
+		if !tt.allowed && (err == nil || !strings.Contains(err.Error(), "not permitted")) {
+			t.Errorf("%s: expected %v to be refused, got %v", tt.name, tt.env, err)
+		}
+	}
+}
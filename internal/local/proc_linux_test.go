@@ -0,0 +1,80 @@
+//go:build linux
+
+package local
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// liveGroupMembers returns the PIDs of non-zombie processes in group pgid.
+func liveGroupMembers(t *testing.T, pgid int) []int {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		t.Fatalf("Failed to read /proc: %v", err)
+	}
+
+	var pids []int
+	for _, e := range entries {
+		pid, err := strconv.Atoi(e.Name())
+		if err != nil {
+			continue
+		}
+		stat, err := os.ReadFile(filepath.Join("/proc", e.Name(), "stat"))
+		if err != nil {
+			continue
+		}
+		// Fields after the parenthesised command name: state ppid pgrp ...
+		fields := strings.Fields(string(stat[strings.LastIndexByte(string(stat), ')')+1:]))
+		if len(fields) < 3 || fields[0] == "Z" {
+			continue
+		}
+		if pgrp, _ := strconv.Atoi(fields[2]); pgrp == pgid {
+			pids = append(pids, pid)
+		}
+	}
+	return pids
+}
+
+func TestExecuteCommandTimeoutKillsProcessGroup(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "pid")
+
+	cmd := &LocalCommand{
+		Command: "echo $$ > " + pidFile + "; sleep 100 & sleep 100",
+		Timeout: 500 * time.Millisecond,
+	}
+
+	start := time.Now()
+	if _, err := NewLocalClient().ExecuteCommand(context.Background(), cmd); err == nil {
+		t.Fatal("Expected timeout error, got nil")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("ExecuteCommand took %s to return after timeout", elapsed)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("Failed to read shell pid: %v", err)
+	}
+	pgid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatalf("Invalid shell pid %q: %v", data, err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		pids := liveGroupMembers(t, pgid)
+		if len(pids) == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Processes still running in group %d: %v", pgid, pids)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+
+package local
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// setProcessGroup makes cmd the leader of a new process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// killProcessGroup kills cmd and every process in its group.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	if err := syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// minimalEnv is the environment of a command that does not inherit the
+// agent's.
+func minimalEnv() []string {
+	return []string{"PATH=/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"}
+}
+
+// exitSignal returns the name of the signal that ended the process, or ""
+// if it exited normally.
+func exitSignal(err *exec.ExitError) string {
+	status, ok := err.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return ""
+	}
+	if name := unix.SignalName(status.Signal()); name != "" {
+		return name
+	}
+	return status.Signal().String()
+}
+
+// setCredential makes cmd run as the given user and/or group. The group
+// defaults to the user's primary group; an empty user keeps the agent's uid.
+func setCredential(cmd *exec.Cmd, username, groupname string) error {
+	if username == "" && groupname == "" {
+		return nil
+	}
+
+	uid, gid := uint32(os.Getuid()), uint32(os.Getgid())
+	if username != "" {
+		u, err := user.Lookup(username)
+		if err != nil {
+			return fmt.Errorf("run_as_user %q: %w", username, err)
+		}
+		uid, gid, err = parseIDs(u.Uid, u.Gid)
+		if err != nil {
+			return fmt.Errorf("run_as_user %q: %w", username, err)
+		}
+	}
+	if groupname != "" {
+		g, err := user.LookupGroup(groupname)
+		if err != nil {
+			return fmt.Errorf("run_as_group %q: %w", groupname, err)
+		}
+		_, gid, err = parseIDs("0", g.Gid)
+		if err != nil {
+			return fmt.Errorf("run_as_group %q: %w", groupname, err)
+		}
+	}
+
+	// Only root can switch to another identity; fail clearly instead of
+	// with a bare EPERM from fork/exec
+	if os.Geteuid() != 0 && (uid != uint32(os.Getuid()) || gid != uint32(os.Getgid())) {
+		return fmt.Errorf("agent is not running as root and lacks permission to run commands as uid %d gid %d", uid, gid)
+	}
+
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uid, Gid: gid}
+	return nil
+}
+
+func parseIDs(uidStr, gidStr string) (uint32, uint32, error) {
+	uid, err := strconv.ParseUint(uidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid uid %q", uidStr)
+	}
+	gid, err := strconv.ParseUint(gidStr, 10, 32)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid gid %q", gidStr)
+	}
+	return uint32(uid), uint32(gid), nil
+}
@@ -0,0 +1,50 @@
+//go:build windows
+
+package local
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strconv"
+)
+
+// setProcessGroup is a no-op on Windows; process groups do not exist there
+// and killProcessGroup walks the process tree instead.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// killProcessGroup kills cmd together with its child processes.
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process == nil {
+		return
+	}
+	kill := exec.Command("taskkill", "/T", "/F", "/PID", strconv.Itoa(cmd.Process.Pid))
+	if err := kill.Run(); err != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// minimalEnv is the environment of a command that does not inherit the
+// agent's. cmd.exe and most system tools need SystemRoot to start.
+func minimalEnv() []string {
+	root := os.Getenv("SystemRoot")
+	if root == "" {
+		root = `C:\Windows`
+	}
+	return []string{
+		"SystemRoot=" + root,
+		"PATH=" + root + `\System32;` + root,
+	}
+}
+
+// exitSignal always returns "" on Windows, where processes are not ended
+// by signals.
+func exitSignal(err *exec.ExitError) string { return "" }
+
+// setCredential is not supported on Windows.
+func setCredential(cmd *exec.Cmd, username, groupname string) error {
+	if username == "" && groupname == "" {
+		return nil
+	}
+	return errors.New("run_as_user and run_as_group are not supported on Windows")
+}
@@ -0,0 +1,291 @@
+package local
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// RemoteHost is an SSH server and the credentials to log in with. Key is
+// a PEM private key; Password is used when Key is empty. HostKey pins the
+// server's public key in authorized_keys format; without it the key is
+// checked against a known_hosts file.
+type RemoteHost struct {
+	Host     string `json:"host"` // host or host:port, port 22 by default
+	User     string `json:"user"`
+	Key      string `json:"-"`
+	Password string `json:"-"`
+	HostKey  string `json:"host_key,omitempty"`
+}
+
+// RemoteCommand runs Command on Target over SSH, optionally through the
+// Jump bastion host.
+type RemoteCommand struct {
+	Target  RemoteHost
+	Jump    *RemoteHost
+	Command string
+	Env     map[string]string
+	Stdin   string
+	Timeout time.Duration
+
+	// KnownHostsFile verifies host keys that are not pinned with HostKey.
+	KnownHostsFile string
+
+	// MaxOutputBytes caps how much of stdout and of stderr is captured.
+	// Defaults to DefaultMaxOutputBytes.
+	MaxOutputBytes int64
+}
+
+// RemotePlan is what ExecuteRemote would do, for dry runs.
+type RemotePlan struct {
+	Host    string   `json:"host"`
+	User    string   `json:"user"`
+	Jump    string   `json:"jump,omitempty"`
+	Command string   `json:"command"`
+	EnvKeys []string `json:"env_keys,omitempty"`
+	Timeout string   `json:"timeout"`
+}
+
+func withDefaultPort(host string) string {
+	if _, _, err := net.SplitHostPort(host); err == nil {
+		return host
+	}
+	return net.JoinHostPort(strings.Trim(host, "[]"), "22")
+}
+
+// clientConfig builds the SSH login for h.
+func (h RemoteHost) clientConfig(knownHostsFile string, timeout time.Duration) (*ssh.ClientConfig, error) {
+	if h.Host == "" || h.User == "" {
+		return nil, fmt.Errorf("remote host and user are required")
+	}
+
+	var auth []ssh.AuthMethod
+	switch {
+	case h.Key != "":
+		signer, err := ssh.ParsePrivateKey([]byte(h.Key))
+		if err != nil {
+			return nil, fmt.Errorf("invalid key for %s: %w", h.Host, err)
+		}
+		auth = append(auth, ssh.PublicKeys(signer))
+	case h.Password != "":
+		auth = append(auth, ssh.Password(h.Password))
+	default:
+		return nil, fmt.Errorf("key or password is required for %s", h.Host)
+	}
+
+	var hostKeyCallback ssh.HostKeyCallback
+	switch {
+	case h.HostKey != "":
+		key, _, _, _, err := ssh.ParseAuthorizedKey([]byte(h.HostKey))
+		if err != nil {
+			return nil, fmt.Errorf("invalid host_key for %s: %w", h.Host, err)
+		}
+		hostKeyCallback = ssh.FixedHostKey(key)
+	case knownHostsFile != "":
+		callback, err := knownhosts.New(knownHostsFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load known_hosts: %w", err)
+		}
+		hostKeyCallback = callback
+	default:
+		return nil, fmt.Errorf("cannot verify %s: set host_key or local.remote.known_hosts_file", h.Host)
+	}
+
+	return &ssh.ClientConfig{
+		User:            h.User,
+		Auth:            auth,
+		HostKeyCallback: hostKeyCallback,
+		Timeout:         timeout,
+	}, nil
+}
+
+// dialSSH opens a connection to h with dial and logs in.
+func dialSSH(ctx context.Context, h RemoteHost, config *ssh.ClientConfig, dial func(ctx context.Context, network, address string) (net.Conn, error)) (*ssh.Client, error) {
+	address := withDefaultPort(h.Host)
+	conn, err := dial(ctx, "tcp", address)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", address, err)
+	}
+
+	// NewClientConn has no context; closing the connection unblocks it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	defer stop()
+
+	c, chans, reqs, err := ssh.NewClientConn(conn, address, config)
+	if err != nil {
+		conn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, fmt.Errorf("SSH login to %s failed: %w", address, err)
+	}
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// connect logs in to cmd.Target, through cmd.Jump when set. The returned
+// close function closes every connection that was opened.
+func (cmd *RemoteCommand) connect(ctx context.Context) (*ssh.Client, func(), error) {
+	targetConfig, err := cmd.Target.clientConfig(cmd.KnownHostsFile, cmd.Timeout)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var dialer net.Dialer
+	if cmd.Jump == nil {
+		client, err := dialSSH(ctx, cmd.Target, targetConfig, dialer.DialContext)
+		if err != nil {
+			return nil, nil, err
+		}
+		return client, func() { client.Close() }, nil
+	}
+
+	jumpConfig, err := cmd.Jump.clientConfig(cmd.KnownHostsFile, cmd.Timeout)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jump host: %w", err)
+	}
+	jump, err := dialSSH(ctx, *cmd.Jump, jumpConfig, dialer.DialContext)
+	if err != nil {
+		return nil, nil, fmt.Errorf("jump host: %w", err)
+	}
+	client, err := dialSSH(ctx, cmd.Target, targetConfig, jump.DialContext)
+	if err != nil {
+		jump.Close()
+		return nil, nil, err
+	}
+	return client, func() { client.Close(); jump.Close() }, nil
+}
+
+// ExecuteRemote runs cmd on a remote host over SSH and reports it in the
+// same shape as a local command. Like ExecuteCommand, a command stopped
+// by its timeout or ctx returns both its partial result and an error.
+func (c *LocalClient) ExecuteRemote(ctx context.Context, cmd *RemoteCommand) (*LocalResult, error) {
+	if cmd.Timeout == 0 {
+		cmd.Timeout = 30 * time.Second
+	}
+	if cmd.MaxOutputBytes <= 0 {
+		cmd.MaxOutputBytes = DefaultMaxOutputBytes
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, cmd.Timeout)
+	defer cancel()
+
+	start := time.Now()
+	client, closeAll, err := cmd.connect(ctx)
+	if err != nil {
+		if errors.Is(err, context.DeadlineExceeded) {
+			return nil, fmt.Errorf("command timed out")
+		}
+		if errors.Is(err, context.Canceled) {
+			return nil, fmt.Errorf("command cancelled")
+		}
+		return nil, err
+	}
+	defer closeAll()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open SSH session: %w", err)
+	}
+	defer session.Close()
+
+	for key, value := range cmd.Env {
+		if err := session.Setenv(key, value); err != nil {
+			return nil, fmt.Errorf("remote host refused environment variable %s (check AcceptEnv): %w", key, err)
+		}
+	}
+	stdout := &limitedBuffer{max: cmd.MaxOutputBytes}
+	stderr := &limitedBuffer{max: cmd.MaxOutputBytes}
+	session.Stdout = stdout
+	session.Stderr = stderr
+	if cmd.Stdin != "" {
+		session.Stdin = strings.NewReader(cmd.Stdin)
+	}
+
+	if err := session.Start(cmd.Command); err != nil {
+		return nil, fmt.Errorf("failed to start remote command: %w", err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- session.Wait()
+	}()
+
+	var killed error
+	select {
+	case <-ctx.Done():
+		// Not every server honours signals; closing the connection ends
+		// the session regardless
+		session.Signal(ssh.SIGKILL)
+		closeAll()
+		err = <-done
+		killed = ctx.Err()
+	case err = <-done:
+	}
+	duration := time.Since(start)
+
+	result := &LocalResult{
+		Stdout:     stdout.String(),
+		Stderr:     stderr.String(),
+		Duration:   duration.String(),
+		DurationMs: duration.Milliseconds(),
+		Truncated:  stdout.dropped > 0 || stderr.dropped > 0,
+	}
+
+	var exitError *ssh.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = 0
+	case errors.As(err, &exitError):
+		result.ExitCode = exitError.ExitStatus()
+		if exitError.Signal() != "" {
+			result.ExitCode = -1
+			result.Signal = "SIG" + exitError.Signal()
+		}
+	default:
+		result.ExitCode = -1
+		if killed == nil {
+			result.Stderr += fmt.Sprintf("\nExecution error: %v", err)
+		}
+	}
+
+	switch {
+	case killed == context.DeadlineExceeded:
+		result.TimedOut = true
+		return result, fmt.Errorf("command timed out")
+	case killed != nil:
+		result.Cancelled = true
+		return result, fmt.Errorf("command cancelled")
+	}
+	return result, nil
+}
+
+// PlanRemote validates cmd as ExecuteRemote would, without connecting.
+func (c *LocalClient) PlanRemote(cmd *RemoteCommand) (*RemotePlan, error) {
+	timeout := cmd.Timeout
+	if timeout == 0 {
+		timeout = 30 * time.Second
+	}
+	if _, err := cmd.Target.clientConfig(cmd.KnownHostsFile, timeout); err != nil {
+		return nil, err
+	}
+	plan := &RemotePlan{
+		Host:    withDefaultPort(cmd.Target.Host),
+		User:    cmd.Target.User,
+		Command: cmd.Command,
+		EnvKeys: sortedKeys(cmd.Env),
+		Timeout: timeout.String(),
+	}
+	if cmd.Jump != nil {
+		if _, err := cmd.Jump.clientConfig(cmd.KnownHostsFile, timeout); err != nil {
+			return nil, fmt.Errorf("jump host: %w", err)
+		}
+		plan.Jump = cmd.Jump.User + "@" + withDefaultPort(cmd.Jump.Host)
+	}
+	return plan, nil
+}
@@ -0,0 +1,316 @@
+package local
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// sshServer is an in-process SSH server that understands a few commands:
+// "echo <text>", "env <name>", "fail" (exit 3) and "sleep" (blocks until
+// signalled or disconnected). It also forwards direct-tcpip channels, so
+// it can act as a jump host.
+type sshServer struct {
+	addr      string
+	hostKey   string // authorized_keys line
+	forwarded atomic.Int32
+}
+
+func newSSHServer(t *testing.T, clientKey ssh.PublicKey) *sshServer {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, pass []byte) (*ssh.Permissions, error) {
+			if c.User() == "admin" && string(pass) == "secret" {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("password rejected")
+		},
+		PublicKeyCallback: func(c ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if clientKey != nil && string(key.Marshal()) == string(clientKey.Marshal()) {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("key rejected")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &sshServer{
+		addr:    listener.Addr().String(),
+		hostKey: string(ssh.MarshalAuthorizedKey(hostSigner.PublicKey())),
+	}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn, config)
+		}
+	}()
+	return s
+}
+
+func (s *sshServer) serve(conn net.Conn, config *ssh.ServerConfig) {
+	sconn, chans, reqs, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	defer sconn.Close()
+	go ssh.DiscardRequests(reqs)
+
+	for newChannel := range chans {
+		switch newChannel.ChannelType() {
+		case "session":
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				continue
+			}
+			go s.session(channel, requests)
+		case "direct-tcpip":
+			var target struct {
+				Host     string
+				Port     uint32
+				OrigHost string
+				OrigPort uint32
+			}
+			if err := ssh.Unmarshal(newChannel.ExtraData(), &target); err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			upstream, err := net.Dial("tcp", net.JoinHostPort(target.Host, fmt.Sprint(target.Port)))
+			if err != nil {
+				newChannel.Reject(ssh.ConnectionFailed, err.Error())
+				continue
+			}
+			channel, requests, err := newChannel.Accept()
+			if err != nil {
+				upstream.Close()
+				continue
+			}
+			s.forwarded.Add(1)
+			go ssh.DiscardRequests(requests)
+			go func() {
+				io.Copy(channel, upstream)
+				channel.Close()
+			}()
+			go func() {
+				io.Copy(upstream, channel)
+				upstream.Close()
+			}()
+		default:
+			newChannel.Reject(ssh.UnknownChannelType, "unsupported")
+		}
+	}
+}
+
+func (s *sshServer) session(channel ssh.Channel, requests <-chan *ssh.Request) {
+	defer channel.Close()
+	env := map[string]string{}
+	signals := make(chan string, 1)
+
+	for req := range requests {
+		switch req.Type {
+		case "env":
+			var kv struct{ Name, Value string }
+			ssh.Unmarshal(req.Payload, &kv)
+			env[kv.Name] = kv.Value
+			req.Reply(true, nil)
+		case "signal":
+			var sig struct{ Signal string }
+			ssh.Unmarshal(req.Payload, &sig)
+			signals <- sig.Signal
+		case "exec":
+			var exec struct{ Command string }
+			ssh.Unmarshal(req.Payload, &exec)
+			req.Reply(true, nil)
+			go func() {
+				for req := range requests {
+					if req.Type == "signal" {
+						var sig struct{ Signal string }
+						ssh.Unmarshal(req.Payload, &sig)
+						signals <- sig.Signal
+					}
+				}
+			}()
+			s.run(channel, exec.Command, env, signals)
+			return
+		default:
+			req.Reply(false, nil)
+		}
+	}
+}
+
+func (s *sshServer) run(channel ssh.Channel, command string, env map[string]string, signals <-chan string) {
+	exit := func(status uint32) {
+		channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{status}))
+	}
+	name, arg, _ := strings.Cut(command, " ")
+	switch name {
+	case "echo":
+		fmt.Fprintln(channel, arg)
+		exit(0)
+	case "env":
+		fmt.Fprintln(channel, env[arg])
+		exit(0)
+	case "fail":
+		fmt.Fprintln(channel.Stderr(), "boom")
+		exit(3)
+	case "sleep":
+		select {
+		case sig := <-signals:
+			channel.SendRequest("exit-signal", false, ssh.Marshal(struct {
+				Signal     string
+				CoreDumped bool
+				Error      string
+				Lang       string
+			}{Signal: sig}))
+		case <-time.After(5 * time.Second):
+			exit(0)
+		}
+	default:
+		exit(127)
+	}
+}
+
+func newClientKey(t *testing.T) (string, ssh.PublicKey) {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(block)), signer.PublicKey()
+}
+
+func TestExecuteRemoteWithPassword(t *testing.T) {
+	server := newSSHServer(t, nil)
+	cmd := &RemoteCommand{
+		Target:  RemoteHost{Host: server.addr, User: "admin", Password: "secret", HostKey: server.hostKey},
+		Command: "echo hello",
+	}
+
+	result, err := NewLocalClient().ExecuteRemote(context.Background(), cmd)
+	if err != nil {
+		t.Fatalf("ExecuteRemote failed: %v", err)
+	}
+	if result.ExitCode != 0 || result.Stdout != "hello\n" {
+		t.Errorf("Expected 'hello' with exit 0, got %+v", result)
+	}
+}
+
+func TestExecuteRemoteWithKeyReportsExitCodeAndEnv(t *testing.T) {
+	key, public := newClientKey(t)
+	server := newSSHServer(t, public)
+	target := RemoteHost{Host: server.addr, User: "deploy", Key: key, HostKey: server.hostKey}
+
+	result, err := NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{Target: target, Command: "fail"})
+	if err != nil {
+		t.Fatalf("ExecuteRemote failed: %v", err)
+	}
+	if result.ExitCode != 3 || result.Stderr != "boom\n" {
+		t.Errorf("Expected exit 3 with stderr 'boom', got %+v", result)
+	}
+
+	result, err = NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{
+		Target:  target,
+		Command: "env GREETING",
+		Env:     map[string]string{"GREETING": "hi"},
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRemote failed: %v", err)
+	}
+	if result.Stdout != "hi\n" {
+		t.Errorf("Expected the environment to reach the remote command, got %q", result.Stdout)
+	}
+}
+
+func TestExecuteRemoteThroughJumpHost(t *testing.T) {
+	key, public := newClientKey(t)
+	target := newSSHServer(t, public)
+	bastion := newSSHServer(t, public)
+
+	result, err := NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{
+		Target:  RemoteHost{Host: target.addr, User: "deploy", Key: key, HostKey: target.hostKey},
+		Jump:    &RemoteHost{Host: bastion.addr, User: "jump", Key: key, HostKey: bastion.hostKey},
+		Command: "echo via bastion",
+	})
+	if err != nil {
+		t.Fatalf("ExecuteRemote failed: %v", err)
+	}
+	if result.Stdout != "via bastion\n" {
+		t.Errorf("Expected output from the target, got %+v", result)
+	}
+	if got := bastion.forwarded.Load(); got != 1 {
+		t.Errorf("Expected the connection to go through the bastion, it forwarded %d", got)
+	}
+}
+
+func TestExecuteRemoteVerifiesHostKey(t *testing.T) {
+	server := newSSHServer(t, nil)
+	other := newSSHServer(t, nil)
+
+	_, err := NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{
+		Target:  RemoteHost{Host: server.addr, User: "admin", Password: "secret", HostKey: other.hostKey},
+		Command: "echo hello",
+	})
+	if err == nil || !strings.Contains(err.Error(), "host key") {
+		t.Errorf("Expected a host key mismatch, got %v", err)
+	}
+
+	_, err = NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{
+		Target:  RemoteHost{Host: server.addr, User: "admin", Password: "secret"},
+		Command: "echo hello",
+	})
+	if err == nil || !strings.Contains(err.Error(), "known_hosts") {
+		t.Errorf("Expected an unverifiable host to be refused, got %v", err)
+	}
+}
+
+func TestExecuteRemoteTimeout(t *testing.T) {
+	server := newSSHServer(t, nil)
+
+	result, err := NewLocalClient().ExecuteRemote(context.Background(), &RemoteCommand{
+		Target:  RemoteHost{Host: server.addr, User: "admin", Password: "secret", HostKey: server.hostKey},
+		Command: "sleep",
+		Timeout: 300 * time.Millisecond,
+	})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+	if result == nil || !result.TimedOut || result.ExitCode != -1 {
+		t.Errorf("Expected a timed out result, got %+v", result)
+	}
+}
@@ -0,0 +1,102 @@
+package logging
+
+import (
+	"edge-agent/internal/config"
+	"fmt"
+	"io"
+	"log"
+	"log/slog"
+	"os"
+)
+
+// Formats accepted by logging.format.
+const (
+	FormatText = "text"
+	FormatJSON = "json"
+)
+
+// ParseLevel converts a logging.level name (debug, info, warn or error) to
+// a slog level. An empty name means info.
+func ParseLevel(name string) (slog.Level, error) {
+	if name == "" {
+		return slog.LevelInfo, nil
+	}
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(name)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("unknown log level %q: must be one of debug, info, warn, error", name)
+	}
+	return level, nil
+}
+
+// level is shared by every handler Configure installs, so SetLevel takes
+// effect on the running logger.
+var level slog.LevelVar
+
+// SetLevel changes the minimum level of the running logger.
+func SetLevel(name string) error {
+	parsed, err := ParseLevel(name)
+	if err != nil {
+		return err
+	}
+	level.Set(parsed)
+	return nil
+}
+
+// Configure routes all logging to w in the given format, dropping records
+// below level. Text keeps the standard log line layout with the level
+// added; JSON emits one object per record with time, level, msg and any
+// attributes. Plain log.Printf calls are treated as info.
+func Configure(w io.Writer, format string, minLevel slog.Level) error {
+	var handler slog.Handler
+	switch format {
+	case "", FormatText:
+		handler = newTextHandler(w, &level)
+	case FormatJSON:
+		handler = slog.NewJSONHandler(w, &slog.HandlerOptions{Level: &level})
+	default:
+		return fmt.Errorf("unknown log format %q: must be text or json", format)
+	}
+
+	// With Lshortfile set, slog records the caller of log.Printf so the
+	// text handler can keep reporting file:line
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	level.Set(minLevel)
+	slog.SetDefault(slog.New(traceHandler{handler}))
+	return nil
+}
+
+// Setup applies the logging config: console output, plus the log file if
+// one is configured and writable.
+func Setup(cfg config.Logging) {
+	var w io.Writer = os.Stderr
+
+	// If log file is specified, create both console and file logging
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0666)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error opening log file %s: %v\n", cfg.File, err)
+			fmt.Fprintf(os.Stderr, "Continuing with console logging only...\n")
+		} else if _, err := file.WriteString(""); err != nil {
+			// Test write to ensure file is writable
+			fmt.Fprintf(os.Stderr, "Error writing to log file %s: %v\n", cfg.File, err)
+			file.Close()
+			fmt.Fprintf(os.Stderr, "Continuing with console logging only...\n")
+		} else {
+			w = io.MultiWriter(os.Stdout, file)
+			fmt.Fprintf(os.Stderr, "Logging enabled: console + file (%s)\n", cfg.File)
+		}
+	}
+
+	SetSensitiveKeys(cfg.RedactKeys)
+	SetTraceMessages(cfg.TraceMessages)
+
+	minLevel, err := ParseLevel(cfg.Level)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v, using info\n", err)
+	}
+	if err := Configure(w, cfg.Format, minLevel); err != nil {
+		fmt.Fprintf(os.Stderr, "%v, using text\n", err)
+		Configure(w, FormatText, minLevel)
+	}
+	slog.Debug("Logging configured", "format", cfg.Format, "level", minLevel.String())
+}
@@ -0,0 +1,182 @@
+package logging
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"os"
+	"strings"
+	"testing"
+)
+
+// restoreDefaults undoes Configure so other tests keep the standard logger.
+func restoreDefaults(t *testing.T) {
+	prev := slog.Default()
+	flags := log.Flags()
+	prevLevel := level.Level()
+	t.Cleanup(func() {
+		level.Set(prevLevel)
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(flags)
+	})
+}
+
+func TestJSONFormatEmitsParseableRecords(t *testing.T) {
+	restoreDefaults(t)
+	var buf bytes.Buffer
+	if err := Configure(&buf, FormatJSON, slog.LevelInfo); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	slog.Info("Processing command", "command_id", "cmd-1", "protocol", "websocket", "url", "ws://server")
+	log.Printf("plain %s", "message")
+
+	lines := bufio.NewScanner(&buf)
+	var records []map[string]interface{}
+	for lines.Scan() {
+		var record map[string]interface{}
+		if err := json.Unmarshal(lines.Bytes(), &record); err != nil {
+			t.Fatalf("Log line is not JSON: %q: %v", lines.Text(), err)
+		}
+		records = append(records, record)
+	}
+	if len(records) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(records))
+	}
+
+	first := records[0]
+	for _, key := range []string{"time", "level", "msg"} {
+		if _, ok := first[key]; !ok {
+			t.Errorf("Expected %q in record %v", key, first)
+		}
+	}
+	if first["level"] != "INFO" || first["msg"] != "Processing command" {
+		t.Errorf("Unexpected level or message: %v", first)
+	}
+	if first["command_id"] != "cmd-1" || first["protocol"] != "websocket" || first["url"] != "ws://server" {
+		t.Errorf("Expected contextual fields, got %v", first)
+	}
+	if records[1]["msg"] != "plain message" {
+		t.Errorf("Expected log.Printf to be captured as a record, got %v", records[1])
+	}
+}
+
+func TestTextFormatKeepsLogLines(t *testing.T) {
+	restoreDefaults(t)
+	var buf bytes.Buffer
+	if err := Configure(&buf, FormatText, slog.LevelInfo); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	log.Printf("plain message")
+	slog.Info("Processing command", "command_id", "cmd-1", "url", "ws://server")
+
+	out := buf.String()
+	if !strings.Contains(out, "logging_test.go:") || !strings.Contains(out, "INFO plain message\n") {
+		t.Errorf("Expected a text log line with source and level, got %q", out)
+	}
+	if !strings.Contains(out, "INFO Processing command command_id=cmd-1 url=ws://server\n") {
+		t.Errorf("Expected attributes appended to the text line, got %q", out)
+	}
+}
+
+func TestLevelFiltersLowerRecords(t *testing.T) {
+	for _, format := range []string{FormatText, FormatJSON} {
+		t.Run(format, func(t *testing.T) {
+			restoreDefaults(t)
+			level, err := ParseLevel("warn")
+			if err != nil {
+				t.Fatalf("ParseLevel failed: %v", err)
+			}
+			var buf bytes.Buffer
+			if err := Configure(&buf, format, level); err != nil {
+				t.Fatalf("Configure failed: %v", err)
+			}
+
+			slog.Debug("Sending message", "data", "{}")
+			slog.Info("Processing command", "command_id", "cmd-1")
+			log.Printf("plain info message")
+			slog.Warn("Connection lost")
+			slog.Error("Reconnection failed")
+
+			out := buf.String()
+			for _, hidden := range []string{"Sending message", "Processing command", "plain info message"} {
+				if strings.Contains(out, hidden) {
+					t.Errorf("Expected %q to be filtered at warn, got %q", hidden, out)
+				}
+			}
+			for _, shown := range []string{"Connection lost", "Reconnection failed"} {
+				if !strings.Contains(out, shown) {
+					t.Errorf("Expected %q at warn, got %q", shown, out)
+				}
+			}
+		})
+	}
+}
+
+func TestSetLevelChangesRunningLogger(t *testing.T) {
+	restoreDefaults(t)
+	var buf bytes.Buffer
+	if err := Configure(&buf, FormatText, slog.LevelInfo); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	slog.Debug("before")
+	if err := SetLevel("debug"); err != nil {
+		t.Fatalf("SetLevel failed: %v", err)
+	}
+	slog.Debug("after")
+
+	out := buf.String()
+	if strings.Contains(out, "before") {
+		t.Errorf("Expected debug to be filtered before SetLevel, got %q", out)
+	}
+	if !strings.Contains(out, "after") {
+		t.Errorf("Expected debug after SetLevel, got %q", out)
+	}
+	if err := SetLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	if level, err := ParseLevel(""); err != nil || level != slog.LevelInfo {
+		t.Errorf("Expected empty level to mean info, got %v, %v", level, err)
+	}
+	if level, err := ParseLevel("debug"); err != nil || level != slog.LevelDebug {
+		t.Errorf("Expected debug, got %v, %v", level, err)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestUnknownFormatIsRejected(t *testing.T) {
+	if err := Configure(&bytes.Buffer{}, "xml", slog.LevelInfo); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+}
+
+func TestTraceIDIsAddedFromContext(t *testing.T) {
+	restoreDefaults(t)
+	var buf bytes.Buffer
+	if err := Configure(&buf, FormatText, slog.LevelInfo); err != nil {
+		t.Fatalf("Configure failed: %v", err)
+	}
+
+	ctx := WithTraceID(context.Background(), "trace-7")
+	slog.InfoContext(ctx, "API call completed", "command_id", "cmd-7")
+	slog.Info("Unrelated line")
+
+	out := buf.String()
+	if !strings.Contains(out, "INFO API call completed command_id=cmd-7 trace_id=trace-7\n") {
+		t.Errorf("Expected the trace ID on the command's line, got %q", out)
+	}
+	if strings.Count(out, "trace_id") != 1 {
+		t.Errorf("Expected only the command's line to carry a trace ID, got %q", out)
+	}
+}
@@ -0,0 +1,31 @@
+package logging
+
+import (
+	"log/slog"
+	"sync/atomic"
+)
+
+var traceMessages atomic.Bool
+
+// SetTraceMessages turns the per-message send and receive log lines of
+// the transports on or off, independently of the log level.
+func SetTraceMessages(on bool) {
+	traceMessages.Store(on)
+}
+
+// TracingMessages reports whether logging.trace_messages is on.
+func TracingMessages() bool {
+	return traceMessages.Load()
+}
+
+// TraceMessage logs a single message crossing the wire, with data
+// redacted, when logging.trace_messages is on. data may be nil.
+func TraceMessage(msg string, data []byte, args ...any) {
+	if !traceMessages.Load() {
+		return
+	}
+	if data != nil {
+		args = append(args, "data", RedactJSON(data))
+	}
+	slog.Info(msg, args...)
+}
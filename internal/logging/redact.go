@@ -0,0 +1,105 @@
+package logging
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// Redacted replaces the value of a sensitive key in logged data.
+const Redacted = "[REDACTED]"
+
+// DefaultSensitiveKeys are masked when logging.redact_keys is not configured.
+var DefaultSensitiveKeys = []string{"Authorization", "token", "password", "pin_code", "key", "registration_token"}
+
+var (
+	sensitiveMu   sync.RWMutex
+	sensitiveKeys = keySet(DefaultSensitiveKeys)
+)
+
+func keySet(keys []string) map[string]bool {
+	set := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		set[strings.ToLower(key)] = true
+	}
+	return set
+}
+
+// SetSensitiveKeys replaces the keys whose values are masked by Redact and
+// RedactJSON. Matching is case-insensitive; an empty list restores the
+// defaults.
+func SetSensitiveKeys(keys []string) {
+	if len(keys) == 0 {
+		keys = DefaultSensitiveKeys
+	}
+	set := keySet(keys)
+
+	sensitiveMu.Lock()
+	defer sensitiveMu.Unlock()
+	sensitiveKeys = set
+}
+
+func isSensitive(key string) bool {
+	sensitiveMu.RLock()
+	defer sensitiveMu.RUnlock()
+	return sensitiveKeys[strings.ToLower(key)]
+}
+
+// Redact returns a copy of v with the values of sensitive keys masked at
+// any depth. Maps and slices decoded from JSON are handled; anything else
+// is round-tripped through JSON first so structs are covered too.
+func Redact(v interface{}) interface{} {
+	switch v := v.(type) {
+	case nil, string, bool, float64, json.Number:
+		return v
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			if isSensitive(key) {
+				out[key] = Redacted
+			} else {
+				out[key] = Redact(value)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, value := range v {
+			out[i] = Redact(value)
+		}
+		return out
+	case map[string]string:
+		out := make(map[string]string, len(v))
+		for key, value := range v {
+			if isSensitive(key) {
+				value = Redacted
+			}
+			out[key] = value
+		}
+		return out
+	}
+
+	data, err := json.Marshal(v)
+	if err != nil {
+		return v
+	}
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return v
+	}
+	return Redact(decoded)
+}
+
+// RedactJSON masks sensitive keys in a JSON document for logging. Data
+// that is not JSON is returned unchanged.
+func RedactJSON(data []byte) string {
+	var decoded interface{}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return string(data)
+	}
+	redacted, err := json.Marshal(Redact(decoded))
+	if err != nil {
+		return string(data)
+	}
+	return string(redacted)
+}
@@ -0,0 +1,52 @@
+package logging
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestRedactMasksNestedSensitiveKeys(t *testing.T) {
+	message := map[string]interface{}{
+		"type": "api_call",
+		"payload": map[string]interface{}{
+			"headers":  map[string]interface{}{"authorization": "Bearer secret-1"},
+			"users":    []interface{}{map[string]interface{}{"name": "admin", "Password": "secret-2"}},
+			"pin_code": "1234",
+		},
+	}
+
+	redacted := Redact(message).(map[string]interface{})
+	payload := redacted["payload"].(map[string]interface{})
+	if payload["headers"].(map[string]interface{})["authorization"] != Redacted {
+		t.Errorf("Expected authorization to be masked case-insensitively, got %v", payload["headers"])
+	}
+	user := payload["users"].([]interface{})[0].(map[string]interface{})
+	if user["Password"] != Redacted || user["name"] != "admin" {
+		t.Errorf("Expected only the password to be masked, got %v", user)
+	}
+	if payload["pin_code"] != Redacted {
+		t.Errorf("Expected pin_code to be masked, got %v", payload["pin_code"])
+	}
+
+	original := message["payload"].(map[string]interface{})["pin_code"]
+	if original != "1234" {
+		t.Errorf("Redact modified the original message: %v", original)
+	}
+}
+
+func TestRedactJSONUsesConfiguredKeys(t *testing.T) {
+	SetSensitiveKeys([]string{"api_key"})
+	defer SetSensitiveKeys(nil)
+
+	out := RedactJSON([]byte(`{"api_key":"k-123","token":"t-456"}`))
+	if strings.Contains(out, "k-123") {
+		t.Errorf("Expected configured key to be masked, got %s", out)
+	}
+	if !strings.Contains(out, "t-456") {
+		t.Errorf("Expected only configured keys to be masked, got %s", out)
+	}
+
+	if out := RedactJSON([]byte("not json token=x")); out != "not json token=x" {
+		t.Errorf("Expected non-JSON data unchanged, got %s", out)
+	}
+}
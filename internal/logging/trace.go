@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+type traceKey struct{}
+
+// WithTraceID returns a context carrying the trace ID of the command being
+// handled. Records logged with it, through the slog *Context functions,
+// get a trace_id attribute.
+func WithTraceID(ctx context.Context, traceID string) context.Context {
+	if traceID == "" {
+		return ctx
+	}
+	return context.WithValue(ctx, traceKey{}, traceID)
+}
+
+// TraceID returns the trace ID set by WithTraceID, or "".
+func TraceID(ctx context.Context) string {
+	if ctx == nil {
+		return ""
+	}
+	traceID, _ := ctx.Value(traceKey{}).(string)
+	return traceID
+}
+
+// traceHandler adds the context's trace ID to every record.
+type traceHandler struct {
+	slog.Handler
+}
+
+func (h traceHandler) Handle(ctx context.Context, r slog.Record) error {
+	if traceID := TraceID(ctx); traceID != "" {
+		r = r.Clone()
+		r.AddAttrs(slog.String("trace_id", traceID))
+	}
+	return h.Handler.Handle(ctx, r)
+}
+
+func (h traceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return traceHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h traceHandler) WithGroup(name string) slog.Handler {
+	return traceHandler{h.Handler.WithGroup(name)}
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import "sync"
+
+// OtherLabel is the label value that command types outside the known set
+// are bucketed into, so a server sending arbitrary types cannot blow up
+// label cardinality.
+const OtherLabel = "other"
+
+// DefaultCommandTypes are labelled individually when metrics.command_types
+// is not configured.
+var DefaultCommandTypes = []string{
+	"api_call",
+	"http_request",
+	"local_command",
+	"interactive_shell_start",
+	"shell_input",
+	"shell_resize",
+	"quick_command",
+	"custom",
+	"output_fetch",
+	"heartbeat_history",
+	"cancel",
+	"status",
+	"metrics",
+	"reboot",
+	"snapshot_state",
+	"restore_state",
+	"file_list",
+	"file_download",
+	"file_upload",
+	"file_delete",
+}
+
+// CommandMetrics counts processed commands per command-type label.
+type CommandMetrics struct {
+	mu     sync.Mutex
+	known  map[string]bool
+	counts map[string]uint64
+	failed map[string]uint64
+}
+
+// NewCommandMetrics creates counters labelled by the given known command
+// types; DefaultCommandTypes is used when knownTypes is empty.
+func NewCommandMetrics(knownTypes []string) *CommandMetrics {
+	if len(knownTypes) == 0 {
+		knownTypes = DefaultCommandTypes
+	}
+	known := make(map[string]bool, len(knownTypes))
+	for _, t := range knownTypes {
+		known[t] = true
+	}
+	return &CommandMetrics{
+		known:  known,
+		counts: make(map[string]uint64),
+		failed: make(map[string]uint64),
+	}
+}
+
+// Label returns the label value to record cmdType under.
+func (m *CommandMetrics) Label(cmdType string) string {
+	if m.known[cmdType] {
+		return cmdType
+	}
+	return OtherLabel
+}
+
+// Inc records one processed command of the given type.
+func (m *CommandMetrics) Inc(cmdType string) {
+	label := m.Label(cmdType)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.counts[label]++
+}
+
+// IncFailed records one failed command of the given type.
+func (m *CommandMetrics) IncFailed(cmdType string) {
+	label := m.Label(cmdType)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.failed[label]++
+}
+
+// Counts returns a copy of the per-label counters.
+func (m *CommandMetrics) Counts() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyCounts(m.counts)
+}
+
+// FailedCounts returns a copy of the per-label failure counters.
+func (m *CommandMetrics) FailedCounts() map[string]uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return copyCounts(m.failed)
+}
+
+func copyCounts(src map[string]uint64) map[string]uint64 {
+	counts := make(map[string]uint64, len(src))
+	for label, n := range src {
+		counts[label] = n
+	}
+	return counts
+}
+
+// Total returns the number of processed commands across all labels.
+func (m *CommandMetrics) Total() uint64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total uint64
+	for _, n := range m.counts {
+		total += n
+	}
+	return total
+}
@@ -0,0 +1,65 @@
+package metrics
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestCommandMetricsLabelCardinality(t *testing.T) {
+	m := NewCommandMetrics([]string{"api_call", "local_command"})
+
+	m.Inc("api_call")
+	m.Inc("local_command")
+	m.Inc("local_command")
+	for i := 0; i < 1000; i++ {
+		m.Inc(fmt.Sprintf("junk_%d", i))
+	}
+
+	counts := m.Counts()
+	if len(counts) != 3 {
+		t.Errorf("Expected 3 distinct labels, got %d: %v", len(counts), counts)
+	}
+	if counts["api_call"] != 1 || counts["local_command"] != 2 {
+		t.Errorf("Known types not labelled individually: %v", counts)
+	}
+	if counts[OtherLabel] != 1000 {
+		t.Errorf("Expected 1000 commands under %q, got %d", OtherLabel, counts[OtherLabel])
+	}
+}
+
+func TestCommandMetricsDefaultKnownTypes(t *testing.T) {
+	m := NewCommandMetrics(nil)
+	if got := m.Label("file_list"); got != "file_list" {
+		t.Errorf("Expected default known type file_list, got %q", got)
+	}
+	if got := m.Label("custom_thing"); got != OtherLabel {
+		t.Errorf("Expected unknown type to map to %q, got %q", OtherLabel, got)
+	}
+}
+
+func TestCommandMetricsTotal(t *testing.T) {
+	m := NewCommandMetrics([]string{"api_call"})
+	m.Inc("api_call")
+	m.Inc("api_call")
+	m.Inc("custom_thing")
+
+	if got := m.Total(); got != 3 {
+		t.Errorf("Expected 3 processed commands, got %d", got)
+	}
+}
+
+func TestCommandMetricsFailedCounts(t *testing.T) {
+	m := NewCommandMetrics([]string{"api_call"})
+	m.Inc("api_call")
+	m.Inc("api_call")
+	m.IncFailed("api_call")
+	m.IncFailed("custom_thing")
+
+	failed := m.FailedCounts()
+	if failed["api_call"] != 1 || failed[OtherLabel] != 1 {
+		t.Errorf("Unexpected failure counters: %v", failed)
+	}
+	if m.Counts()["api_call"] != 2 {
+		t.Errorf("Failures should not change processed counters: %v", m.Counts())
+	}
+}
@@ -0,0 +1,56 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRegistryWritesPrometheusText(t *testing.T) {
+	r := NewRegistry()
+	commands := r.NewCounterVec("commands_total", "Commands processed.", "type", "result")
+	duration := r.NewHistogramVec("command_duration_seconds", "Command duration.", []float64{0.1, 1}, "type")
+	connected := r.NewGauge("transport_connected", "Transport connected.")
+
+	commands.Inc("api_call", "success")
+	commands.Inc("api_call", "success")
+	commands.Inc("api_call", "error")
+	duration.Observe(0.05, "api_call")
+	duration.Observe(0.5, "api_call")
+	duration.Observe(3, "api_call")
+	connected.Set(1)
+
+	var buf bytes.Buffer
+	r.WritePrometheus(&buf)
+	out := buf.String()
+
+	for _, line := range []string{
+		"# TYPE commands_total counter",
+		`commands_total{type="api_call",result="error"} 1`,
+		`commands_total{type="api_call",result="success"} 2`,
+		"# TYPE command_duration_seconds histogram",
+		`command_duration_seconds_bucket{type="api_call",le="0.1"} 1`,
+		`command_duration_seconds_bucket{type="api_call",le="1"} 2`,
+		`command_duration_seconds_bucket{type="api_call",le="+Inf"} 3`,
+		`command_duration_seconds_sum{type="api_call"} 3.55`,
+		`command_duration_seconds_count{type="api_call"} 3`,
+		"# TYPE transport_connected gauge",
+		"transport_connected 1",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+}
+
+func TestRegistryRejectsDuplicateNames(t *testing.T) {
+	r := NewRegistry()
+	r.NewGauge("transport_connected", "Transport connected.")
+
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected registering the same name twice to panic")
+		}
+	}()
+	r.NewGauge("transport_connected", "Transport connected.")
+}
@@ -0,0 +1,118 @@
+package metrics
+
+import (
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/load"
+	"github.com/shirou/gopsutil/v3/mem"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// SystemMetrics is a snapshot of device telemetry.
+type SystemMetrics struct {
+	Load    LoadAverage        `json:"load"`
+	Memory  MemoryUsage        `json:"memory"`
+	Disks   []DiskUsage        `json:"disks"`
+	Network []InterfaceCounter `json:"network"`
+}
+
+// LoadAverage holds the 1, 5 and 15 minute load averages.
+type LoadAverage struct {
+	Load1  float64 `json:"load1"`
+	Load5  float64 `json:"load5"`
+	Load15 float64 `json:"load15"`
+}
+
+// MemoryUsage is physical memory in bytes.
+type MemoryUsage struct {
+	TotalBytes     uint64  `json:"total_bytes"`
+	UsedBytes      uint64  `json:"used_bytes"`
+	FreeBytes      uint64  `json:"free_bytes"`
+	AvailableBytes uint64  `json:"available_bytes"`
+	UsedPercent    float64 `json:"used_percent"`
+}
+
+// DiskUsage is the usage of the filesystem mounted at Mount. Error is set
+// instead when the mount could not be read.
+type DiskUsage struct {
+	Mount       string  `json:"mount"`
+	TotalBytes  uint64  `json:"total_bytes"`
+	UsedBytes   uint64  `json:"used_bytes"`
+	FreeBytes   uint64  `json:"free_bytes"`
+	UsedPercent float64 `json:"used_percent"`
+	Error       string  `json:"error,omitempty"`
+}
+
+// InterfaceCounter holds the byte and packet counters of a network interface.
+type InterfaceCounter struct {
+	Name        string `json:"name"`
+	BytesRecv   uint64 `json:"bytes_recv"`
+	BytesSent   uint64 `json:"bytes_sent"`
+	PacketsRecv uint64 `json:"packets_recv"`
+	PacketsSent uint64 `json:"packets_sent"`
+}
+
+// SystemCollector gathers device telemetry; disk usage is reported for each
+// of the given mount points.
+type SystemCollector interface {
+	Collect(mounts []string) (*SystemMetrics, error)
+}
+
+// portableCollector uses gopsutil on platforms without /proc.
+type portableCollector struct{}
+
+func (portableCollector) Collect(mounts []string) (*SystemMetrics, error) {
+	m := &SystemMetrics{}
+
+	if avg, err := load.Avg(); err == nil {
+		m.Load = LoadAverage{Load1: avg.Load1, Load5: avg.Load5, Load15: avg.Load15}
+	}
+
+	vm, err := mem.VirtualMemory()
+	if err != nil {
+		return nil, err
+	}
+	m.Memory = MemoryUsage{
+		TotalBytes:     vm.Total,
+		UsedBytes:      vm.Used,
+		FreeBytes:      vm.Free,
+		AvailableBytes: vm.Available,
+		UsedPercent:    vm.UsedPercent,
+	}
+
+	for _, mount := range mounts {
+		usage, err := disk.Usage(mount)
+		if err != nil {
+			m.Disks = append(m.Disks, DiskUsage{Mount: mount, Error: err.Error()})
+			continue
+		}
+		m.Disks = append(m.Disks, DiskUsage{
+			Mount:       mount,
+			TotalBytes:  usage.Total,
+			UsedBytes:   usage.Used,
+			FreeBytes:   usage.Free,
+			UsedPercent: usage.UsedPercent,
+		})
+	}
+
+	if counters, err := net.IOCounters(true); err == nil {
+		for _, c := range counters {
+			m.Network = append(m.Network, InterfaceCounter{
+				Name:        c.Name,
+				BytesRecv:   c.BytesRecv,
+				BytesSent:   c.BytesSent,
+				PacketsRecv: c.PacketsRecv,
+				PacketsSent: c.PacketsSent,
+			})
+		}
+	}
+
+	return m, nil
+}
+
+// percent returns used as a percentage of total, rounded to two decimals.
+func percent(used, total uint64) float64 {
+	if total == 0 {
+		return 0
+	}
+	return float64(int64(float64(used)/float64(total)*10000+0.5)) / 100
+}
@@ -0,0 +1,167 @@
+//go:build linux
+
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// NewSystemCollector returns the collector for this platform.
+func NewSystemCollector() SystemCollector {
+	return procCollector{root: "/proc"}
+}
+
+// procCollector reads telemetry straight from procfs.
+type procCollector struct {
+	root string
+}
+
+func (p procCollector) Collect(mounts []string) (*SystemMetrics, error) {
+	m := &SystemMetrics{}
+
+	loadAvg, err := p.readLoad()
+	if err != nil {
+		return nil, err
+	}
+	m.Load = loadAvg
+
+	memory, err := p.readMemory()
+	if err != nil {
+		return nil, err
+	}
+	m.Memory = memory
+
+	for _, mount := range mounts {
+		m.Disks = append(m.Disks, diskUsage(mount))
+	}
+
+	network, err := p.readNetwork()
+	if err != nil {
+		return nil, err
+	}
+	m.Network = network
+
+	return m, nil
+}
+
+// readLoad parses /proc/loadavg: "0.52 0.58 0.59 1/467 12345".
+func (p procCollector) readLoad() (LoadAverage, error) {
+	data, err := os.ReadFile(filepath.Join(p.root, "loadavg"))
+	if err != nil {
+		return LoadAverage{}, err
+	}
+	fields := strings.Fields(string(data))
+	if len(fields) < 3 {
+		return LoadAverage{}, fmt.Errorf("unexpected loadavg format %q", data)
+	}
+	var values [3]float64
+	for i := range values {
+		if values[i], err = strconv.ParseFloat(fields[i], 64); err != nil {
+			return LoadAverage{}, fmt.Errorf("invalid load average %q: %w", fields[i], err)
+		}
+	}
+	return LoadAverage{Load1: values[0], Load5: values[1], Load15: values[2]}, nil
+}
+
+// readMemory parses the kB values of /proc/meminfo.
+func (p procCollector) readMemory() (MemoryUsage, error) {
+	f, err := os.Open(filepath.Join(p.root, "meminfo"))
+	if err != nil {
+		return MemoryUsage{}, err
+	}
+	defer f.Close()
+
+	values := make(map[string]uint64)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue
+		}
+		fields := strings.Fields(rest)
+		if len(fields) == 0 {
+			continue
+		}
+		if kb, err := strconv.ParseUint(fields[0], 10, 64); err == nil {
+			values[key] = kb * 1024
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return MemoryUsage{}, err
+	}
+
+	total, ok := values["MemTotal"]
+	if !ok {
+		return MemoryUsage{}, fmt.Errorf("MemTotal missing from meminfo")
+	}
+	available, ok := values["MemAvailable"]
+	if !ok {
+		// Kernels before 3.14 lack MemAvailable
+		available = values["MemFree"] + values["Buffers"] + values["Cached"]
+	}
+	used := total - available
+	return MemoryUsage{
+		TotalBytes:     total,
+		UsedBytes:      used,
+		FreeBytes:      values["MemFree"],
+		AvailableBytes: available,
+		UsedPercent:    percent(used, total),
+	}, nil
+}
+
+// readNetwork parses the per-interface counters of /proc/net/dev.
+func (p procCollector) readNetwork() ([]InterfaceCounter, error) {
+	f, err := os.Open(filepath.Join(p.root, "net", "dev"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var counters []InterfaceCounter
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		name, rest, ok := strings.Cut(scanner.Text(), ":")
+		if !ok {
+			continue // header lines
+		}
+		fields := strings.Fields(rest)
+		if len(fields) < 10 {
+			continue
+		}
+		var values [4]uint64
+		for i, idx := range []int{0, 1, 8, 9} {
+			values[i], _ = strconv.ParseUint(fields[idx], 10, 64)
+		}
+		counters = append(counters, InterfaceCounter{
+			Name:        strings.TrimSpace(name),
+			BytesRecv:   values[0],
+			PacketsRecv: values[1],
+			BytesSent:   values[2],
+			PacketsSent: values[3],
+		})
+	}
+	return counters, scanner.Err()
+}
+
+func diskUsage(mount string) DiskUsage {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(mount, &st); err != nil {
+		return DiskUsage{Mount: mount, Error: err.Error()}
+	}
+	total := st.Blocks * uint64(st.Bsize)
+	free := st.Bavail * uint64(st.Bsize)
+	used := total - st.Bfree*uint64(st.Bsize)
+	return DiskUsage{
+		Mount:       mount,
+		TotalBytes:  total,
+		UsedBytes:   used,
+		FreeBytes:   free,
+		UsedPercent: percent(used, used+free),
+	}
+}
@@ -0,0 +1,54 @@
+//go:build linux
+
+package metrics
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestProcCollectorParsesProcfs(t *testing.T) {
+	root := t.TempDir()
+	files := map[string]string{
+		"loadavg": "0.52 1.25 2.00 1/467 12345\n",
+		"meminfo": "MemTotal:        8000000 kB\nMemFree:         1000000 kB\nMemAvailable:    6000000 kB\nBuffers:          100000 kB\n",
+		"net/dev": "Inter-|   Receive                                                |  Transmit\n" +
+			" face |bytes    packets errs drop fifo frame compressed multicast|bytes    packets errs drop fifo colls carrier compressed\n" +
+			"    lo:    1000      10    0    0    0     0          0         0     1000      10    0    0    0     0       0          0\n" +
+			"  eth0: 5000000    4000    0    0    0     0          0         0   250000    3000    0    0    0     0       0          0\n",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m, err := procCollector{root: root}.Collect(nil)
+	if err != nil {
+		t.Fatalf("Collect failed: %v", err)
+	}
+
+	if m.Load != (LoadAverage{Load1: 0.52, Load5: 1.25, Load15: 2.00}) {
+		t.Errorf("Unexpected load %+v", m.Load)
+	}
+	want := MemoryUsage{
+		TotalBytes:     8000000 * 1024,
+		UsedBytes:      2000000 * 1024,
+		FreeBytes:      1000000 * 1024,
+		AvailableBytes: 6000000 * 1024,
+		UsedPercent:    25,
+	}
+	if m.Memory != want {
+		t.Errorf("Expected memory %+v, got %+v", want, m.Memory)
+	}
+	if len(m.Network) != 2 {
+		t.Fatalf("Expected 2 interfaces, got %+v", m.Network)
+	}
+	eth0 := m.Network[1]
+	if eth0 != (InterfaceCounter{Name: "eth0", BytesRecv: 5000000, PacketsRecv: 4000, BytesSent: 250000, PacketsSent: 3000}) {
+		t.Errorf("Unexpected eth0 counters %+v", eth0)
+	}
+}
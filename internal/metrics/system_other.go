@@ -0,0 +1,8 @@
+//go:build !linux
+
+package metrics
+
+// NewSystemCollector returns the collector for this platform.
+func NewSystemCollector() SystemCollector {
+	return portableCollector{}
+}
@@ -0,0 +1,52 @@
+package metrics
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSystemCollectorReportsPlausibleValues(t *testing.T) {
+	collectors := map[string]SystemCollector{
+		"platform": NewSystemCollector(),
+		"portable": portableCollector{},
+	}
+	for name, collector := range collectors {
+		m, err := collector.Collect([]string{"/", "/does-not-exist"})
+		if err != nil {
+			t.Fatalf("%s: Collect failed: %v", name, err)
+		}
+
+		if m.Load.Load1 < 0 || m.Load.Load5 < 0 || m.Load.Load15 < 0 {
+			t.Errorf("%s: negative load average %+v", name, m.Load)
+		}
+		if m.Memory.TotalBytes == 0 || m.Memory.UsedBytes > m.Memory.TotalBytes || m.Memory.AvailableBytes > m.Memory.TotalBytes {
+			t.Errorf("%s: implausible memory %+v", name, m.Memory)
+		}
+		if m.Memory.UsedPercent <= 0 || m.Memory.UsedPercent > 100 {
+			t.Errorf("%s: memory used_percent out of range: %v", name, m.Memory.UsedPercent)
+		}
+
+		if len(m.Disks) != 2 {
+			t.Fatalf("%s: expected a disk entry per mount, got %+v", name, m.Disks)
+		}
+		root := m.Disks[0]
+		if root.Mount != "/" || root.Error != "" || root.TotalBytes == 0 || root.UsedPercent < 0 || root.UsedPercent > 100 {
+			t.Errorf("%s: implausible root disk usage %+v", name, root)
+		}
+		if m.Disks[1].Error == "" {
+			t.Errorf("%s: expected an error for a missing mount, got %+v", name, m.Disks[1])
+		}
+
+		// The JSON form is what the metrics command returns
+		data, _ := json.Marshal(m)
+		var decoded map[string]interface{}
+		if err := json.Unmarshal(data, &decoded); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"load", "memory", "disks", "network"} {
+			if _, ok := decoded[key]; !ok {
+				t.Errorf("%s: metrics are missing %q: %s", name, key, data)
+			}
+		}
+	}
+}
@@ -0,0 +1,414 @@
+package mqtt
+
+import (
+	"context"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"fmt"
+	"log"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+var _ transport.Transport = (*MQTTClient)(nil)
+
+// Default topics; {client_id} is replaced with the configured client ID.
+const (
+	DefaultCommandTopic  = "edge-agent/{client_id}/commands"
+	DefaultResponseTopic = "edge-agent/{client_id}/responses"
+)
+
+// operationTimeout bounds subscribing and publishing.
+const operationTimeout = 10 * time.Second
+
+// Config selects the broker topics and credentials.
+type Config struct {
+	// CommandTopic is subscribed to for incoming commands
+	CommandTopic string
+	// ResponseTopic receives responses, heartbeats and the identification
+	ResponseTopic string
+	QoS           byte
+	Username      string
+	Password      string
+	// ConnectTimeout bounds each connection attempt, until the broker
+	// acknowledges it. Defaults to transport.DefaultConnectTimeout.
+	ConnectTimeout time.Duration
+}
+
+// MQTTClient carries the command envelope over an MQTT broker: commands
+// arrive on the command topic and everything the agent sends is published
+// to the response topic. Reconnection follows the transport's
+// ReconnectConfig rather than paho's own auto-reconnect.
+type MQTTClient struct {
+	commandHandler transport.Handler
+	client         paho.Client // per connection, replaced on every dial
+	mu             sync.RWMutex
+	connected      bool
+	config         Config
+	malformed      transport.Malformed
+
+	reconnect    transport.ReconnectConfig
+	onReconnect  []func()
+	onDisconnect []func()
+	stopped      bool
+	cancel       context.CancelFunc
+	lost         chan struct{} // closed when the current connection drops
+
+	broker   string
+	clientID string
+	identity func() transport.Identity
+}
+
+func NewMQTTClient(cfg Config, reconnect transport.ReconnectConfig) *MQTTClient {
+	if cfg.CommandTopic == "" {
+		cfg.CommandTopic = DefaultCommandTopic
+	}
+	if cfg.ResponseTopic == "" {
+		cfg.ResponseTopic = DefaultResponseTopic
+	}
+	return &MQTTClient{
+		config:    cfg,
+		reconnect: reconnect,
+	}
+}
+
+// topic fills the client ID into a topic template.
+func (c *MQTTClient) topic(template string) string {
+	return strings.ReplaceAll(template, "{client_id}", c.clientID)
+}
+
+func (c *MQTTClient) Connect(ctx context.Context, broker, clientID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	// Stop supervising any previous session before starting a new one
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.broker = broker
+	c.clientID = clientID
+	c.stopped = false
+	c.cancel = cancel
+	reconnect := c.reconnect
+	c.mu.Unlock()
+
+	if err := transport.Retry(ctx, reconnect, "MQTT", func() error { return c.dial(ctx) }); err != nil {
+		cancel()
+		return err
+	}
+
+	go c.supervise(ctx)
+
+	return nil
+}
+
+// dial connects to the broker, subscribes to the command topic and
+// identifies the client.
+func (c *MQTTClient) dial(ctx context.Context) error {
+	c.mu.RLock()
+	broker, clientID, identityFn, cfg := c.broker, c.clientID, c.identity, c.config
+	c.mu.RUnlock()
+	timeout := transport.ConnectTimeout(cfg.ConnectTimeout)
+
+	slog.Info("Connecting to MQTT broker", "protocol", "mqtt", "url", broker, "client_id", clientID)
+
+	lost := make(chan struct{})
+	var client paho.Client
+	opts := paho.NewClientOptions().
+		AddBroker(broker).
+		SetClientID(clientID).
+		SetUsername(cfg.Username).
+		SetPassword(cfg.Password).
+		SetCleanSession(true).
+		SetAutoReconnect(false).
+		SetConnectTimeout(timeout).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			slog.Warn("MQTT connection lost", "protocol", "mqtt", "error", err)
+			c.dropClient(client)
+		})
+	client = paho.NewClient(opts)
+
+	if err := wait(ctx, client.Connect(), timeout); err != nil {
+		return fmt.Errorf("failed to connect to MQTT broker: %w", err)
+	}
+	if err := wait(ctx, client.Subscribe(c.topic(cfg.CommandTopic), cfg.QoS, c.onMessage), operationTimeout); err != nil {
+		client.Disconnect(0)
+		return fmt.Errorf("failed to subscribe to %s: %w", c.topic(cfg.CommandTopic), err)
+	}
+
+	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		client.Disconnect(0)
+		return fmt.Errorf("MQTT client stopped")
+	}
+	// Tear down a connection left over from a concurrent dial
+	if c.connected && c.client != nil {
+		c.client.Disconnect(0)
+		close(c.lost)
+	}
+	c.client = client
+	c.connected = true
+	c.lost = lost
+	c.mu.Unlock()
+
+	log.Printf("MQTT connected successfully")
+
+	// Send identification message immediately after connection
+	identification := transport.Identify(identityFn, clientID)
+
+	if err := c.Send(map[string]interface{}{
+		"type":    "identification",
+		"id":      "init",
+		"payload": identification,
+		"success": true,
+	}); err != nil {
+		slog.Error("Failed to send identification", "protocol", "mqtt", "error", err)
+	}
+
+	return nil
+}
+
+// wait blocks until token completes, ctx ends or timeout passes.
+func wait(ctx context.Context, token paho.Token, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-token.Done():
+		return token.Error()
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
+
+// supervise waits for the connection to drop and redials until Disconnect
+// is called, the context ends or the reconnect policy gives up.
+func (c *MQTTClient) supervise(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		lost := c.lost
+		c.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			c.Disconnect()
+			c.disconnected()
+			return
+		case <-lost:
+		}
+		c.disconnected()
+
+		c.mu.RLock()
+		stopped := c.stopped
+		reconnect := c.reconnect
+		c.mu.RUnlock()
+		if stopped {
+			return
+		}
+
+		slog.Warn("❌ MQTT connection lost")
+
+		if !reconnect.Enabled {
+			slog.Warn("MQTT reconnection disabled, not attempting to reconnect")
+			return
+		}
+
+		log.Printf("🔄 MQTT disconnected, attempting to reconnect...")
+		if err := transport.Redial(ctx, reconnect, "MQTT", func() error { return c.dial(ctx) }); err != nil {
+			slog.Error("❌ MQTT reconnection failed, giving up", "error", err)
+			return
+		}
+
+		log.Printf("✅ MQTT reconnected successfully")
+
+		c.mu.RLock()
+		callbacks := append([]func(){}, c.onReconnect...)
+		c.mu.RUnlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// dropClient tears down client if it is still the active connection.
+func (c *MQTTClient) dropClient(client paho.Client) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if client == nil || c.client != client || !c.connected {
+		return
+	}
+
+	c.connected = false
+	client.Disconnect(0)
+	close(c.lost)
+}
+
+func (c *MQTTClient) Disconnect() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+
+	if !c.connected {
+		return nil
+	}
+
+	c.connected = false
+	if c.client != nil {
+		c.client.Disconnect(250)
+	}
+	close(c.lost)
+
+	log.Printf("MQTT disconnected")
+	return nil
+}
+
+// DropConnection closes the current connection without stopping the
+// client, so supervise treats it as lost and redials.
+func (c *MQTTClient) DropConnection() {
+	c.mu.RLock()
+	client := c.client
+	c.mu.RUnlock()
+	c.dropClient(client)
+}
+
+func (c *MQTTClient) IsConnected() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.connected
+}
+
+// SetReconnect replaces the reconnect policy used for future redials.
+func (c *MQTTClient) SetReconnect(reconnect transport.ReconnectConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = reconnect
+}
+
+// OnReconnect registers fn to be called after each successful redial.
+func (c *MQTTClient) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// OnDisconnect registers fn to be called whenever the connection drops or
+// is closed.
+func (c *MQTTClient) OnDisconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDisconnect = append(c.onDisconnect, fn)
+}
+
+// disconnected runs the OnDisconnect callbacks.
+func (c *MQTTClient) disconnected() {
+	c.mu.RLock()
+	callbacks := append([]func(){}, c.onDisconnect...)
+	c.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// Send publishes message to the response topic.
+func (c *MQTTClient) Send(message map[string]interface{}) error {
+	c.mu.RLock()
+	connected, client, topic, qos := c.connected, c.client, c.topic(c.config.ResponseTopic), c.config.QoS
+	c.mu.RUnlock()
+	if !connected {
+		return fmt.Errorf("MQTT not connected")
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	logging.TraceMessage("Sending message", data, "protocol", "mqtt", "topic", topic)
+	if err := wait(context.Background(), client.Publish(topic, qos, false, data), operationTimeout); err != nil {
+		return fmt.Errorf("failed to publish to %s: %w", topic, err)
+	}
+	return nil
+}
+
+// rejectMalformed tells the server a message could not be parsed and
+// reconnects once too many arrive in a row.
+func (c *MQTTClient) rejectMalformed() {
+	reconnect := c.malformed.Record()
+	if err := c.Send(transport.MalformedReply()); err != nil {
+		slog.Warn("Failed to report invalid message", "protocol", "mqtt", "error", err)
+	}
+	if reconnect {
+		slog.Warn("Too many malformed messages in a row, reconnecting", "protocol", "mqtt", "count", transport.MaxConsecutiveMalformed)
+		c.DropConnection()
+	}
+}
+
+// MalformedMessages returns how many received messages were not valid JSON.
+func (c *MQTTClient) MalformedMessages() uint64 {
+	return c.malformed.Total()
+}
+
+// onMessage handles a message on the command topic.
+func (c *MQTTClient) onMessage(_ paho.Client, msg paho.Message) {
+	data := msg.Payload()
+	logging.TraceMessage("Received raw message", data, "protocol", "mqtt", "topic", msg.Topic())
+
+	var message map[string]interface{}
+	if err := json.Unmarshal(data, &message); err != nil {
+		slog.Warn("Invalid MQTT message format", "error", err, "data", logging.RedactJSON(data))
+		c.rejectMalformed()
+		return
+	}
+	c.malformed.Reset()
+
+	switch message["type"] {
+	case "identification_success":
+		slog.Debug("Identification successful", "message", logging.Redact(message))
+		return
+	case "ping":
+		c.Send(map[string]interface{}{
+			"type":      "pong",
+			"id":        message["id"],
+			"timestamp": time.Now().Unix(),
+		})
+		return
+	}
+
+	c.mu.RLock()
+	handler := c.commandHandler
+	c.mu.RUnlock()
+	if handler == nil {
+		return
+	}
+	if response := handler(message); response != nil {
+		if err := c.Send(response); err != nil {
+			slog.Error("Failed to send response", "protocol", "mqtt", "command_id", message["id"], "error", err)
+		}
+	}
+}
+
+func (c *MQTTClient) SetHandler(handler transport.Handler) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.commandHandler = handler
+}
+
+// SetIdentityProvider sets the source of the identification message.
+func (c *MQTTClient) SetIdentityProvider(provider func() transport.Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = provider
+}
@@ -0,0 +1,256 @@
+package mqtt
+
+import (
+	"context"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is a minimal MQTT 3.1.1 broker: it accepts every client,
+// routes publishes to exact-match subscriptions and can drop all
+// connections to simulate an outage.
+type testBroker struct {
+	listener  net.Listener
+	mu        sync.Mutex
+	conns     map[net.Conn]map[string]bool // subscriptions per connection
+	published chan *packets.PublishPacket  // everything clients publish
+}
+
+func newTestBroker(t *testing.T) *testBroker {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	b := &testBroker{
+		listener:  listener,
+		conns:     make(map[net.Conn]map[string]bool),
+		published: make(chan *packets.PublishPacket, 100),
+	}
+	go b.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		b.dropAll()
+	})
+	return b
+}
+
+func (b *testBroker) url() string { return "tcp://" + b.listener.Addr().String() }
+
+func (b *testBroker) serve() {
+	for {
+		conn, err := b.listener.Accept()
+		if err != nil {
+			return
+		}
+		b.mu.Lock()
+		b.conns[conn] = make(map[string]bool)
+		b.mu.Unlock()
+		go b.handle(conn)
+	}
+}
+
+func (b *testBroker) handle(conn net.Conn) {
+	defer func() {
+		b.mu.Lock()
+		delete(b.conns, conn)
+		b.mu.Unlock()
+		conn.Close()
+	}()
+
+	for {
+		packet, err := packets.ReadPacket(conn)
+		if err != nil {
+			return
+		}
+		var reply packets.ControlPacket
+		switch p := packet.(type) {
+		case *packets.ConnectPacket:
+			reply = packets.NewControlPacket(packets.Connack)
+		case *packets.SubscribePacket:
+			b.mu.Lock()
+			for _, topic := range p.Topics {
+				b.conns[conn][topic] = true
+			}
+			b.mu.Unlock()
+			suback := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+			suback.MessageID = p.MessageID
+			suback.ReturnCodes = p.Qoss
+			reply = suback
+		case *packets.PublishPacket:
+			b.published <- p
+			if p.Qos > 0 {
+				puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+				puback.MessageID = p.MessageID
+				reply = puback
+			}
+		case *packets.PingreqPacket:
+			reply = packets.NewControlPacket(packets.Pingresp)
+		case *packets.DisconnectPacket:
+			return
+		}
+		if reply != nil {
+			b.mu.Lock()
+			err := reply.Write(conn)
+			b.mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// publish delivers payload at QoS 0 to every client subscribed to topic.
+func (b *testBroker) publish(topic string, payload []byte) int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delivered := 0
+	for conn, topics := range b.conns {
+		if !topics[topic] {
+			continue
+		}
+		p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+		p.TopicName = topic
+		p.Payload = payload
+		if p.Write(conn) == nil {
+			delivered++
+		}
+	}
+	return delivered
+}
+
+// dropAll closes every client connection.
+func (b *testBroker) dropAll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for conn := range b.conns {
+		conn.Close()
+	}
+}
+
+// next returns the next message published on topic.
+func (b *testBroker) next(t *testing.T, topic string) map[string]interface{} {
+	t.Helper()
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case p := <-b.published:
+			if p.TopicName != topic {
+				continue
+			}
+			var message map[string]interface{}
+			if err := json.Unmarshal(p.Payload, &message); err != nil {
+				t.Fatalf("Invalid message on %s: %s", topic, p.Payload)
+			}
+			return message
+		case <-timeout:
+			t.Fatalf("Timed out waiting for a message on %s", topic)
+			return nil
+		}
+	}
+}
+
+// sendCommand publishes a command once the client has subscribed.
+func (b *testBroker) sendCommand(t *testing.T, topic string, command map[string]interface{}) {
+	t.Helper()
+	data, _ := json.Marshal(command)
+	deadline := time.Now().Add(5 * time.Second)
+	for b.publish(topic, data) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("No client subscribed to %s", topic)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func echoHandler(message map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"type":    "command_response",
+		"id":      message["id"],
+		"success": true,
+		"payload": message["payload"],
+	}
+}
+
+func TestMQTTClientCommandRoundTrip(t *testing.T) {
+	broker := newTestBroker(t)
+
+	client := NewMQTTClient(Config{QoS: 1}, transport.ReconnectConfig{})
+	client.SetHandler(echoHandler)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, broker.url(), "edge-7"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	identification := broker.next(t, "edge-agent/edge-7/responses")
+	if identification["type"] != "identification" || identification["id"] != "init" {
+		t.Fatalf("Expected the identification first, got %v", identification)
+	}
+
+	broker.sendCommand(t, "edge-agent/edge-7/commands", map[string]interface{}{
+		"type":    "status",
+		"id":      "cmd-1",
+		"payload": map[string]interface{}{"verbose": true},
+	})
+
+	response := broker.next(t, "edge-agent/edge-7/responses")
+	if response["id"] != "cmd-1" || response["type"] != "command_response" || response["success"] != true {
+		t.Errorf("Unexpected response %v", response)
+	}
+}
+
+func TestMQTTClientReconnectsAfterBrokerDrop(t *testing.T) {
+	broker := newTestBroker(t)
+
+	client := NewMQTTClient(Config{
+		CommandTopic:  "site/{client_id}/in",
+		ResponseTopic: "site/{client_id}/out",
+	}, transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	client.SetHandler(echoHandler)
+
+	reconnected := make(chan struct{}, 10)
+	disconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+	client.OnDisconnect(func() { disconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := client.Connect(ctx, broker.url(), "edge-8"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	broker.next(t, "site/edge-8/out") // identification
+
+	broker.dropAll()
+
+	for name, ch := range map[string]chan struct{}{"disconnect": disconnected, "reconnect": reconnected} {
+		select {
+		case <-ch:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for %s", name)
+		}
+	}
+	if !client.IsConnected() {
+		t.Fatal("Expected the client to be connected after reconnecting")
+	}
+	broker.next(t, "site/edge-8/out") // identification again
+
+	// The command subscription is restored on the new connection
+	broker.sendCommand(t, "site/edge-8/in", map[string]interface{}{"type": "status", "id": "cmd-2"})
+	if response := broker.next(t, "site/edge-8/out"); response["id"] != "cmd-2" {
+		t.Errorf("Expected the response to cmd-2 after reconnect, got %v", response)
+	}
+}
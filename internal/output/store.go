@@ -0,0 +1,154 @@
+package output
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultInlineMaxBytes is used when command_output.inline_max_bytes is not configured.
+const DefaultInlineMaxBytes = 64 * 1024
+
+// Retention defaults, used when command_output.max_age or
+// command_output.max_total_bytes is not configured.
+const (
+	DefaultMaxAge        = 24 * time.Hour
+	DefaultMaxTotalBytes = 100 * 1024 * 1024
+)
+
+// refPrefix starts the name of every file Save writes.
+const refPrefix = "out-"
+
+// Config holds configuration for the command output store.
+type Config struct {
+	Dir            string `yaml:"store_dir"`
+	InlineMaxBytes int    `yaml:"inline_max_bytes"`
+	// MaxAge and MaxTotalBytes bound what the store keeps: older outputs
+	// are deleted, then the oldest ones until the total fits.
+	MaxAge        time.Duration `yaml:"max_age"`
+	MaxTotalBytes int64         `yaml:"max_total_bytes"`
+}
+
+// Store keeps command outputs that are too large to send inline so the
+// server can fetch them on demand by reference.
+type Store struct {
+	dir       string
+	inlineMax int
+	maxAge    time.Duration
+	maxTotal  int64
+	mu        sync.Mutex // serializes Save so pruning sees a consistent directory
+}
+
+// NewStore creates a Store, making sure the storage directory exists.
+func NewStore(cfg Config) (*Store, error) {
+	if cfg.Dir == "" {
+		return nil, errors.New("store_dir must be provided")
+	}
+	if err := os.MkdirAll(cfg.Dir, 0755); err != nil {
+		return nil, err
+	}
+	inlineMax := cfg.InlineMaxBytes
+	if inlineMax <= 0 {
+		inlineMax = DefaultInlineMaxBytes
+	}
+	maxAge := cfg.MaxAge
+	if maxAge <= 0 {
+		maxAge = DefaultMaxAge
+	}
+	maxTotal := cfg.MaxTotalBytes
+	if maxTotal <= 0 {
+		maxTotal = DefaultMaxTotalBytes
+	}
+	return &Store{dir: cfg.Dir, inlineMax: inlineMax, maxAge: maxAge, maxTotal: maxTotal}, nil
+}
+
+// Inline reports whether data is small enough to be returned inline.
+func (s *Store) Inline(data string) bool {
+	return len(data) <= s.inlineMax
+}
+
+// Save writes data to the store and returns the reference to fetch it by.
+// Outputs past the retention limits are pruned on the way.
+func (s *Store) Save(stream string, data string) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	ref := fmt.Sprintf("%s%d-%s", refPrefix, time.Now().UnixNano(), stream)
+	if err := os.WriteFile(filepath.Join(s.dir, ref), []byte(data), 0644); err != nil {
+		return "", err
+	}
+	if err := s.prune(ref); err != nil {
+		slog.Warn("Failed to prune command output store", "dir", s.dir, "error", err)
+	}
+	return ref, nil
+}
+
+// prune deletes outputs older than maxAge, then the oldest remaining ones
+// until the store fits in maxTotal. The output just saved as keep is never
+// deleted, so its reference stays valid even if it alone exceeds maxTotal.
+func (s *Store) prune(keep string) error {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return err
+	}
+
+	type stored struct {
+		name    string
+		size    int64
+		modTime time.Time
+	}
+	var outputs []stored
+	var total int64
+	cutoff := time.Now().Add(-s.maxAge)
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() || !strings.HasPrefix(entry.Name(), refPrefix) {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		if entry.Name() != keep && info.ModTime().Before(cutoff) {
+			s.remove(entry.Name())
+			continue
+		}
+		outputs = append(outputs, stored{entry.Name(), info.Size(), info.ModTime()})
+		total += info.Size()
+	}
+
+	slices.SortFunc(outputs, func(a, b stored) int { return a.modTime.Compare(b.modTime) })
+	for _, output := range outputs {
+		if total <= s.maxTotal {
+			break
+		}
+		if output.name == keep {
+			continue
+		}
+		if s.remove(output.name) {
+			total -= output.size
+		}
+	}
+	return nil
+}
+
+func (s *Store) remove(ref string) bool {
+	if err := os.Remove(filepath.Join(s.dir, ref)); err != nil && !os.IsNotExist(err) {
+		slog.Warn("Failed to delete stored command output", "ref", ref, "error", err)
+		return false
+	}
+	return true
+}
+
+// Load returns the content stored under ref.
+func (s *Store) Load(ref string) ([]byte, error) {
+	if ref == "" || filepath.Base(ref) != ref || strings.HasPrefix(ref, ".") {
+		return nil, errors.New("invalid output reference")
+	}
+	return os.ReadFile(filepath.Join(s.dir, ref))
+}
@@ -0,0 +1,82 @@
+package output
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func saveOutput(t *testing.T, s *Store, data string) string {
+	t.Helper()
+	ref, err := s.Save("stdout", data)
+	if err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	return ref
+}
+
+func TestSavePrunesExpiredOutputs(t *testing.T) {
+	s, err := NewStore(Config{Dir: t.TempDir(), MaxAge: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	old := saveOutput(t, s, "old output")
+	past := time.Now().Add(-2 * time.Hour)
+	if err := os.Chtimes(filepath.Join(s.dir, old), past, past); err != nil {
+		t.Fatalf("Failed to age output: %v", err)
+	}
+	fresh := saveOutput(t, s, "fresh output")
+
+	if _, err := s.Load(old); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired output to be deleted, got %v", err)
+	}
+	if data, err := s.Load(fresh); err != nil || string(data) != "fresh output" {
+		t.Errorf("Expected the fresh output to be kept, got %q, %v", data, err)
+	}
+}
+
+func TestSavePrunesOldestOutputsOverTotalSize(t *testing.T) {
+	s, err := NewStore(Config{Dir: t.TempDir(), MaxTotalBytes: 25})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+
+	var refs []string
+	for i, data := range []string{"first-10b!", "second-10b", "third-10b!"} {
+		refs = append(refs, saveOutput(t, s, data))
+		// Distinct modification times keep the age order unambiguous
+		at := time.Now().Add(time.Duration(i-3) * time.Minute)
+		os.Chtimes(filepath.Join(s.dir, refs[i]), at, at)
+	}
+
+	if _, err := s.Load(refs[0]); !os.IsNotExist(err) {
+		t.Errorf("Expected the oldest output to be deleted, got %v", err)
+	}
+	for _, ref := range refs[1:] {
+		if _, err := s.Load(ref); err != nil {
+			t.Errorf("Expected %s to be kept, got %v", ref, err)
+		}
+	}
+}
+
+func TestSaveKeepsOutputLargerThanTotalSize(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(Config{Dir: dir, MaxTotalBytes: 4})
+	if err != nil {
+		t.Fatalf("Failed to create store: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "unrelated.txt"), []byte("not an output"), 0644); err != nil {
+		t.Fatalf("Failed to write file: %v", err)
+	}
+
+	ref := saveOutput(t, s, "larger than the limit")
+
+	if _, err := s.Load(ref); err != nil {
+		t.Errorf("Expected the output just saved to be kept, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "unrelated.txt")); err != nil {
+		t.Errorf("Expected files the store did not write to be left alone, got %v", err)
+	}
+}
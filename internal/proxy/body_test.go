@@ -0,0 +1,76 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// echoRequestServer records the Content-Type and body of the last request.
+func echoRequestServer(contentType, body *string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		*contentType = r.Header.Get("Content-Type")
+		*body = string(data)
+		w.Write([]byte(`{"success": true}`))
+	}))
+}
+
+func TestRequestBodyEncoding(t *testing.T) {
+	var gotType, gotBody string
+	server := echoRequestServer(&gotType, &gotBody)
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	client := NewAPIClient(cfg)
+
+	tests := []struct {
+		name     string
+		headers  map[string]string
+		body     interface{}
+		wantType string
+		wantBody string
+	}{
+		{
+			name:     "form",
+			headers:  map[string]string{"Content-Type": "application/x-www-form-urlencoded"},
+			body:     "cell=1&reason=quick+command",
+			wantType: "application/x-www-form-urlencoded",
+			wantBody: "cell=1&reason=quick+command",
+		},
+		{
+			name:     "raw text",
+			body:     "plain text, not JSON",
+			wantType: "text/plain; charset=utf-8",
+			wantBody: "plain text, not JSON",
+		},
+		{
+			name:     "pre-serialized bytes",
+			headers:  map[string]string{"Content-Type": "application/json"},
+			body:     []byte(`{"already":"encoded"}`),
+			wantType: "application/json",
+			wantBody: `{"already":"encoded"}`,
+		},
+		{
+			name:     "map",
+			body:     map[string]interface{}{"cell_number": 1},
+			wantType: "application/json",
+			wantBody: `{"cell_number":1}`,
+		},
+	}
+	for _, tt := range tests {
+		if _, err := client.ExecuteAPICall(context.Background(), "", "/submit", "POST", tt.headers, tt.body); err != nil {
+			t.Fatalf("%s: ExecuteAPICall failed: %v", tt.name, err)
+		}
+		if gotType != tt.wantType {
+			t.Errorf("%s: expected Content-Type %q, got %q", tt.name, tt.wantType, gotType)
+		}
+		if gotBody != tt.wantBody {
+			t.Errorf("%s: expected body %q, got %q", tt.name, tt.wantBody, gotBody)
+		}
+	}
+}
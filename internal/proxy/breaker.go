@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned without contacting the upstream while its
+// circuit breaker is open.
+var ErrCircuitOpen = errors.New("circuit open")
+
+type circuitState int
+
+const (
+	circuitClosed circuitState = iota
+	circuitOpen
+	circuitHalfOpen
+)
+
+func (s circuitState) String() string {
+	switch s {
+	case circuitOpen:
+		return "open"
+	case circuitHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+// outcome classifies a finished request for the circuit breaker.
+type outcome int
+
+const (
+	outcomeSuccess outcome = iota
+	outcomeFailure
+	outcomeIgnored // e.g. cancelled by the caller; says nothing about the upstream
+)
+
+// breaker is a circuit breaker for one upstream. After threshold consecutive
+// failures it opens and fast-fails requests for cooldown, then half-opens
+// to let a single probe through: success closes it, failure reopens it.
+type breaker struct {
+	mu        sync.Mutex
+	state     circuitState
+	failures  int
+	openedAt  time.Time
+	probing   bool
+	threshold int
+	cooldown  time.Duration
+	now       func() time.Time
+}
+
+func newBreaker(threshold int, cooldown time.Duration) *breaker {
+	return &breaker{threshold: threshold, cooldown: cooldown, now: time.Now}
+}
+
+// allow reports whether a request may be sent now. It returns the remaining
+// cooldown when the circuit is open.
+func (b *breaker) allow() (bool, time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.state {
+	case circuitOpen:
+		remaining := b.openedAt.Add(b.cooldown).Sub(b.now())
+		if remaining > 0 {
+			return false, remaining
+		}
+		b.state = circuitHalfOpen
+		b.probing = true
+		return true, 0
+	case circuitHalfOpen:
+		if b.probing {
+			return false, 0
+		}
+		b.probing = true
+		return true, 0
+	default:
+		return true, 0
+	}
+}
+
+// record updates the breaker with the outcome of an allowed request.
+func (b *breaker) record(o outcome) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.state == circuitHalfOpen {
+		b.probing = false
+		switch o {
+		case outcomeSuccess:
+			b.state = circuitClosed
+			b.failures = 0
+		case outcomeFailure:
+			b.state = circuitOpen
+			b.openedAt = b.now()
+		}
+		return
+	}
+
+	switch o {
+	case outcomeSuccess:
+		b.failures = 0
+	case outcomeFailure:
+		b.failures++
+		if b.state == circuitClosed && b.failures >= b.threshold {
+			b.state = circuitOpen
+			b.openedAt = b.now()
+		}
+	}
+}
+
+func (b *breaker) currentState() circuitState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
@@ -0,0 +1,108 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCircuitBreakerTransitions(t *testing.T) {
+	var healthy int32
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&calls, 1)
+		if atomic.LoadInt32(&healthy) == 0 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.CircuitBreaker.FailureThreshold = 3
+	cfg.APIProxy.CircuitBreaker.Cooldown = time.Minute
+	client := NewAPIClient(cfg)
+
+	now := time.Now()
+	b := client.fallback.breaker
+	b.now = func() time.Time { return now }
+
+	call := func() (*APIResponse, error) {
+		return client.ExecuteAPICall(context.Background(), "", "/status", "GET", nil, nil)
+	}
+
+	// closed: failures pass through until the threshold is reached
+	for i := 0; i < 3; i++ {
+		resp, err := call()
+		if err != nil || resp.StatusCode != http.StatusInternalServerError {
+			t.Fatalf("Call %d: expected upstream 500, got %+v, %v", i+1, resp, err)
+		}
+	}
+	if b.currentState() != circuitOpen {
+		t.Fatalf("Expected circuit to open after 3 failures, got %s", b.currentState())
+	}
+
+	// open: requests fail fast without reaching the upstream
+	if _, err := call(); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("Expected ErrCircuitOpen while open, got %v", err)
+	}
+	if got := atomic.LoadInt32(&calls); got != 3 {
+		t.Errorf("Expected the open circuit to skip the upstream, got %d calls", got)
+	}
+
+	// half-open: after the cooldown a failed probe reopens the circuit
+	now = now.Add(time.Minute)
+	if _, err := call(); err != nil {
+		t.Fatalf("Expected the probe to reach the upstream, got %v", err)
+	}
+	if b.currentState() != circuitOpen {
+		t.Fatalf("Expected a failed probe to reopen the circuit, got %s", b.currentState())
+	}
+
+	// half-open → closed: a successful probe closes the circuit
+	atomic.StoreInt32(&healthy, 1)
+	now = now.Add(time.Minute)
+	if allowed, _ := b.allow(); !allowed || b.currentState() != circuitHalfOpen {
+		t.Fatalf("Expected circuit to half-open after the cooldown, got %s", b.currentState())
+	}
+	if allowed, _ := b.allow(); allowed {
+		t.Error("Expected only one probe at a time while half-open")
+	}
+	b.record(outcomeIgnored)
+
+	resp, err := call()
+	if err != nil || !resp.Success {
+		t.Fatalf("Expected the probe to succeed, got %+v, %v", resp, err)
+	}
+	if b.currentState() != circuitClosed {
+		t.Errorf("Expected a successful probe to close the circuit, got %s", b.currentState())
+	}
+}
+
+func TestCircuitBreakerIgnoresClientErrors(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.CircuitBreaker.FailureThreshold = 1
+	client := NewAPIClient(cfg)
+
+	for i := 0; i < 3; i++ {
+		if _, err := client.ExecuteAPICall(context.Background(), "", "/missing", "GET", nil, nil); err != nil {
+			t.Fatalf("Expected 404 to pass through, got %v", err)
+		}
+	}
+	if state := client.fallback.breaker.currentState(); state != circuitClosed {
+		t.Errorf("Expected 4xx responses to leave the circuit closed, got %s", state)
+	}
+}
@@ -3,149 +3,597 @@ package proxy
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"edge-agent/internal/config"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
 )
 
 type APIClient struct {
+	config   *config.Config
+	fallback *profile
+	profiles map[string]*profile
+	// httpHosts limits where http_request may go
+	httpHosts *HostPolicy
+}
+
+// profile is one upstream the API client can talk to, with its own
+// base URL, default headers, auth and TLS settings.
+type profile struct {
 	client    *http.Client
-	config    *config.Config
 	baseURL   string
 	headers   map[string]string
 	authToken string
+	authType  string
+	userAgent string
+	retry     retryPolicy
+	breaker   *breaker    // nil when the circuit breaker is disabled
+	hosts     *HostPolicy // limits where api_call may go besides baseURL
+
+	maxResponseBytes int64
+	truncateResponse bool
+
+	logRequests bool
+	redact      map[string]bool // canonical header names whose values are masked
+}
+
+// DefaultMaxResponseBytes is used when max_response_bytes is not configured.
+const DefaultMaxResponseBytes = 10 * 1024 * 1024
+
+// ErrResponseTooLarge is returned when an upstream response exceeds
+// max_response_bytes and truncate_response is off.
+var ErrResponseTooLarge = errors.New("response body too large")
+
+// upstreamResponse is the result of a single upstream request.
+type upstreamResponse struct {
+	status        int
+	body          []byte
+	headers       http.Header
+	contentLength int64 // -1 when the upstream did not announce it
+	truncated     bool  // body holds only the first max_response_bytes
 }
 
 type APIResponse struct {
-	Data    interface{} `json:"data,omitempty"`
-	Error   string      `json:"error,omitempty"`
-	Success bool        `json:"success"`
+	Data       interface{}       `json:"data,omitempty"`
+	Error      string            `json:"error,omitempty"`
+	StatusCode int               `json:"status_code,omitempty"`
+	Headers    map[string]string `json:"headers,omitempty"` // multiple values are joined with ", "
+	Success    bool              `json:"success"`
 }
 
 func NewAPIClient(cfg *config.Config) *APIClient {
-	return &APIClient{
-		client: &http.Client{
-			Timeout: cfg.APIProxy.Timeout,
-		},
-		config:    cfg,
-		baseURL:   cfg.APIProxy.BaseURL,
-		headers:   cfg.APIProxy.Headers,
-		authToken: cfg.APIProxy.Auth.Token,
+	c := &APIClient{
+		config:   cfg,
+		fallback: newProfile("default", cfg.APIProxy),
+		profiles: make(map[string]*profile),
+	}
+	for name, p := range cfg.APIProfiles {
+		c.profiles[name] = newProfile(name, p)
 	}
+
+	for _, p := range append([]*profile{c.fallback}, profileList(c.profiles)...) {
+		policy, err := NewHostPolicy(cfg.APICall.AllowedHosts, cfg.APICall.DeniedHosts, urlHost(p.baseURL))
+		if err != nil {
+			slog.Warn("api_call host policy is invalid, refusing all api_call requests", "error", err)
+		}
+		p.hosts = policy
+	}
+	policy, err := NewHostPolicy(cfg.HTTPRequest.AllowedHosts, cfg.HTTPRequest.DeniedHosts, urlHost(c.fallback.baseURL))
+	if err != nil {
+		slog.Warn("http_request host policy is invalid, refusing all http_request requests", "error", err)
+	}
+	c.httpHosts = policy
+	return c
 }
 
-func (c *APIClient) ExecuteAPICall(ctx context.Context, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
-	fullURL := fmt.Sprintf("%s%s", c.baseURL, url)
-	return c.executeHTTPRequest(ctx, fullURL, method, headers, body)
+// SetUserAgent sets the User-Agent of profiles that do not configure
+// their own user_agent.
+func (c *APIClient) SetUserAgent(userAgent string) {
+	for _, p := range append([]*profile{c.fallback}, profileList(c.profiles)...) {
+		if p.userAgent == "" {
+			p.userAgent = userAgent
+		}
+	}
 }
 
-func (c *APIClient) ExecuteHTTPRequest(ctx context.Context, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
-	return c.executeHTTPRequest(ctx, url, method, headers, body)
+func profileList(profiles map[string]*profile) []*profile {
+	list := make([]*profile, 0, len(profiles))
+	for _, p := range profiles {
+		list = append(list, p)
+	}
+	return list
+}
+
+// urlHost returns the host name of rawURL, or "" if it has none.
+func urlHost(rawURL string) string {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return ""
+	}
+	return u.Hostname()
+}
+
+// newTransport builds the connection pool shared by all requests of a profile.
+// newTransport builds the transport of a profile. Requests go through
+// proxy; every other connection is checked against the request's host
+// policy once its address is resolved.
+func newTransport(p config.APIProxy, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	maxIdle := p.MaxIdleConns
+	if maxIdle <= 0 {
+		maxIdle = 100
+	}
+	idleTimeout := p.IdleConnTimeout
+	if idleTimeout <= 0 {
+		idleTimeout = 90 * time.Second
+	}
+
+	guard := &dialGuard{dialer: &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}}
+	return &http.Transport{
+		Proxy:             guard.proxy(proxy),
+		DialContext:       guard.DialContext,
+		ForceAttemptHTTP2: true,
+		MaxIdleConns:      maxIdle,
+		// A profile talks to one upstream, so let it keep the whole idle
+		// pool instead of net/http's default of 2 per host
+		MaxIdleConnsPerHost:   maxIdle,
+		MaxConnsPerHost:       p.MaxConnsPerHost,
+		IdleConnTimeout:       idleTimeout,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
 }
 
-func (c *APIClient) executeHTTPRequest(ctx context.Context, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
-	// Prepare request body
-	var reqBody []byte
-	var err error
+func newProfile(name string, p config.APIProxy) *profile {
+	proxy, err := proxyFunc(p.ProxyURL)
+	if err != nil {
+		slog.Warn("Failed to configure proxy for API profile, using environment", "profile", name, "error", err)
+		proxy = http.ProxyFromEnvironment
+	}
 
-	if body != nil {
-		reqBody, err = json.Marshal(body)
+	transport := newTransport(p, proxy)
+	tlsConfig, err := newTLSConfig(p)
+	if err != nil {
+		slog.Warn("Failed to configure TLS for API profile", "profile", name, "error", err)
+	} else {
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	authType := p.Auth.Type
+	if authType == "" {
+		authType = "Bearer"
+	}
+
+	maxResponseBytes := p.MaxResponseBytes
+	if maxResponseBytes <= 0 {
+		maxResponseBytes = DefaultMaxResponseBytes
+	}
+
+	redact := map[string]bool{"Authorization": true}
+	for _, name := range p.RedactHeaders {
+		redact[http.CanonicalHeaderKey(name)] = true
+	}
+
+	maxRedirects := DefaultMaxRedirects
+	if p.MaxRedirects != nil {
+		maxRedirects = *p.MaxRedirects
+	}
+
+	var b *breaker
+	if p.CircuitBreaker.FailureThreshold > 0 {
+		cooldown := p.CircuitBreaker.Cooldown
+		if cooldown <= 0 {
+			cooldown = 30 * time.Second
+		}
+		b = newBreaker(p.CircuitBreaker.FailureThreshold, cooldown)
+	}
+
+	return &profile{
+		client: &http.Client{
+			Timeout:       p.Timeout,
+			Transport:     transport,
+			CheckRedirect: checkRedirect(maxRedirects),
+		},
+		baseURL:   p.BaseURL,
+		headers:   p.Headers,
+		authToken: p.Auth.Token,
+		authType:  authType,
+		userAgent: p.UserAgent,
+		retry:     newRetryPolicy(p),
+		breaker:   b,
+
+		maxResponseBytes: maxResponseBytes,
+		truncateResponse: p.TruncateResponse,
+
+		logRequests: p.LogRequests,
+		redact:      redact,
+	}
+}
+
+func newTLSConfig(p config.APIProxy) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: p.TLS.InsecureSkipVerify,
+	}
+
+	if p.TLS.CAFile != "" {
+		caCert, err := os.ReadFile(p.TLS.CAFile)
 		if err != nil {
-			return nil, fmt.Errorf("failed to marshal payload: %w", err)
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caCert) {
+			return nil, fmt.Errorf("no certificates found in %s", p.TLS.CAFile)
 		}
+		tlsConfig.RootCAs = pool
 	}
 
-	// Create HTTP request
-	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(reqBody))
+	if p.TLS.CertFile != "" || p.TLS.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(p.TLS.CertFile, p.TLS.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// proxyFunc returns the proxy selector for proxyURL. net/http dials SOCKS5
+// proxies itself, so socks5:// URLs need no extra dialer. An empty URL
+// uses the HTTP_PROXY/HTTPS_PROXY/NO_PROXY environment.
+func proxyFunc(proxyURL string) (func(*http.Request) (*url.URL, error), error) {
+	if proxyURL == "" {
+		return http.ProxyFromEnvironment, nil
+	}
+	u, err := url.Parse(proxyURL)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
+		return nil, fmt.Errorf("invalid proxy_url: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("unsupported proxy_url scheme %q", u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("proxy_url %q has no host", proxyURL)
 	}
+	return http.ProxyURL(u), nil
+}
 
-	// Set default headers
-	if reqBody != nil {
-		req.Header.Set("Content-Type", "application/json")
+// profile returns the named profile, or the default api_proxy when name is empty.
+func (c *APIClient) profile(name string) (*profile, error) {
+	if name == "" {
+		return c.fallback, nil
+	}
+	p, ok := c.profiles[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown API profile %q", name)
 	}
+	return p, nil
+}
 
-	// Add custom headers from config
-	for key, value := range c.headers {
-		if _, exists := headers[key]; !exists {
-			req.Header.Set(key, value)
-		}
+func (c *APIClient) ExecuteAPICall(ctx context.Context, profileName string, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
+	p, err := c.profile(profileName)
+	if err != nil {
+		return nil, err
 	}
+	fullURL, err := p.resolve(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.hosts.Permit(ctx, fullURL); err != nil {
+		return nil, err
+	}
+	return c.executeHTTPRequest(withHostPolicy(ctx, p.hosts), p, fullURL, method, headers, body)
+}
 
-	// Add custom headers from request
-	for key, value := range headers {
-		req.Header.Set(key, value)
+func (c *APIClient) ExecuteHTTPRequest(ctx context.Context, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
+	if err := c.httpHosts.Permit(ctx, url); err != nil {
+		return nil, err
 	}
+	return c.executeHTTPRequest(withHostPolicy(ctx, c.httpHosts), c.fallback, url, method, headers, body)
+}
 
-	// Add authentication header if token is provided and not in request headers
-	if c.authToken != "" {
-		if _, exists := headers["Authorization"]; !exists {
-			authType := c.config.APIProxy.Auth.Type
-			if authType == "" {
-				authType = "Bearer"
+func (c *APIClient) executeHTTPRequest(ctx context.Context, p *profile, url string, method string, headers map[string]string, body interface{}) (*APIResponse, error) {
+	reqBody, contentType, err := encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+
+	if p.breaker != nil {
+		allowed, cooldown := p.breaker.allow()
+		if !allowed {
+			if cooldown > 0 {
+				return nil, fmt.Errorf("%w: upstream %s is failing, retrying in %s", ErrCircuitOpen, p.baseURL, cooldown.Round(time.Millisecond))
 			}
-			req.Header.Set("Authorization", fmt.Sprintf("%s %s", authType, c.authToken))
+			return nil, fmt.Errorf("%w: upstream %s is being probed", ErrCircuitOpen, p.baseURL)
 		}
 	}
 
-	// Execute request
-	resp, err := c.client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to execute request: %w", err)
+	// Retry transient failures, giving up early if the next attempt
+	// could not start before the context deadline
+	maxAttempts := p.retry.attempts(method)
+	var upstream *upstreamResponse
+	var status int
+	for attempt := 1; ; attempt++ {
+		upstream, err = c.doRequest(ctx, p, url, method, headers, reqBody, contentType)
+		if upstream != nil {
+			status = upstream.status
+		}
+
+		transient := err != nil && ctx.Err() == nil && !errors.Is(err, ErrResponseTooLarge) && !redirectRefused(err)
+		retryable := transient || (err == nil && p.retry.statuses[status])
+		if !retryable || attempt >= maxAttempts {
+			break
+		}
+
+		delay := p.retry.delay(attempt)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			break
+		}
+		if err != nil {
+			slog.WarnContext(ctx, fmt.Sprintf("Upstream request %s %s failed (attempt %d/%d), retrying in %s", method, url, attempt, maxAttempts, delay), "error", err)
+		} else {
+			slog.WarnContext(ctx, fmt.Sprintf("Upstream request %s %s returned %d (attempt %d/%d), retrying in %s", method, url, status, attempt, maxAttempts, delay))
+		}
+		if transport.Sleep(ctx, delay) != nil {
+			break
+		}
+	}
+
+	if p.breaker != nil {
+		switch {
+		case err != nil && (ctx.Err() != nil || errors.Is(err, ErrResponseTooLarge) || redirectRefused(err)):
+			p.breaker.record(outcomeIgnored)
+		case err != nil || status >= 500:
+			p.breaker.record(outcomeFailure)
+		default:
+			p.breaker.record(outcomeSuccess)
+		}
 	}
-	defer resp.Body.Close()
 
-	// Read response body
-	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read response body: %w", err)
+		return nil, err
 	}
+	responseBody := upstream.body
+
+	respHeaders := flattenHeaders(upstream.headers)
 
 	var apiResp APIResponse
 
-	// Check HTTP status
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		fmt.Errorf("API request failed with status %d: %s", resp.StatusCode, string(responseBody))
+	// An oversized response is only returned as a preview
+	if upstream.truncated {
+		slog.WarnContext(ctx, "API response truncated", "status", status, "bytes", len(responseBody))
+		apiResp.StatusCode = status
+		apiResp.Headers = respHeaders
+		apiResp.Success = status >= 200 && status < 300
+		apiResp.Data = map[string]interface{}{
+			"preview":        string(responseBody),
+			"content_length": upstream.contentLength,
+			"truncated":      true,
+		}
+		if !apiResp.Success {
+			apiResp.Error = fmt.Sprintf("API request failed with status %d", status)
+		}
 		return &apiResp, nil
 	}
 
-	// Parse response
+	// Check HTTP status; the upstream's error body is passed through as data
+	if status < 200 || status >= 300 {
+		slog.WarnContext(ctx, "API request failed", "status", status, "body", logging.RedactJSON(responseBody))
+		apiResp.StatusCode = status
+		apiResp.Headers = respHeaders
+		apiResp.Error = fmt.Sprintf("API request failed with status %d: %s", status, string(responseBody))
+		var errorBody interface{}
+		if err := json.Unmarshal(responseBody, &errorBody); err == nil {
+			apiResp.Data = errorBody
+		}
+		return &apiResp, nil
+	}
 
+	// Parse response
 	if err := json.Unmarshal(responseBody, &apiResp); err != nil {
 		var apiRespI interface{}
 
 		if err := json.Unmarshal(responseBody, &apiRespI); err != nil {
 			// Log the actual response for debugging
-			log.Printf("Raw response (status %d): %s", resp.StatusCode, string(responseBody))
+			slog.DebugContext(ctx, "Raw response", "status", status, "body", string(responseBody))
 			return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 		}
-		apiResp.Success = resp.StatusCode == 200
+		apiResp.Success = status == 200
 		apiResp.Data = apiRespI
 		apiResp.Error = string(responseBody)
 	}
+	apiResp.StatusCode = status
+	apiResp.Headers = respHeaders
 
-	log.Printf("API response: %+v", &apiResp)
+	slog.DebugContext(ctx, "API response", "response", logging.Redact(&apiResp))
 
 	return &apiResp, nil
 }
 
+// redactedHeaders returns h for logging with sensitive values masked.
+func (p *profile) redactedHeaders(h http.Header) map[string]string {
+	out := make(map[string]string, len(h))
+	for key, values := range h {
+		if p.redact[key] {
+			out[key] = "[REDACTED]"
+			continue
+		}
+		out[key] = strings.Join(values, ", ")
+	}
+	return out
+}
+
+// maxDrainBytes bounds how much unread response body is discarded to keep
+// a connection reusable; larger leftovers are cheaper to drop with the connection.
+const maxDrainBytes = 256 * 1024
+
+// drainAndClose discards what is left of body so the keep-alive connection
+// goes back to the pool, then closes it.
+func drainAndClose(body io.ReadCloser) {
+	io.Copy(io.Discard, io.LimitReader(body, maxDrainBytes))
+	body.Close()
+}
+
+func flattenHeaders(h http.Header) map[string]string {
+	if len(h) == 0 {
+		return nil
+	}
+	flat := make(map[string]string, len(h))
+	for key, values := range h {
+		flat[key] = strings.Join(values, ", ")
+	}
+	return flat
+}
+
+// encodeBody prepares a request body: strings and bytes are sent verbatim,
+// anything else is encoded as JSON.
+func encodeBody(body interface{}) ([]byte, string, error) {
+	switch b := body.(type) {
+	case nil:
+		return nil, "", nil
+	case string:
+		return []byte(b), "text/plain; charset=utf-8", nil
+	case []byte:
+		return b, "application/octet-stream", nil
+	default:
+		reqBody, err := json.Marshal(body)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to marshal payload: %w", err)
+		}
+		return reqBody, "application/json", nil
+	}
+}
+
+// newRequest builds the upstream request with the profile's headers and
+// authentication applied.
+func newRequest(ctx context.Context, p *profile, url string, method string, headers map[string]string, reqBody []byte, contentType string) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set default headers; a Content-Type from config or the caller wins
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	// Add custom headers from config
+	for key, value := range p.headers {
+		if _, exists := headers[key]; !exists {
+			req.Header.Set(key, value)
+		}
+	}
+
+	// Add custom headers from request
+	for key, value := range headers {
+		req.Header.Set(key, value)
+	}
+
+	if req.Header.Get("User-Agent") == "" && p.userAgent != "" {
+		req.Header.Set("User-Agent", p.userAgent)
+	}
+	if req.Header.Get("Accept-Encoding") == "" {
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+	}
+
+	setTraceHeaders(ctx, req)
+
+	// Add authentication header if token is provided and not in request headers
+	if p.authToken != "" {
+		if _, exists := headers["Authorization"]; !exists {
+			req.Header.Set("Authorization", fmt.Sprintf("%s %s", p.authType, p.authToken))
+		}
+	}
+	return req, nil
+}
+
+// doRequest sends a single request and reads up to max_response_bytes of the response.
+func (c *APIClient) doRequest(ctx context.Context, p *profile, url string, method string, headers map[string]string, reqBody []byte, contentType string) (*upstreamResponse, error) {
+	req, err := newRequest(ctx, p, url, method, headers, reqBody, contentType)
+	if err != nil {
+		return nil, err
+	}
+
+	// Execute request
+	start := time.Now()
+	resp, err := p.client.Do(req)
+	if err != nil {
+		if p.logRequests {
+			log.Printf("Upstream %s %s headers=%v failed after %s: %v", method, url, p.redactedHeaders(req.Header), time.Since(start), err)
+		}
+		return nil, fmt.Errorf("failed to execute request: %w", err)
+	}
+	defer drainAndClose(resp.Body)
+
+	if p.logRequests {
+		log.Printf("Upstream %s %s headers=%v -> %d in %s", method, url, p.redactedHeaders(req.Header), resp.StatusCode, time.Since(start))
+	}
+
+	// The limit applies to the decoded body, so a small compressed
+	// response cannot expand past it
+	decoded, err := decodeBody(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	// Read at most one byte past the limit to tell whether it was exceeded
+	responseBody, err := io.ReadAll(io.LimitReader(decoded, p.maxResponseBytes+1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	upstream := &upstreamResponse{
+		status:        resp.StatusCode,
+		body:          responseBody,
+		headers:       resp.Header,
+		contentLength: resp.ContentLength,
+	}
+	if int64(len(responseBody)) > p.maxResponseBytes {
+		if !p.truncateResponse {
+			return nil, fmt.Errorf("%w: exceeds max_response_bytes (%d)", ErrResponseTooLarge, p.maxResponseBytes)
+		}
+		upstream.body = responseBody[:p.maxResponseBytes]
+		upstream.truncated = true
+	}
+	return upstream, nil
+}
+
 func (c *APIClient) HealthCheck(ctx context.Context) error {
-	url := c.baseURL + "/health"
+	url, err := joinURL(c.fallback.baseURL, "/health")
+	if err != nil {
+		return err
+	}
 
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return fmt.Errorf("failed to create health check request: %w", err)
 	}
+	if c.fallback.userAgent != "" {
+		req.Header.Set("User-Agent", c.fallback.userAgent)
+	}
 
-	resp, err := c.client.Do(req)
+	resp, err := c.fallback.client.Do(req)
 	if err != nil {
 		return fmt.Errorf("health check failed: %w", err)
 	}
-	defer resp.Body.Close()
+	defer drainAndClose(resp.Body)
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("health check failed with status: %d", resp.StatusCode)
@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestExecuteAPICallUsesNamedProfile(t *testing.T) {
+	var gotPath, gotAuth string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotPath = r.URL.Path
+		gotAuth = r.Header.Get("Authorization")
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://127.0.0.1:1"
+	cfg.APIProxy.Auth.Token = "default-token"
+
+	billing := config.APIProxy{BaseURL: server.URL}
+	billing.Auth.Token = "billing-token"
+	billing.Auth.Type = "Token"
+	cfg.APIProfiles = map[string]config.APIProxy{"billing": billing}
+
+	client := NewAPIClient(cfg)
+	resp, err := client.ExecuteAPICall(context.Background(), "billing", "/invoices", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected success response, got %+v", resp)
+	}
+	if gotPath != "/invoices" {
+		t.Errorf("Expected request to /invoices on billing base URL, got %q", gotPath)
+	}
+	if gotAuth != "Token billing-token" {
+		t.Errorf("Expected billing profile auth, got %q", gotAuth)
+	}
+}
+
+func TestExecuteAPICallUnknownProfile(t *testing.T) {
+	client := NewAPIClient(&config.Config{})
+	if _, err := client.ExecuteAPICall(context.Background(), "missing", "/", "GET", nil, nil); err == nil {
+		t.Error("Expected error for unknown profile, got nil")
+	}
+}
+
+func TestExecuteHTTPRequestErrorStatus(t *testing.T) {
+	for _, status := range []int{http.StatusBadRequest, http.StatusNotFound, http.StatusInternalServerError} {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+			// A body that would parse as a successful APIResponse
+			w.Write([]byte(`{"success": true, "data": {"reason": "upstream"}}`))
+		}))
+
+		cfg := &config.Config{}
+		cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+		resp, err := NewAPIClient(cfg).ExecuteHTTPRequest(context.Background(), server.URL, "GET", nil, nil)
+		server.Close()
+		if err != nil {
+			t.Fatalf("ExecuteHTTPRequest failed: %v", err)
+		}
+		if resp.Success {
+			t.Errorf("Status %d: expected failure, got success", status)
+		}
+		if resp.StatusCode != status {
+			t.Errorf("Status %d: expected status_code %d, got %d", status, status, resp.StatusCode)
+		}
+		if !strings.Contains(resp.Error, fmt.Sprintf("status %d", status)) {
+			t.Errorf("Status %d: expected error to mention the status, got %q", status, resp.Error)
+		}
+	}
+}
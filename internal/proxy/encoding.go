@@ -0,0 +1,65 @@
+package proxy
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// acceptEncoding is advertised on every upstream request unless the caller
+// sets its own. net/http only decompresses transparently when it added the
+// header itself, so decodeBody handles the response side for any transport.
+const acceptEncoding = "gzip, deflate"
+
+// decodeBody returns resp's body with a gzip or deflate Content-Encoding
+// removed, and updates resp's headers to describe the decoded body. Other
+// encodings are passed through untouched.
+func decodeBody(resp *http.Response) (io.Reader, error) {
+	encoding := strings.ToLower(strings.TrimSpace(resp.Header.Get("Content-Encoding")))
+	if encoding != "gzip" && encoding != "deflate" {
+		return resp.Body, nil
+	}
+
+	body := bufio.NewReader(resp.Body)
+	// HEAD requests and 204s carry the header without a body
+	if _, err := body.Peek(1); err == io.EOF {
+		return body, nil
+	}
+
+	var decoded io.Reader
+	switch encoding {
+	case "gzip":
+		r, err := gzip.NewReader(body)
+		if err != nil {
+			return nil, fmt.Errorf("invalid gzip response body: %w", err)
+		}
+		decoded = r
+	case "deflate":
+		// "deflate" should be zlib-wrapped, but some servers send raw deflate
+		if header, err := body.Peek(2); err == nil && isZlibHeader(header) {
+			r, err := zlib.NewReader(body)
+			if err != nil {
+				return nil, fmt.Errorf("invalid deflate response body: %w", err)
+			}
+			decoded = r
+		} else {
+			decoded = flate.NewReader(body)
+		}
+	}
+
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return decoded, nil
+}
+
+// isZlibHeader reports whether b starts a zlib stream (RFC 1950).
+func isZlibHeader(b []byte) bool {
+	return b[0]&0x0f == 8 && (uint16(b[0])<<8|uint16(b[1]))%31 == 0
+}
@@ -0,0 +1,88 @@
+package proxy
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"context"
+	"edge-agent/internal/config"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func compressed(t *testing.T, encoding, body string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	switch encoding {
+	case "gzip":
+		w = gzip.NewWriter(&buf)
+	case "deflate":
+		w = zlib.NewWriter(&buf)
+	case "raw-deflate":
+		w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+	}
+	w.Write([]byte(body))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestAPIClientDecodesCompressedResponses(t *testing.T) {
+	for _, encoding := range []string{"gzip", "deflate", "raw-deflate"} {
+		t.Run(encoding, func(t *testing.T) {
+			var accepted string
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				accepted = r.Header.Get("Accept-Encoding")
+				header := encoding
+				if header == "raw-deflate" {
+					header = "deflate"
+				}
+				w.Header().Set("Content-Encoding", header)
+				w.Header().Set("Content-Type", "application/json")
+				w.Write(compressed(t, encoding, `{"success": true, "data": {"answer": 42}}`))
+			}))
+			defer server.Close()
+
+			cfg := &config.Config{}
+			cfg.APIProxy.BaseURL = server.URL
+
+			resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/", "GET", nil, nil)
+			if err != nil {
+				t.Fatalf("ExecuteAPICall failed: %v", err)
+			}
+			if accepted != acceptEncoding {
+				t.Errorf("Expected Accept-Encoding %q, got %q", acceptEncoding, accepted)
+			}
+			data, ok := resp.Data.(map[string]interface{})
+			if !resp.Success || !ok || data["answer"] != float64(42) {
+				t.Errorf("Expected the decoded JSON body, got %+v", resp)
+			}
+			if _, ok := resp.Headers["Content-Encoding"]; ok {
+				t.Errorf("Expected Content-Encoding to be removed from the decoded response, got %v", resp.Headers)
+			}
+		})
+	}
+}
+
+func TestAPIClientKeepsCallerAcceptEncoding(t *testing.T) {
+	var accepted string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		accepted = r.Header.Get("Accept-Encoding")
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+
+	_, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/", "GET", map[string]string{"Accept-Encoding": "identity"}, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if accepted != "identity" {
+		t.Errorf("Expected the caller's Accept-Encoding to be sent, got %q", accepted)
+	}
+}
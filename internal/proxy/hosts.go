@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// ErrHostNotAllowed is returned when a request's destination is refused by
+// the host policy.
+var ErrHostNotAllowed = errors.New("destination host not allowed")
+
+// blockedNetworks are refused unless a host is explicitly allowed:
+// loopback, link-local (which includes the 169.254.169.254 metadata
+// service), unspecified addresses and other cloud metadata endpoints.
+var blockedNetworks = mustParseCIDRs(
+	"127.0.0.0/8",
+	"::1/128",
+	"169.254.0.0/16",
+	"fe80::/10",
+	"0.0.0.0/8",
+	"::/128",
+	"100.100.100.200/32", // Alibaba Cloud metadata
+	"fd00:ec2::254/128",  // AWS metadata over IPv6
+)
+
+func mustParseCIDRs(cidrs ...string) []*net.IPNet {
+	networks := make([]*net.IPNet, len(cidrs))
+	for i, cidr := range cidrs {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			panic(err)
+		}
+		networks[i] = network
+	}
+	return networks
+}
+
+// HostPolicy decides which hosts a request may be sent to. Entries are a
+// host name or IP, "*.example.com" for any subdomain, or a CIDR such as
+// "10.0.0.0/8". A host must match the allowlist (when it is not empty) and
+// no deny entry. Without an allowlist match, hosts resolving to a blocked
+// network are refused.
+type HostPolicy struct {
+	err     error
+	allowed hostMatcher
+	denied  hostMatcher
+	// trusted hosts, such as an api_call's base URL, skip every check
+	trusted map[string]bool
+	// lookup resolves host names; tests swap it out
+	lookup func(ctx context.Context, host string) ([]net.IP, error)
+}
+
+// NewHostPolicy parses the allow and deny lists. trusted hosts are always
+// permitted. If an entry is invalid the returned policy refuses every host
+// and the error is also returned.
+func NewHostPolicy(allowed, denied []string, trusted ...string) (*HostPolicy, error) {
+	p := &HostPolicy{trusted: make(map[string]bool), lookup: lookupIP}
+	var err error
+	if p.allowed, err = parseHostMatcher(allowed); err != nil {
+		p.err = fmt.Errorf("invalid allowed_hosts: %w", err)
+		return p, p.err
+	}
+	if p.denied, err = parseHostMatcher(denied); err != nil {
+		p.err = fmt.Errorf("invalid denied_hosts: %w", err)
+		return p, p.err
+	}
+	for _, host := range trusted {
+		if host != "" {
+			p.trusted[normalizeHost(host)] = true
+		}
+	}
+	return p, nil
+}
+
+func lookupIP(ctx context.Context, host string) ([]net.IP, error) {
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, host)
+	if err != nil {
+		return nil, err
+	}
+	ips := make([]net.IP, len(addrs))
+	for i, addr := range addrs {
+		ips[i] = addr.IP
+	}
+	return ips, nil
+}
+
+// listed reports whether host is explicitly in the allowlist.
+func (p *HostPolicy) listed(host string) bool {
+	return p != nil && p.err == nil && p.allowed.matchHost(normalizeHost(host))
+}
+
+// Permit returns an error wrapping ErrHostNotAllowed if rawURL may not be
+// requested, or nil.
+func (p *HostPolicy) Permit(ctx context.Context, rawURL string) error {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	host := normalizeHost(u.Hostname())
+	if host == "" {
+		return fmt.Errorf("%w: URL %q has no host", ErrHostNotAllowed, rawURL)
+	}
+	if p == nil {
+		return nil
+	}
+	return p.permitHost(host, func() ([]net.IP, error) {
+		if ip := net.ParseIP(host); ip != nil {
+			return []net.IP{ip}, nil
+		}
+		return p.lookup(ctx, host)
+	})
+}
+
+// permitConn checks ip, the address a connection to host is about to use.
+// Permit resolves host before the request is made and the transport
+// resolves it again when it dials, so only this check sees the address
+// actually reached if the DNS answer changed in between.
+func (p *HostPolicy) permitConn(host string, ip net.IP) error {
+	if p == nil {
+		return nil
+	}
+	return p.permitHost(normalizeHost(host), func() ([]net.IP, error) {
+		return []net.IP{ip}, nil
+	})
+}
+
+// permitHost applies the policy to host, calling resolve for the
+// addresses to check when no allowlist entry decides.
+func (p *HostPolicy) permitHost(host string, resolve func() ([]net.IP, error)) error {
+	if p.err != nil {
+		return fmt.Errorf("%w: %v", ErrHostNotAllowed, p.err)
+	}
+	if p.trusted[host] {
+		return nil
+	}
+
+	if p.denied.matchHost(host) {
+		return fmt.Errorf("%w: %s is in denied_hosts", ErrHostNotAllowed, host)
+	}
+	if len(p.allowed.hosts)+len(p.allowed.networks) > 0 {
+		if !p.allowed.matchHost(host) {
+			return fmt.Errorf("%w: %s is not in allowed_hosts", ErrHostNotAllowed, host)
+		}
+		return nil
+	}
+
+	// Fail closed: a host that cannot be checked is not requested
+	ips, err := resolve()
+	if err != nil {
+		return fmt.Errorf("%w: cannot resolve %s: %v", ErrHostNotAllowed, host, err)
+	}
+	for _, ip := range ips {
+		if p.denied.matchIP(ip) {
+			return fmt.Errorf("%w: %s resolves to %s, which is in denied_hosts", ErrHostNotAllowed, host, ip)
+		}
+		for _, network := range blockedNetworks {
+			if network.Contains(ip) {
+				return fmt.Errorf("%w: %s resolves to %s (loopback, link-local or metadata address); add it to allowed_hosts to permit it", ErrHostNotAllowed, host, ip)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeHost lowercases host and drops a trailing dot.
+func normalizeHost(host string) string {
+	return strings.TrimSuffix(strings.ToLower(host), ".")
+}
+
+type hostMatcher struct {
+	hosts    []string // exact names, or ".example.com" for subdomains
+	networks []*net.IPNet
+}
+
+func parseHostMatcher(entries []string) (hostMatcher, error) {
+	var m hostMatcher
+	for _, entry := range entries {
+		entry = normalizeHost(strings.TrimSpace(entry))
+		switch {
+		case entry == "":
+			continue
+		case strings.Contains(entry, "/"):
+			_, network, err := net.ParseCIDR(entry)
+			if err != nil {
+				return m, fmt.Errorf("entry %q: %w", entry, err)
+			}
+			m.networks = append(m.networks, network)
+		case strings.HasPrefix(entry, "*."):
+			m.hosts = append(m.hosts, entry[1:])
+		default:
+			m.hosts = append(m.hosts, entry)
+		}
+	}
+	return m, nil
+}
+
+// matchHost reports whether host matches a name entry, or is an IP inside
+// a CIDR entry.
+func (m hostMatcher) matchHost(host string) bool {
+	for _, entry := range m.hosts {
+		if host == entry || (strings.HasPrefix(entry, ".") && strings.HasSuffix(host, entry)) {
+			return true
+		}
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		return m.matchIP(ip)
+	}
+	return false
+}
+
+// matchIP reports whether ip is listed or inside a CIDR entry.
+func (m hostMatcher) matchIP(ip net.IP) bool {
+	for _, network := range m.networks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	for _, entry := range m.hosts {
+		if listed := net.ParseIP(entry); listed != nil && listed.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// dialGuard dials the connections of an http.Transport, checking each
+// address against the host policy of the request it is made for.
+type dialGuard struct {
+	dialer *net.Dialer
+	// proxies holds the hosts of the proxies requests were routed through;
+	// the proxy, not the agent, connects to the destination
+	proxies sync.Map
+}
+
+// proxy wraps a Transport.Proxy function to remember the proxies it picks.
+func (g *dialGuard) proxy(next func(*http.Request) (*url.URL, error)) func(*http.Request) (*url.URL, error) {
+	return func(req *http.Request) (*url.URL, error) {
+		u, err := next(req)
+		if u != nil {
+			g.proxies.Store(normalizeHost(u.Hostname()), true)
+		}
+		return u, err
+	}
+}
+
+func (g *dialGuard) DialContext(ctx context.Context, network, addr string) (net.Conn, error) {
+	policy, _ := ctx.Value(hostPolicyKey{}).(*HostPolicy)
+	host, _, err := net.SplitHostPort(addr)
+	if policy == nil || err != nil {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+	if _, ok := g.proxies.Load(normalizeHost(host)); ok {
+		return g.dialer.DialContext(ctx, network, addr)
+	}
+
+	dialer := *g.dialer
+	dialer.Control = func(_, address string, _ syscall.RawConn) error {
+		ip, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		return policy.permitConn(host, net.ParseIP(ip))
+	}
+	return dialer.DialContext(ctx, network, addr)
+}
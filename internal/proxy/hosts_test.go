@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHostPolicyPermit(t *testing.T) {
+	resolve := map[string][]net.IP{
+		"api.example.com":   {net.ParseIP("93.184.216.34")},
+		"metadata.internal": {net.ParseIP("169.254.169.254")},
+	}
+	lookup := func(ctx context.Context, host string) ([]net.IP, error) {
+		if ips, ok := resolve[host]; ok {
+			return ips, nil
+		}
+		return nil, errors.New("no such host")
+	}
+
+	tests := []struct {
+		name    string
+		allowed []string
+		denied  []string
+		url     string
+		ok      bool
+	}{
+		{"public host", nil, nil, "https://api.example.com/v1", true},
+		{"metadata IP", nil, nil, "http://169.254.169.254/latest/meta-data/", false},
+		{"loopback", nil, nil, "http://127.0.0.1:8080/", false},
+		{"IPv6 loopback", nil, nil, "http://[::1]:8080/", false},
+		{"name resolving to metadata", nil, nil, "http://metadata.internal/", false},
+		{"unresolvable host", nil, nil, "http://nowhere.invalid/", false},
+		{"denied host", nil, []string{"api.example.com"}, "https://API.example.com/", false},
+		{"denied CIDR via lookup", nil, []string{"93.184.0.0/16"}, "https://api.example.com/", false},
+		{"allowed host", []string{"api.example.com"}, nil, "https://api.example.com/", true},
+		{"not in allowlist", []string{"api.example.com"}, nil, "https://other.example.com/", false},
+		{"wildcard", []string{"*.example.com"}, nil, "https://a.b.example.com/", true},
+		{"wildcard excludes apex", []string{"*.example.com"}, nil, "https://example.com/", false},
+		{"explicitly allowed loopback", []string{"127.0.0.0/8"}, nil, "http://127.0.0.1:8080/", true},
+		{"deny wins over allow", []string{"*.example.com"}, []string{"api.example.com"}, "https://api.example.com/", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			policy, err := NewHostPolicy(tt.allowed, tt.denied)
+			if err != nil {
+				t.Fatalf("NewHostPolicy failed: %v", err)
+			}
+			policy.lookup = lookup
+			err = policy.Permit(context.Background(), tt.url)
+			if tt.ok && err != nil {
+				t.Errorf("Expected %s to be permitted, got %v", tt.url, err)
+			}
+			if !tt.ok && !errors.Is(err, ErrHostNotAllowed) {
+				t.Errorf("Expected %s to be refused, got %v", tt.url, err)
+			}
+		})
+	}
+}
+
+func TestHostPolicyInvalidEntryRefusesAll(t *testing.T) {
+	policy, err := NewHostPolicy([]string{"10.0.0.0/99"}, nil)
+	if err == nil {
+		t.Fatal("Expected an invalid CIDR to be reported")
+	}
+	if err := policy.Permit(context.Background(), "https://api.example.com/"); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected every host to be refused, got %v", err)
+	}
+}
+
+func TestAPIClientEnforcesHostPolicy(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://localhost:8089"
+	cfg.HTTPRequest.DeniedHosts = []string{"blocked.example.com"}
+	c := NewAPIClient(cfg)
+
+	if _, err := c.ExecuteHTTPRequest(context.Background(), "http://169.254.169.254/latest/meta-data/", "GET", nil, nil); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected the metadata service to be refused, got %v", err)
+	}
+	if _, err := c.PlanHTTPRequest("https://blocked.example.com/", "GET", nil, nil); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected a denied host to be refused, got %v", err)
+	}
+	// The api_proxy base URL is trusted even though it is loopback
+	if _, err := c.PlanAPICall("", "/status", "GET", nil, nil); err != nil {
+		t.Errorf("Expected api_call to its base URL to be permitted, got %v", err)
+	}
+}
+
+func TestHostPolicyCheckedAgainstDialedAddress(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://api.invalid"
+	c := NewAPIClient(cfg)
+	// The check sees a public address; the dial then resolves localhost
+	// to loopback, as a rebinding DNS server would answer
+	c.httpHosts.lookup = func(ctx context.Context, host string) ([]net.IP, error) {
+		return []net.IP{net.ParseIP("93.184.216.34")}, nil
+	}
+
+	target := strings.Replace(server.URL, "127.0.0.1", "localhost", 1)
+	_, err := c.ExecuteHTTPRequest(context.Background(), target, "GET", nil, nil)
+	if !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected the loopback address dialed for %s to be refused, got %v", target, err)
+	}
+	if n := hits.Load(); n != 0 {
+		t.Errorf("Expected no request to reach the server, got %d", n)
+	}
+}
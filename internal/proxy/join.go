@@ -0,0 +1,67 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// joinURL appends the path of ref to base with exactly one slash between
+// them, whatever slashes either side has. The query and fragment come
+// from ref, with any query on base kept in front. ref must be relative.
+func joinURL(base, ref string) (string, error) {
+	b, err := url.Parse(base)
+	if err != nil {
+		return "", fmt.Errorf("invalid base URL %q: %w", base, err)
+	}
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", ref, err)
+	}
+	if r.IsAbs() || r.Host != "" {
+		return "", fmt.Errorf("url %q is not relative", ref)
+	}
+
+	joined := *b
+	if path := strings.TrimLeft(r.EscapedPath(), "/"); path != "" || strings.HasSuffix(r.Path, "/") {
+		escaped := strings.TrimRight(b.EscapedPath(), "/") + "/" + path
+		joined.Path, err = url.PathUnescape(escaped)
+		if err != nil {
+			return "", fmt.Errorf("invalid url %q: %w", ref, err)
+		}
+		joined.RawPath = escaped
+	}
+	switch {
+	case b.RawQuery == "":
+		joined.RawQuery = r.RawQuery
+	case r.RawQuery != "":
+		joined.RawQuery = b.RawQuery + "&" + r.RawQuery
+	}
+	joined.Fragment, joined.RawFragment = r.Fragment, r.RawFragment
+	return joined.String(), nil
+}
+
+// resolve returns the URL an api_call to ref on p requests. A relative
+// ref is joined to the base URL. An absolute ref must be on the base URL's
+// host or on one listed in api_call.allowed_hosts.
+func (p *profile) resolve(ref string) (string, error) {
+	r, err := url.Parse(ref)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", ref, err)
+	}
+	if !r.IsAbs() && r.Host == "" {
+		return joinURL(p.baseURL, ref)
+	}
+
+	host := r.Hostname()
+	if strings.EqualFold(host, urlHost(p.baseURL)) || p.hosts.listed(host) {
+		if r.Scheme == "" {
+			r.Scheme = "https"
+			if b, err := url.Parse(p.baseURL); err == nil && b.Scheme != "" {
+				r.Scheme = b.Scheme
+			}
+		}
+		return r.String(), nil
+	}
+	return "", fmt.Errorf("%w: absolute url to %s is outside the api_proxy base URL; add it to api_call.allowed_hosts", ErrHostNotAllowed, host)
+}
@@ -0,0 +1,77 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"errors"
+	"testing"
+)
+
+func TestJoinURL(t *testing.T) {
+	tests := []struct {
+		base, ref, expected string
+	}{
+		{"http://host", "/api", "http://host/api"},
+		{"http://host", "api", "http://host/api"},
+		{"http://host/", "/api", "http://host/api"},
+		{"http://host/", "api", "http://host/api"},
+		{"http://host//", "/api", "http://host/api"},
+		{"http://host/v1", "/status", "http://host/v1/status"},
+		{"http://host/v1/", "status", "http://host/v1/status"},
+		{"http://host/v1", "status/", "http://host/v1/status/"},
+		{"http://host/v1", "", "http://host/v1"},
+		{"http://host/v1", "/", "http://host/v1/"},
+		{"http://host:8089", "/items?id=7", "http://host:8089/items?id=7"},
+		{"http://host/v1?key=abc", "/items?id=7", "http://host/v1/items?key=abc&id=7"},
+		{"http://host/v1", "/a%2Fb", "http://host/v1/a%2Fb"},
+		{"http://host", "/page#top", "http://host/page#top"},
+	}
+	for _, tt := range tests {
+		got, err := joinURL(tt.base, tt.ref)
+		if err != nil {
+			t.Errorf("joinURL(%q, %q) failed: %v", tt.base, tt.ref, err)
+			continue
+		}
+		if got != tt.expected {
+			t.Errorf("joinURL(%q, %q) = %q, expected %q", tt.base, tt.ref, got, tt.expected)
+		}
+	}
+}
+
+func TestAPICallAbsoluteURL(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://localhost:8089/api"
+	cfg.APICall.AllowedHosts = []string{"partner.example.com"}
+	c := NewAPIClient(cfg)
+
+	tests := []struct {
+		url      string
+		expected string // empty when the URL must be refused
+	}{
+		{"http://localhost:8089/other", "http://localhost:8089/other"},
+		{"https://partner.example.com/v2", "https://partner.example.com/v2"},
+		{"//localhost:8089/x", "http://localhost:8089/x"},
+		{"https://evil.example.com/steal", ""},
+		{"//evil.example.com/steal", ""},
+	}
+	for _, tt := range tests {
+		planned, err := c.PlanAPICall("", tt.url, "GET", nil, nil)
+		if tt.expected == "" {
+			if !errors.Is(err, ErrHostNotAllowed) {
+				t.Errorf("Expected %q to be refused, got %v", tt.url, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Expected %q to be permitted, got %v", tt.url, err)
+			continue
+		}
+		if planned.URL != tt.expected {
+			t.Errorf("Expected %q to resolve to %q, got %q", tt.url, tt.expected, planned.URL)
+		}
+	}
+
+	if _, err := c.ExecuteAPICall(context.Background(), "", "https://evil.example.com/", "GET", nil, nil); !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected ExecuteAPICall to refuse another host, got %v", err)
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"edge-agent/internal/config"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestRequestLoggingRedactsSecrets(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.Auth.Token = "super-secret-token"
+	cfg.APIProxy.LogRequests = true
+	cfg.APIProxy.RedactHeaders = []string{"x-api-key"}
+
+	headers := map[string]string{"X-Api-Key": "key-123", "X-Request-Id": "req-42"}
+	if _, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/orders", "GET", headers, nil); err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+
+	output := buf.String()
+	for _, secret := range []string{"super-secret-token", "key-123"} {
+		if strings.Contains(output, secret) {
+			t.Errorf("Log output leaks %q:\n%s", secret, output)
+		}
+	}
+	for _, expected := range []string{"GET " + server.URL + "/orders", "Authorization:[REDACTED]", "X-Api-Key:[REDACTED]", "X-Request-Id:req-42", "-> 202"} {
+		if !strings.Contains(output, expected) {
+			t.Errorf("Expected log output to contain %q:\n%s", expected, output)
+		}
+	}
+}
+
+func TestRequestLoggingDisabledByDefault(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer server.Close()
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	if _, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/orders", "GET", nil, nil); err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+
+	if strings.Contains(buf.String(), "Upstream GET") {
+		t.Errorf("Expected no request log without log_requests:\n%s", buf.String())
+	}
+}
@@ -0,0 +1,62 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+)
+
+// PlannedRequest describes the upstream request an API call or HTTP
+// request would send, for dry runs.
+type PlannedRequest struct {
+	Method  string            `json:"method"`
+	URL     string            `json:"url"`
+	Headers map[string]string `json:"headers"` // sensitive values are redacted
+	Body    string            `json:"body,omitempty"`
+}
+
+// PlanAPICall validates an api_call and returns the request
+// ExecuteAPICall would send, without sending it.
+func (c *APIClient) PlanAPICall(profileName string, url string, method string, headers map[string]string, body interface{}) (*PlannedRequest, error) {
+	p, err := c.profile(profileName)
+	if err != nil {
+		return nil, err
+	}
+	fullURL, err := p.resolve(url)
+	if err != nil {
+		return nil, err
+	}
+	if err := p.hosts.Permit(context.Background(), fullURL); err != nil {
+		return nil, err
+	}
+	return plan(p, fullURL, method, headers, body)
+}
+
+// PlanHTTPRequest validates an http_request and returns the request
+// ExecuteHTTPRequest would send, without sending it.
+func (c *APIClient) PlanHTTPRequest(url string, method string, headers map[string]string, body interface{}) (*PlannedRequest, error) {
+	if err := c.httpHosts.Permit(context.Background(), url); err != nil {
+		return nil, err
+	}
+	return plan(c.fallback, url, method, headers, body)
+}
+
+func plan(p *profile, url string, method string, headers map[string]string, body interface{}) (*PlannedRequest, error) {
+	reqBody, contentType, err := encodeBody(body)
+	if err != nil {
+		return nil, err
+	}
+	req, err := newRequest(context.Background(), p, url, method, headers, reqBody, contentType)
+	if err != nil {
+		return nil, err
+	}
+	if req.URL.Scheme == "" || req.URL.Host == "" {
+		return nil, fmt.Errorf("invalid URL %q: scheme and host are required", url)
+	}
+
+	return &PlannedRequest{
+		Method:  req.Method,
+		URL:     req.URL.String(),
+		Headers: p.redactedHeaders(req.Header),
+		Body:    string(reqBody),
+	}, nil
+}
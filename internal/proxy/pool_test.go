@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestKeepAliveReuse(t *testing.T) {
+	var newConns int32
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true, "data": "` + strings.Repeat("x", 100*1024) + `"}`))
+	}))
+	server.Config.ConnState = func(conn net.Conn, state http.ConnState) {
+		if state == http.StateNew {
+			atomic.AddInt32(&newConns, 1)
+		}
+	}
+	server.Start()
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.MaxIdleConns = 4
+	cfg.APIProxy.MaxConnsPerHost = 2
+	cfg.APIProxy.IdleConnTimeout = time.Minute
+	// Leave most of each body unread; that must not cost a new connection
+	cfg.APIProxy.MaxResponseBytes = 16
+	cfg.APIProxy.TruncateResponse = true
+	client := NewAPIClient(cfg)
+
+	for i := 0; i < 5; i++ {
+		if _, err := client.ExecuteAPICall(context.Background(), "", "/status", "GET", nil, nil); err != nil {
+			t.Fatalf("Request %d failed: %v", i+1, err)
+		}
+	}
+
+	if got := atomic.LoadInt32(&newConns); got != 1 {
+		t.Errorf("Expected sequential requests to reuse one connection, got %d connections", got)
+	}
+}
+
+func TestTransportPoolSettings(t *testing.T) {
+	p := config.APIProxy{MaxIdleConns: 8, MaxConnsPerHost: 3, IdleConnTimeout: 15 * time.Second}
+	transport := newTransport(p, http.ProxyFromEnvironment)
+
+	if transport.MaxIdleConns != 8 || transport.MaxIdleConnsPerHost != 8 {
+		t.Errorf("Expected 8 idle connections, got %d (%d per host)", transport.MaxIdleConns, transport.MaxIdleConnsPerHost)
+	}
+	if transport.MaxConnsPerHost != 3 || transport.IdleConnTimeout != 15*time.Second {
+		t.Errorf("Expected max_conns_per_host 3 and idle timeout 15s, got %d and %v", transport.MaxConnsPerHost, transport.IdleConnTimeout)
+	}
+}
@@ -0,0 +1,162 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHTTPProxyIsUsed(t *testing.T) {
+	var mu sync.Mutex
+	var proxied []string
+	proxyServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL
+		mu.Lock()
+		proxied = append(proxied, r.URL.String())
+		mu.Unlock()
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer proxyServer.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://upstream.invalid"
+	cfg.APIProxy.ProxyURL = proxyServer.URL
+
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/status", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall through proxy failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected success response, got %+v", resp)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(proxied) != 1 || proxied[0] != "http://upstream.invalid/status" {
+		t.Errorf("Expected the proxy to receive http://upstream.invalid/status, got %v", proxied)
+	}
+}
+
+// socks5Stub is a minimal no-auth SOCKS5 server that records CONNECT targets
+// and forwards them to backend.
+type socks5Stub struct {
+	listener net.Listener
+	backend  string
+	mu       sync.Mutex
+	targets  []string
+}
+
+func newSOCKS5Stub(t *testing.T, backend string) *socks5Stub {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5Stub{listener: l, backend: backend}
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s
+}
+
+func (s *socks5Stub) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// Greeting: version, method count, methods; reply with "no auth"
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	conn.Write([]byte{5, 0})
+
+	// Request: version, CONNECT, reserved, address type, address, port
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(conn, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case 1:
+		addr := make([]byte, 4)
+		io.ReadFull(conn, addr)
+		host = net.IP(addr).String()
+	case 3:
+		length := make([]byte, 1)
+		io.ReadFull(conn, length)
+		name := make([]byte, length[0])
+		io.ReadFull(conn, name)
+		host = string(name)
+	default:
+		return
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(conn, port); err != nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.targets = append(s.targets, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", s.backend)
+	if err != nil {
+		conn.Write([]byte{5, 1, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	conn.Write([]byte{5, 0, 0, 1, 0, 0, 0, 0, 0, 0})
+
+	go io.Copy(upstream, conn)
+	io.Copy(conn, upstream)
+}
+
+func TestSOCKS5ProxyIsUsed(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer backend.Close()
+
+	socks := newSOCKS5Stub(t, backend.Listener.Addr().String())
+	defer socks.listener.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = "http://upstream.invalid:8080"
+	cfg.APIProxy.ProxyURL = "socks5://" + socks.listener.Addr().String()
+
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/status", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall through SOCKS5 failed: %v", err)
+	}
+	if !resp.Success {
+		t.Errorf("Expected success response, got %+v", resp)
+	}
+
+	socks.mu.Lock()
+	defer socks.mu.Unlock()
+	if len(socks.targets) != 1 || socks.targets[0] != "upstream.invalid:8080" {
+		t.Errorf("Expected a SOCKS5 CONNECT to upstream.invalid:8080, got %v", socks.targets)
+	}
+}
+
+func TestProxyFuncRejectsInvalidURL(t *testing.T) {
+	for _, proxyURL := range []string{"ftp://proxy:21", "http://", "://bad"} {
+		if _, err := proxyFunc(proxyURL); err == nil {
+			t.Errorf("Expected error for proxy_url %q", proxyURL)
+		}
+	}
+}
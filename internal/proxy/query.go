@@ -0,0 +1,60 @@
+package proxy
+
+import (
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// WithQuery adds the parameters in query to rawURL, which may be relative.
+// A value may be a string, number, bool or null, or an array of those to
+// repeat the key. The URL's existing query is kept as it is and the new
+// parameters follow it, sorted by key.
+func WithQuery(rawURL string, query map[string]interface{}) (string, error) {
+	if len(query) == 0 {
+		return rawURL, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url %q: %w", rawURL, err)
+	}
+
+	values := url.Values{}
+	for key, value := range query {
+		items, ok := value.([]interface{})
+		if !ok {
+			items = []interface{}{value}
+		}
+		for _, item := range items {
+			s, err := queryValue(item)
+			if err != nil {
+				return "", fmt.Errorf("invalid query parameter %q: %w", key, err)
+			}
+			values.Add(key, s)
+		}
+	}
+
+	// Encode sorts by key but keeps the order of a repeated key's values
+	if u.RawQuery != "" {
+		u.RawQuery += "&" + values.Encode()
+	} else {
+		u.RawQuery = values.Encode()
+	}
+	return u.String(), nil
+}
+
+func queryValue(v interface{}) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case int:
+		return strconv.Itoa(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case nil:
+		return "", nil
+	}
+	return "", fmt.Errorf("unsupported value %v: expected a string, number, bool or an array of them", v)
+}
@@ -0,0 +1,40 @@
+package proxy
+
+import "testing"
+
+func TestWithQuery(t *testing.T) {
+	tests := []struct {
+		name     string
+		url      string
+		query    map[string]interface{}
+		expected string
+	}{
+		{"bare URL", "https://api.example.com/items", map[string]interface{}{"q": "a b&c", "limit": 10.0}, "https://api.example.com/items?limit=10&q=a+b%26c"},
+		{"relative path", "/items", map[string]interface{}{"active": true}, "/items?active=true"},
+		{"existing query kept", "https://api.example.com/items?sort=-date&page=2", map[string]interface{}{"limit": 20.0}, "https://api.example.com/items?sort=-date&page=2&limit=20"},
+		{"repeated keys", "/items", map[string]interface{}{"tag": []interface{}{"red", "blue", 3.5}}, "/items?tag=red&tag=blue&tag=3.5"},
+		{"null value", "/items", map[string]interface{}{"flag": nil}, "/items?flag="},
+		{"fragment kept", "/page#top", map[string]interface{}{"a": "1"}, "/page?a=1#top"},
+		{"no query", "/items?x=1", nil, "/items?x=1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := WithQuery(tt.url, tt.query)
+			if err != nil {
+				t.Fatalf("WithQuery failed: %v", err)
+			}
+			if got != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, got)
+			}
+		})
+	}
+}
+
+func TestWithQueryRejectsNestedValues(t *testing.T) {
+	if _, err := WithQuery("/items", map[string]interface{}{"filter": map[string]interface{}{"a": 1.0}}); err == nil {
+		t.Error("Expected an object value to be refused")
+	}
+	if _, err := WithQuery("/items", map[string]interface{}{"tag": []interface{}{[]interface{}{"x"}}}); err == nil {
+		t.Error("Expected a nested array to be refused")
+	}
+}
@@ -0,0 +1,54 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// DefaultMaxRedirects is used when max_redirects is not configured.
+const DefaultMaxRedirects = 10
+
+// ErrTooManyRedirects is returned when an upstream redirects more than
+// max_redirects times.
+var ErrTooManyRedirects = errors.New("too many redirects")
+
+type hostPolicyKey struct{}
+
+// withHostPolicy makes policy apply to the redirects of requests made
+// with ctx.
+func withHostPolicy(ctx context.Context, policy *HostPolicy) context.Context {
+	return context.WithValue(ctx, hostPolicyKey{}, policy)
+}
+
+// checkRedirect returns an http.Client CheckRedirect that follows at most
+// maxRedirects redirects, each to a host the request's policy permits,
+// and drops the Authorization header once the host changes. With
+// maxRedirects 0 the redirect response itself is returned.
+func checkRedirect(maxRedirects int) func(*http.Request, []*http.Request) error {
+	return func(req *http.Request, via []*http.Request) error {
+		if maxRedirects == 0 {
+			return http.ErrUseLastResponse
+		}
+		if len(via) > maxRedirects {
+			return fmt.Errorf("%w: stopped after %d", ErrTooManyRedirects, maxRedirects)
+		}
+
+		policy, _ := req.Context().Value(hostPolicyKey{}).(*HostPolicy)
+		if err := policy.Permit(req.Context(), req.URL.String()); err != nil {
+			return fmt.Errorf("redirect refused: %w", err)
+		}
+
+		if req.URL.Hostname() != via[0].URL.Hostname() {
+			req.Header.Del("Authorization")
+		}
+		return nil
+	}
+}
+
+// redirectRefused reports whether err came from checkRedirect turning a
+// redirect down, which retrying will not change.
+func redirectRefused(err error) bool {
+	return errors.Is(err, ErrTooManyRedirects) || errors.Is(err, ErrHostNotAllowed)
+}
@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func intPtr(n int) *int { return &n }
+
+func TestRedirectsAreCapped(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := hits.Add(1)
+		http.Redirect(w, r, fmt.Sprintf("/hop%d", n), http.StatusFound)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.MaxRedirects = intPtr(3)
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+
+	_, err := NewAPIClient(cfg).ExecuteHTTPRequest(context.Background(), server.URL, "GET", nil, nil)
+	if !errors.Is(err, ErrTooManyRedirects) {
+		t.Fatalf("Expected ErrTooManyRedirects, got %v", err)
+	}
+	if got := hits.Load(); got != 4 {
+		t.Errorf("Expected the first request and 3 redirects, got %d requests", got)
+	}
+}
+
+func TestZeroMaxRedirectsReturnsRedirect(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hits.Add(1)
+		http.Redirect(w, r, "/elsewhere", http.StatusFound)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.MaxRedirects = intPtr(0)
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+
+	resp, err := NewAPIClient(cfg).ExecuteHTTPRequest(context.Background(), server.URL, "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteHTTPRequest failed: %v", err)
+	}
+	if resp.StatusCode != http.StatusFound || resp.Headers["Location"] != "/elsewhere" {
+		t.Errorf("Expected the 302 to be returned as is, got %d %v", resp.StatusCode, resp.Headers)
+	}
+	if got := hits.Load(); got != 1 {
+		t.Errorf("Expected the redirect not to be followed, got %d requests", got)
+	}
+}
+
+func TestCrossHostRedirectStripsAuthorization(t *testing.T) {
+	authorization := make(chan string, 2)
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		authorization <- r.Header.Get("Authorization")
+		w.Write([]byte(`{"success": true}`))
+	}))
+	defer other.Close()
+	otherURL := strings.Replace(other.URL, "127.0.0.1", "localhost", 1)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/same":
+			http.Redirect(w, r, "/final", http.StatusFound)
+		case "/away":
+			http.Redirect(w, r, otherURL+"/final", http.StatusFound)
+		default:
+			authorization <- r.Header.Get("Authorization")
+			w.Write([]byte(`{"success": true}`))
+		}
+	}))
+	defer upstream.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = upstream.URL
+	cfg.APIProxy.Auth.Token = "secret"
+	cfg.APICall.AllowedHosts = []string{"localhost"}
+	c := NewAPIClient(cfg)
+
+	if _, err := c.ExecuteAPICall(context.Background(), "", "/same", "GET", nil, nil); err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if got := <-authorization; got != "Bearer secret" {
+		t.Errorf("Expected Authorization to survive a same-host redirect, got %q", got)
+	}
+
+	if _, err := c.ExecuteAPICall(context.Background(), "", "/away", "GET", nil, nil); err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if got := <-authorization; got != "" {
+		t.Errorf("Expected Authorization to be stripped on a cross-host redirect, got %q", got)
+	}
+}
+
+func TestRedirectToBlockedHostIsRefused(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "http://169.254.169.254/latest/meta-data/", http.StatusFound)
+	}))
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.Retry.MaxAttempts = 3
+	cfg.HTTPRequest.AllowedHosts = []string{"127.0.0.1"}
+
+	_, err := NewAPIClient(cfg).ExecuteHTTPRequest(context.Background(), server.URL, "GET", nil, nil)
+	if !errors.Is(err, ErrHostNotAllowed) {
+		t.Errorf("Expected the redirect to be refused, got %v", err)
+	}
+}
@@ -0,0 +1,66 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func newResponseSizeServer(body string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(body))
+	}))
+}
+
+func TestResponseWithinLimit(t *testing.T) {
+	server := newResponseSizeServer(`{"success": true, "data": "ok"}`)
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.MaxResponseBytes = 64
+
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if !resp.Success || resp.Data != "ok" {
+		t.Errorf("Expected the full response, got %+v", resp)
+	}
+}
+
+func TestResponseOverLimit(t *testing.T) {
+	body := `{"data": "` + strings.Repeat("x", 1000) + `"}`
+	server := newResponseSizeServer(body)
+	defer server.Close()
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.MaxResponseBytes = 100
+
+	_, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/", "GET", nil, nil)
+	if !errors.Is(err, ErrResponseTooLarge) {
+		t.Fatalf("Expected ErrResponseTooLarge, got %v", err)
+	}
+
+	// With truncate_response a preview and the full length are returned instead
+	cfg.APIProxy.TruncateResponse = true
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	data, _ := resp.Data.(map[string]interface{})
+	if !resp.Success || data["truncated"] != true {
+		t.Fatalf("Expected a truncated preview, got %+v", resp)
+	}
+	if data["preview"] != body[:100] {
+		t.Errorf("Expected the first 100 bytes as preview, got %q", data["preview"])
+	}
+	if data["content_length"] != int64(len(body)) {
+		t.Errorf("Expected content_length %d, got %v", len(body), data["content_length"])
+	}
+}
@@ -0,0 +1,75 @@
+package proxy
+
+import (
+	"edge-agent/internal/config"
+	"math"
+	"net/http"
+	"time"
+)
+
+// defaultRetryableStatusCodes are retried when retryable_status_codes is not configured.
+var defaultRetryableStatusCodes = []int{
+	http.StatusBadGateway,
+	http.StatusServiceUnavailable,
+	http.StatusGatewayTimeout,
+}
+
+// idempotentMethods are retried even without retry_non_idempotent.
+var idempotentMethods = map[string]bool{
+	http.MethodGet:     true,
+	http.MethodHead:    true,
+	http.MethodPut:     true,
+	http.MethodDelete:  true,
+	http.MethodOptions: true,
+}
+
+// retryPolicy decides whether and when a failed upstream request is retried.
+type retryPolicy struct {
+	maxAttempts   int
+	initialDelay  time.Duration
+	multiplier    float64
+	statuses      map[int]bool
+	nonIdempotent bool
+}
+
+func newRetryPolicy(p config.APIProxy) retryPolicy {
+	r := retryPolicy{
+		maxAttempts:   p.Retry.MaxAttempts,
+		initialDelay:  p.Retry.InitialDelay,
+		multiplier:    p.Retry.BackoffMultiplier,
+		statuses:      make(map[int]bool),
+		nonIdempotent: p.Retry.RetryNonIdempotent,
+	}
+	if r.maxAttempts < 1 {
+		r.maxAttempts = 1
+	}
+	if r.initialDelay <= 0 {
+		r.initialDelay = 500 * time.Millisecond
+	}
+	if r.multiplier < 1 {
+		r.multiplier = 2
+	}
+
+	codes := p.Retry.RetryableStatusCodes
+	if len(codes) == 0 {
+		codes = defaultRetryableStatusCodes
+	}
+	for _, code := range codes {
+		r.statuses[code] = true
+	}
+	return r
+}
+
+// attempts returns how many times a request with the given method may be tried.
+func (r retryPolicy) attempts(method string) int {
+	if !r.nonIdempotent && !idempotentMethods[method] {
+		return 1
+	}
+	return r.maxAttempts
+}
+
+// delay returns the wait before the retry following the given number of
+// failed attempts (1-based).
+func (r retryPolicy) delay(failed int) time.Duration {
+	return time.Duration(float64(r.initialDelay) * math.Pow(r.multiplier, float64(failed-1)))
+}
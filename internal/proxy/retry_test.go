@@ -0,0 +1,101 @@
+package proxy
+
+import (
+	"context"
+	"edge-agent/internal/config"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// flakyServer fails the first failures requests with 503 and then succeeds.
+func flakyServer(failures int32) (*httptest.Server, *int32) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) <= failures {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"success": true}`))
+	}))
+	return server, &calls
+}
+
+func retryConfig(baseURL string) *config.Config {
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = baseURL
+	cfg.APIProxy.Retry.MaxAttempts = 3
+	cfg.APIProxy.Retry.InitialDelay = 10 * time.Millisecond
+	cfg.APIProxy.Retry.BackoffMultiplier = 2
+	return cfg
+}
+
+func TestRetryFailsTwiceThenSucceeds(t *testing.T) {
+	server, calls := flakyServer(2)
+	defer server.Close()
+
+	resp, err := NewAPIClient(retryConfig(server.URL)).ExecuteAPICall(context.Background(), "", "/status", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if !resp.Success || resp.StatusCode != http.StatusOK {
+		t.Errorf("Expected success after retries, got %+v", resp)
+	}
+	if got := atomic.LoadInt32(calls); got != 3 {
+		t.Errorf("Expected 3 attempts, got %d", got)
+	}
+}
+
+func TestRetrySkipsNonIdempotentMethods(t *testing.T) {
+	server, calls := flakyServer(2)
+	defer server.Close()
+
+	cfg := retryConfig(server.URL)
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/orders", "POST", nil, map[string]interface{}{"id": 1})
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if resp.Success || resp.StatusCode != http.StatusServiceUnavailable {
+		t.Errorf("Expected POST to fail without retries, got %+v", resp)
+	}
+	if got := atomic.LoadInt32(calls); got != 1 {
+		t.Errorf("Expected a single attempt for POST, got %d", got)
+	}
+
+	// retry_non_idempotent opts POST in
+	cfg.APIProxy.Retry.RetryNonIdempotent = true
+	atomic.StoreInt32(calls, 0)
+	resp, err = NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/orders", "POST", nil, map[string]interface{}{"id": 1})
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if !resp.Success || atomic.LoadInt32(calls) != 3 {
+		t.Errorf("Expected POST to succeed on the third attempt, got %+v after %d calls", resp, atomic.LoadInt32(calls))
+	}
+}
+
+func TestRetryRespectsContextDeadline(t *testing.T) {
+	server, calls := flakyServer(10)
+	defer server.Close()
+
+	cfg := retryConfig(server.URL)
+	cfg.APIProxy.Retry.MaxAttempts = 10
+	cfg.APIProxy.Retry.InitialDelay = time.Second
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	resp, err := NewAPIClient(cfg).ExecuteAPICall(ctx, "", "/status", "GET", nil, nil)
+	if err != nil {
+		t.Fatalf("ExecuteAPICall failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 150*time.Millisecond {
+		t.Errorf("Expected retries to stop before the deadline, took %v", elapsed)
+	}
+	if resp.StatusCode != http.StatusServiceUnavailable || atomic.LoadInt32(calls) != 1 {
+		t.Errorf("Expected the last 503 after a single attempt, got %+v after %d calls", resp, atomic.LoadInt32(calls))
+	}
+}
@@ -0,0 +1,134 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"edge-agent/internal/config"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writePEM writes a PEM block of the given type to dir/name and returns its path.
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// newClientCA creates a CA and a client certificate signed by it, returning
+// the CA and the paths of the client certificate and key.
+func newClientCA(t *testing.T, dir string) (*x509.Certificate, string, string) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	caTemplate := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	caDER, err := x509.CreateCertificate(rand.Reader, caTemplate, caTemplate, &caKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ca, err := x509.ParseCertificate(caDER)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientTemplate := &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "edge-agent"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	clientDER, err := x509.CreateCertificate(rand.Reader, clientTemplate, ca, &clientKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(clientKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile := writePEM(t, dir, "client.crt", "CERTIFICATE", clientDER)
+	keyFile := writePEM(t, dir, "client.key", "EC PRIVATE KEY", keyDER)
+	return ca, certFile, keyFile
+}
+
+func TestMutualTLS(t *testing.T) {
+	dir := t.TempDir()
+	ca, certFile, keyFile := newClientCA(t, dir)
+
+	server := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"success": true, "data": "` + r.TLS.PeerCertificates[0].Subject.CommonName + `"}`))
+	}))
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca)
+	server.TLS = &tls.Config{ClientAuth: tls.RequireAndVerifyClientCert, ClientCAs: clientCAs}
+	server.StartTLS()
+	defer server.Close()
+
+	// The server certificate is self-signed, so trust it as a private CA
+	serverCA := writePEM(t, dir, "server-ca.crt", "CERTIFICATE", server.Certificate().Raw)
+
+	cfg := &config.Config{}
+	cfg.APIProxy.BaseURL = server.URL
+	cfg.APIProxy.TLS.CAFile = serverCA
+	cfg.APIProxy.TLS.CertFile = certFile
+	cfg.APIProxy.TLS.KeyFile = keyFile
+
+	client := NewAPIClient(cfg)
+	for i := 0; i < 2; i++ {
+		resp, err := client.ExecuteAPICall(context.Background(), "", "/whoami", "GET", nil, nil)
+		if err != nil {
+			t.Fatalf("ExecuteAPICall with client certificate failed: %v", err)
+		}
+		if !resp.Success || resp.Data != "edge-agent" {
+			t.Errorf("Expected the server to see the edge-agent certificate, got %+v", resp)
+		}
+	}
+
+	// Without a client certificate the handshake is rejected
+	cfg.APIProxy.TLS.CertFile = ""
+	cfg.APIProxy.TLS.KeyFile = ""
+	if _, err := NewAPIClient(cfg).ExecuteAPICall(context.Background(), "", "/whoami", "GET", nil, nil); err == nil {
+		t.Error("Expected the request without a client certificate to fail")
+	}
+}
+
+func TestTLSConfigInvalidClientCertificate(t *testing.T) {
+	p := config.APIProxy{}
+	p.TLS.CertFile = filepath.Join(t.TempDir(), "missing.crt")
+	p.TLS.KeyFile = filepath.Join(t.TempDir(), "missing.key")
+
+	if _, err := newTLSConfig(p); err == nil {
+		t.Error("Expected an error for a missing client certificate")
+	}
+}
@@ -0,0 +1,51 @@
+package proxy
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"edge-agent/internal/logging"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Headers that carry a command's trace ID to the upstream.
+const (
+	requestIDHeader   = "X-Request-ID"
+	traceparentHeader = "traceparent"
+)
+
+// w3cTraceID matches a trace-id as used in a W3C traceparent header.
+var w3cTraceID = regexp.MustCompile(`^[0-9a-f]{32}$`)
+
+// setTraceHeaders propagates the trace ID in ctx, if any, as X-Request-ID
+// and traceparent. Headers the caller set explicitly are kept.
+func setTraceHeaders(ctx context.Context, req *http.Request) {
+	traceID := logging.TraceID(ctx)
+	if traceID == "" {
+		return
+	}
+	if req.Header.Get(requestIDHeader) == "" {
+		req.Header.Set(requestIDHeader, traceID)
+	}
+	if req.Header.Get(traceparentHeader) == "" {
+		req.Header.Set(traceparentHeader, traceparent(traceID))
+	}
+}
+
+// traceparent builds a W3C trace context header with a fresh span ID.
+// A trace ID that is not already 32 hex digits is hashed into one, so a
+// command always maps to the same trace.
+func traceparent(traceID string) string {
+	id := strings.ToLower(traceID)
+	if !w3cTraceID.MatchString(id) || id == strings.Repeat("0", 32) {
+		sum := sha256.Sum256([]byte(traceID))
+		id = hex.EncodeToString(sum[:16])
+	}
+	var span [8]byte
+	rand.Read(span[:])
+	return fmt.Sprintf("00-%s-%s-01", id, hex.EncodeToString(span[:]))
+}
@@ -0,0 +1,260 @@
+package scheduler
+
+import (
+	"container/heap"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Priority orders queued jobs; higher priorities run first.
+type Priority int
+
+const (
+	Low Priority = iota
+	Normal
+	High
+)
+
+var priorityNames = map[string]Priority{
+	"low":    Low,
+	"normal": Normal,
+	"high":   High,
+}
+
+// ParsePriority converts a priority name ("low", "normal" or "high") to a Priority.
+func ParsePriority(name string) (Priority, error) {
+	p, ok := priorityNames[name]
+	if !ok {
+		return Normal, fmt.Errorf("invalid priority %q: must be one of low, normal, high", name)
+	}
+	return p, nil
+}
+
+func (p Priority) String() string {
+	for name, value := range priorityNames {
+		if value == p {
+			return name
+		}
+	}
+	return fmt.Sprintf("priority(%d)", int(p))
+}
+
+// Overflow is what Submit does when the queue is at its limit.
+type Overflow int
+
+const (
+	// Block waits until a worker frees a slot.
+	Block Overflow = iota
+	// RejectNewest refuses the job being submitted.
+	RejectNewest
+	// RejectOldest drops the longest-waiting job to make room.
+	RejectOldest
+)
+
+var overflowNames = map[string]Overflow{
+	"block":         Block,
+	"reject_newest": RejectNewest,
+	"reject_oldest": RejectOldest,
+}
+
+// ParseOverflow converts an overflow policy name ("block", "reject_newest"
+// or "reject_oldest") to an Overflow.
+func ParseOverflow(name string) (Overflow, error) {
+	o, ok := overflowNames[name]
+	if !ok {
+		return Block, fmt.Errorf("invalid overflow policy %q: must be one of block, reject_newest, reject_oldest", name)
+	}
+	return o, nil
+}
+
+// ErrStopped is returned by Submit after Stop has been called.
+var ErrStopped = errors.New("scheduler stopped")
+
+// ErrQueueFull is returned by Submit when the queue is at its limit and
+// the overflow policy is RejectNewest.
+var ErrQueueFull = errors.New("queue full")
+
+type job struct {
+	priority Priority
+	seq      uint64
+	run      func()
+	dropped  func() // called instead of run if the job is evicted
+}
+
+// jobQueue is a heap ordered by priority, then by submission order.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	if q[i].priority != q[j].priority {
+		return q[i].priority > q[j].priority
+	}
+	return q[i].seq < q[j].seq
+}
+func (q jobQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *jobQueue) Push(x interface{}) { *q = append(*q, x.(*job)) }
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	item := old[n-1]
+	old[n-1] = nil
+	*q = old[:n-1]
+	return item
+}
+
+// Scheduler runs submitted jobs on a fixed pool of workers, highest
+// priority first and in submission order within a priority.
+type Scheduler struct {
+	mu       sync.Mutex
+	cond     *sync.Cond
+	notFull  *sync.Cond // signalled when a job leaves the queue
+	queue    jobQueue
+	limit    int // 0 means unbounded
+	overflow Overflow
+	seq      uint64
+	workers  int
+	running  bool
+	stopped  bool
+	wg       sync.WaitGroup
+}
+
+// New creates a Scheduler with the given number of workers (at least one).
+func New(workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{workers: workers}
+	s.cond = sync.NewCond(&s.mu)
+	s.notFull = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetLimit bounds the queue to depth waiting jobs, handling a full queue
+// according to overflow. A depth of 0 or less leaves it unbounded.
+func (s *Scheduler) SetLimit(depth int, overflow Overflow) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if depth < 0 {
+		depth = 0
+	}
+	s.limit = depth
+	s.overflow = overflow
+	s.notFull.Broadcast()
+}
+
+// Start launches the workers. Jobs submitted before Start wait in the queue.
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running {
+		return
+	}
+	s.running = true
+	s.stopped = false
+	for i := 0; i < s.workers; i++ {
+		s.wg.Add(1)
+		go s.work()
+	}
+}
+
+// Stop discards queued jobs and waits for running ones to finish. The
+// scheduler can be started again afterwards.
+func (s *Scheduler) Stop() {
+	s.mu.Lock()
+	s.stopped = true
+	s.queue = nil
+	s.cond.Broadcast()
+	s.notFull.Broadcast()
+	s.mu.Unlock()
+
+	s.wg.Wait()
+
+	s.mu.Lock()
+	s.running = false
+	s.mu.Unlock()
+}
+
+// Submit queues fn to run with the given priority.
+func (s *Scheduler) Submit(priority Priority, fn func()) error {
+	return s.SubmitOrDrop(priority, fn, nil)
+}
+
+// SubmitOrDrop queues fn like Submit. If the queue is full it blocks or
+// returns ErrQueueFull, depending on the overflow policy. Under
+// RejectOldest the oldest waiting job is evicted instead and its dropped
+// function, if any, is called in place of its run function.
+func (s *Scheduler) SubmitOrDrop(priority Priority, fn func(), dropped func()) error {
+	s.mu.Lock()
+
+	for !s.stopped && s.full() && s.overflow == Block {
+		s.notFull.Wait()
+	}
+	if s.stopped {
+		s.mu.Unlock()
+		return ErrStopped
+	}
+
+	var evicted *job
+	if s.full() {
+		if s.overflow == RejectNewest {
+			s.mu.Unlock()
+			return ErrQueueFull
+		}
+		evicted = s.evictOldest()
+	}
+
+	s.seq++
+	heap.Push(&s.queue, &job{priority: priority, seq: s.seq, run: fn, dropped: dropped})
+	s.cond.Signal()
+	s.mu.Unlock()
+
+	if evicted != nil && evicted.dropped != nil {
+		evicted.dropped()
+	}
+	return nil
+}
+
+func (s *Scheduler) full() bool {
+	return s.limit > 0 && len(s.queue) >= s.limit
+}
+
+// evictOldest removes and returns the job that has waited longest,
+// whatever its priority.
+func (s *Scheduler) evictOldest() *job {
+	oldest := 0
+	for i, j := range s.queue {
+		if j.seq < s.queue[oldest].seq {
+			oldest = i
+		}
+	}
+	return heap.Remove(&s.queue, oldest).(*job)
+}
+
+// Len returns the number of jobs waiting to run.
+func (s *Scheduler) Len() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.queue)
+}
+
+func (s *Scheduler) work() {
+	defer s.wg.Done()
+
+	for {
+		s.mu.Lock()
+		for len(s.queue) == 0 && !s.stopped {
+			s.cond.Wait()
+		}
+		if s.stopped {
+			s.mu.Unlock()
+			return
+		}
+		next := heap.Pop(&s.queue).(*job)
+		s.notFull.Signal()
+		s.mu.Unlock()
+
+		next.run()
+	}
+}
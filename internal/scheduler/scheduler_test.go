@@ -0,0 +1,192 @@
+package scheduler
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSchedulerRunsHighestPriorityFirst(t *testing.T) {
+	s := New(1)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	record := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			defer wg.Done()
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+		}
+	}
+
+	// Queue everything before the workers start
+	s.Submit(Low, record("low-1"))
+	s.Submit(Normal, record("normal-1"))
+	s.Submit(Low, record("low-2"))
+	s.Submit(High, record("high-1"))
+	s.Submit(Normal, record("normal-2"))
+
+	s.Start()
+	defer s.Stop()
+	wg.Wait()
+
+	expected := []string{"high-1", "normal-1", "normal-2", "low-1", "low-2"}
+	if !reflect.DeepEqual(order, expected) {
+		t.Errorf("Expected order %v, got %v", expected, order)
+	}
+}
+
+func TestSchedulerSubmitAfterStop(t *testing.T) {
+	s := New(1)
+	s.Start()
+	s.Stop()
+
+	if err := s.Submit(Normal, func() {}); err != ErrStopped {
+		t.Errorf("Expected ErrStopped, got %v", err)
+	}
+}
+
+func TestParsePriority(t *testing.T) {
+	for name, expected := range map[string]Priority{"low": Low, "normal": Normal, "high": High} {
+		p, err := ParsePriority(name)
+		if err != nil || p != expected {
+			t.Errorf("ParsePriority(%q) = %v, %v; expected %v", name, p, err, expected)
+		}
+	}
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Error("Expected error for unknown priority")
+	}
+}
+
+func TestSchedulerRejectNewestWhenFull(t *testing.T) {
+	s := New(1)
+	s.SetLimit(2, RejectNewest)
+
+	for i := 0; i < 2; i++ {
+		if err := s.Submit(Normal, func() {}); err != nil {
+			t.Fatalf("Submit %d failed: %v", i, err)
+		}
+	}
+	if err := s.Submit(High, func() {}); err != ErrQueueFull {
+		t.Errorf("Expected ErrQueueFull, got %v", err)
+	}
+	if s.Len() != 2 {
+		t.Errorf("Expected 2 queued jobs, got %d", s.Len())
+	}
+}
+
+func TestSchedulerRejectOldestEvictsLongestWaiting(t *testing.T) {
+	s := New(1)
+	s.SetLimit(2, RejectOldest)
+
+	var dropped []string
+	var ran []string
+	var wg sync.WaitGroup
+	submit := func(name string, p Priority) {
+		wg.Add(1)
+		err := s.SubmitOrDrop(p, func() {
+			defer wg.Done()
+			ran = append(ran, name)
+		}, func() {
+			defer wg.Done()
+			dropped = append(dropped, name)
+		})
+		if err != nil {
+			t.Fatalf("Submit %s failed: %v", name, err)
+		}
+	}
+
+	submit("high-1", High)
+	submit("low-1", Low)
+	submit("low-2", Low)
+	submit("low-3", Low)
+
+	s.Start()
+	defer s.Stop()
+	wg.Wait()
+
+	if !reflect.DeepEqual(dropped, []string{"high-1", "low-1"}) {
+		t.Errorf("Expected the two oldest jobs to be dropped, got %v", dropped)
+	}
+	if !reflect.DeepEqual(ran, []string{"low-2", "low-3"}) {
+		t.Errorf("Expected the newest jobs to run, got %v", ran)
+	}
+}
+
+func TestSchedulerBlockWaitsForSpace(t *testing.T) {
+	s := New(1)
+	s.SetLimit(1, Block)
+
+	release := make(chan struct{})
+	started := make(chan struct{})
+	s.Submit(Normal, func() {
+		close(started)
+		<-release
+	})
+	s.Start()
+	defer s.Stop()
+	<-started
+
+	// The worker is busy, so this fills the queue
+	if err := s.Submit(Normal, func() {}); err != nil {
+		t.Fatalf("Submit failed: %v", err)
+	}
+
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- s.Submit(Normal, func() {})
+	}()
+	select {
+	case err := <-submitted:
+		t.Fatalf("Expected Submit to block on a full queue, got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	select {
+	case err := <-submitted:
+		if err != nil {
+			t.Errorf("Expected Submit to succeed once space freed, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit still blocked after the queue drained")
+	}
+}
+
+func TestSchedulerStopUnblocksSubmit(t *testing.T) {
+	s := New(1)
+	s.SetLimit(1, Block)
+	s.Submit(Normal, func() {})
+
+	submitted := make(chan error, 1)
+	go func() {
+		submitted <- s.Submit(Normal, func() {})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	s.Stop()
+
+	select {
+	case err := <-submitted:
+		if err != ErrStopped {
+			t.Errorf("Expected ErrStopped, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Submit still blocked after Stop")
+	}
+}
+
+func TestParseOverflow(t *testing.T) {
+	for name, expected := range map[string]Overflow{"block": Block, "reject_newest": RejectNewest, "reject_oldest": RejectOldest} {
+		o, err := ParseOverflow(name)
+		if err != nil || o != expected {
+			t.Errorf("ParseOverflow(%q) = %v, %v; expected %v", name, o, err, expected)
+		}
+	}
+	if _, err := ParseOverflow("drop"); err == nil {
+		t.Error("Expected error for unknown overflow policy")
+	}
+}
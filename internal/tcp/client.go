@@ -1,75 +1,234 @@
 package tcp
 
 import (
+	"bufio"
 	"context"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
 	"encoding/json"
 	"fmt"
 	"log"
+	"log/slog"
 	"net"
 	"sync"
 	"time"
 )
 
+var _ transport.Transport = (*TCPClient)(nil)
+
 type TCPClient struct {
-	commandHandler func(message map[string]interface{}) map[string]interface{}
+	commandHandler transport.Handler
 	conn           net.Conn
-	sendChan       chan []byte
+	sendChan       chan []byte // per connection, replaced on every dial
 	mu             sync.RWMutex
 	connected      bool
+	maxMessageSize int64
+	connectTimeout time.Duration
+	malformed      transport.Malformed
+	sequence       transport.Sequence
+	// dialContext opens the TCP connection; tests swap it out
+	dialContext func(ctx context.Context, network, address string) (net.Conn, error)
+
+	reconnect    transport.ReconnectConfig
+	onReconnect  []func()
+	onDisconnect []func()
+	stopped      bool
+	cancel       context.CancelFunc
+	lost         chan struct{} // closed when the current connection drops
+	acked        chan struct{} // closed when the server acknowledges identification
+
+	identifyTimeout time.Duration
+
+	address  string
+	clientID string
+	identity func() transport.Identity
 }
 
-func NewTCPClient() *TCPClient {
+func NewTCPClient(maxMessageBytes int64, reconnect transport.ReconnectConfig) *TCPClient {
+	if maxMessageBytes <= 0 {
+		maxMessageBytes = DefaultMaxMessageBytes
+	}
 	return &TCPClient{
-		sendChan: make(chan []byte, 256),
+		maxMessageSize:  maxMessageBytes,
+		connectTimeout:  transport.DefaultConnectTimeout,
+		identifyTimeout: transport.DefaultIdentifyTimeout,
+		dialContext:     (&net.Dialer{}).DialContext,
+		reconnect:       reconnect,
 	}
 }
 
-func (c *TCPClient) Connect(ctx context.Context, address, clientID string, metadata map[string]interface{}) error {
-	log.Printf("Connecting to TCP server: %s (client: %s)", address, clientID)
+// SetConnectTimeout bounds each connection attempt; 0 restores
+// transport.DefaultConnectTimeout.
+func (c *TCPClient) SetConnectTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connectTimeout = transport.ConnectTimeout(timeout)
+}
+
+func (c *TCPClient) Connect(ctx context.Context, address, clientID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	c.mu.Lock()
+	// Stop supervising any previous session before starting a new one
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.address = address
+	c.clientID = clientID
+	c.stopped = false
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	c.mu.RLock()
+	reconnect := c.reconnect
+	c.mu.RUnlock()
 
-	dialer := &net.Dialer{
-		Timeout: 10 * time.Second,
+	if err := transport.Retry(ctx, reconnect, "TCP", func() error { return c.dial(ctx) }); err != nil {
+		cancel()
+		return err
 	}
 
-	conn, err := dialer.DialContext(ctx, "tcp", address)
+	go c.supervise(ctx)
+
+	return nil
+}
+
+// dial opens a new connection, identifies the client and starts the pumps.
+func (c *TCPClient) dial(ctx context.Context) error {
+	c.mu.RLock()
+	address, clientID, identityFn := c.address, c.clientID, c.identity
+	timeout, dialContext, identifyTimeout := c.connectTimeout, c.dialContext, c.identifyTimeout
+	c.mu.RUnlock()
+
+	slog.Info("Connecting to TCP server", "protocol", "tcp", "url", address, "client_id", clientID)
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn, err := dialContext(dialCtx, "tcp", address)
 	if err != nil {
+		if transport.DialTimedOut(ctx, dialCtx, err) {
+			return fmt.Errorf("failed to connect to TCP server: timed out after %s", timeout)
+		}
 		return fmt.Errorf("failed to connect to TCP server: %w", err)
 	}
 
+	lost := make(chan struct{})
+	acked := make(chan struct{})
+	sendChan := make(chan []byte, 256)
+
 	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("TCP client stopped")
+	}
+	// Tear down a connection left over from a concurrent dial
+	if c.connected && c.conn != nil {
+		c.conn.Close()
+		close(c.lost)
+	}
 	c.conn = conn
 	c.connected = true
+	c.lost = lost
+	c.acked = acked
+	c.sequence.Restart()
+	c.sendChan = sendChan
 	c.mu.Unlock()
 
 	log.Printf("TCP connected successfully")
 
 	// Send identification message immediately after connection
-	identification := map[string]interface{}{
-		"type":      "identify",
-		"client_id": clientID,
-		"metadata":  metadata,
-		"timestamp": time.Now().Unix(),
-	}
+	identification := transport.Identify(identityFn, clientID)
 
 	if err := c.SendCommand(identification); err != nil {
-		log.Printf("Failed to send identification: %v", err)
+		slog.Error("Failed to send identification", "protocol", "tcp", "error", err)
 	} else {
 		//log.Printf("Identification message sent successfully")
 	}
 
 	// Start reader
-	go c.readPump(ctx)
+	go c.readPump(ctx, conn)
 
 	// Start writer
-	go c.writePump(ctx)
+	go c.writePump(ctx, conn, sendChan, lost)
+
+	go transport.AwaitIdentification(ctx, "tcp", identifyTimeout, acked, lost, func() { c.dropConn(conn) })
 
 	return nil
 }
 
+// supervise waits for the connection to drop and redials until Disconnect
+// is called, the context ends or the reconnect policy gives up.
+func (c *TCPClient) supervise(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		lost := c.lost
+		c.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			c.Disconnect()
+			c.disconnected()
+			return
+		case <-lost:
+		}
+		c.disconnected()
+
+		c.mu.RLock()
+		stopped := c.stopped
+		reconnect := c.reconnect
+		c.mu.RUnlock()
+		if stopped {
+			return
+		}
+
+		slog.Warn("❌ TCP connection lost")
+
+		if !reconnect.Enabled {
+			slog.Warn("TCP reconnection disabled, not attempting to reconnect")
+			return
+		}
+
+		log.Printf("🔄 TCP disconnected, attempting to reconnect...")
+		if err := transport.Redial(ctx, reconnect, "TCP", func() error { return c.dial(ctx) }); err != nil {
+			slog.Error("❌ TCP reconnection failed, giving up", "error", err)
+			return
+		}
+
+		log.Printf("✅ TCP reconnected successfully")
+
+		c.mu.RLock()
+		callbacks := append([]func(){}, c.onReconnect...)
+		c.mu.RUnlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// dropConn tears down conn if it is still the active connection.
+func (c *TCPClient) dropConn(conn net.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn == nil || c.conn != conn || !c.connected {
+		return
+	}
+
+	c.connected = false
+	conn.Close()
+	close(c.lost)
+}
+
 func (c *TCPClient) Disconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+
 	if !c.connected {
 		return nil
 	}
@@ -79,18 +238,84 @@ func (c *TCPClient) Disconnect() error {
 	if c.conn != nil {
 		c.conn.Close()
 	}
+	close(c.lost)
 
 	log.Printf("TCP disconnected")
 	return nil
 }
 
+// DropConnection closes the current connection without stopping the
+// client, so supervise treats it as lost and redials.
+func (c *TCPClient) DropConnection() {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	c.dropConn(conn)
+}
+
 func (c *TCPClient) IsConnected() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.connected
 }
 
-func (c *TCPClient) SendCommand(payload map[string]interface{}) error {
+// SetIdentifyTimeout bounds the wait for the server to acknowledge each
+// identification before the connection is dropped and redialed; 0
+// restores transport.DefaultIdentifyTimeout.
+func (c *TCPClient) SetIdentifyTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identifyTimeout = transport.IdentifyTimeout(timeout)
+}
+
+// identified marks the current connection's identification as acknowledged.
+func (c *TCPClient) identified() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.acked != nil {
+		close(c.acked)
+		c.acked = nil
+	}
+}
+
+// SetReconnect replaces the reconnect policy used for future redials.
+func (c *TCPClient) SetReconnect(reconnect transport.ReconnectConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = reconnect
+}
+
+// OnReconnect registers fn to be called after each successful redial.
+func (c *TCPClient) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// OnDisconnect registers fn to be called whenever the connection drops or
+// is closed.
+func (c *TCPClient) OnDisconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDisconnect = append(c.onDisconnect, fn)
+}
+
+// disconnected runs the OnDisconnect callbacks.
+func (c *TCPClient) disconnected() {
+	c.mu.RLock()
+	callbacks := append([]func(){}, c.onDisconnect...)
+	c.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// Send queues message for delivery to the server.
+func (c *TCPClient) Send(message map[string]interface{}) error {
+	return c.SendCommand(message)
+}
+
+func (c *TCPClient) SendCommand(payload interface{}) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("TCP not connected")
 	}
@@ -100,51 +325,77 @@ func (c *TCPClient) SendCommand(payload map[string]interface{}) error {
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	//log.Printf("Sending TCP message: %s", string(data))
+	logging.TraceMessage("Sending message", data, "protocol", "tcp")
+
+	// Bind to the current connection so a send racing with Disconnect
+	// fails fast instead of leaking into a channel nobody drains
+	c.mu.RLock()
+	connected, sendChan, lost := c.connected, c.sendChan, c.lost
+	c.mu.RUnlock()
+	if !connected {
+		return fmt.Errorf("TCP not connected")
+	}
 
 	select {
-	case c.sendChan <- data:
+	case <-lost:
+		return fmt.Errorf("TCP connection closed")
+	default:
+	}
+
+	select {
+	case sendChan <- data:
 		return nil
+	case <-lost:
+		return fmt.Errorf("TCP connection closed")
 	case <-time.After(5 * time.Second):
 		return fmt.Errorf("send timeout")
 	}
 }
 
-func (c *TCPClient) readPump(ctx context.Context) {
-	defer c.Disconnect()
+func (c *TCPClient) readPump(ctx context.Context, conn net.Conn) {
+	if conn == nil {
+		return
+	}
+	defer c.dropConn(conn)
+
+	reader := bufio.NewReader(conn)
 
-	buffer := make([]byte, 4096)
+	// A blocked Read ignores ctx, so expire its deadline on cancellation
+	stop := context.AfterFunc(ctx, func() { conn.SetReadDeadline(time.Now()) })
+	defer stop()
 
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			n, err := c.conn.Read(buffer)
-			if err != nil {
-				log.Printf("TCP read error: %v", err)
+		data, err := readFrame(reader, c.maxMessageSize)
+		if err != nil {
+			if ctx.Err() != nil {
 				return
 			}
-
-			if n > 0 {
-				data := buffer[:n]
-				//log.Printf("Received raw TCP data: %s", string(data))
-				c.handleMessage(data)
-			}
+			slog.Warn("TCP read error", "protocol", "tcp", "error", err)
+			return
 		}
+
+		logging.TraceMessage("Received raw message", data, "protocol", "tcp")
+		c.handleMessage(data)
 	}
 }
 
-func (c *TCPClient) writePump(ctx context.Context) {
+func (c *TCPClient) writePump(ctx context.Context, conn net.Conn, sendChan chan []byte, lost chan struct{}) {
+	if conn == nil {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case data := <-c.sendChan:
+		case <-lost:
+			return
+		case data := <-sendChan:
 			//log.Printf("Writing to TCP: %s", string(data))
-			_, err := c.conn.Write(data)
+			err := writeFrame(conn, c.sequence.Stamp(data))
 			if err != nil {
-				log.Printf("TCP write error: %v", err)
+				slog.Warn("TCP write error", "protocol", "tcp", "error", err)
+				c.dropConn(conn)
 				return
 			}
 			//log.Printf("TCP message written successfully")
@@ -152,13 +403,39 @@ func (c *TCPClient) writePump(ctx context.Context) {
 	}
 }
 
+// rejectMalformed tells the server a message could not be parsed and
+// redials once too many arrive in a row.
+func (c *TCPClient) rejectMalformed() {
+	reconnect := c.malformed.Record()
+	if err := c.SendCommand(transport.MalformedReply()); err != nil {
+		slog.Warn("Failed to report invalid message", "protocol", "tcp", "error", err)
+	}
+	if reconnect {
+		slog.Warn("Too many malformed messages in a row, reconnecting", "protocol", "tcp", "count", transport.MaxConsecutiveMalformed)
+		c.DropConnection()
+	}
+}
+
+// MalformedMessages returns how many received messages were not valid JSON.
+func (c *TCPClient) MalformedMessages() uint64 {
+	return c.malformed.Total()
+}
+
+// SequenceGaps returns how many gaps or reorderings were detected in the
+// sequence numbers of received messages.
+func (c *TCPClient) SequenceGaps() uint64 {
+	return c.sequence.Gaps()
+}
+
 func (c *TCPClient) handleMessage(data []byte) {
 	var message map[string]interface{}
 	if err := json.Unmarshal(data, &message); err != nil {
-		log.Printf("Invalid TCP message format: %v", err)
-		log.Printf("Raw data that failed to parse: %s", string(data))
+		slog.Warn("Invalid TCP message format", "error", err, "data", logging.RedactJSON(data))
+		c.rejectMalformed()
 		return
 	}
+	c.malformed.Reset()
+	c.sequence.Observe("tcp", transport.SeqOf(message))
 
 	//log.Printf("Received TCP command: %+v", message)
 
@@ -166,7 +443,8 @@ func (c *TCPClient) handleMessage(data []byte) {
 	if msgType, ok := message["type"].(string); ok {
 		switch msgType {
 		case "identification_success":
-			log.Printf("Identification successful: %+v", message)
+			slog.Debug("Identification successful", "message", logging.Redact(message))
+			c.identified()
 			// Не отправляем ответ на identification_success
 			return
 		case "status_request":
@@ -193,13 +471,20 @@ func (c *TCPClient) handleMessage(data []byte) {
 		response := c.commandHandler(message)
 		if response != nil {
 			if err := c.SendCommand(response); err != nil {
-				log.Printf("Failed to send response: %v", err)
+				slog.Error("Failed to send response", "protocol", "tcp", "command_id", message["id"], "error", err)
 			}
 		}
 		return
 	}
 }
 
-func (c *TCPClient) SetCommandHandler(handler func(message map[string]interface{}) map[string]interface{}) {
+func (c *TCPClient) SetHandler(handler transport.Handler) {
 	c.commandHandler = handler
 }
+
+// SetIdentityProvider sets the source of the identification message.
+func (c *TCPClient) SetIdentityProvider(provider func() transport.Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = provider
+}
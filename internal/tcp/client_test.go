@@ -0,0 +1,499 @@
+package tcp
+
+import (
+	"bufio"
+	"context"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"net"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestTCPClientReconnectsToFlappingServer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Accept each connection, wait for identification and drop it.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			readFrame(bufio.NewReader(conn), DefaultMaxMessageBytes)
+			conn.Close()
+		}
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+
+	reconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reconnected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for reconnect %d", i+1)
+		}
+	}
+}
+
+func TestTCPClientConnectDisconnectRace(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Keep every connection open until the client closes it.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := readFrame(reader, DefaultMaxMessageBytes); err != nil {
+						conn.Close()
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			client.Connect(ctx, listener.Addr().String(), "test-client")
+		}()
+		go func() {
+			defer wg.Done()
+			client.Send(map[string]interface{}{"type": "ping"})
+		}()
+		go func() {
+			defer wg.Done()
+			client.Disconnect()
+		}()
+	}
+	wg.Wait()
+
+	client.Disconnect()
+	if client.IsConnected() {
+		t.Error("Client still connected after Disconnect")
+	}
+}
+
+func TestTCPClientSendAfterDisconnect(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 512)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					data, err := readFrame(reader, DefaultMaxMessageBytes)
+					if err != nil {
+						return
+					}
+					received <- string(data)
+				}
+			}()
+		}
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	client.Disconnect()
+
+	// More sends than the channel buffer holds must all fail fast
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Send(map[string]interface{}{"type": "stale"}); err == nil {
+				t.Error("Expected Send after Disconnect to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Sends after Disconnect took %s, expected to fail fast", elapsed)
+	}
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Send(map[string]interface{}{"type": "fresh"}); err != nil {
+		t.Fatalf("Send after reconnect failed: %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-received:
+			if strings.Contains(msg, "stale") {
+				t.Fatalf("Stale message delivered after reconnect: %s", msg)
+			}
+			if strings.Contains(msg, "fresh") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for message sent after reconnect")
+		}
+	}
+}
+
+func TestTCPClientSendsIdentity(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		if data, err := readFrame(reader, DefaultMaxMessageBytes); err == nil {
+			received <- data
+		}
+		readFrame(reader, DefaultMaxMessageBytes) // hold the connection until the client leaves
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{})
+	client.SetIdentityProvider(func() transport.Identity {
+		return transport.Identity{Version: "1.2.3", Arch: "arm64", Capabilities: []string{"local_command", "metrics"}}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "edge-42"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	select {
+	case data := <-received:
+		var identity transport.Identity
+		if err := json.Unmarshal(data, &identity); err != nil {
+			t.Fatalf("Invalid identification message %s: %v", data, err)
+		}
+		if identity.Type != "identify" || identity.ClientID != "edge-42" || identity.Arch != "arm64" || identity.Timestamp == 0 {
+			t.Errorf("Unexpected identification %s", data)
+		}
+		if !reflect.DeepEqual(identity.Capabilities, []string{"local_command", "metrics"}) {
+			t.Errorf("Expected capabilities [local_command metrics], got %v", identity.Capabilities)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server never received the identification message")
+	}
+}
+
+func TestTCPReadPumpExitsPromptlyOnCancel(t *testing.T) {
+	clientConn, serverConn := net.Pipe()
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		client.readPump(ctx, clientConn)
+		close(done)
+	}()
+
+	// Let readPump block in Read with nothing to receive
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("readPump did not exit after the context was cancelled")
+	}
+}
+
+// blockingDial stands in for a connect that never completes: it returns
+// only when ctx ends.
+func blockingDial(ctx context.Context, network, address string) (net.Conn, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestTCPClientConnectTimeout(t *testing.T) {
+	client := NewTCPClient(0, transport.ReconnectConfig{Enabled: false})
+	client.dialContext = blockingDial
+	client.SetConnectTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	err := client.Connect(context.Background(), "10.255.255.1:9000", "test-client")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Expected a connect timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the attempt to give up after about 100ms, took %s", elapsed)
+	}
+}
+
+func TestTCPClientConnectAbortedByContext(t *testing.T) {
+	client := NewTCPClient(0, transport.ReconnectConfig{Enabled: true, InitialDelay: time.Hour})
+	client.dialContext = blockingDial
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- client.Connect(ctx, "10.255.255.1:9000", "test-client") }()
+	select {
+	case err := <-done:
+		if err == nil || strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected the connect to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connect did not return after the context was cancelled")
+	}
+}
+
+func TestTCPClientAnswersMalformedMessages(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// The first connection gets garbage; later ones are just held open.
+	reply := make(chan []byte, 1)
+	accepted := make(chan struct{}, 10)
+	go func() {
+		for first := true; ; first = false {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted <- struct{}{}
+			go func(first bool) {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				readFrame(reader, DefaultMaxMessageBytes) // identification
+				if first {
+					writeFrame(conn, []byte("{not json"))
+					if data, err := readFrame(reader, DefaultMaxMessageBytes); err == nil {
+						reply <- data
+					}
+					for i := 1; i < transport.MaxConsecutiveMalformed; i++ {
+						writeFrame(conn, []byte("garbage"))
+					}
+				}
+				for {
+					if _, err := readFrame(reader, DefaultMaxMessageBytes); err != nil {
+						return
+					}
+				}
+			}(first)
+		}
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	<-accepted
+
+	select {
+	case data := <-reply:
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Invalid reply %s: %v", data, err)
+		}
+		if message["type"] != "error" || message["error"] != "invalid json" || message["id"] != "" {
+			t.Errorf("Unexpected reply to a malformed message: %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the error reply")
+	}
+
+	// The last malformed message in a row drops the connection
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to reconnect after too many malformed messages")
+	}
+	if got := client.MalformedMessages(); got != transport.MaxConsecutiveMalformed {
+		t.Errorf("Expected %d malformed messages, got %d", transport.MaxConsecutiveMalformed, got)
+	}
+}
+
+func TestTCPClientReconnectsWhenIdentificationIsNotAcknowledged(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	// Read everything the client sends but never answer it.
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				reader := bufio.NewReader(conn)
+				for {
+					if _, err := readFrame(reader, DefaultMaxMessageBytes); err != nil {
+						return
+					}
+				}
+			}()
+		}
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	client.SetIdentifyTimeout(50 * time.Millisecond)
+
+	reconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to reconnect when identification is never acknowledged")
+	}
+}
+
+func TestTCPClientNumbersMessagesAndDetectsGaps(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	sent := make(chan uint64, 2)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		for i := 0; i < 2; i++ {
+			data, err := readFrame(reader, DefaultMaxMessageBytes)
+			if err != nil {
+				return
+			}
+			var message struct {
+				Seq uint64 `json:"seq"`
+			}
+			json.Unmarshal(data, &message)
+			sent <- message.Seq
+		}
+		// seq 2 never arrives
+		writeFrame(conn, []byte(`{"seq":1,"type":"identification_success"}`))
+		writeFrame(conn, []byte(`{"seq":3,"type":"identification_success"}`))
+		readFrame(reader, DefaultMaxMessageBytes)
+	}()
+
+	client := NewTCPClient(0, transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if err := client.Connect(ctx, listener.Addr().String(), "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Send(map[string]interface{}{"type": "heartbeat"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	for want := uint64(1); want <= 2; want++ {
+		select {
+		case seq := <-sent:
+			if seq != want {
+				t.Errorf("Expected outgoing seq %d, got %d", want, seq)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Timed out waiting for the client's messages")
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for client.SequenceGaps() != 1 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 1 sequence gap, got %d", client.SequenceGaps())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,48 @@
+package tcp
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+)
+
+// frameHeaderSize is the size of the big-endian length prefix that precedes
+// every message on the wire.
+const frameHeaderSize = 4
+
+// DefaultMaxMessageBytes is used when tcp.max_message_bytes is not configured.
+const DefaultMaxMessageBytes int64 = 64 * 1024 * 1024
+
+// readFrame reads one length-prefixed message from r, allocating exactly the
+// declared length. Frames larger than maxBytes are rejected before allocating.
+func readFrame(r *bufio.Reader, maxBytes int64) ([]byte, error) {
+	var header [frameHeaderSize]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	size := int64(binary.BigEndian.Uint32(header[:]))
+	if size > maxBytes {
+		return nil, fmt.Errorf("frame of %d bytes exceeds limit of %d bytes", size, maxBytes)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, fmt.Errorf("failed to read frame body: %w", err)
+	}
+	return data, nil
+}
+
+// writeFrame writes data to w prefixed with its length.
+func writeFrame(w io.Writer, data []byte) error {
+	if int64(len(data)) > math.MaxUint32 {
+		return fmt.Errorf("message of %d bytes is too large to frame", len(data))
+	}
+	frame := make([]byte, frameHeaderSize+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[frameHeaderSize:], data)
+	_, err := w.Write(frame)
+	return err
+}
@@ -0,0 +1,35 @@
+package tcp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+func TestReadFrameLargePayload(t *testing.T) {
+	payload := bytes.Repeat([]byte("a"), 1024*1024)
+
+	var buf bytes.Buffer
+	if err := writeFrame(&buf, payload); err != nil {
+		t.Fatalf("writeFrame failed: %v", err)
+	}
+
+	data, err := readFrame(bufio.NewReader(&buf), DefaultMaxMessageBytes)
+	if err != nil {
+		t.Fatalf("readFrame failed: %v", err)
+	}
+	if !bytes.Equal(data, payload) {
+		t.Errorf("Frame mismatch: expected %d bytes, got %d", len(payload), len(data))
+	}
+}
+
+func TestReadFrameRejectsOversizedLength(t *testing.T) {
+	var header [frameHeaderSize]byte
+	binary.BigEndian.PutUint32(header[:], 2*1024*1024*1024)
+
+	_, err := readFrame(bufio.NewReader(bytes.NewReader(header[:])), DefaultMaxMessageBytes)
+	if err == nil {
+		t.Error("Expected error for 2GB length prefix, got nil")
+	}
+}
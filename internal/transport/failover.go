@@ -0,0 +1,255 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"log/slog"
+	"sync"
+)
+
+var _ Transport = (*Failover)(nil)
+
+// Failover keeps a single connection to one of several equivalent
+// endpoints. It dials them in order and, when the active connection is
+// lost, moves on to the next one, wrapping around after the last. The
+// wrapped transport only ever makes one attempt per dial; retries and
+// backoff between rounds follow the Failover's ReconnectConfig.
+type Failover struct {
+	inner     Transport
+	addresses []string
+
+	mu           sync.Mutex
+	reconnect    ReconnectConfig
+	active       int // index into addresses of the current endpoint
+	clientID     string
+	stopped      bool
+	cancel       context.CancelFunc
+	onReconnect  []func()
+	onDisconnect []func()
+
+	lost chan struct{} // signalled when the inner connection drops
+}
+
+// NewFailover wraps inner so that it connects to addresses in order of
+// preference. inner's own reconnect policy is disabled.
+func NewFailover(inner Transport, addresses []string, reconnect ReconnectConfig) *Failover {
+	f := &Failover{
+		inner:     inner,
+		addresses: addresses,
+		reconnect: reconnect,
+		lost:      make(chan struct{}, 1),
+	}
+	inner.SetReconnect(ReconnectConfig{})
+	inner.OnDisconnect(f.innerDisconnected)
+	return f
+}
+
+// Connect dials the endpoints in order, starting with the first. The
+// address argument is ignored in favour of the endpoints given to
+// NewFailover.
+func (f *Failover) Connect(ctx context.Context, _, clientID string) error {
+	ctx, cancel := context.WithCancel(ctx)
+
+	f.mu.Lock()
+	// Stop supervising any previous session before starting a new one
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.clientID = clientID
+	f.stopped = false
+	f.cancel = cancel
+	reconnect := f.reconnect
+	f.mu.Unlock()
+
+	// Forget a loss left over from a previous session
+	select {
+	case <-f.lost:
+	default:
+	}
+
+	if err := Retry(ctx, reconnect, "failover", func() error { return f.dial(ctx, 0) }); err != nil {
+		cancel()
+		return err
+	}
+
+	go f.supervise(ctx)
+
+	return nil
+}
+
+// dial tries every endpoint once, starting at index start, and keeps the
+// first that connects.
+func (f *Failover) dial(ctx context.Context, start int) error {
+	f.mu.Lock()
+	clientID := f.clientID
+	f.mu.Unlock()
+
+	if len(f.addresses) == 0 {
+		return fmt.Errorf("no endpoints configured")
+	}
+
+	var lastErr error
+	for i := range f.addresses {
+		index := (start + i) % len(f.addresses)
+		address := f.addresses[index]
+
+		err := f.inner.Connect(ctx, address, clientID)
+		if err == nil {
+			f.mu.Lock()
+			f.active = index
+			f.mu.Unlock()
+			if index != 0 {
+				slog.Warn("Connected to fallback endpoint", "url", address, "endpoint", index+1, "endpoints", len(f.addresses))
+			}
+			return nil
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		slog.Warn("❌ Endpoint unavailable", "url", address, "error", err)
+		lastErr = err
+	}
+	return fmt.Errorf("all %d endpoints failed: %w", len(f.addresses), lastErr)
+}
+
+// supervise waits for the active connection to drop and fails over to the
+// next endpoint until Disconnect is called, the context ends or the
+// reconnect policy gives up.
+func (f *Failover) supervise(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			f.inner.Disconnect()
+			return
+		case <-f.lost:
+		}
+
+		f.mu.Lock()
+		stopped, reconnect, active := f.stopped, f.reconnect, f.active
+		f.mu.Unlock()
+		if stopped || ctx.Err() != nil {
+			return
+		}
+
+		if !reconnect.Enabled {
+			slog.Warn("Reconnection disabled, not failing over", "url", f.addresses[active])
+			return
+		}
+
+		log.Printf("🔄 Lost connection to %s, failing over...", f.addresses[active])
+		if err := Redial(ctx, reconnect, "failover", func() error { return f.dial(ctx, active+1) }); err != nil {
+			slog.Error("❌ Failover gave up", "error", err)
+			return
+		}
+
+		f.mu.Lock()
+		callbacks := append([]func(){}, f.onReconnect...)
+		f.mu.Unlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// innerDisconnected forwards the wrapped transport's disconnect and wakes
+// supervise unless the session was stopped.
+func (f *Failover) innerDisconnected() {
+	f.mu.Lock()
+	stopped := f.stopped
+	callbacks := append([]func(){}, f.onDisconnect...)
+	f.mu.Unlock()
+
+	for _, fn := range callbacks {
+		fn()
+	}
+	if stopped {
+		return
+	}
+	select {
+	case f.lost <- struct{}{}:
+	default:
+	}
+}
+
+// ActiveEndpoint returns the endpoint of the current or most recent
+// connection.
+func (f *Failover) ActiveEndpoint() string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.addresses) == 0 {
+		return ""
+	}
+	return f.addresses[f.active]
+}
+
+// Dropped passes through the wrapped transport's count of discarded
+// messages, if it keeps one.
+func (f *Failover) Dropped() uint64 {
+	if counter, ok := f.inner.(interface{ Dropped() uint64 }); ok {
+		return counter.Dropped()
+	}
+	return 0
+}
+
+// MalformedMessages passes through the wrapped transport's count of
+// messages that could not be decoded, if it keeps one.
+func (f *Failover) MalformedMessages() uint64 {
+	if counter, ok := f.inner.(interface{ MalformedMessages() uint64 }); ok {
+		return counter.MalformedMessages()
+	}
+	return 0
+}
+
+// SequenceGaps passes through the wrapped transport's count of gaps in
+// incoming sequence numbers, if it keeps one.
+func (f *Failover) SequenceGaps() uint64 {
+	if counter, ok := f.inner.(interface{ SequenceGaps() uint64 }); ok {
+		return counter.SequenceGaps()
+	}
+	return 0
+}
+
+func (f *Failover) Disconnect() error {
+	f.mu.Lock()
+	f.stopped = true
+	if f.cancel != nil {
+		f.cancel()
+	}
+	f.mu.Unlock()
+	return f.inner.Disconnect()
+}
+
+func (f *Failover) IsConnected() bool { return f.inner.IsConnected() }
+
+func (f *Failover) DropConnection() { f.inner.DropConnection() }
+
+func (f *Failover) Send(message map[string]interface{}) error { return f.inner.Send(message) }
+
+func (f *Failover) SetHandler(handler Handler) { f.inner.SetHandler(handler) }
+
+func (f *Failover) SetIdentityProvider(provider func() Identity) {
+	f.inner.SetIdentityProvider(provider)
+}
+
+// OnReconnect registers fn to be called after each successful failover.
+func (f *Failover) OnReconnect(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onReconnect = append(f.onReconnect, fn)
+}
+
+// OnDisconnect registers fn to be called whenever the active connection
+// drops or is closed.
+func (f *Failover) OnDisconnect(fn func()) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.onDisconnect = append(f.onDisconnect, fn)
+}
+
+// SetReconnect replaces the policy used between failover rounds.
+func (f *Failover) SetReconnect(reconnect ReconnectConfig) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reconnect = reconnect
+}
@@ -0,0 +1,140 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// endpointTransport connects to whichever of its addresses are up and
+// records every dial.
+type endpointTransport struct {
+	mu           sync.Mutex
+	up           map[string]bool
+	connected    string
+	dials        []string
+	reconnect    ReconnectConfig
+	onDisconnect []func()
+}
+
+func (t *endpointTransport) Connect(ctx context.Context, address, clientID string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.dials = append(t.dials, address)
+	if !t.up[address] {
+		return fmt.Errorf("%s is down", address)
+	}
+	t.connected = address
+	return nil
+}
+
+// drop simulates losing the connection to the current endpoint.
+func (t *endpointTransport) drop() {
+	t.mu.Lock()
+	t.up[t.connected] = false
+	t.connected = ""
+	callbacks := append([]func(){}, t.onDisconnect...)
+	t.mu.Unlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+func (t *endpointTransport) Disconnect() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.connected = ""
+	return nil
+}
+
+func (t *endpointTransport) IsConnected() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.connected != ""
+}
+
+func (t *endpointTransport) OnDisconnect(fn func()) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.onDisconnect = append(t.onDisconnect, fn)
+}
+
+func (t *endpointTransport) SetReconnect(reconnect ReconnectConfig) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.reconnect = reconnect
+}
+
+func (t *endpointTransport) DropConnection()                              {}
+func (t *endpointTransport) Send(message map[string]interface{}) error    { return nil }
+func (t *endpointTransport) SetHandler(handler Handler)                   {}
+func (t *endpointTransport) SetIdentityProvider(provider func() Identity) {}
+func (t *endpointTransport) OnReconnect(fn func())                        {}
+
+var fastFailover = ReconnectConfig{Enabled: true, MaxAttempts: 3, InitialDelay: time.Millisecond}
+
+func TestFailoverSkipsDeadPrimary(t *testing.T) {
+	inner := &endpointTransport{up: map[string]bool{"secondary": true}}
+	f := NewFailover(inner, []string{"primary", "secondary"}, fastFailover)
+
+	if inner.reconnect.Enabled {
+		t.Error("Expected the wrapped transport's own reconnection to be disabled")
+	}
+
+	if err := f.Connect(context.Background(), "", "edge-1"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer f.Disconnect()
+
+	if got := f.ActiveEndpoint(); got != "secondary" {
+		t.Errorf("Expected to connect to the secondary, got %q", got)
+	}
+	if len(inner.dials) != 2 || inner.dials[0] != "primary" {
+		t.Errorf("Expected the primary to be tried first, dials were %v", inner.dials)
+	}
+}
+
+func TestFailoverMovesToNextEndpointOnDisconnect(t *testing.T) {
+	inner := &endpointTransport{up: map[string]bool{"primary": true, "secondary": true}}
+	f := NewFailover(inner, []string{"primary", "secondary"}, fastFailover)
+
+	reconnected := make(chan struct{}, 1)
+	f.OnReconnect(func() { reconnected <- struct{}{} })
+
+	if err := f.Connect(context.Background(), "", "edge-1"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer f.Disconnect()
+	if got := f.ActiveEndpoint(); got != "primary" {
+		t.Fatalf("Expected to start on the primary, got %q", got)
+	}
+
+	inner.drop()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for failover")
+	}
+	if got := f.ActiveEndpoint(); got != "secondary" {
+		t.Errorf("Expected to fail over to the secondary, got %q", got)
+	}
+	if !f.IsConnected() {
+		t.Error("Expected to be connected after failing over")
+	}
+}
+
+func TestFailoverGivesUpWhenAllEndpointsAreDown(t *testing.T) {
+	inner := &endpointTransport{up: map[string]bool{}}
+	f := NewFailover(inner, []string{"primary", "secondary"}, fastFailover)
+
+	if err := f.Connect(context.Background(), "", "edge-1"); err == nil {
+		t.Fatal("Expected Connect to fail with every endpoint down")
+	}
+	// Three rounds over both endpoints
+	if len(inner.dials) != 6 {
+		t.Errorf("Expected 6 dials, got %v", inner.dials)
+	}
+}
@@ -0,0 +1,70 @@
+package transport
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Identity is the identification message sent to the server on every
+// (re)connect. It tells the server who the agent is and which commands it
+// accepts.
+type Identity struct {
+	Type     string `json:"type"` // always "identify"
+	ClientID string `json:"client_id"`
+	Version  string `json:"version"`
+	OS       string `json:"os"`
+	Arch     string `json:"arch"`
+	Hostname string `json:"hostname"`
+	// RegistrationToken lets the server check the agent is allowed to
+	// join. It is only ever sent here, never with other messages.
+	RegistrationToken string `json:"registration_token,omitempty"`
+	// Capabilities lists the command types the agent currently accepts.
+	Capabilities []string `json:"capabilities"`
+	// Metadata carries additional free-form details such as system stats.
+	Metadata  map[string]interface{} `json:"metadata,omitempty"`
+	Timestamp int64                  `json:"timestamp"`
+}
+
+// Identify builds the identification message for clientID from provider,
+// which may be nil.
+func Identify(provider func() Identity, clientID string) Identity {
+	var identity Identity
+	if provider != nil {
+		identity = provider()
+	}
+	identity.Type = "identify"
+	identity.ClientID = clientID
+	identity.Timestamp = time.Now().Unix()
+	return identity
+}
+
+// DefaultIdentifyTimeout is how long a transport waits for the server to
+// acknowledge identification when no identify_timeout is configured.
+const DefaultIdentifyTimeout = 30 * time.Second
+
+// IdentifyTimeout returns d, or DefaultIdentifyTimeout if d is not positive.
+func IdentifyTimeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultIdentifyTimeout
+	}
+	return d
+}
+
+// AwaitIdentification calls drop if acked is not closed within timeout,
+// i.e. the server never answered the identification message with
+// identification_success. It returns early when the connection is lost
+// or ctx ends.
+func AwaitIdentification(ctx context.Context, protocol string, timeout time.Duration, acked, lost <-chan struct{}, drop func()) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-acked:
+	case <-lost:
+	case <-ctx.Done():
+	case <-timer.C:
+		slog.Warn("Server did not acknowledge identification, reconnecting", "protocol", protocol, "timeout", timeout)
+		drop()
+	}
+}
@@ -0,0 +1,56 @@
+package transport
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// MaxConsecutiveMalformed is how many unparseable messages in a row make a
+// transport drop the connection and redial: by then the stream is more
+// likely out of sync than the server sending the odd bad message.
+const MaxConsecutiveMalformed = 5
+
+// Malformed counts incoming messages that could not be decoded. The zero
+// value is ready to use.
+type Malformed struct {
+	total atomic.Uint64
+
+	mu          sync.Mutex
+	consecutive int
+}
+
+// Record counts a malformed message and reports whether it completes a
+// run of MaxConsecutiveMalformed, in which case the run starts over.
+func (m *Malformed) Record() (reconnect bool) {
+	m.total.Add(1)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consecutive++
+	if m.consecutive >= MaxConsecutiveMalformed {
+		m.consecutive = 0
+		return true
+	}
+	return false
+}
+
+// Reset ends a run of malformed messages, e.g. after a valid one.
+func (m *Malformed) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.consecutive = 0
+}
+
+// Total returns how many malformed messages have been received.
+func (m *Malformed) Total() uint64 {
+	return m.total.Load()
+}
+
+// MalformedReply is sent back for a message that could not be decoded.
+// Its id is empty because the message's own id could not be read.
+func MalformedReply() map[string]interface{} {
+	return map[string]interface{}{
+		"type":  "error",
+		"error": "invalid json",
+		"id":    "",
+	}
+}
@@ -0,0 +1,86 @@
+package transport
+
+import (
+	"bytes"
+	"log/slog"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// Sequence numbers outgoing messages and checks the numbers on incoming
+// ones for gaps. Outgoing numbering continues across reconnects, so
+// messages lost with a dropped connection show up as a gap on the server;
+// incoming numbering starts over with every connection. The zero value is
+// ready to use.
+type Sequence struct {
+	sent atomic.Uint64
+	gaps atomic.Uint64
+
+	mu       sync.Mutex
+	lastSeen uint64
+}
+
+// Stamp returns data, a JSON object, with the next outgoing sequence
+// number added as its "seq" field. Anything else is returned unchanged.
+func (s *Sequence) Stamp(data []byte) []byte {
+	object := bytes.TrimLeft(data, " \t\r\n")
+	if len(object) == 0 || object[0] != '{' {
+		return data
+	}
+	fields := object[1:]
+
+	stamped := make([]byte, 0, len(data)+24)
+	stamped = append(stamped, `{"seq":`...)
+	stamped = strconv.AppendUint(stamped, s.sent.Add(1), 10)
+	if rest := bytes.TrimLeft(fields, " \t\r\n"); len(rest) > 0 && rest[0] != '}' {
+		stamped = append(stamped, ',')
+	}
+	return append(stamped, fields...)
+}
+
+// Observe checks seq, the number of an incoming message, against the last
+// one seen on this connection and logs and counts a gap when messages
+// were skipped or arrived out of order. A zero seq means the message was
+// not numbered and is ignored.
+func (s *Sequence) Observe(protocol string, seq uint64) {
+	if seq == 0 {
+		return
+	}
+	s.mu.Lock()
+	last := s.lastSeen
+	if seq > last {
+		s.lastSeen = seq
+	}
+	s.mu.Unlock()
+
+	switch {
+	case last == 0 || seq == last+1:
+	case seq > last+1:
+		s.gaps.Add(1)
+		slog.Warn("Incoming messages missing", "protocol", protocol, "expected_seq", last+1, "seq", seq, "missing", seq-last-1)
+	default:
+		s.gaps.Add(1)
+		slog.Warn("Incoming message out of order", "protocol", protocol, "last_seq", last, "seq", seq)
+	}
+}
+
+// Restart forgets the incoming numbering, for a new connection.
+func (s *Sequence) Restart() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastSeen = 0
+}
+
+// Gaps returns how many gaps and reorderings Observe has detected.
+func (s *Sequence) Gaps() uint64 {
+	return s.gaps.Load()
+}
+
+// SeqOf returns the "seq" field of a decoded message, or 0 if it has none.
+func SeqOf(message map[string]interface{}) uint64 {
+	if seq, ok := message["seq"].(float64); ok && seq > 0 {
+		return uint64(seq)
+	}
+	return 0
+}
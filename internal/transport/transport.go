@@ -0,0 +1,171 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"log/slog"
+	"math"
+	"math/rand"
+	"net"
+	"time"
+)
+
+// Transport is a connection to the control server that keeps itself alive,
+// redialing on connection loss according to its ReconnectConfig.
+type Transport interface {
+	// Connect dials the server and sends the identification message. It
+	// returns once the first connection is established or retries are exhausted.
+	Connect(ctx context.Context, address, clientID string) error
+	// Disconnect closes the connection and stops any further reconnection.
+	Disconnect() error
+	// IsConnected reports whether a connection is currently established.
+	IsConnected() bool
+	// DropConnection closes the current connection as if it had been lost,
+	// so the transport redials according to its reconnect policy.
+	DropConnection()
+	// Send queues a message for delivery to the server.
+	Send(message map[string]interface{}) error
+	// SetHandler registers the handler for incoming commands. A non-nil
+	// return value is sent back to the server as the response.
+	SetHandler(handler Handler)
+	// SetIdentityProvider registers the source of the Identity sent as the
+	// identification message on every (re)connect.
+	SetIdentityProvider(provider func() Identity)
+	// OnReconnect registers a callback invoked after each successful redial.
+	OnReconnect(fn func())
+	// OnDisconnect registers a callback invoked whenever an established
+	// connection is lost or closed.
+	OnDisconnect(fn func())
+	// SetReconnect replaces the reconnect policy used for future redials.
+	SetReconnect(reconnect ReconnectConfig)
+}
+
+// Handler processes an incoming command message and returns the response.
+type Handler func(message map[string]interface{}) map[string]interface{}
+
+// ReconnectConfig controls how a transport redials after a failure.
+type ReconnectConfig struct {
+	Enabled           bool
+	MaxAttempts       int // 0 retries forever
+	InitialDelay      time.Duration
+	MaxDelay          time.Duration
+	BackoffMultiplier float64
+
+	// OnDialError, if set, is called with the error of every failed dial.
+	OnDialError func(error)
+}
+
+// DefaultConnectTimeout bounds a single connection attempt when no
+// connect_timeout is configured.
+const DefaultConnectTimeout = 10 * time.Second
+
+// ConnectTimeout returns d, or DefaultConnectTimeout if d is not positive.
+func ConnectTimeout(d time.Duration) time.Duration {
+	if d <= 0 {
+		return DefaultConnectTimeout
+	}
+	return d
+}
+
+// DialTimedOut reports whether a dial made with dialCtx, derived from
+// ctx, failed because its connect timeout ran out rather than because ctx
+// ended or the server refused.
+func DialTimedOut(ctx, dialCtx context.Context, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	var netErr net.Error
+	return dialCtx.Err() != nil || (errors.As(err, &netErr) && netErr.Timeout())
+}
+
+// backoffJitter is the maximum fraction by which a backoff delay is randomly
+// shortened or lengthened so that a fleet of agents does not redial in lockstep.
+const backoffJitter = 0.2
+
+// nextBackoff returns the delay before the retry following the given number
+// of previous attempts (0-based): min(initial * mult^attempt, max) with up
+// to ±20% random jitter, never exceeding max.
+func (r ReconnectConfig) nextBackoff(attempt int) time.Duration {
+	multiplier := r.BackoffMultiplier
+	if multiplier < 1 {
+		multiplier = 1
+	}
+
+	delay := float64(r.InitialDelay) * math.Pow(multiplier, float64(attempt))
+	if r.MaxDelay > 0 && delay > float64(r.MaxDelay) {
+		delay = float64(r.MaxDelay)
+	}
+	if delay > math.MaxInt64/2 {
+		delay = math.MaxInt64 / 2 // leave room for jitter without overflowing
+	}
+
+	delay += delay * backoffJitter * (2*rand.Float64() - 1)
+	if r.MaxDelay > 0 && delay > float64(r.MaxDelay) {
+		delay = float64(r.MaxDelay)
+	}
+	return time.Duration(delay)
+}
+
+// Retry calls dial until it succeeds, the context is cancelled or the
+// configured attempts are exhausted. With reconnection disabled dial is
+// attempted exactly once; with MaxAttempts 0 it is retried forever.
+func Retry(ctx context.Context, cfg ReconnectConfig, name string, dial func() error) error {
+	maxAttempts := cfg.MaxAttempts
+	attempts := 0
+
+	for {
+		if maxAttempts > 0 {
+			log.Printf("Attempting to connect to %s server (attempt %d/%d)...", name, attempts+1, maxAttempts)
+		} else {
+			log.Printf("Attempting to connect to %s server (attempt %d)...", name, attempts+1)
+		}
+
+		err := dial()
+		if err == nil {
+			return nil
+		}
+		slog.Warn("❌ Failed to connect", "protocol", name, "error", err)
+		if cfg.OnDialError != nil {
+			cfg.OnDialError(err)
+		}
+
+		if !cfg.Enabled {
+			return fmt.Errorf("%s reconnection disabled: %w", name, err)
+		}
+
+		attempts++
+		if maxAttempts > 0 && attempts >= maxAttempts {
+			return fmt.Errorf("maximum reconnection attempts (%d) reached: %w", maxAttempts, err)
+		}
+
+		delay := cfg.nextBackoff(attempts - 1)
+		log.Printf("⏳ Waiting %s before next reconnection attempt...", delay)
+		if err := Sleep(ctx, delay); err != nil {
+			return err
+		}
+	}
+}
+
+// Redial waits one backoff interval after a lost connection and then retries
+// dial like Retry does.
+func Redial(ctx context.Context, cfg ReconnectConfig, name string, dial func() error) error {
+	if err := Sleep(ctx, cfg.nextBackoff(0)); err != nil {
+		return err
+	}
+	return Retry(ctx, cfg, name, dial)
+}
+
+// Sleep waits for d or until ctx is cancelled, whichever comes first.
+func Sleep(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
@@ -0,0 +1,147 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestNextBackoffRespectsMaxDelay(t *testing.T) {
+	cfg := ReconnectConfig{
+		InitialDelay:      time.Second,
+		MaxDelay:          30 * time.Second,
+		BackoffMultiplier: 2,
+	}
+
+	for attempt := 0; attempt < 100; attempt++ {
+		if d := cfg.nextBackoff(attempt); d > cfg.MaxDelay || d <= 0 {
+			t.Errorf("attempt %d: delay %s outside (0, %s]", attempt, d, cfg.MaxDelay)
+		}
+	}
+}
+
+func TestNextBackoffJitterWithinRange(t *testing.T) {
+	cfg := ReconnectConfig{
+		InitialDelay:      time.Second,
+		MaxDelay:          time.Hour,
+		BackoffMultiplier: 2,
+	}
+
+	for attempt := 0; attempt < 5; attempt++ {
+		base := time.Second << attempt
+		low := time.Duration(float64(base) * (1 - backoffJitter))
+		high := time.Duration(float64(base) * (1 + backoffJitter))
+
+		seen := make(map[time.Duration]bool)
+		for i := 0; i < 200; i++ {
+			d := cfg.nextBackoff(attempt)
+			if d < low || d > high {
+				t.Fatalf("attempt %d: delay %s outside [%s, %s]", attempt, d, low, high)
+			}
+			seen[d] = true
+		}
+		if len(seen) < 2 {
+			t.Errorf("attempt %d: expected jittered delays, got a constant %s", attempt, base)
+		}
+	}
+}
+
+func TestRetryZeroMaxAttemptsRetriesForever(t *testing.T) {
+	cfg := ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  0,
+		InitialDelay: time.Millisecond,
+		MaxDelay:     time.Millisecond,
+	}
+
+	calls := 0
+	err := Retry(context.Background(), cfg, "test", func() error {
+		calls++
+		if calls <= 8 {
+			return errors.New("network unreachable")
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Retry gave up after %d attempts: %v", calls, err)
+	}
+	if calls != 9 {
+		t.Errorf("Expected 9 dial attempts, got %d", calls)
+	}
+}
+
+func TestRetryStopsAtMaxAttempts(t *testing.T) {
+	cfg := ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  3,
+		InitialDelay: time.Millisecond,
+	}
+
+	calls := 0
+	err := Retry(context.Background(), cfg, "test", func() error {
+		calls++
+		return errors.New("network unreachable")
+	})
+	if err == nil {
+		t.Fatal("Expected Retry to give up, got nil")
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 dial attempts, got %d", calls)
+	}
+}
+
+func TestAwaitIdentificationKeepsAcknowledgedConnection(t *testing.T) {
+	acked := make(chan struct{})
+	close(acked)
+	dropped := false
+	AwaitIdentification(context.Background(), "test", 10*time.Millisecond, acked, make(chan struct{}), func() { dropped = true })
+	if dropped {
+		t.Error("Expected an acknowledged connection to be kept")
+	}
+
+	AwaitIdentification(context.Background(), "test", 10*time.Millisecond, make(chan struct{}), make(chan struct{}), func() { dropped = true })
+	if !dropped {
+		t.Error("Expected an unacknowledged connection to be dropped")
+	}
+}
+
+func TestSequenceStampsOutgoingMessages(t *testing.T) {
+	var s Sequence
+	tests := []struct{ in, want string }{
+		{`{"type":"heartbeat"}`, `{"seq":1,"type":"heartbeat"}`},
+		{`{}`, `{"seq":2}`},
+		{`[1,2]`, `[1,2]`},
+		{`{"type":"pong"}`, `{"seq":3,"type":"pong"}`},
+	}
+	for _, tt := range tests {
+		if got := string(s.Stamp([]byte(tt.in))); got != tt.want {
+			t.Errorf("Stamp(%s) = %s, want %s", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSequenceDetectsGapsAndReordering(t *testing.T) {
+	var s Sequence
+	for _, seq := range []uint64{5, 6, 0, 7} {
+		s.Observe("test", seq)
+	}
+	if s.Gaps() != 0 {
+		t.Fatalf("Expected consecutive messages to have no gaps, got %d", s.Gaps())
+	}
+
+	s.Observe("test", 9) // 8 is missing
+	if s.Gaps() != 1 {
+		t.Errorf("Expected a gap to be detected, got %d", s.Gaps())
+	}
+	s.Observe("test", 8) // arrives late
+	if s.Gaps() != 2 {
+		t.Errorf("Expected a reordering to be detected, got %d", s.Gaps())
+	}
+
+	s.Restart()
+	s.Observe("test", 1)
+	if s.Gaps() != 2 {
+		t.Errorf("Expected numbering to start over on a new connection, got %d gaps", s.Gaps())
+	}
+}
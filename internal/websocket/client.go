@@ -2,24 +2,51 @@ package websocket
 
 import (
 	"context"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"log/slog"
+	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+var _ transport.Transport = (*WSClient)(nil)
+
 type WSClient struct {
-	commandHandler func(message WSMessage) WSMessage
+	commandHandler transport.Handler
 	conn           *websocket.Conn
-	sendChan       chan []byte
+	sendChan       chan []byte // per connection, replaced on every dial
 	mu             sync.RWMutex
 	writeMu        sync.Mutex
 	pingInterval   time.Duration
 	connected      bool
-	reconnect      bool
+
+	reconnect    transport.ReconnectConfig
+	onReconnect  []func()
+	onDisconnect []func()
+	stopped      bool
+	cancel       context.CancelFunc
+	lost         chan struct{} // closed when the current connection drops
+	acked        chan struct{} // closed when the server acknowledges identification
+
+	send           SendConfig
+	malformed      transport.Malformed
+	sequence       transport.Sequence
+	connectTimeout time.Duration // bounds the TCP connect and the handshake
+	// identifyTimeout bounds the wait for identification_success
+	identifyTimeout time.Duration
+	dropped         atomic.Uint64 // messages discarded while the send queue was full
+
+	url      string
+	clientID string
+	identity func() transport.Identity
 }
 
 type WSMessage struct {
@@ -27,92 +54,318 @@ type WSMessage struct {
 	ID      string      `json:"id"`
 	Payload interface{} `json:"payload"`
 	Success bool        `json:"success"`
+	Seq     uint64      `json:"seq,omitempty"`
 }
 
-func NewWSClient() *WSClient {
+func NewWSClient(reconnect transport.ReconnectConfig) *WSClient {
 	return &WSClient{
-		sendChan:     make(chan []byte, 256),
-		pingInterval: 30 * time.Second,
+		pingInterval:    30 * time.Second,
+		reconnect:       reconnect,
+		send:            DefaultSendConfig(),
+		connectTimeout:  transport.DefaultConnectTimeout,
+		identifyTimeout: transport.DefaultIdentifyTimeout,
 	}
 }
 
-func (c *WSClient) Connect(ctx context.Context, wsURL, clientID string, metadata map[string]interface{}) error {
-	log.Printf("Connecting to WebSocket: %s (client: %s)", wsURL, clientID)
+// SetConnectTimeout bounds each connection attempt, including the
+// WebSocket handshake; 0 restores transport.DefaultConnectTimeout.
+func (c *WSClient) SetConnectTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.connectTimeout = transport.ConnectTimeout(timeout)
+}
+
+func (c *WSClient) Connect(ctx context.Context, wsURL, clientID string) error {
+	ctx, cancel := context.WithCancel(ctx)
 
-	// Set dial timeout
-	dialer := websocket.DefaultDialer
-	dialer.HandshakeTimeout = 10 * time.Second
+	c.mu.Lock()
+	// Stop supervising any previous session before starting a new one
+	if c.cancel != nil {
+		c.cancel()
+	}
+	c.url = wsURL
+	c.clientID = clientID
+	c.stopped = false
+	c.cancel = cancel
+	c.mu.Unlock()
+
+	c.mu.RLock()
+	reconnect := c.reconnect
+	c.mu.RUnlock()
+
+	if err := transport.Retry(ctx, reconnect, "websocket", func() error { return c.dial(ctx) }); err != nil {
+		cancel()
+		return err
+	}
+
+	go c.supervise(ctx)
+
+	return nil
+}
+
+// dial opens a new connection, identifies the client and starts the pumps.
+func (c *WSClient) dial(ctx context.Context) error {
+	c.mu.RLock()
+	wsURL, clientID, identityFn, timeout := c.url, c.clientID, c.identity, c.connectTimeout
+	identifyTimeout := c.identifyTimeout
+	c.mu.RUnlock()
+
+	slog.Info("Connecting to WebSocket", "protocol", "websocket", "url", wsURL, "client_id", clientID)
+
+	dialCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// dialCtx bounds both the TCP connect and the handshake
+	dialer := *websocket.DefaultDialer
+	dialer.HandshakeTimeout = 0
+
+	// The handshake only watches dialCtx's deadline; closing the connection
+	// when dialCtx ends makes a cancelled ctx abort it as well
+	var netDialer net.Dialer
+	var stopWatch func() bool
+	dialer.NetDialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		conn, err := netDialer.DialContext(ctx, network, address)
+		if err != nil {
+			return nil, err
+		}
+		stopWatch = context.AfterFunc(ctx, func() { conn.Close() })
+		return conn, nil
+	}
 
 	// Connect to WebSocket directly without JWT
-	conn, _, err := dialer.Dial(wsURL, nil)
+	conn, _, err := dialer.DialContext(dialCtx, wsURL, nil)
+	if stopWatch != nil && !stopWatch() && err == nil {
+		// ctx ended just as the handshake finished
+		err = dialCtx.Err()
+	}
 	if err != nil {
+		if transport.DialTimedOut(ctx, dialCtx, err) {
+			return fmt.Errorf("failed to connect to WebSocket: timed out after %s", timeout)
+		}
 		return fmt.Errorf("failed to connect to WebSocket: %w", err)
 	}
 
+	lost := make(chan struct{})
+	acked := make(chan struct{})
+	sendChan := make(chan []byte, 256)
+
 	c.mu.Lock()
+	if c.stopped {
+		c.mu.Unlock()
+		conn.Close()
+		return fmt.Errorf("WebSocket client stopped")
+	}
+	// Tear down a connection left over from a concurrent dial
+	if c.connected && c.conn != nil {
+		c.conn.Close()
+		close(c.lost)
+	}
 	c.conn = conn
 	c.connected = true
+	c.lost = lost
+	c.acked = acked
+	c.sequence.Restart()
+	c.sendChan = sendChan
 	c.mu.Unlock()
 
 	log.Printf("WebSocket connected successfully")
 
 	// Send identification message immediately after connection
-	identification := map[string]interface{}{
-		"type":      "identify",
-		"client_id": clientID,
-		"metadata":  metadata,
-		"timestamp": time.Now().Unix(),
-	}
+	identification := transport.Identify(identityFn, clientID)
 
 	if err := c.SendCommand("identification", identification, "init"); err != nil {
-		log.Printf("Failed to send identification: %v", err)
+		slog.Error("Failed to send identification", "protocol", "websocket", "error", err)
 	} else {
-		log.Printf("Identification message sent successfully")
+		slog.Debug("Identification message sent successfully", "protocol", "websocket")
 	}
 
 	// Start reader
-	go c.readPump(ctx)
+	go c.readPump(ctx, conn)
 
 	// Start writer
-	go c.writePump(ctx)
+	go c.writePump(ctx, conn, sendChan, lost)
 
 	// Start ping
-	go c.pingPump(ctx)
+	go c.pingPump(ctx, conn, lost)
+
+	go transport.AwaitIdentification(ctx, "websocket", identifyTimeout, acked, lost, func() { c.dropConn(conn) })
 
 	return nil
 }
 
+// supervise waits for the connection to drop and redials until Disconnect
+// is called, the context ends or the reconnect policy gives up.
+func (c *WSClient) supervise(ctx context.Context) {
+	for {
+		c.mu.RLock()
+		lost := c.lost
+		c.mu.RUnlock()
+
+		select {
+		case <-ctx.Done():
+			c.Disconnect()
+			c.disconnected()
+			return
+		case <-lost:
+		}
+		c.disconnected()
+
+		c.mu.RLock()
+		stopped := c.stopped
+		reconnect := c.reconnect
+		c.mu.RUnlock()
+		if stopped {
+			return
+		}
+
+		slog.Warn("❌ websocket connection lost")
+
+		if !reconnect.Enabled {
+			slog.Warn("websocket reconnection disabled, not attempting to reconnect")
+			return
+		}
+
+		log.Printf("🔄 websocket disconnected, attempting to reconnect...")
+		if err := transport.Redial(ctx, reconnect, "websocket", func() error { return c.dial(ctx) }); err != nil {
+			slog.Error("❌ websocket reconnection failed, giving up", "error", err)
+			return
+		}
+
+		log.Printf("✅ websocket reconnected successfully")
+
+		c.mu.RLock()
+		callbacks := append([]func(){}, c.onReconnect...)
+		c.mu.RUnlock()
+		for _, fn := range callbacks {
+			fn()
+		}
+	}
+}
+
+// dropConn tears down conn if it is still the active connection.
+func (c *WSClient) dropConn(conn *websocket.Conn) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if conn == nil || c.conn != conn || !c.connected {
+		return
+	}
+
+	c.connected = false
+	conn.Close()
+	close(c.lost)
+}
+
 func (c *WSClient) Disconnect() error {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.stopped = true
+	if c.cancel != nil {
+		c.cancel()
+	}
+
 	if !c.connected {
 		return nil
 	}
 
 	c.connected = false
-	c.reconnect = false
 
 	if c.conn != nil {
 		c.writeMu.Lock()
 		err := c.conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
 		c.writeMu.Unlock()
-		if err != nil {
-			log.Printf("Error sending close message: %v", err)
+		// readPump may already have closed it on cancellation
+		if err != nil && !errors.Is(err, websocket.ErrCloseSent) && !errors.Is(err, net.ErrClosed) {
+			slog.Warn("Error sending close message", "error", err)
 		}
 		c.conn.Close()
 	}
+	close(c.lost)
 
 	log.Printf("WebSocket disconnected")
 	return nil
 }
 
+// DropConnection closes the current connection without stopping the
+// client, so supervise treats it as lost and redials.
+func (c *WSClient) DropConnection() {
+	c.mu.RLock()
+	conn := c.conn
+	c.mu.RUnlock()
+	c.dropConn(conn)
+}
+
 func (c *WSClient) IsConnected() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 	return c.connected
 }
 
+// SetIdentifyTimeout bounds the wait for the server to acknowledge each
+// identification before the connection is dropped and redialed; 0
+// restores transport.DefaultIdentifyTimeout.
+func (c *WSClient) SetIdentifyTimeout(timeout time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identifyTimeout = transport.IdentifyTimeout(timeout)
+}
+
+// identified marks the current connection's identification as acknowledged.
+func (c *WSClient) identified() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.acked != nil {
+		close(c.acked)
+		c.acked = nil
+	}
+}
+
+// SetReconnect replaces the reconnect policy used for future redials.
+func (c *WSClient) SetReconnect(reconnect transport.ReconnectConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reconnect = reconnect
+}
+
+// OnReconnect registers fn to be called after each successful redial.
+func (c *WSClient) OnReconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onReconnect = append(c.onReconnect, fn)
+}
+
+// OnDisconnect registers fn to be called whenever the connection drops or
+// is closed.
+func (c *WSClient) OnDisconnect(fn func()) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.onDisconnect = append(c.onDisconnect, fn)
+}
+
+// disconnected runs the OnDisconnect callbacks.
+func (c *WSClient) disconnected() {
+	c.mu.RLock()
+	callbacks := append([]func(){}, c.onDisconnect...)
+	c.mu.RUnlock()
+	for _, fn := range callbacks {
+		fn()
+	}
+}
+
+// Send queues a raw message for delivery to the server.
+func (c *WSClient) Send(message map[string]interface{}) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("WebSocket not connected")
+	}
+
+	data, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %w", err)
+	}
+
+	return c.enqueue(data, c.sendPolicy(message))
+}
+
 func (c *WSClient) SendCommand(cmdType string, payload interface{}, id string) error {
 	if !c.IsConnected() {
 		return fmt.Errorf("WebSocket not connected")
@@ -130,60 +383,73 @@ func (c *WSClient) SendCommand(cmdType string, payload interface{}, id string) e
 		return fmt.Errorf("failed to marshal message: %w", err)
 	}
 
-	log.Printf("Sending message: %s", string(data))
+	return c.enqueue(data, SendTimeout)
+}
 
-	select {
-	case c.sendChan <- data:
-		return nil
-	case <-time.After(5 * time.Second):
-		return fmt.Errorf("send timeout")
+func (c *WSClient) readPump(ctx context.Context, conn *websocket.Conn) {
+	if conn == nil {
+		return
 	}
-}
+	defer c.dropConn(conn)
 
-func (c *WSClient) readPump(ctx context.Context) {
-	defer c.Disconnect()
+	conn.SetReadLimit(512 * 1024 * 1024) // 512MB max message size
 
-	c.conn.SetReadLimit(512 * 1024 * 1024) // 512MB max message size
+	// ReadMessage blocks regardless of ctx, so close the connection on
+	// cancellation to make it return, saying goodbye to the server first
+	stop := context.AfterFunc(ctx, func() {
+		conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		conn.Close()
+	})
+	defer stop()
 
 	for {
-		select {
-		case <-ctx.Done():
-			return
-		default:
-			_, message, err := c.conn.ReadMessage()
-			if err != nil {
-				if websocket.IsUnexpectedCloseError(err) || websocket.IsCloseError(err) {
-					log.Printf("WebSocket connection closed: %v", err)
-					return
-				}
-				log.Printf("WebSocket read error: %v", err)
+		_, message, err := conn.ReadMessage()
+		if err != nil {
+			if ctx.Err() != nil {
 				return
 			}
-
-			// Handle incoming message
-			c.handleMessage(message)
+			if websocket.IsUnexpectedCloseError(err) || websocket.IsCloseError(err) {
+				log.Printf("WebSocket connection closed: %v", err)
+				return
+			}
+			slog.Warn("WebSocket read error", "error", err)
+			return
 		}
+
+		// Handle incoming message
+		c.handleMessage(message)
 	}
 }
 
-func (c *WSClient) writePump(ctx context.Context) {
+func (c *WSClient) writePump(ctx context.Context, conn *websocket.Conn, sendChan chan []byte, lost chan struct{}) {
+	if conn == nil {
+		return
+	}
+
 	for {
 		select {
 		case <-ctx.Done():
 			return
-		case data := <-c.sendChan:
+		case <-lost:
+			return
+		case data := <-sendChan:
 			c.writeMu.Lock()
-			err := c.conn.WriteMessage(websocket.TextMessage, data)
+			err := conn.WriteMessage(websocket.TextMessage, c.sequence.Stamp(data))
 			c.writeMu.Unlock()
 			if err != nil {
-				log.Printf("WebSocket write error: %v", err)
+				slog.Warn("WebSocket write error", "error", err)
+				c.dropConn(conn)
 				return
 			}
 		}
 	}
 }
 
-func (c *WSClient) pingPump(ctx context.Context) {
+func (c *WSClient) pingPump(ctx context.Context, conn *websocket.Conn, lost chan struct{}) {
+	if conn == nil {
+		return
+	}
+
 	ticker := time.NewTicker(54 * time.Second) // Send ping every 54 seconds
 	defer ticker.Stop()
 
@@ -191,36 +457,63 @@ func (c *WSClient) pingPump(ctx context.Context) {
 		select {
 		case <-ctx.Done():
 			return
+		case <-lost:
+			return
 		case <-ticker.C:
-			if c.IsConnected() {
-				c.writeMu.Lock()
-				err := c.conn.WriteMessage(websocket.PingMessage, nil)
-				c.writeMu.Unlock()
-				if err != nil {
-					log.Printf("WebSocket ping error: %v", err)
-					return
-				}
+			c.writeMu.Lock()
+			err := conn.WriteMessage(websocket.PingMessage, nil)
+			c.writeMu.Unlock()
+			if err != nil {
+				slog.Warn("WebSocket ping error", "error", err)
+				return
 			}
 		}
 	}
 }
 
+// rejectMalformed tells the server a message could not be parsed and
+// redials once too many arrive in a row.
+func (c *WSClient) rejectMalformed() {
+	reconnect := c.malformed.Record()
+	if err := c.Send(transport.MalformedReply()); err != nil {
+		slog.Warn("Failed to report invalid message", "protocol", "websocket", "error", err)
+	}
+	if reconnect {
+		slog.Warn("Too many malformed messages in a row, reconnecting", "protocol", "websocket", "count", transport.MaxConsecutiveMalformed)
+		c.DropConnection()
+	}
+}
+
+// MalformedMessages returns how many received messages were not valid JSON.
+func (c *WSClient) MalformedMessages() uint64 {
+	return c.malformed.Total()
+}
+
+// SequenceGaps returns how many gaps or reorderings were detected in the
+// sequence numbers of received messages.
+func (c *WSClient) SequenceGaps() uint64 {
+	return c.sequence.Gaps()
+}
+
 func (c *WSClient) handleMessage(data []byte) {
-	log.Printf("Received raw message: %s", string(data))
+	logging.TraceMessage("Received raw message", data, "protocol", "websocket")
 
 	var message WSMessage
 	if err := json.Unmarshal(data, &message); err != nil {
-		log.Printf("Invalid WebSocket message format: %v", err)
-		log.Printf("Raw data that failed to parse: %s", string(data))
+		slog.Warn("Invalid WebSocket message format", "error", err, "data", logging.RedactJSON(data))
+		c.rejectMalformed()
 		return
 	}
+	c.malformed.Reset()
+	c.sequence.Observe("websocket", message.Seq)
 
-	log.Printf("Received WebSocket command: %s (ID: %s)", message.Type, message.ID)
+	logging.TraceMessage("Received WebSocket command", nil, "protocol", "websocket", "command_id", message.ID, "type", message.Type)
 
 	// Сначала обрабатываем системные сообщения
 	switch message.Type {
 	case "identification_success":
-		log.Printf("Identification successful: %+v", message.Payload)
+		slog.Debug("Identification successful", "payload", logging.Redact(message.Payload))
+		c.identified()
 		// Не отправляем ответ на identification_success
 		return
 	case "status_request":
@@ -241,24 +534,30 @@ func (c *WSClient) handleMessage(data []byte) {
 
 	// Если command handler установлен, используем его для остальных сообщений
 	if c.commandHandler != nil {
-		response := c.commandHandler(message)
-		if response.Type != "" {
-			responseData, err := json.Marshal(response)
-			if err != nil {
-				log.Printf("Failed to marshal response: %v", err)
-				return
-			}
-
-			select {
-			case c.sendChan <- responseData:
-			case <-time.After(5 * time.Second):
-				log.Printf("Failed to send response: timeout")
+		// Pass the whole envelope on, so fields such as signature and
+		// trace_id reach the handler as they do over TCP
+		var command map[string]interface{}
+		if err := json.Unmarshal(data, &command); err != nil {
+			slog.Warn("Invalid WebSocket message format", "error", err, "data", logging.RedactJSON(data))
+			return
+		}
+		response := c.commandHandler(command)
+		if response != nil {
+			if err := c.Send(response); err != nil {
+				slog.Error("Failed to send response", "protocol", "websocket", "command_id", message.ID, "error", err)
 			}
 		}
 		return
 	}
 }
 
-func (c *WSClient) SetCommandHandler(handler func(message WSMessage) WSMessage) {
+func (c *WSClient) SetHandler(handler transport.Handler) {
 	c.commandHandler = handler
 }
+
+// SetIdentityProvider sets the source of the identification message.
+func (c *WSClient) SetIdentityProvider(provider func() transport.Identity) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.identity = provider
+}
@@ -0,0 +1,653 @@
+package websocket
+
+import (
+	"bytes"
+	"context"
+	"edge-agent/internal/logging"
+	"edge-agent/internal/transport"
+	"encoding/json"
+	"log"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestWSClientReconnectsToFlappingServer(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// Accept each connection, wait for identification and drop it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		conn.ReadMessage()
+		conn.Close()
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+
+	reconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-reconnected:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for reconnect %d", i+1)
+		}
+	}
+}
+
+func TestWSClientConnectDisconnectRace(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// Keep every connection open until the client closes it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(3)
+		go func() {
+			defer wg.Done()
+			client.Connect(ctx, wsURL, "test-client")
+		}()
+		go func() {
+			defer wg.Done()
+			client.Send(map[string]interface{}{"type": "ping"})
+		}()
+		go func() {
+			defer wg.Done()
+			client.Disconnect()
+		}()
+	}
+	wg.Wait()
+
+	client.Disconnect()
+	if client.IsConnected() {
+		t.Error("Client still connected after Disconnect")
+	}
+}
+
+func TestWSClientSendAfterDisconnect(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	received := make(chan string, 512)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			_, data, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			received <- string(data)
+		}
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	client.Disconnect()
+
+	// More sends than the channel buffer holds must all fail fast
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 300; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := client.Send(map[string]interface{}{"type": "stale"}); err == nil {
+				t.Error("Expected Send after Disconnect to fail")
+			}
+		}()
+	}
+	wg.Wait()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Sends after Disconnect took %s, expected to fail fast", elapsed)
+	}
+
+	if err := client.Connect(ctx, wsURL, "test-client"); err != nil {
+		t.Fatalf("Reconnect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Send(map[string]interface{}{"type": "fresh"}); err != nil {
+		t.Fatalf("Send after reconnect failed: %v", err)
+	}
+
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case msg := <-received:
+			if strings.Contains(msg, "stale") {
+				t.Fatalf("Stale message delivered after reconnect: %s", msg)
+			}
+			if strings.Contains(msg, "fresh") {
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for message sent after reconnect")
+		}
+	}
+}
+
+func TestWSClientSendsIdentity(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		_, data, err := conn.ReadMessage()
+		if err == nil {
+			received <- data
+		}
+		conn.ReadMessage() // hold the connection until the client leaves
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	client.SetIdentityProvider(func() transport.Identity {
+		return transport.Identity{Version: "1.2.3", OS: "linux", Capabilities: []string{"api_call", "status"}}
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "edge-42"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	select {
+	case data := <-received:
+		var message struct {
+			Type    string             `json:"type"`
+			Payload transport.Identity `json:"payload"`
+		}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Invalid identification message %s: %v", data, err)
+		}
+		identity := message.Payload
+		if message.Type != "identification" || identity.Type != "identify" || identity.ClientID != "edge-42" || identity.Version != "1.2.3" {
+			t.Errorf("Unexpected identification %s", data)
+		}
+		if !reflect.DeepEqual(identity.Capabilities, []string{"api_call", "status"}) {
+			t.Errorf("Expected capabilities [api_call status], got %v", identity.Capabilities)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Server never received the identification message")
+	}
+}
+
+func TestWSClientRedactsSecretsInSendLog(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	var buf safeBuffer
+	prev := slog.Default()
+	logging.Configure(&buf, logging.FormatJSON, slog.LevelInfo)
+	logging.SetTraceMessages(true)
+	defer func() {
+		logging.SetTraceMessages(false)
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "edge-42"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	err := client.Send(map[string]interface{}{
+		"type":    "command_response",
+		"payload": map[string]interface{}{"token": "super-secret-token", "status": "ok"},
+	})
+	if err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "Sending message") {
+		t.Fatalf("Expected the send to be logged, got %s", out)
+	}
+	if strings.Contains(out, "super-secret-token") {
+		t.Errorf("Token leaked into the log: %s", out)
+	}
+}
+
+// safeBuffer is a bytes.Buffer that tolerates concurrent log writes.
+type safeBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *safeBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *safeBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestWSClientSendPoliciesOnFullQueue(t *testing.T) {
+	client := NewWSClient(transport.ReconnectConfig{})
+	client.SetSendConfig(SendConfig{
+		Timeout:         50 * time.Millisecond,
+		ResponsePolicy:  SendBlock,
+		HeartbeatPolicy: SendDrop,
+	})
+
+	// Pose as connected with a queue that nobody drains yet
+	sendChan := make(chan []byte, 1)
+	sendChan <- []byte(`{"type":"filler"}`)
+	client.connected = true
+	client.sendChan = sendChan
+	client.lost = make(chan struct{})
+
+	start := time.Now()
+	if err := client.Send(map[string]interface{}{"type": "heartbeat", "id": "heartbeat-1"}); err == nil {
+		t.Error("Expected the heartbeat to be dropped")
+	}
+	if elapsed := time.Since(start); elapsed > 40*time.Millisecond {
+		t.Errorf("Expected the heartbeat to be dropped at once, took %s", elapsed)
+	}
+	if client.Dropped() != 1 {
+		t.Errorf("Expected 1 dropped message, got %d", client.Dropped())
+	}
+
+	start = time.Now()
+	if err := client.SendCommand("pong", nil, "p1"); err == nil || !strings.Contains(err.Error(), "timeout") {
+		t.Errorf("Expected a send timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("Expected the send to wait for the timeout, took %s", elapsed)
+	}
+	if client.Dropped() != 2 {
+		t.Errorf("Expected 2 dropped messages, got %d", client.Dropped())
+	}
+
+	// A response waits past the timeout until the writer catches up
+	sent := make(chan error, 1)
+	go func() {
+		sent <- client.Send(map[string]interface{}{"id": "cmd-1", "success": true})
+	}()
+	select {
+	case err := <-sent:
+		t.Fatalf("Expected the response to block on the full queue, got %v", err)
+	case <-time.After(150 * time.Millisecond):
+	}
+	<-sendChan
+	select {
+	case err := <-sent:
+		if err != nil {
+			t.Errorf("Expected the response to be queued, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Response was not queued after the queue drained")
+	}
+	if data := <-sendChan; !strings.Contains(string(data), `"cmd-1"`) {
+		t.Errorf("Expected the queued response, got %s", data)
+	}
+	if client.Dropped() != 2 {
+		t.Errorf("Expected a blocked response not to count as dropped, got %d", client.Dropped())
+	}
+
+	// A blocked response gives up when the connection drops
+	sendChan <- []byte(`{"type":"filler"}`)
+	go func() {
+		sent <- client.Send(map[string]interface{}{"id": "cmd-2", "success": true})
+	}()
+	time.Sleep(20 * time.Millisecond)
+	close(client.lost)
+	select {
+	case err := <-sent:
+		if err == nil {
+			t.Error("Expected the blocked response to fail once the connection closed")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Blocked response outlived the connection")
+	}
+}
+
+func TestWSReadPumpExitsPromptlyOnCancel(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	// Accept the connection and never send anything.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage()
+	}))
+	defer server.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		t.Fatalf("Dial failed: %v", err)
+	}
+	defer conn.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+
+	done := make(chan struct{})
+	go func() {
+		client.readPump(ctx, conn)
+		close(done)
+	}()
+
+	// Let readPump block in ReadMessage on the quiet connection
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+
+	select {
+	case <-done:
+	case <-time.After(200 * time.Millisecond):
+		t.Fatal("readPump did not exit after the context was cancelled")
+	}
+}
+
+// silentServer accepts TCP connections and never answers, like a server
+// stuck before the handshake.
+func silentServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			t.Cleanup(func() { conn.Close() })
+		}
+	}()
+	return "ws://" + listener.Addr().String()
+}
+
+func TestWSClientConnectTimeout(t *testing.T) {
+	client := NewWSClient(transport.ReconnectConfig{Enabled: false})
+	client.SetConnectTimeout(100 * time.Millisecond)
+
+	start := time.Now()
+	err := client.Connect(context.Background(), silentServer(t), "test-client")
+	if err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Fatalf("Expected a connect timeout, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected the attempt to give up after about 100ms, took %s", elapsed)
+	}
+}
+
+func TestWSClientConnectAbortedByContext(t *testing.T) {
+	client := NewWSClient(transport.ReconnectConfig{Enabled: true, InitialDelay: time.Hour})
+	url := silentServer(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	done := make(chan error, 1)
+	go func() { done <- client.Connect(ctx, url, "test-client") }()
+	select {
+	case err := <-done:
+		if err == nil || strings.Contains(err.Error(), "timed out") {
+			t.Errorf("Expected the connect to be cancelled, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Connect did not return after the context was cancelled")
+	}
+}
+
+func TestWSClientAnswersMalformedMessages(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// The first connection gets garbage; later ones are just held open.
+	reply := make(chan []byte, 1)
+	accepted := make(chan struct{}, 10)
+	var connections sync.Mutex
+	first := true
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		connections.Lock()
+		garbage := first
+		first = false
+		connections.Unlock()
+		accepted <- struct{}{}
+
+		conn.ReadMessage() // identification
+		if garbage {
+			conn.WriteMessage(websocket.TextMessage, []byte("{not json"))
+			if _, data, err := conn.ReadMessage(); err == nil {
+				reply <- data
+			}
+			for i := 1; i < transport.MaxConsecutiveMalformed; i++ {
+				conn.WriteMessage(websocket.TextMessage, []byte("garbage"))
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	<-accepted
+
+	select {
+	case data := <-reply:
+		var message map[string]interface{}
+		if err := json.Unmarshal(data, &message); err != nil {
+			t.Fatalf("Invalid reply %s: %v", data, err)
+		}
+		if message["type"] != "error" || message["error"] != "invalid json" || message["id"] != "" {
+			t.Errorf("Unexpected reply to a malformed message: %s", data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Timed out waiting for the error reply")
+	}
+
+	// The last malformed message in a row drops the connection
+	select {
+	case <-accepted:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to reconnect after too many malformed messages")
+	}
+	if got := client.MalformedMessages(); got != transport.MaxConsecutiveMalformed {
+		t.Errorf("Expected %d malformed messages, got %d", transport.MaxConsecutiveMalformed, got)
+	}
+}
+
+func TestWSClientReconnectsWhenIdentificationIsNotAcknowledged(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+
+	// Read everything the client sends but never answer it.
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	client := NewWSClient(transport.ReconnectConfig{
+		Enabled:      true,
+		MaxAttempts:  10,
+		InitialDelay: 10 * time.Millisecond,
+	})
+	client.SetIdentifyTimeout(50 * time.Millisecond)
+
+	reconnected := make(chan struct{}, 10)
+	client.OnReconnect(func() { reconnected <- struct{}{} })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "test-client"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+
+	select {
+	case <-reconnected:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the client to reconnect when identification is never acknowledged")
+	}
+}
+
+func TestWSClientOmitsMessageTraceByDefault(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.ReadMessage() // identification
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"ping"}`))
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}))
+	defer server.Close()
+
+	// Even at debug level the per-message lines need trace_messages
+	var buf safeBuffer
+	prev := slog.Default()
+	logging.Configure(&buf, logging.FormatJSON, slog.LevelDebug)
+	defer func() {
+		slog.SetDefault(prev)
+		log.SetOutput(os.Stderr)
+		log.SetFlags(log.LstdFlags)
+	}()
+
+	client := NewWSClient(transport.ReconnectConfig{})
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	wsURL := "ws" + strings.TrimPrefix(server.URL, "http")
+	if err := client.Connect(ctx, wsURL, "edge-42"); err != nil {
+		t.Fatalf("Connect failed: %v", err)
+	}
+	defer client.Disconnect()
+	if err := client.Send(map[string]interface{}{"type": "heartbeat"}); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	time.Sleep(100 * time.Millisecond) // let the ping round trip
+
+	out := buf.String()
+	for _, line := range []string{"Sending message", "Received raw message", "Received WebSocket command"} {
+		if strings.Contains(out, line) {
+			t.Errorf("Expected no %q line without trace_messages, got %s", line, out)
+		}
+	}
+	if !strings.Contains(out, "Connecting to WebSocket") {
+		t.Errorf("Expected regular log lines to be kept, got %s", out)
+	}
+}
@@ -0,0 +1,142 @@
+package websocket
+
+import (
+	"edge-agent/internal/logging"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// SendPolicy decides what happens to an outgoing message while the send
+// queue is full, e.g. on a slow link.
+type SendPolicy string
+
+const (
+	// SendBlock waits until there is room in the queue, or until the
+	// connection drops or the client is stopped.
+	SendBlock SendPolicy = "block"
+	// SendTimeout waits up to SendConfig.Timeout and then fails.
+	SendTimeout SendPolicy = "timeout"
+	// SendDrop fails at once; the message is counted as dropped.
+	SendDrop SendPolicy = "drop"
+)
+
+// DefaultSendTimeout is used when SendConfig.Timeout is not set.
+const DefaultSendTimeout = 5 * time.Second
+
+// ParseSendPolicy converts "block", "timeout" or "drop" to a SendPolicy.
+func ParseSendPolicy(name string) (SendPolicy, error) {
+	switch p := SendPolicy(name); p {
+	case SendBlock, SendTimeout, SendDrop:
+		return p, nil
+	}
+	return "", fmt.Errorf("invalid send policy %q: must be one of block, timeout, drop", name)
+}
+
+// SendConfig controls backpressure on the send queue. Command responses
+// and heartbeats get their own policies; every other message uses
+// SendTimeout.
+type SendConfig struct {
+	Timeout         time.Duration
+	ResponsePolicy  SendPolicy
+	HeartbeatPolicy SendPolicy
+}
+
+// DefaultSendConfig blocks for responses, which the server is waiting
+// for, and drops heartbeats, which the next one supersedes.
+func DefaultSendConfig() SendConfig {
+	return SendConfig{
+		Timeout:         DefaultSendTimeout,
+		ResponsePolicy:  SendBlock,
+		HeartbeatPolicy: SendDrop,
+	}
+}
+
+// SetSendConfig replaces the backpressure policies for future sends.
+func (c *WSClient) SetSendConfig(cfg SendConfig) {
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = DefaultSendTimeout
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.send = cfg
+}
+
+// Dropped returns how many messages were discarded because the send queue
+// stayed full, by a drop policy or a send timeout.
+func (c *WSClient) Dropped() uint64 {
+	return c.dropped.Load()
+}
+
+// sendPolicy returns the policy for message: heartbeats and command
+// responses (which carry "success") are configurable.
+func (c *WSClient) sendPolicy(message map[string]interface{}) SendPolicy {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if message["type"] == "heartbeat" {
+		return c.send.HeartbeatPolicy
+	}
+	if _, ok := message["success"]; ok {
+		return c.send.ResponsePolicy
+	}
+	return SendTimeout
+}
+
+// enqueue hands data to the writer of the current connection, applying
+// policy while the queue is full.
+func (c *WSClient) enqueue(data []byte, policy SendPolicy) error {
+	// Bind to the current connection so a send racing with Disconnect
+	// fails fast instead of leaking into a channel nobody drains
+	c.mu.RLock()
+	connected, sendChan, lost, url, timeout := c.connected, c.sendChan, c.lost, c.url, c.send.Timeout
+	c.mu.RUnlock()
+
+	logging.TraceMessage("Sending message", data, "protocol", "websocket", "url", url)
+	if !connected {
+		return fmt.Errorf("WebSocket not connected")
+	}
+
+	select {
+	case <-lost:
+		return fmt.Errorf("WebSocket connection closed")
+	default:
+	}
+
+	select {
+	case sendChan <- data:
+		return nil
+	default:
+	}
+
+	// The queue is full
+	var expired <-chan time.Time
+	switch policy {
+	case SendDrop:
+		return c.drop(policy)
+	case SendTimeout:
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	// Disconnect and a cancelled context both close lost, so a blocked
+	// send never outlives the connection
+	select {
+	case sendChan <- data:
+		return nil
+	case <-lost:
+		return fmt.Errorf("WebSocket connection closed")
+	case <-expired:
+		return c.drop(policy)
+	}
+}
+
+func (c *WSClient) drop(policy SendPolicy) error {
+	dropped := c.dropped.Add(1)
+	slog.Warn("Send queue full, message dropped", "protocol", "websocket", "policy", policy, "dropped_total", dropped)
+	if policy == SendTimeout {
+		return fmt.Errorf("send timeout")
+	}
+	return fmt.Errorf("send queue full")
+}
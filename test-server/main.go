@@ -1,9 +1,11 @@
 package main
 
 import (
+	"bufio"
 	"crypto/rand"
 	"crypto/rsa"
 	"encoding/base64"
+	"encoding/binary"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -66,8 +68,7 @@ func (a *Agent) Write(data []byte) error {
 	if a.wsConn != nil {
 		return a.wsConn.WriteMessage(websocket.TextMessage, data)
 	}
-	_, err := a.tcpConn.Write(append(data, '\n'))
-	return err
+	return writeTCPFrame(a.tcpConn, data)
 }
 
 func (a *Agent) Close() {
@@ -492,16 +493,44 @@ var (
 	ptyWSConnections   = make(map[string]*websocket.Conn)
 )
 
+// writeTCPFrame writes data prefixed with its 4-byte big-endian length,
+// matching the framing used by the agent's TCP transport.
+func writeTCPFrame(w io.Writer, data []byte) error {
+	frame := make([]byte, 4+len(data))
+	binary.BigEndian.PutUint32(frame, uint32(len(data)))
+	copy(frame[4:], data)
+	_, err := w.Write(frame)
+	return err
+}
+
+// readTCPFrame reads one length-prefixed message from r.
+func readTCPFrame(r *bufio.Reader) ([]byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+	data := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
 func handleAgentConnection(agentID string, conn net.Conn) {
-	decoder := json.NewDecoder(conn)
+	reader := bufio.NewReader(conn)
 	for {
-		var msg map[string]interface{}
-		if err := decoder.Decode(&msg); err != nil {
+		data, err := readTCPFrame(reader)
+		if err != nil {
 			if err != io.EOF {
 				log.Printf("Error reading from agent %s: %v", agentID, err)
 			}
 			return
 		}
+		var msg map[string]interface{}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			log.Printf("Invalid TCP message from %s: %v", agentID, err)
+			continue
+		}
 		agent, _ := am.GetAgent(agentID)
 		handleAgentMessage(agentID, msg, agent)
 	}
@@ -528,7 +557,7 @@ func handleAgentMessage(agentID string, msg map[string]interface{}, agent *Agent
 			log.Printf("Warning: Agent %s identified as %s but sent NO METADATA. Is it running old code?", agentID, clientID)
 		}
 
-		log.Printf("Agent identification: client_id=%s, metadata=%+v", clientID, metadata)
+		log.Printf("Agent identification: client_id=%s, version=%v, capabilities=%v, metadata=%+v", clientID, data["version"], data["capabilities"], metadata)
 
 		if agent != nil {
 			agent.mu.Lock()
@@ -583,6 +612,15 @@ func handleAgentMessage(agentID string, msg map[string]interface{}, agent *Agent
 			}
 			log.Printf("Heartbeat from %s: Latency=%dms, Stats=%+v", agentID, agent.Latency, agent.Stats)
 			agent.mu.Unlock()
+
+			// Acknowledge so the agent can track heartbeat round-trips
+			if id, _ := msg["id"].(string); id != "" {
+				ack, _ := json.Marshal(map[string]interface{}{
+					"type": "heartbeat_ack",
+					"id":   id,
+				})
+				agent.Write(ack)
+			}
 		}
 
 	case "ping":
@@ -1008,12 +1046,15 @@ func handleFileDownload(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// The agent returns {"path", "data" (base64), "size", "mod_time"}
 	payload, _ := resp["payload"].(map[string]interface{})
+	var file map[string]interface{}
+	if payload != nil {
+		file, _ = payload["data"].(map[string]interface{})
+	}
 	var dataStr string
-	if payload != nil && payload["data"] != nil {
-		dataStr, _ = payload["data"].(string)
-	} else if resp["data"] != nil {
-		dataStr, _ = resp["data"].(string)
+	if file != nil {
+		dataStr, _ = file["data"].(string)
 	}
 
 	dataStr = strings.TrimSpace(dataStr)